@@ -0,0 +1,522 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the gateway's file-based configuration. Every field has a
+// default, so a file only needs to contain what it changes.
+type Config struct {
+	Listen      ListenConfig      `yaml:"listen"`
+	Upstreams   UpstreamsConfig   `yaml:"upstreams"`
+	Timeouts    TimeoutsConfig    `yaml:"timeouts"`
+	Middleware  MiddlewareConfig  `yaml:"middleware"`
+	CORS        CORSConfig        `yaml:"cors"`
+	Compression CompressionConfig `yaml:"compression"`
+	Tenancy     TenancyConfig     `yaml:"tenancy"`
+	API         APIConfig         `yaml:"api"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Concurrency ConcurrencyConfig `yaml:"concurrency"`
+	Canary      CanaryConfig      `yaml:"canary"`
+}
+
+// ListenConfig is only read at startup; changing it requires a restart,
+// except for ShutdownDelay.
+type ListenConfig struct {
+	Address      string        `yaml:"address"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// ShutdownDelay is how long /readyz fails before the listener closes.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+}
+
+type UpstreamsConfig struct {
+	TaskService UpstreamConfig `yaml:"task_service"`
+	UserService UpstreamConfig `yaml:"user_service"`
+}
+
+type UpstreamConfig struct {
+	// Address is host:port, a comma-separated list of instances, or a gRPC
+	// target such as dns:///task-service:8081. A host name with several A
+	// records is balanced over all of them.
+	Address string `yaml:"address"`
+	// Balancer spreads RPCs over the instances: round_robin or
+	// least_request.
+	Balancer string `yaml:"balancer"`
+	// HealthCheck stops sending RPCs to instances whose gRPC health service
+	// does not report SERVING.
+	HealthCheck bool `yaml:"health_check"`
+	// Compression is the gRPC compressor for requests: none, gzip or zstd.
+	// Responses use whatever the request used.
+	Compression string `yaml:"compression"`
+}
+
+type TimeoutsConfig struct {
+	Default time.Duration `yaml:"default"`
+	// Routes is keyed by "METHOD /path/template". Zero disables the deadline.
+	Routes map[string]time.Duration `yaml:"routes"`
+}
+
+type MiddlewareConfig struct {
+	AccessLog         bool `yaml:"access_log"`
+	RequestValidation bool `yaml:"request_validation"`
+}
+
+// CompressionConfig controls HTTP response compression.
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Encodings are offered in order of preference: zstd and/or gzip.
+	Encodings    []string `yaml:"encodings"`
+	MinSize      int      `yaml:"min_size"`
+	ContentTypes []string `yaml:"content_types"`
+}
+
+// IdempotencyConfig controls Idempotency-Key handling. Store is only read
+// at startup; the Postgres connection string comes from
+// IDEMPOTENCY_DATABASE_URL.
+type IdempotencyConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+	// Store is memory or postgres.
+	Store string `yaml:"store"`
+	// Routes is a list of "METHOD /path/template" entries.
+	Routes []string `yaml:"routes"`
+}
+
+// ConcurrencyConfig is the adaptive concurrency limit applied to each
+// upstream separately.
+type ConcurrencyConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	InitialLimit int  `yaml:"initial_limit"`
+	MinLimit     int  `yaml:"min_limit"`
+	MaxLimit     int  `yaml:"max_limit"`
+	// LatencyThreshold is the RPC latency treated as congestion.
+	LatencyThreshold time.Duration `yaml:"latency_threshold"`
+	BackoffRatio     float64       `yaml:"backoff_ratio"`
+	// ReadReserve is the fraction of the limit kept for reads.
+	ReadReserve float64       `yaml:"read_reserve"`
+	RetryAfter  time.Duration `yaml:"retry_after"`
+}
+
+// CanaryConfig sends part of the task-service traffic to a canary
+// deployment. A request is assigned by the header, then by the cookie, and
+// otherwise by Percent; the assignment is kept in the cookie.
+type CanaryConfig struct {
+	Enabled  bool           `yaml:"enabled"`
+	Upstream UpstreamConfig `yaml:"upstream"`
+	// Percent of the unassigned clients that get the canary, 0 to 100.
+	Percent float64 `yaml:"percent"`
+	// Header forces a variant with the value "canary" or "stable".
+	Header string `yaml:"header"`
+	// Cookie forces a variant like Header and keeps clients on the variant
+	// they were assigned for StickyTTL.
+	Cookie    string        `yaml:"cookie"`
+	StickyTTL time.Duration `yaml:"sticky_ttl"`
+}
+
+type APIConfig struct {
+	// LegacyAlias keeps serving /api/... as a deprecated alias of /api/v1/....
+	LegacyAlias LegacyAliasConfig `yaml:"legacy_alias"`
+}
+
+type LegacyAliasConfig struct {
+	Enabled      bool      `yaml:"enabled"`
+	DeprecatedAt time.Time `yaml:"deprecated_at"`
+	Sunset       time.Time `yaml:"sunset"`
+}
+
+// TenancyConfig controls how requests are assigned to a tenant.
+type TenancyConfig struct {
+	// JWTClaim names the Bearer token claim that holds the tenant. It
+	// requires Secret, so that no token is trusted unverified.
+	JWTClaim string `yaml:"jwt_claim"`
+	// AllowHeader accepts X-Tenant-ID from clients without a token. Any
+	// client can then act for any tenant, so it is only safe behind a proxy
+	// that sets the header itself.
+	AllowHeader bool `yaml:"allow_header"`
+	// DefaultTenant applies when nothing names a tenant; empty rejects such
+	// requests.
+	DefaultTenant string `yaml:"default_tenant"`
+	// Secret verifies HS256 tokens. It is not part of the file; it comes
+	// from JWT_SECRET.
+	Secret string `yaml:"-"`
+}
+
+type CORSConfig struct {
+	CORSPolicy `yaml:",inline"`
+	// Routes overrides the policy per path template, e.g. "/api/v1/users/{id}".
+	// Fields left out inherit from the top-level policy.
+	Routes map[string]CORSOverride `yaml:"routes"`
+}
+
+type CORSPolicy struct {
+	// AllowedOrigins holds exact origins, wildcard subdomains such as
+	// "https://*.example.com", or "*".
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+type CORSOverride struct {
+	AllowedOrigins   []string       `yaml:"allowed_origins"`
+	AllowedMethods   []string       `yaml:"allowed_methods"`
+	AllowedHeaders   []string       `yaml:"allowed_headers"`
+	ExposedHeaders   []string       `yaml:"exposed_headers"`
+	AllowCredentials *bool          `yaml:"allow_credentials"`
+	MaxAge           *time.Duration `yaml:"max_age"`
+}
+
+// Policy returns the policy for the route with the given path template.
+func (c CORSConfig) Policy(route string) CORSPolicy {
+	p := c.CORSPolicy
+	o, ok := c.Routes[route]
+	if !ok {
+		return p
+	}
+	if o.AllowedOrigins != nil {
+		p.AllowedOrigins = o.AllowedOrigins
+	}
+	if o.AllowedMethods != nil {
+		p.AllowedMethods = o.AllowedMethods
+	}
+	if o.AllowedHeaders != nil {
+		p.AllowedHeaders = o.AllowedHeaders
+	}
+	if o.ExposedHeaders != nil {
+		p.ExposedHeaders = o.ExposedHeaders
+	}
+	if o.AllowCredentials != nil {
+		p.AllowCredentials = *o.AllowCredentials
+	}
+	if o.MaxAge != nil {
+		p.MaxAge = *o.MaxAge
+	}
+	return p
+}
+
+// Default returns the configuration the gateway runs with when no file is
+// given. Upstream addresses still honour TASK_SERVICE_ADDR and
+// USER_SERVICE_ADDR so existing deployments keep working.
+func Default() *Config {
+	return &Config{
+		Listen: ListenConfig{
+			Address:       ":8080",
+			ReadTimeout:   15 * time.Second,
+			WriteTimeout:  15 * time.Second,
+			IdleTimeout:   60 * time.Second,
+			ShutdownDelay: 5 * time.Second,
+		},
+		Upstreams: UpstreamsConfig{
+			TaskService: UpstreamConfig{Address: getenv("TASK_SERVICE_ADDR", "localhost:8081"), Balancer: "round_robin", HealthCheck: true, Compression: "none"},
+			UserService: UpstreamConfig{Address: getenv("USER_SERVICE_ADDR", "localhost:8082"), Balancer: "round_robin", HealthCheck: true, Compression: "none"},
+		},
+		Timeouts: TimeoutsConfig{
+			Default: 5 * time.Second,
+			Routes: map[string]time.Duration{
+				"GET /api/v1/tasks/events":  0,
+				"GET /api/v1/tasks/export":  0,
+				"POST /api/v1/tasks/import": 2 * time.Minute,
+				"POST /api/v1/batch":        10 * time.Second,
+			},
+		},
+		Middleware: MiddlewareConfig{
+			AccessLog:         true,
+			RequestValidation: true,
+		},
+		CORS: CORSConfig{
+			CORSPolicy: CORSPolicy{
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+				AllowedHeaders: []string{"Content-Type", "Authorization", "X-Request-ID", "Request-Timeout", "X-Tenant-ID", "Idempotency-Key", "X-Canary"},
+				ExposedHeaders: []string{"X-Request-ID", "traceresponse", "Idempotent-Replayed", "Link"},
+				MaxAge:         10 * time.Minute,
+			},
+		},
+		Compression: CompressionConfig{
+			Enabled:      true,
+			Encodings:    []string{"zstd", "gzip"},
+			MinSize:      1024,
+			ContentTypes: []string{"application/json", "text/plain", "text/csv", "application/x-ndjson"},
+		},
+		Tenancy: defaultTenancy(),
+		Idempotency: IdempotencyConfig{
+			Enabled: true,
+			TTL:     24 * time.Hour,
+			Store:   "memory",
+			Routes:  []string{"POST /api/v1/tasks", "POST /api/v1/users"},
+		},
+		Concurrency: ConcurrencyConfig{
+			Enabled:          true,
+			InitialLimit:     20,
+			MinLimit:         2,
+			MaxLimit:         200,
+			LatencyThreshold: 500 * time.Millisecond,
+			BackoffRatio:     0.9,
+			ReadReserve:      0.2,
+			RetryAfter:       time.Second,
+		},
+		Canary: CanaryConfig{
+			Upstream:  UpstreamConfig{Address: os.Getenv("TASK_SERVICE_CANARY_ADDR"), Balancer: "round_robin", HealthCheck: true, Compression: "none"},
+			Header:    "X-Canary",
+			Cookie:    "canary",
+			StickyTTL: 24 * time.Hour,
+		},
+		API: APIConfig{
+			LegacyAlias: LegacyAliasConfig{
+				Enabled:      true,
+				DeprecatedAt: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+				Sunset:       time.Date(2027, 4, 18, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+}
+
+// defaultTenancy reads the tenant from the tenant_id claim of bearer tokens
+// when JWT_SECRET is set to verify them, and otherwise uses the default
+// tenant.
+func defaultTenancy() TenancyConfig {
+	t := TenancyConfig{DefaultTenant: "default", Secret: os.Getenv("JWT_SECRET")}
+	if t.Secret != "" {
+		t.JWTClaim = "tenant_id"
+	}
+	return t
+}
+
+// Load reads the file at path over the defaults and validates the result.
+// An empty path yields the defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, cfg.Validate()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := Parse(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes YAML (or JSON, which is valid YAML) into cfg and validates
+// it. Unknown keys are rejected so that typos do not go unnoticed.
+func Parse(data []byte, cfg *Config) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return cfg.Validate()
+}
+
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Listen.Address); err != nil {
+		add("listen.address: %v", err)
+	}
+	for name, d := range map[string]time.Duration{
+		"listen.read_timeout":   c.Listen.ReadTimeout,
+		"listen.write_timeout":  c.Listen.WriteTimeout,
+		"listen.idle_timeout":   c.Listen.IdleTimeout,
+		"listen.shutdown_delay": c.Listen.ShutdownDelay,
+	} {
+		if d < 0 {
+			add("%s must not be negative", name)
+		}
+	}
+
+	upstreams := map[string]UpstreamConfig{
+		"upstreams.task_service": c.Upstreams.TaskService,
+		"upstreams.user_service": c.Upstreams.UserService,
+	}
+	if c.Canary.Enabled {
+		upstreams["canary.upstream"] = c.Canary.Upstream
+	}
+	for name, u := range upstreams {
+		if strings.TrimSpace(u.Address) == "" {
+			add("%s.address is required", name)
+		}
+		switch u.Balancer {
+		case "", "round_robin", "least_request":
+		default:
+			add("%s.balancer: unsupported balancer %q", name, u.Balancer)
+		}
+		switch u.Compression {
+		case "", "none", "gzip", "zstd":
+		default:
+			add("%s.compression: unsupported compressor %q", name, u.Compression)
+		}
+	}
+
+	if c.Timeouts.Default <= 0 {
+		add("timeouts.default must be positive")
+	}
+	for route, d := range c.Timeouts.Routes {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || !strings.HasPrefix(path, "/") {
+			add("timeouts.routes: %q must look like \"GET /api/v1/tasks\"", route)
+			continue
+		}
+		switch method {
+		case "GET", "POST", "PUT", "DELETE":
+		default:
+			add("timeouts.routes: %q has unsupported method %s", route, method)
+		}
+		if d < 0 {
+			add("timeouts.routes: %q must not be negative", route)
+		}
+	}
+
+	validateCORS("cors", c.CORS.CORSPolicy, add)
+	for route := range c.CORS.Routes {
+		if !strings.HasPrefix(route, "/") {
+			add("cors.routes: %q must be a path template such as \"/api/v1/tasks\"", route)
+			continue
+		}
+		validateCORS(fmt.Sprintf("cors.routes[%q]", route), c.CORS.Policy(route), add)
+	}
+
+	for _, enc := range c.Compression.Encodings {
+		if enc != "gzip" && enc != "zstd" {
+			add("compression.encodings: unsupported encoding %q", enc)
+		}
+	}
+	if c.Compression.MinSize < 0 {
+		add("compression.min_size must not be negative")
+	}
+
+	if c.Tenancy.JWTClaim != "" && c.Tenancy.Secret == "" {
+		add("tenancy.jwt_claim requires JWT_SECRET to verify bearer tokens")
+	}
+	if c.Tenancy.DefaultTenant != "" && !tenant.Valid(c.Tenancy.DefaultTenant) {
+		add("tenancy.default_tenant: %q is not a valid tenant ID", c.Tenancy.DefaultTenant)
+	}
+
+	switch c.Idempotency.Store {
+	case "memory", "postgres":
+	default:
+		add("idempotency.store: unsupported store %q", c.Idempotency.Store)
+	}
+	if c.Idempotency.TTL <= 0 {
+		add("idempotency.ttl must be positive")
+	}
+	for _, route := range c.Idempotency.Routes {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || method != "POST" || !strings.HasPrefix(path, "/") {
+			add("idempotency.routes: %q must look like \"POST /api/v1/tasks\"", route)
+		}
+		// Its response carries the webhook's signing secret, which must not
+		// be kept in the store
+		if strings.TrimSuffix(path, "/") == "/api/v1/webhooks" {
+			add("idempotency.routes: %q cannot be covered because its response holds a secret", route)
+		}
+	}
+
+	if cc := c.Concurrency; cc.Enabled {
+		if cc.MinLimit < 1 || cc.MaxLimit < cc.MinLimit {
+			add("concurrency: need 1 <= min_limit <= max_limit")
+		}
+		if cc.InitialLimit < cc.MinLimit || cc.InitialLimit > cc.MaxLimit {
+			add("concurrency.initial_limit must be between min_limit and max_limit")
+		}
+		if cc.BackoffRatio <= 0 || cc.BackoffRatio >= 1 {
+			add("concurrency.backoff_ratio must be between 0 and 1")
+		}
+		if cc.ReadReserve < 0 || cc.ReadReserve >= 1 {
+			add("concurrency.read_reserve must be at least 0 and below 1")
+		}
+		if cc.LatencyThreshold < 0 || cc.RetryAfter < 0 {
+			add("concurrency durations must not be negative")
+		}
+	}
+
+	if cn := c.Canary; cn.Enabled {
+		if cn.Percent < 0 || cn.Percent > 100 {
+			add("canary.percent must be between 0 and 100")
+		}
+		if cn.Header == "" || cn.Cookie == "" {
+			add("canary.header and canary.cookie are required")
+		}
+		if cn.StickyTTL <= 0 {
+			add("canary.sticky_ttl must be positive")
+		}
+	}
+
+	if a := c.API.LegacyAlias; !a.DeprecatedAt.IsZero() && !a.Sunset.IsZero() && a.Sunset.Before(a.DeprecatedAt) {
+		add("api.legacy_alias.sunset must not be before deprecated_at")
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Dump returns the configuration as generic values keyed like the file,
+// with durations in their string form, for display.
+func (c *Config) Dump() map[string]interface{} {
+	var out map[string]interface{}
+	data, err := yaml.Marshal(c)
+	if err == nil {
+		err = yaml.Unmarshal(data, &out)
+	}
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	return out
+}
+
+func validateCORS(name string, p CORSPolicy, add func(string, ...interface{})) {
+	if len(p.AllowedOrigins) == 0 {
+		add("%s.allowed_origins must not be empty", name)
+	}
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				add("%s: allow_credentials cannot be combined with the \"*\" origin", name)
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			add("%s.allowed_origins: %q must look like \"https://app.example.com\"", name, origin)
+			continue
+		}
+		if host := u.Hostname(); strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			add("%s.allowed_origins: %q may only use a wildcard as the first label", name, origin)
+		}
+	}
+	if p.MaxAge < 0 {
+		add("%s.max_age must not be negative", name)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
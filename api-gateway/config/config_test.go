@@ -0,0 +1,272 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/config"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := config.Default().Validate(); err != nil {
+		t.Fatalf("Default().Validate() = %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		// want is a substring of the error, or empty for success
+		want string
+	}{
+		{"empty", "", ""},
+		{"override", "timeouts:\n  default: 2s\n", ""},
+		{"unknown key", "timeouts:\n  defualt: 2s\n", "defualt"},
+		{"listen address", "listen:\n  address: nonsense\n", "listen.address"},
+		{"negative listen timeout", "listen:\n  write_timeout: -1s\n", "listen.write_timeout must not be negative"},
+		{"upstream address", "upstreams:\n  task_service:\n    address: ' '\n", "upstreams.task_service.address is required"},
+		{"balancer", "upstreams:\n  user_service:\n    balancer: random\n", `unsupported balancer "random"`},
+		{"upstream compression", "upstreams:\n  task_service:\n    compression: brotli\n", `unsupported compressor "brotli"`},
+		{"default timeout", "timeouts:\n  default: 0s\n", "timeouts.default must be positive"},
+		{"route without method", "timeouts:\n  routes:\n    /api/v1/tasks: 1s\n", "must look like"},
+		{"route method", "timeouts:\n  routes:\n    PATCH /api/v1/tasks: 1s\n", "unsupported method PATCH"},
+		{"route timeout", "timeouts:\n  routes:\n    GET /api/v1/tasks: -1s\n", `"GET /api/v1/tasks" must not be negative`},
+		{"cors route", "cors:\n  routes:\n    api/v1/tasks:\n      max_age: 1m\n", "cors.routes"},
+		{"response encoding", "compression:\n  encodings: [br]\n", `unsupported encoding "br"`},
+		{"min size", "compression:\n  min_size: -1\n", "compression.min_size"},
+		{"default tenant", "tenancy:\n  default_tenant: 'bad tenant!'\n", "tenancy.default_tenant"},
+		{"no default tenant", "tenancy:\n  default_tenant: ''\n", ""},
+		{"idempotency store", "idempotency:\n  store: redis\n", `unsupported store "redis"`},
+		{"idempotency ttl", "idempotency:\n  ttl: 0s\n", "idempotency.ttl must be positive"},
+		{"idempotency route", "idempotency:\n  routes: [GET /api/v1/tasks]\n", "idempotency.routes"},
+		{"idempotent webhook creation", "idempotency:\n  routes: [POST /api/v1/webhooks]\n", "holds a secret"},
+		{"concurrency limits", "concurrency:\n  min_limit: 10\n  max_limit: 5\n", "min_limit <= max_limit"},
+		{"concurrency initial", "concurrency:\n  initial_limit: 500\n", "initial_limit"},
+		{"backoff ratio", "concurrency:\n  backoff_ratio: 1\n", "backoff_ratio"},
+		{"read reserve", "concurrency:\n  read_reserve: 1\n", "read_reserve"},
+		{"concurrency disabled", "concurrency:\n  enabled: false\n  backoff_ratio: 1\n", ""},
+		{"canary percent", "canary:\n  enabled: true\n  upstream:\n    address: canary:8081\n  percent: 101\n", "canary.percent"},
+		{"canary address", "canary:\n  enabled: true\n  upstream:\n    address: ''\n", "canary.upstream.address is required"},
+		{"canary cookie", "canary:\n  enabled: true\n  upstream:\n    address: canary:8081\n  cookie: ''\n", "canary.header and canary.cookie"},
+		{"canary disabled", "canary:\n  percent: 101\n", ""},
+		{"sunset", "api:\n  legacy_alias:\n    deprecated_at: 2027-01-01T00:00:00Z\n    sunset: 2026-01-01T00:00:00Z\n", "sunset must not be before"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := config.Parse([]byte(tt.yaml), config.Default())
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Parse = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReportsEveryProblem(t *testing.T) {
+	err := config.Parse([]byte("timeouts:\n  default: 0s\nidempotency:\n  ttl: 0s\n"), config.Default())
+	if err == nil {
+		t.Fatal("Parse succeeded")
+	}
+	for _, want := range []string{"idempotency.ttl", "timeouts.default"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestTenancyRequiresSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	if cfg := config.Default(); cfg.Tenancy.JWTClaim != "" || cfg.Tenancy.AllowHeader {
+		t.Errorf("default tenancy without a secret = %+v, want neither tokens nor the header trusted", cfg.Tenancy)
+	}
+	err := config.Parse([]byte("tenancy:\n  jwt_claim: tenant_id\n"), config.Default())
+	if err == nil || !strings.Contains(err.Error(), "tenancy.jwt_claim requires JWT_SECRET") {
+		t.Errorf("jwt_claim without a secret: Parse = %v", err)
+	}
+
+	t.Setenv("JWT_SECRET", "s3cret")
+	cfg := config.Default()
+	if cfg.Tenancy.JWTClaim != "tenant_id" || cfg.Tenancy.Secret != "s3cret" {
+		t.Errorf("default tenancy with a secret = %+v", cfg.Tenancy)
+	}
+	if err := config.Parse([]byte("tenancy:\n  jwt_claim: org\n"), cfg); err != nil {
+		t.Errorf("jwt_claim with a secret: Parse = %v", err)
+	}
+	if strings.Contains(fmt.Sprint(cfg.Dump()), "s3cret") {
+		t.Errorf("Dump exposes the secret: %v", cfg.Dump()["tenancy"])
+	}
+}
+
+func TestCORSPolicyOverride(t *testing.T) {
+	cfg := config.Default()
+	err := config.Parse([]byte("cors:\n  routes:\n    /api/v1/users:\n      allowed_origins: [https://admin.example.com]\n      allow_credentials: true\n"), cfg)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	p := cfg.CORS.Policy("/api/v1/users")
+	if len(p.AllowedOrigins) != 1 || p.AllowedOrigins[0] != "https://admin.example.com" || !p.AllowCredentials {
+		t.Errorf("overridden policy = %+v", p)
+	}
+	if p.MaxAge != cfg.CORS.MaxAge {
+		t.Errorf("max_age = %v, want the inherited %v", p.MaxAge, cfg.CORS.MaxAge)
+	}
+	if p := cfg.CORS.Policy("/api/v1/tasks"); p.AllowCredentials {
+		t.Error("route without override got the override")
+	}
+}
+
+func TestManagerReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("timeouts:\n  default: 2s\n")
+	m, err := config.NewManager(path)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if got := m.Current().Timeouts.Default; got != 2*time.Second {
+		t.Fatalf("timeouts.default = %v, want 2s", got)
+	}
+
+	var hooked []string
+	m.OnChange(func(old, next *config.Config) (config.Change, error) {
+		hooked = append(hooked, next.Timeouts.Default.String())
+		return config.Change{}, nil
+	})
+
+	// A valid change is applied and the hooks see it
+	write("timeouts:\n  default: 3s\n")
+	if err := m.Reload(context.Background(), "test"); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := m.Current().Timeouts.Default; got != 3*time.Second {
+		t.Errorf("timeouts.default = %v, want 3s", got)
+	}
+	if len(hooked) != 1 || hooked[0] != "3s" {
+		t.Errorf("hooks ran with %v, want [3s]", hooked)
+	}
+
+	// An unchanged file does not run the hooks
+	if err := m.Reload(context.Background(), "test"); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(hooked) != 1 {
+		t.Errorf("hooks ran %d times for an unchanged file", len(hooked))
+	}
+
+	// An invalid file is rejected and the previous configuration stays
+	write("timeouts:\n  default: -1s\n")
+	if err := m.Reload(context.Background(), "test"); err == nil {
+		t.Error("Reload of an invalid file succeeded")
+	}
+	if got := m.Current().Timeouts.Default; got != 3*time.Second {
+		t.Errorf("timeouts.default = %v after a rejected reload, want 3s", got)
+	}
+
+	// A failing hook rejects the reload as well
+	m.OnChange(func(old, next *config.Config) (config.Change, error) {
+		return config.Change{}, errors.New("cannot apply")
+	})
+	write("timeouts:\n  default: 4s\n")
+	if err := m.Reload(context.Background(), "test"); err == nil {
+		t.Error("Reload with a failing hook succeeded")
+	}
+	if got := m.Current().Timeouts.Default; got != 3*time.Second {
+		t.Errorf("timeouts.default = %v after a failed hook, want 3s", got)
+	}
+}
+
+func TestManagerReloadIsAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("timeouts:\n  default: 2s\n")
+	m, err := config.NewManager(path)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	// The first hook stands in for an upstream connection: it prepares
+	// the new default and only switches to it when applied
+	applied := m.Current().Timeouts.Default
+	discarded := 0
+	m.OnChange(func(old, next *config.Config) (config.Change, error) {
+		return config.Change{
+			Apply:   func() { applied = next.Timeouts.Default },
+			Discard: func() { discarded++ },
+		}, nil
+	})
+	fail := true
+	m.OnChange(func(old, next *config.Config) (config.Change, error) {
+		if fail {
+			return config.Change{}, errors.New("cannot dial")
+		}
+		return config.Change{}, nil
+	})
+
+	// When the second hook fails, the first hook's change is discarded
+	// rather than applied
+	write("timeouts:\n  default: 3s\n")
+	if err := m.Reload(context.Background(), "test"); err == nil {
+		t.Fatal("Reload with a failing hook succeeded")
+	}
+	if applied != 2*time.Second {
+		t.Errorf("first hook applied %v from a rejected reload, want 2s", applied)
+	}
+	if discarded != 1 {
+		t.Errorf("first hook's change discarded %d times, want 1", discarded)
+	}
+	if got := m.Current().Timeouts.Default; got != 2*time.Second {
+		t.Errorf("timeouts.default = %v after a rejected reload, want 2s", got)
+	}
+
+	// Once every hook succeeds, all changes are applied together
+	fail = false
+	write("timeouts:\n  default: 4s\n")
+	if err := m.Reload(context.Background(), "test"); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if applied != 4*time.Second {
+		t.Errorf("first hook applied %v, want 4s", applied)
+	}
+	if discarded != 1 {
+		t.Errorf("a successful reload discarded a change")
+	}
+	if got := m.Current().Timeouts.Default; got != 4*time.Second {
+		t.Errorf("timeouts.default = %v, want 4s", got)
+	}
+}
+
+func TestNewManagerRejectsInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte("listen:\n  address: nonsense\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.NewManager(path); err == nil {
+		t.Error("NewManager accepted an invalid file")
+	}
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const pollInterval = 2 * time.Second
+
+// Manager holds the active configuration and replaces it when the file
+// changes or the process receives SIGHUP. Readers call Current on every
+// request, so a reload takes effect atomically for all new requests.
+type Manager struct {
+	path    string
+	current atomic.Pointer[Config]
+
+	mu       sync.Mutex
+	checksum string
+	onChange []func(old, new *Config) (Change, error)
+}
+
+// Change is a configuration change a hook has prepared but not applied.
+// Either field may be nil.
+type Change struct {
+	// Apply makes the change take effect. It must not fail: everything
+	// that can fail belongs in the hook that prepared the change.
+	Apply func()
+	// Discard releases whatever was prepared, such as a dialled
+	// connection, when the reload is rejected.
+	Discard func()
+}
+
+func NewManager(path string) (*Manager, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{path: path}
+	m.current.Store(cfg)
+	m.checksum, _ = fileChecksum(path)
+	return m, nil
+}
+
+func (m *Manager) Current() *Config {
+	return m.current.Load()
+}
+
+// OnChange registers a hook that prepares a new configuration for a
+// component that cannot simply read Current, such as an upstream
+// connection. A reload runs every hook first; only if all of them succeed
+// are their changes applied, together with publishing the new
+// configuration. If one fails, the changes already prepared are discarded
+// and the previous configuration stays active everywhere.
+func (m *Manager) OnChange(fn func(old, new *Config) (Change, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = append(m.onChange, fn)
+}
+
+// Reload re-reads the file. trigger names what caused the reload and is
+// recorded on the span and in the log.
+func (m *Manager) Reload(ctx context.Context, trigger string) error {
+	ctx, span := tracing.GetTracer().Start(ctx, "Config.Reload")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("config.path", m.path),
+		attribute.String("config.trigger", trigger),
+	)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Remember what was attempted so the watcher does not retry a broken
+	// file every poll.
+	m.checksum, _ = fileChecksum(m.path)
+
+	next, err := Load(m.path)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid configuration")
+		log.Printf("Config reload (%s) rejected: %v", trigger, err)
+		return err
+	}
+
+	old := m.current.Load()
+	changed := changedSections(old, next)
+	span.SetAttributes(attribute.StringSlice("config.changed", changed))
+
+	if len(changed) == 0 {
+		log.Printf("Config reload (%s): no changes", trigger)
+		return nil
+	}
+
+	prepared := make([]Change, 0, len(m.onChange))
+	for _, fn := range m.onChange {
+		change, err := fn(old, next)
+		if err != nil {
+			for i := len(prepared) - 1; i >= 0; i-- {
+				if prepared[i].Discard != nil {
+					prepared[i].Discard()
+				}
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to apply configuration")
+			log.Printf("Config reload (%s) failed to apply: %v", trigger, err)
+			return err
+		}
+		prepared = append(prepared, change)
+	}
+
+	for _, change := range prepared {
+		if change.Apply != nil {
+			change.Apply()
+		}
+	}
+	m.current.Store(next)
+
+	// The shutdown delay is read when shutting down, so it applies at once.
+	restart := old.Listen
+	restart.ShutdownDelay = next.Listen.ShutdownDelay
+	if restart != next.Listen {
+		log.Printf("Config reload (%s): listen settings changed; restart the gateway to apply them", trigger)
+	}
+	if old.Idempotency.Store != next.Idempotency.Store {
+		log.Printf("Config reload (%s): idempotency.store changed; restart the gateway to apply it", trigger)
+	}
+	log.Printf("Config reload (%s) applied: changed %v", trigger, changed)
+	return nil
+}
+
+// Watch reloads on SIGHUP and whenever the file's contents change, until
+// ctx is cancelled.
+func (m *Manager) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			m.Reload(context.Background(), "sighup")
+		case <-ticker.C:
+			if m.path == "" {
+				continue
+			}
+			sum, err := fileChecksum(m.path)
+			if err != nil {
+				continue
+			}
+			m.mu.Lock()
+			unchanged := sum == m.checksum
+			m.mu.Unlock()
+			if !unchanged {
+				m.Reload(context.Background(), "file")
+			}
+		}
+	}
+}
+
+func changedSections(old, next *Config) []string {
+	var changed []string
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(next).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, ov.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return changed
+}
+
+func fileChecksum(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,34 @@
+package gql
+
+import (
+	"net/http"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	graphqlotel "github.com/graph-gophers/graphql-go/trace/otel"
+)
+
+// NewHandler parses the schema against the gRPC clients and returns an HTTP
+// handler that gives every request its own UserLoader.
+func NewHandler(tasks taskpb.TaskServiceClient, users userpb.UserServiceClient) (http.Handler, error) {
+	schema, err := graphql.ParseSchema(schemaSDL,
+		&Resolver{tasks: tasks, users: users},
+		graphql.Tracer(&graphqlotel.Tracer{Tracer: tracing.GetTracer()}),
+		// Every assignee resolver of a page must be running at once for the
+		// loader to see all of their IDs in one batch.
+		graphql.MaxParallelism(loaderMaxBatch),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &relay.Handler{Schema: schema}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithUserLoader(r.Context(), NewUserLoader(users))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	}), nil
+}
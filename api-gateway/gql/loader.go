@@ -0,0 +1,175 @@
+package gql
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	loaderWait     = 2 * time.Millisecond
+	loaderMaxBatch = 100
+)
+
+type loaderKey struct{}
+
+// UserLoader collects the user IDs requested while a GraphQL query resolves
+// and fetches them with a single GetUsersByIds call. A loader lives for one
+// request, so its memoized results never leak between requests. Batches are
+// still kept per tenant, so a lookup only ever runs as the tenant that asked
+// for it.
+type UserLoader struct {
+	client userpb.UserServiceClient
+
+	mu      sync.Mutex
+	pending map[string]*userBatch // by tenant
+	batches map[batchKey]*userBatch
+}
+
+type batchKey struct {
+	tenant string
+	id     string
+}
+
+type userBatch struct {
+	// ctx carries the values of the first caller but none of its
+	// cancellation, so that one caller giving up does not fail the others
+	ctx       context.Context
+	deadline  time.Time // latest deadline of the callers
+	unbounded bool      // some caller has no deadline
+	ids       []string
+	links     []trace.Link
+	once      sync.Once
+	done      chan struct{}
+	users     map[string]*userpb.User
+	err       error
+}
+
+func NewUserLoader(client userpb.UserServiceClient) *UserLoader {
+	return &UserLoader{
+		client:  client,
+		pending: make(map[string]*userBatch),
+		batches: make(map[batchKey]*userBatch),
+	}
+}
+
+func WithUserLoader(ctx context.Context, l *UserLoader) context.Context {
+	return context.WithValue(ctx, loaderKey{}, l)
+}
+
+func userLoaderFrom(ctx context.Context) *UserLoader {
+	l, _ := ctx.Value(loaderKey{}).(*UserLoader)
+	return l
+}
+
+// Load returns the user with the given ID, or nil if user-service does not
+// know it. Calls made within loaderWait of each other share one batch, which
+// runs until the last of their deadlines.
+func (l *UserLoader) Load(ctx context.Context, id string) (*userpb.User, error) {
+	span := trace.SpanFromContext(ctx)
+	tenantID := tenant.FromContext(ctx)
+	key := batchKey{tenantID, id}
+
+	l.mu.Lock()
+	b, ok := l.batches[key]
+	if !ok {
+		b = l.pending[tenantID]
+		if b == nil {
+			b = &userBatch{ctx: context.WithoutCancel(ctx), done: make(chan struct{})}
+			l.pending[tenantID] = b
+			time.AfterFunc(loaderWait, func() { l.dispatch(tenantID, b) })
+		}
+		b.ids = append(b.ids, id)
+		l.batches[key] = b
+	}
+	if b == l.pending[tenantID] {
+		b.join(ctx)
+		if sc := span.SpanContext(); sc.IsValid() {
+			b.links = append(b.links, trace.Link{SpanContext: sc})
+		}
+	}
+	full := len(b.ids) >= loaderMaxBatch
+	l.mu.Unlock()
+
+	span.AddEvent("UserLoader.Load", trace.WithAttributes(
+		attribute.String("user.id", id),
+		attribute.Bool("loader.memoized", ok),
+	))
+
+	if full {
+		l.dispatch(tenantID, b)
+	}
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.users[id], nil
+}
+
+// join extends the batch's deadline to cover the caller's.
+func (b *userBatch) join(ctx context.Context) {
+	d, ok := ctx.Deadline()
+	switch {
+	case !ok:
+		b.unbounded = true
+	case d.After(b.deadline):
+		b.deadline = d
+	}
+}
+
+func (l *UserLoader) dispatch(tenantID string, b *userBatch) {
+	b.once.Do(func() {
+		l.mu.Lock()
+		if l.pending[tenantID] == b {
+			delete(l.pending, tenantID)
+		}
+		ids := b.ids
+		links := b.links
+		ctx := b.ctx
+		if !b.unbounded {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, b.deadline)
+			defer cancel()
+		}
+		l.mu.Unlock()
+
+		ctx, span := tracing.GetTracer().Start(ctx, "UserLoader.Batch", trace.WithLinks(links...))
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.StringSlice("user.ids", ids),
+			attribute.Int("loader.batch_size", len(ids)),
+			attribute.Int("loader.waiters", len(links)),
+		)
+
+		resp, err := l.client.GetUsersByIds(ctx, &userpb.GetUsersByIdsRequest{Ids: ids})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to get users by IDs")
+			b.err = err
+			close(b.done)
+			return
+		}
+
+		b.users = make(map[string]*userpb.User, len(resp.Users))
+		for _, u := range resp.Users {
+			b.users[u.Id] = u
+		}
+
+		span.SetAttributes(attribute.Int("result.count", len(resp.Users)))
+		close(b.done)
+	})
+}
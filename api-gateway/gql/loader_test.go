@@ -0,0 +1,259 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"google.golang.org/grpc"
+)
+
+// fakeUsers answers GetUsersByIds from a fixed set of users and records
+// every call. When release is set, calls block until it is closed.
+type fakeUsers struct {
+	userpb.UserServiceClient
+
+	known   map[string]bool
+	release chan struct{}
+	called  chan context.Context
+
+	mu    sync.Mutex
+	calls []usersCall
+}
+
+type usersCall struct {
+	ctx    context.Context
+	tenant string
+	ids    []string
+}
+
+func newFakeUsers(ids ...string) *fakeUsers {
+	f := &fakeUsers{known: make(map[string]bool), called: make(chan context.Context, 16)}
+	for _, id := range ids {
+		f.known[id] = true
+	}
+	return f
+}
+
+func (f *fakeUsers) GetUsersByIds(ctx context.Context, req *userpb.GetUsersByIdsRequest, _ ...grpc.CallOption) (*userpb.GetUsersByIdsResponse, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, usersCall{ctx: ctx, tenant: tenant.FromContext(ctx), ids: req.Ids})
+	f.mu.Unlock()
+	f.called <- ctx
+
+	if f.release != nil {
+		<-f.release
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	resp := &userpb.GetUsersByIdsResponse{}
+	for _, id := range req.Ids {
+		if f.known[id] {
+			resp.Users = append(resp.Users, &userpb.User{Id: id, Name: "name of " + id})
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeUsers) Calls() []usersCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]usersCall(nil), f.calls...)
+}
+
+func TestUserLoaderBatchesAndDeduplicates(t *testing.T) {
+	users := newFakeUsers("user-1", "user-2", "user-3")
+	l := NewUserLoader(users)
+	ctx := tenant.NewContext(context.Background(), "acme")
+
+	ids := []string{"user-1", "user-2", "user-1", "user-3", "user-404", "user-2"}
+	got := make([]*userpb.User, len(ids))
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i], errs[i] = l.Load(ctx, id)
+		}()
+	}
+	wg.Wait()
+
+	for i, id := range ids {
+		if errs[i] != nil {
+			t.Fatalf("Load(%s): %v", id, errs[i])
+		}
+		switch {
+		case id == "user-404" && got[i] != nil:
+			t.Errorf("Load(%s) = %v, want nil", id, got[i])
+		case id != "user-404" && (got[i] == nil || got[i].Id != id):
+			t.Errorf("Load(%s) = %v", id, got[i])
+		}
+	}
+
+	calls := users.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("GetUsersByIds called %d times, want 1", len(calls))
+	}
+	sorted := append([]string(nil), calls[0].ids...)
+	sort.Strings(sorted)
+	if want := []string{"user-1", "user-2", "user-3", "user-404"}; fmt.Sprint(sorted) != fmt.Sprint(want) {
+		t.Errorf("batch IDs = %v, want %v", sorted, want)
+	}
+
+	// Memoized: a later Load of a known ID makes no further call
+	if _, err := l.Load(ctx, "user-2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(users.Calls()); n != 1 {
+		t.Errorf("GetUsersByIds called %d times after memoized Load, want 1", n)
+	}
+}
+
+func TestUserLoaderSeparatesTenants(t *testing.T) {
+	users := newFakeUsers("user-1")
+	l := NewUserLoader(users)
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"acme", "globex"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Load(tenant.NewContext(context.Background(), id), "user-1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	calls := users.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("GetUsersByIds called %d times, want one per tenant", len(calls))
+	}
+	tenants := []string{calls[0].tenant, calls[1].tenant}
+	sort.Strings(tenants)
+	if tenants[0] != "acme" || tenants[1] != "globex" {
+		t.Errorf("batch tenants = %v, want [acme globex]", tenants)
+	}
+}
+
+func TestUserLoaderOutlivesCancelledCaller(t *testing.T) {
+	users := newFakeUsers("user-1")
+	users.release = make(chan struct{})
+	l := NewUserLoader(users)
+
+	first, cancel := context.WithCancel(tenant.NewContext(context.Background(), "acme"))
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := l.Load(first, "user-1")
+		firstErr <- err
+	}()
+
+	batchCtx := <-users.called
+	cancel()
+	if err := <-firstErr; err != context.Canceled {
+		t.Fatalf("cancelled caller got %v, want context.Canceled", err)
+	}
+	if err := batchCtx.Err(); err != nil {
+		t.Fatalf("batch context cancelled with its first caller: %v", err)
+	}
+	close(users.release)
+
+	// Another caller sharing the batch still gets its result
+	u, err := l.Load(tenant.NewContext(context.Background(), "acme"), "user-1")
+	if err != nil || u == nil {
+		t.Fatalf("Load after first caller cancelled = %v, %v", u, err)
+	}
+}
+
+func TestUserBatchDeadline(t *testing.T) {
+	now := time.Now()
+	withDeadline := func(d time.Duration) context.Context {
+		ctx, cancel := context.WithDeadline(context.Background(), now.Add(d))
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	tests := []struct {
+		name          string
+		callers       []context.Context
+		wantDeadline  time.Time
+		wantUnbounded bool
+	}{
+		{"single caller", []context.Context{withDeadline(time.Second)}, now.Add(time.Second), false},
+		{"latest wins", []context.Context{withDeadline(time.Second), withDeadline(time.Minute), withDeadline(time.Millisecond)}, now.Add(time.Minute), false},
+		{"caller without deadline", []context.Context{withDeadline(time.Second), context.Background()}, now.Add(time.Second), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &userBatch{}
+			for _, ctx := range tt.callers {
+				b.join(ctx)
+			}
+			if !b.deadline.Equal(tt.wantDeadline) || b.unbounded != tt.wantUnbounded {
+				t.Errorf("deadline = %v, unbounded = %v; want %v, %v", b.deadline, b.unbounded, tt.wantDeadline, tt.wantUnbounded)
+			}
+		})
+	}
+}
+
+// fakeTasks lists a fixed page of tasks.
+type fakeTasks struct {
+	taskpb.TaskServiceClient
+	tasks []*taskpb.Task
+}
+
+func (f *fakeTasks) ListTasks(context.Context, *taskpb.ListTasksRequest, ...grpc.CallOption) (*taskpb.ListTasksResponse, error) {
+	return &taskpb.ListTasksResponse{Tasks: f.tasks}, nil
+}
+
+// TestAssigneesResolveInOneCall is the N+1 check: a page of tasks resolves
+// all of its assignees with a single GetUsersByIds call.
+func TestAssigneesResolveInOneCall(t *testing.T) {
+	tasks := &fakeTasks{}
+	for i := 0; i < 20; i++ {
+		tasks.tasks = append(tasks.tasks, &taskpb.Task{
+			Id:         fmt.Sprintf("task-%d", i),
+			AssigneeId: fmt.Sprintf("user-%d", i%4),
+		})
+	}
+	users := newFakeUsers("user-0", "user-1", "user-2", "user-3")
+
+	h, err := NewHandler(tasks, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"query": "{ tasks(pageSize: 20) { tasks { id assignee { id name } } } }"}`
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	req = req.WithContext(tenant.NewContext(req.Context(), "acme"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), `"errors"`) {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := strings.Count(rec.Body.String(), `"name":"name of user-`); got != 20 {
+		t.Errorf("resolved %d assignees, want 20", got)
+	}
+
+	calls := users.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("GetUsersByIds called %d times, want 1", len(calls))
+	}
+	if len(calls[0].ids) != 4 {
+		t.Errorf("batch IDs = %v, want the 4 distinct assignees", calls[0].ids)
+	}
+}
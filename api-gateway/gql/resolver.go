@@ -0,0 +1,242 @@
+package gql
+
+import (
+	"context"
+
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	graphql "github.com/graph-gophers/graphql-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Resolver struct {
+	tasks taskpb.TaskServiceClient
+	users userpb.UserServiceClient
+}
+
+func (r *Resolver) Task(ctx context.Context, args struct{ ID graphql.ID }) (*TaskResolver, error) {
+	task, err := r.tasks.GetTask(ctx, &taskpb.GetTaskRequest{Id: string(args.ID)})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &TaskResolver{task: task}, nil
+}
+
+func (r *Resolver) Tasks(ctx context.Context, args struct {
+	PageSize          int32
+	PageToken         *string
+	AssigneeID        *string
+	Status            *string
+	IncludeTotalCount bool
+}) (*TaskPageResolver, error) {
+	req := &taskpb.ListTasksRequest{
+		PageSize:          args.PageSize,
+		PageToken:         deref(args.PageToken),
+		IncludeTotalCount: args.IncludeTotalCount,
+	}
+	if args.AssigneeID != nil {
+		req.AssigneeId = *args.AssigneeID
+	}
+	if args.Status != nil {
+		req.Status = taskpb.TaskStatus(taskpb.TaskStatus_value[*args.Status]).Enum()
+	}
+
+	resp, err := r.tasks.ListTasks(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskPageResolver{resp: resp}, nil
+}
+
+func (r *Resolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*UserResolver, error) {
+	user, err := r.users.GetUser(ctx, &userpb.GetUserRequest{Id: string(args.ID)})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &UserResolver{user: user}, nil
+}
+
+func (r *Resolver) Users(ctx context.Context, args struct {
+	PageSize          int32
+	PageToken         *string
+	IncludeTotalCount bool
+}) (*UserPageResolver, error) {
+	resp, err := r.users.ListUsers(ctx, &userpb.ListUsersRequest{
+		PageSize:          args.PageSize,
+		PageToken:         deref(args.PageToken),
+		IncludeTotalCount: args.IncludeTotalCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &UserPageResolver{resp: resp}, nil
+}
+
+type CreateTaskInput struct {
+	Title       string
+	Description *string
+	AssigneeID  *string
+}
+
+func (r *Resolver) CreateTask(ctx context.Context, args struct{ Input CreateTaskInput }) (*TaskResolver, error) {
+	task, err := r.tasks.CreateTask(ctx, &taskpb.CreateTaskRequest{
+		Title:       args.Input.Title,
+		Description: deref(args.Input.Description),
+		AssigneeId:  deref(args.Input.AssigneeID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TaskResolver{task: task}, nil
+}
+
+type UpdateTaskInput struct {
+	Title       *string
+	Description *string
+	Status      *string
+	AssigneeID  *string
+}
+
+func (r *Resolver) UpdateTask(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input UpdateTaskInput
+}) (*TaskResolver, error) {
+	req := &taskpb.UpdateTaskRequest{
+		Id:          string(args.ID),
+		Title:       deref(args.Input.Title),
+		Description: deref(args.Input.Description),
+		AssigneeId:  deref(args.Input.AssigneeID),
+	}
+	if args.Input.Status != nil {
+		req.Status = taskpb.TaskStatus(taskpb.TaskStatus_value[*args.Input.Status])
+	}
+
+	task, err := r.tasks.UpdateTask(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskResolver{task: task}, nil
+}
+
+func (r *Resolver) DeleteTask(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	if _, err := r.tasks.DeleteTask(ctx, &taskpb.DeleteTaskRequest{Id: string(args.ID)}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type CreateUserInput struct {
+	Name  string
+	Email string
+}
+
+func (r *Resolver) CreateUser(ctx context.Context, args struct{ Input CreateUserInput }) (*UserResolver, error) {
+	user, err := r.users.CreateUser(ctx, &userpb.CreateUserRequest{
+		Name:  args.Input.Name,
+		Email: args.Input.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &UserResolver{user: user}, nil
+}
+
+type TaskResolver struct {
+	task *taskpb.Task
+}
+
+func (t *TaskResolver) ID() graphql.ID           { return graphql.ID(t.task.Id) }
+func (t *TaskResolver) Title() string            { return t.task.Title }
+func (t *TaskResolver) Description() string      { return t.task.Description }
+func (t *TaskResolver) Status() string           { return t.task.Status.String() }
+func (t *TaskResolver) AssigneeID() string       { return t.task.AssigneeId }
+func (t *TaskResolver) CreatedAt() *graphql.Time { return toTime(t.task.CreatedAt) }
+func (t *TaskResolver) UpdatedAt() *graphql.Time { return toTime(t.task.UpdatedAt) }
+
+func (t *TaskResolver) Assignee(ctx context.Context) (*UserResolver, error) {
+	if t.task.AssigneeId == "" {
+		return nil, nil
+	}
+
+	loader := userLoaderFrom(ctx)
+	if loader == nil {
+		return nil, nil
+	}
+
+	user, err := loader.Load(ctx, t.task.AssigneeId)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return &UserResolver{user: user}, nil
+}
+
+type UserResolver struct {
+	user *userpb.User
+}
+
+func (u *UserResolver) ID() graphql.ID           { return graphql.ID(u.user.Id) }
+func (u *UserResolver) Name() string             { return u.user.Name }
+func (u *UserResolver) Email() string            { return u.user.Email }
+func (u *UserResolver) CreatedAt() *graphql.Time { return toTime(u.user.CreatedAt) }
+
+type TaskPageResolver struct {
+	resp *taskpb.ListTasksResponse
+}
+
+func (p *TaskPageResolver) Tasks() []*TaskResolver {
+	tasks := make([]*TaskResolver, len(p.resp.Tasks))
+	for i, task := range p.resp.Tasks {
+		tasks[i] = &TaskResolver{task: task}
+	}
+	return tasks
+}
+
+func (p *TaskPageResolver) TotalCount() *int32 { return p.resp.TotalCount }
+
+func (p *TaskPageResolver) NextPageToken() *string { return optional(p.resp.NextPageToken) }
+
+type UserPageResolver struct {
+	resp *userpb.ListUsersResponse
+}
+
+func (p *UserPageResolver) Users() []*UserResolver {
+	users := make([]*UserResolver, len(p.resp.Users))
+	for i, user := range p.resp.Users {
+		users[i] = &UserResolver{user: user}
+	}
+	return users
+}
+
+func (p *UserPageResolver) TotalCount() *int32 { return p.resp.TotalCount }
+
+func (p *UserPageResolver) NextPageToken() *string { return optional(p.resp.NextPageToken) }
+
+func toTime(ts *timestamppb.Timestamp) *graphql.Time {
+	if ts == nil {
+		return nil
+	}
+	return &graphql.Time{Time: ts.AsTime()}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// optional maps the empty string to null.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
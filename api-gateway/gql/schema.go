@@ -0,0 +1,80 @@
+package gql
+
+const schemaSDL = `
+	schema {
+		query: Query
+		mutation: Mutation
+	}
+
+	scalar Time
+
+	enum TaskStatus {
+		TODO
+		IN_PROGRESS
+		DONE
+	}
+
+	type Task {
+		id: ID!
+		title: String!
+		description: String!
+		status: TaskStatus!
+		assigneeId: String!
+		# Resolved through a per-request loader that batches into one
+		# UserService.GetUsersByIds call. Null when the user does not exist.
+		assignee: User
+		createdAt: Time
+		updatedAt: Time
+	}
+
+	type User {
+		id: ID!
+		name: String!
+		email: String!
+		createdAt: Time
+	}
+
+	type TaskPage {
+		tasks: [Task!]!
+		totalCount: Int
+		nextPageToken: String
+	}
+
+	type UserPage {
+		users: [User!]!
+		totalCount: Int
+		nextPageToken: String
+	}
+
+	input CreateTaskInput {
+		title: String!
+		description: String
+		assigneeId: String
+	}
+
+	input UpdateTaskInput {
+		title: String
+		description: String
+		status: TaskStatus
+		assigneeId: String
+	}
+
+	input CreateUserInput {
+		name: String!
+		email: String!
+	}
+
+	type Query {
+		task(id: ID!): Task
+		tasks(pageSize: Int = 10, pageToken: String, assigneeId: String, status: TaskStatus, includeTotalCount: Boolean = false): TaskPage!
+		user(id: ID!): User
+		users(pageSize: Int = 10, pageToken: String, includeTotalCount: Boolean = false): UserPage!
+	}
+
+	type Mutation {
+		createTask(input: CreateTaskInput!): Task!
+		updateTask(id: ID!, input: UpdateTaskInput!): Task!
+		deleteTask(id: ID!): Boolean!
+		createUser(input: CreateUserInput!): User!
+	}
+`
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const maxBatchItems = 20
+
+type BatchItem struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type BatchRequest struct {
+	Requests []BatchItem `json:"requests"`
+}
+
+type BatchItemResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type BatchResponse struct {
+	Responses []BatchItemResult `json:"responses"`
+}
+
+// BatchHandler executes several API calls in one HTTP request by replaying
+// each of them through the router, at most concurrency at a time.
+type BatchHandler struct {
+	router      http.Handler
+	concurrency int
+}
+
+func NewBatchHandler(router http.Handler, concurrency int) *BatchHandler {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &BatchHandler{
+		router:      router,
+		concurrency: concurrency,
+	}
+}
+
+func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "Batch")
+	defer span.End()
+
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to decode request")
+		requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Requests) == 0 || len(req.Requests) > maxBatchItems {
+		span.SetStatus(codes.Error, "Invalid batch size")
+		requestid.Error(w, r, fmt.Sprintf("A batch must contain between 1 and %d requests", maxBatchItems), http.StatusBadRequest)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.Int("batch.size", len(req.Requests)),
+		attribute.Int("batch.concurrency", h.concurrency),
+	)
+
+	parentRequestID := requestid.FromContext(r.Context())
+
+	results := make([]BatchItemResult, len(req.Requests))
+	sem := make(chan struct{}, h.concurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range req.Requests {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item BatchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			itemCtx, itemSpan := tracing.GetTracer().Start(ctx, fmt.Sprintf("Batch.Item %s %s", item.Method, item.Path))
+			defer itemSpan.End()
+
+			itemSpan.SetAttributes(
+				attribute.Int("batch.index", i),
+				attribute.String("http.method", item.Method),
+				attribute.String("http.target", item.Path),
+			)
+
+			if !batchable(item.Path) {
+				itemSpan.SetStatus(codes.Error, "Path not allowed in batch")
+				results[i] = errorResult(http.StatusBadRequest, "path must be an /api/v1/ route other than /api/v1/batch, /api/v1/tasks/events and /api/v1/tasks/export")
+				return
+			}
+
+			sub, err := http.NewRequestWithContext(itemCtx, item.Method, item.Path, bytes.NewReader(item.Body))
+			if err != nil {
+				itemSpan.RecordError(err)
+				itemSpan.SetStatus(codes.Error, "Invalid sub-request")
+				results[i] = errorResult(http.StatusBadRequest, "invalid method or path")
+				return
+			}
+			if len(item.Body) > 0 {
+				sub.Header.Set("Content-Type", "application/json")
+			}
+			if parentRequestID != "" {
+				sub.Header.Set("X-Request-ID", fmt.Sprintf("%s-%d", parentRequestID, i))
+			}
+			// Sub-requests resolve their tenant exactly as the batch did
+			for _, name := range []string{"Authorization", tenant.Header} {
+				if v := r.Header.Get(name); v != "" {
+					sub.Header.Set(name, v)
+				}
+			}
+
+			rec := newBatchRecorder()
+			h.router.ServeHTTP(rec, sub)
+
+			itemSpan.SetAttributes(attribute.Int("http.status_code", rec.status))
+			if rec.status >= http.StatusInternalServerError {
+				itemSpan.SetStatus(codes.Error, "Sub-request failed")
+			}
+
+			results[i] = BatchItemResult{Status: rec.status, Body: rec.jsonBody()}
+		}(i, item)
+	}
+
+	wg.Wait()
+
+	failed := 0
+	for _, res := range results {
+		if res.Status >= http.StatusBadRequest {
+			failed++
+		}
+	}
+	span.SetAttributes(attribute.Int("batch.failed", failed))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchResponse{Responses: results})
+}
+
+// unbatchable are the routes, below /api/v1, that a batch item may not call:
+// the batch itself, and the streams, which a batch would hold a worker on
+// until its budget runs out or buffer whole in memory.
+var unbatchable = map[string]bool{
+	"/batch":        true,
+	"/tasks/events": true,
+	"/tasks/export": true,
+}
+
+// batchable reports whether a batch item may call path, which may carry a
+// query and use the legacy /api/ prefix.
+func batchable(path string) bool {
+	u, err := url.Parse(path)
+	if err != nil || u.Host != "" || !strings.HasPrefix(u.Path, "/api/") {
+		return false
+	}
+	route := strings.TrimPrefix(u.Path, "/api")
+	route = strings.TrimPrefix(route, "/v1")
+	return !unbatchable[strings.TrimSuffix(route, "/")]
+}
+
+func errorResult(status int, message string) BatchItemResult {
+	body, _ := json.Marshal(map[string]string{"error": message})
+	return BatchItemResult{Status: status, Body: body}
+}
+
+// batchRecorder captures a sub-request's response in memory.
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func newBatchRecorder() *batchRecorder {
+	return &batchRecorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (rec *batchRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *batchRecorder) WriteHeader(code int) {
+	if rec.wrote {
+		return
+	}
+	rec.wrote = true
+	rec.status = code
+}
+
+func (rec *batchRecorder) Write(data []byte) (int, error) {
+	rec.wrote = true
+	return rec.body.Write(data)
+}
+
+// jsonBody returns the recorded body as JSON, wrapping plain-text error
+// messages in a JSON string.
+func (rec *batchRecorder) jsonBody() json.RawMessage {
+	data := bytes.TrimSpace(rec.body.Bytes())
+	if len(data) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return data
+	}
+	encoded, _ := json.Marshal(string(data))
+	return encoded
+}
@@ -0,0 +1,248 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/handlers"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+)
+
+func serveBatch(t *testing.T, h http.Handler, body string, header http.Header) (*httptest.ResponseRecorder, handlers.BatchResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/batch", strings.NewReader(body))
+	req = req.WithContext(requestid.NewContext(req.Context(), "req-1"))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp handlers.BatchResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return rec, resp
+}
+
+func TestBatchItemStatus(t *testing.T) {
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/tasks/task-1":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"task-1"}`)
+		case "/api/v1/tasks":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"status":%q}`, r.URL.Query().Get("status"))
+		case "/api/v1/users/user-1":
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/v1/boom":
+			http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	rec, resp := serveBatch(t, handlers.NewBatchHandler(router, 2), `{"requests":[
+		{"method":"GET","path":"/api/v1/tasks/task-1"},
+		{"method":"GET","path":"/api/v1/tasks/missing"},
+		{"method":"POST","path":"/api/v1/tasks?status=DONE","body":{"title":"a"}},
+		{"method":"DELETE","path":"/api/v1/users/user-1"},
+		{"method":"GET","path":"/api/v1/boom"},
+		{"method":"GET","path":"/healthz"}
+	]}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	want := []struct {
+		status int
+		body   string
+	}{
+		{http.StatusOK, `{"id":"task-1"}`},
+		{http.StatusNotFound, `"404 page not found"`},
+		{http.StatusCreated, `{"status":"DONE"}`},
+		{http.StatusNoContent, ``},
+		{http.StatusBadGateway, `"upstream unavailable"`},
+		{http.StatusBadRequest, `{"error":"path must be an /api/v1/ route other than /api/v1/batch, /api/v1/tasks/events and /api/v1/tasks/export"}`},
+	}
+	if len(resp.Responses) != len(want) {
+		t.Fatalf("%d responses, want %d", len(resp.Responses), len(want))
+	}
+	for i, w := range want {
+		got := resp.Responses[i]
+		if got.Status != w.status || string(got.Body) != w.body {
+			t.Errorf("response %d = %d %s, want %d %s", i, got.Status, got.Body, w.status, w.body)
+		}
+	}
+}
+
+func TestBatchPropagatesHeaders(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]http.Header{}
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+	})
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	header.Set(tenant.Header, "acme")
+	header.Set("Cookie", "session=1")
+	_, resp := serveBatch(t, handlers.NewBatchHandler(router, 2), `{"requests":[
+		{"method":"GET","path":"/api/v1/tasks"},
+		{"method":"POST","path":"/api/v1/users","body":{"name":"a"}}
+	]}`, header)
+	if len(resp.Responses) != 2 {
+		t.Fatalf("%d responses, want 2", len(resp.Responses))
+	}
+
+	tests := []struct {
+		path        string
+		requestID   string
+		contentType string
+	}{
+		{"/api/v1/tasks", "req-1-0", ""},
+		{"/api/v1/users", "req-1-1", "application/json"},
+	}
+	for _, tt := range tests {
+		h := seen[tt.path]
+		if h == nil {
+			t.Errorf("%s was not called", tt.path)
+			continue
+		}
+		if got := h.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("%s: Authorization = %q, want the batch's", tt.path, got)
+		}
+		if got := h.Get(tenant.Header); got != "acme" {
+			t.Errorf("%s: %s = %q, want acme", tt.path, tenant.Header, got)
+		}
+		if got := h.Get("X-Request-ID"); got != tt.requestID {
+			t.Errorf("%s: X-Request-ID = %q, want %q", tt.path, got, tt.requestID)
+		}
+		if got := h.Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.path, got, tt.contentType)
+		}
+		if got := h.Get("Cookie"); got != "" {
+			t.Errorf("%s: Cookie = %q, want other headers left out", tt.path, got)
+		}
+	}
+}
+
+func TestBatchRejectsPaths(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/healthz"},
+		{"POST", "/graphql"},
+		{"GET", "http://example.com/api/v1/tasks"},
+		{"POST", "/api/v1/batch"},
+		{"POST", "/api/batch"},
+		{"POST", "/api/v1/batch/"},
+		{"GET", "/api/v1/tasks/events"},
+		{"GET", "/api/tasks/events"},
+		{"GET", "/api/v1/tasks/events?since=10"},
+		{"GET", "/api/v1/tasks/%65vents"},
+		{"GET", "/api/v1/tasks/export"},
+		{"GET", "/api/v1/tasks/export?format=csv"},
+		{"GET", "/api/tasks/export"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			called := false
+			router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+
+			body := fmt.Sprintf(`{"requests":[{"method":%q,"path":%q}]}`, tt.method, tt.path)
+			_, resp := serveBatch(t, handlers.NewBatchHandler(router, 1), body, nil)
+			if len(resp.Responses) != 1 || resp.Responses[0].Status != http.StatusBadRequest {
+				t.Errorf("responses = %+v, want one 400", resp.Responses)
+			}
+			if called {
+				t.Error("router was called")
+			}
+		})
+	}
+}
+
+func TestBatchRejectsRequest(t *testing.T) {
+	many := make([]string, 21)
+	for i := range many {
+		many[i] = `{"method":"GET","path":"/api/v1/tasks"}`
+	}
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not JSON", `requests`},
+		{"empty", `{"requests":[]}`},
+		{"too many requests", `{"requests":[` + strings.Join(many, ",") + `]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Error("router was called")
+			})
+			rec, _ := serveBatch(t, handlers.NewBatchHandler(router, 4), tt.body, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+		})
+	}
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	tests := []struct {
+		concurrency int
+		want        int
+	}{
+		{1, 1},
+		{3, 3},
+		{0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.concurrency), func(t *testing.T) {
+			var mu sync.Mutex
+			inFlight, peak := 0, 0
+			router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				inFlight++
+				peak = max(peak, inFlight)
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			})
+
+			items := make([]string, 10)
+			for i := range items {
+				items[i] = fmt.Sprintf(`{"method":"GET","path":"/api/v1/tasks/task-%d"}`, i)
+			}
+			_, resp := serveBatch(t, handlers.NewBatchHandler(router, tt.concurrency), `{"requests":[`+strings.Join(items, ",")+`]}`, nil)
+			if len(resp.Responses) != len(items) {
+				t.Fatalf("%d responses, want %d", len(resp.Responses), len(items))
+			}
+			if peak != tt.want {
+				t.Errorf("%d sub-requests ran at once, want %d", peak, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	csvContentType    = "text/csv"
+	ndjsonContentType = "application/x-ndjson"
+
+	// importBatchSize rows are sent per ImportTasks call and inserted in one
+	// transaction.
+	importBatchSize = 100
+	maxImportBytes  = 64 << 20
+	// importReportGrace is how long past the route's deadline the final
+	// import report may take to write.
+	importReportGrace = 5 * time.Second
+	// exportFlushEvery rows the export is flushed to the client.
+	exportFlushEvery = 100
+)
+
+// csvColumns is the header of CSV exports. Imports find columns by name and
+// ignore id and the timestamps, so an export can be imported as it is.
+var csvColumns = []string{"id", "title", "description", "status", "assignee_id", "created_at", "updated_at"}
+
+// ImportRowError is one problem with one row of an import file. Line is the
+// line the row starts on; in CSV files the header is line 1.
+type ImportRowError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportTasksResponse reports an import. Rows with errors are skipped and
+// the rest imported. Error is set when the import stopped before the end of
+// the file; the batches before that stay imported.
+type ImportTasksResponse struct {
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Errors   []ImportRowError `json:"errors"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// ExportTasks streams every task matching the ListTasks filters as CSV or
+// NDJSON, writing each task as task-service sends it.
+func (h *TaskHandler) ExportTasks(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "ExportTasks")
+	defer span.End()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	assigneeID := r.URL.Query().Get("assignee_id")
+	taskStatus := r.URL.Query().Get("status")
+
+	span.SetAttributes(
+		attribute.String("export.format", format),
+		attribute.String("filter.assignee_id", assigneeID),
+		attribute.String("filter.status", taskStatus),
+	)
+
+	var enc taskEncoder
+	switch format {
+	case "csv":
+		enc = &csvTaskEncoder{w: csv.NewWriter(w)}
+		w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+	case "ndjson":
+		enc = &ndjsonTaskEncoder{w: bufio.NewWriter(w)}
+		w.Header().Set("Content-Type", ndjsonContentType)
+	default:
+		span.SetStatus(codes.Error, "Unknown format")
+		requestid.Error(w, r, "format must be csv or ndjson", http.StatusBadRequest)
+		return
+	}
+
+	pbReq := &taskpb.ExportTasksRequest{AssigneeId: assigneeID}
+	if taskStatus != "" {
+		s, ok := taskpb.TaskStatus_value[taskStatus]
+		if !ok {
+			span.SetStatus(codes.Error, "Invalid status")
+			requestid.Error(w, r, statusError, http.StatusBadRequest)
+			return
+		}
+		pbReq.Status = taskpb.TaskStatus(s).Enum()
+	}
+
+	stream, err := h.client.ExportTasks(ctx, pbReq)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to export tasks")
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to export tasks")
+		return
+	}
+
+	// Wait for the first task so that a failure to start the export, such
+	// as a validation error, still gets a proper error response.
+	task, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to export tasks")
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to export tasks")
+		return
+	}
+
+	// The server-wide write timeout would cut a long export off.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tasks.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	exported := 0
+	for err == nil {
+		if err = enc.Encode(task); err != nil {
+			break
+		}
+		exported++
+		if exported%exportFlushEvery == 0 {
+			if err = enc.Flush(); err != nil {
+				break
+			}
+			rc.Flush()
+		}
+		task, err = stream.Recv()
+	}
+	if flushErr := enc.Flush(); err == io.EOF {
+		err = flushErr
+	}
+
+	span.SetAttributes(attribute.Int("export.count", exported))
+
+	// Once the body has started, a failure can only end it early; the span
+	// records why.
+	if err != nil && err != io.EOF {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Export interrupted")
+	}
+}
+
+// ImportTasks reads a CSV or NDJSON file of tasks and inserts them through
+// ImportTasks calls of importBatchSize rows each. Rows that cannot be parsed
+// or fail validation are reported by line and skipped.
+func (h *TaskHandler) ImportTasks(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "ImportTasks")
+	defer span.End()
+
+	format := importFormat(r)
+	span.SetAttributes(attribute.String("import.format", format))
+
+	// Large files take longer to upload, and their report longer to be
+	// ready, than the server-wide read and write timeouts; the size limit
+	// and the route's deadline budget bound them instead.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	if budget, ok := ctx.Deadline(); ok {
+		rc.SetWriteDeadline(budget.Add(importReportGrace))
+	} else {
+		rc.SetWriteDeadline(time.Time{})
+	}
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var rows taskRowReader
+	switch format {
+	case "csv":
+		csvRows, err := newCSVTaskRowReader(body)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Invalid CSV header")
+			requestid.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows = csvRows
+	case "ndjson":
+		rows = &ndjsonTaskRowReader{r: bufio.NewReader(body)}
+	default:
+		span.SetStatus(codes.Error, "Unknown format")
+		requestid.Error(w, r, "Send text/csv or application/x-ndjson, or set format to csv or ndjson", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	report := &ImportTasksResponse{Errors: []ImportRowError{}}
+	failed := make(map[int]bool)
+	reject := func(e ImportRowError) {
+		report.Errors = append(report.Errors, e)
+		failed[e.Line] = true
+	}
+
+	code := http.StatusOK
+	batches := 0
+	var batch []*taskpb.ImportTask
+	var lines []int
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		batches++
+		err := h.importBatch(ctx, batches, batch, lines, report, reject)
+		batch, lines = batch[:0], lines[:0]
+		return err
+	}
+
+	for {
+		line, row, err := rows.Next()
+		if err == io.EOF {
+			err = send()
+		}
+		var rowErr *importRowError
+		if errors.As(err, &rowErr) {
+			reject(ImportRowError{Line: line, Field: rowErr.field, Message: rowErr.message})
+			continue
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Import stopped")
+			code, report.Error = importFailure(err)
+			break
+		}
+		if row == nil {
+			break
+		}
+
+		batch = append(batch, row)
+		lines = append(lines, line)
+		if len(batch) == importBatchSize {
+			if err := send(); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "Import stopped")
+				code, report.Error = importFailure(err)
+				break
+			}
+		}
+	}
+
+	report.Failed = len(failed)
+	span.SetAttributes(
+		attribute.Int("import.batches", batches),
+		attribute.Int("import.imported", report.Imported),
+		attribute.Int("import.failed", report.Failed),
+	)
+
+	writeJSON(w, code, report)
+}
+
+// importBatch sends one batch in a span of its own, so that a long import
+// shows up as one span per transaction.
+func (h *TaskHandler) importBatch(ctx context.Context, n int, batch []*taskpb.ImportTask, lines []int, report *ImportTasksResponse, reject func(ImportRowError)) error {
+	ctx, span := tracing.GetTracer().Start(ctx, "ImportTasks.Batch")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.Int("import.batch", n),
+		attribute.Int("import.batch_size", len(batch)),
+		attribute.Int("import.first_line", lines[0]),
+	)
+
+	resp, err := h.client.ImportTasks(ctx, &taskpb.ImportTasksRequest{Tasks: batch})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to import batch")
+		return fmt.Errorf("batch starting at line %d: %w", lines[0], err)
+	}
+
+	for _, e := range resp.Errors {
+		reject(ImportRowError{Line: lines[e.Index], Field: e.Field, Message: e.Message})
+	}
+	report.Imported += len(resp.Tasks)
+
+	span.SetAttributes(
+		attribute.Int("import.inserted", len(resp.Tasks)),
+		attribute.Int("import.rejected", len(batch)-len(resp.Tasks)),
+	)
+
+	return nil
+}
+
+// importFailure describes an error that stopped an import.
+func importFailure(err error) (int, string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("import files are limited to %d bytes", tooLarge.Limit)
+	}
+	if st, ok := status.FromError(err); ok {
+		if st.Code() == grpccodes.DeadlineExceeded {
+			return http.StatusGatewayTimeout, err.Error()
+		}
+		return http.StatusInternalServerError, err.Error()
+	}
+	return http.StatusBadRequest, err.Error()
+}
+
+// importFormat takes the format query parameter, or else the Content-Type.
+func importFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case csvContentType:
+		return "csv"
+	case ndjsonContentType:
+		return "ndjson"
+	}
+	return ""
+}
+
+type taskEncoder interface {
+	Encode(task *taskpb.Task) error
+	Flush() error
+}
+
+type csvTaskEncoder struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (e *csvTaskEncoder) Encode(task *taskpb.Task) error {
+	if !e.wroteHeader {
+		e.wroteHeader = true
+		if err := e.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+	return e.w.Write([]string{
+		task.Id,
+		task.Title,
+		task.Description,
+		task.Status.String(),
+		task.AssigneeId,
+		task.CreatedAt.AsTime().Format(time.RFC3339Nano),
+		task.UpdatedAt.AsTime().Format(time.RFC3339Nano),
+	})
+}
+
+// Flush also writes the header of an export without tasks.
+func (e *csvTaskEncoder) Flush() error {
+	if !e.wroteHeader {
+		e.wroteHeader = true
+		e.w.Write(csvColumns)
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonTaskEncoder struct {
+	w *bufio.Writer
+}
+
+func (e *ndjsonTaskEncoder) Encode(task *taskpb.Task) error {
+	line, err := protoJSON.Marshal(task)
+	if err != nil {
+		return err
+	}
+	// protojson output never contains a raw newline
+	e.w.Write(line)
+	return e.w.WriteByte('\n')
+}
+
+func (e *ndjsonTaskEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+// taskRowReader yields the rows of an import file with the line each starts
+// on. A row that cannot be used is returned as an *importRowError, after
+// which reading continues; other errors end the file, as does io.EOF.
+type taskRowReader interface {
+	Next() (line int, row *taskpb.ImportTask, err error)
+}
+
+type importRowError struct {
+	field   string
+	message string
+}
+
+func (e *importRowError) Error() string {
+	if e.field == "" {
+		return e.message
+	}
+	return e.field + " " + e.message
+}
+
+type csvTaskRowReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVTaskRowReader(body io.Reader) (*csvTaskRowReader, error) {
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("the CSV header has no title column")
+	}
+
+	return &csvTaskRowReader{r: r, columns: columns}, nil
+}
+
+func (c *csvTaskRowReader) Next() (int, *taskpb.ImportTask, error) {
+	record, err := c.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine, nil, &importRowError{message: parseErr.Err.Error()}
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	line, _ := c.r.FieldPos(0)
+	column := func(name string) string {
+		if i, ok := c.columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	row, err := importTask(column("title"), column("description"), column("status"), column("assignee_id"))
+	return line, row, err
+}
+
+type ndjsonTaskRowReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+// ndjsonTask holds the fields an NDJSON row may set; others, such as those
+// of an export, are ignored.
+type ndjsonTask struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	AssigneeID  string `json:"assignee_id"`
+}
+
+func (n *ndjsonTaskRowReader) Next() (int, *taskpb.ImportTask, error) {
+	for {
+		data, err := n.r.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return 0, nil, err
+		}
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		n.line++
+
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+
+		var task ndjsonTask
+		if err := json.Unmarshal(data, &task); err != nil {
+			return n.line, nil, &importRowError{message: "must be a JSON object with string fields"}
+		}
+		row, err := importTask(task.Title, task.Description, task.Status, task.AssigneeID)
+		return n.line, row, err
+	}
+}
+
+// importTask builds a row from its fields. The status is a TaskStatus name
+// and defaults to TODO; task-service validates the rest.
+func importTask(title, description, statusName, assigneeID string) (*taskpb.ImportTask, error) {
+	row := &taskpb.ImportTask{
+		Title:       title,
+		Description: description,
+		AssigneeId:  assigneeID,
+	}
+	if statusName != "" {
+		s, ok := taskpb.TaskStatus_value[statusName]
+		if !ok {
+			return nil, &importRowError{field: "status", message: "must be one of TODO, IN_PROGRESS, DONE"}
+		}
+		row.Status = taskpb.TaskStatus(s)
+	}
+	return row, nil
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"google.golang.org/grpc"
+)
+
+// fakeBulk records every ImportTasks batch, rejecting rows without a title
+// as task-service does, and exports a fixed set of tasks.
+type fakeBulk struct {
+	taskpb.TaskServiceClient
+	batches [][]*taskpb.ImportTask
+	export  []*taskpb.Task
+}
+
+func (f *fakeBulk) ImportTasks(_ context.Context, req *taskpb.ImportTasksRequest, _ ...grpc.CallOption) (*taskpb.ImportTasksResponse, error) {
+	// The handler reuses the batch slice once the call returns
+	f.batches = append(f.batches, append([]*taskpb.ImportTask(nil), req.Tasks...))
+	resp := &taskpb.ImportTasksResponse{}
+	for i, row := range req.Tasks {
+		if row.Title == "" {
+			resp.Errors = append(resp.Errors, &taskpb.ImportRowError{Index: int32(i), Field: "title", Message: "is required"})
+			continue
+		}
+		resp.Tasks = append(resp.Tasks, &taskpb.Task{Title: row.Title, Status: row.Status})
+	}
+	return resp, nil
+}
+
+func (f *fakeBulk) ExportTasks(_ context.Context, _ *taskpb.ExportTasksRequest, _ ...grpc.CallOption) (grpc.ServerStreamingClient[taskpb.Task], error) {
+	return &fakeExportStream{tasks: f.export}, nil
+}
+
+type fakeExportStream struct {
+	grpc.ClientStream
+	tasks []*taskpb.Task
+}
+
+func (s *fakeExportStream) Recv() (*taskpb.Task, error) {
+	if len(s.tasks) == 0 {
+		return nil, io.EOF
+	}
+	task := s.tasks[0]
+	s.tasks = s.tasks[1:]
+	return task, nil
+}
+
+func importFile(t *testing.T, client *fakeBulk, contentType string, body io.Reader) (int, ImportTasksResponse, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks/import", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	(&TaskHandler{client: client}).ImportTasks(rec, req)
+
+	var report ImportTasksResponse
+	if rec.Code == http.StatusOK || rec.Code == http.StatusRequestEntityTooLarge {
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("decode %q: %v", rec.Body, err)
+		}
+	}
+	return rec.Code, report, rec.Body.String()
+}
+
+func TestImportCSVHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		code int
+		// want is a substring of the error body, or the imported titles
+		want string
+	}{
+		{"plain", "title,status\nA,DONE\n", http.StatusOK, "[A]"},
+		{"byte order mark", "\ufefftitle,status\nA,DONE\n", http.StatusOK, "[A]"},
+		{"case and spaces", " Title ,Description\nA,x\n", http.StatusOK, "[A]"},
+		{"export columns", strings.Join(csvColumns, ",") + "\nid-1,A,,TODO,,2024-05-01T12:00:00Z,2024-05-01T12:00:00Z\n", http.StatusOK, "[A]"},
+		{"no title column", "description,status\nx,TODO\n", http.StatusBadRequest, "no title column"},
+		{"empty file", "", http.StatusBadRequest, "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeBulk{}
+			code, _, body := importFile(t, client, "text/csv", strings.NewReader(tt.file))
+			if code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", code, tt.code, body)
+			}
+			if code != http.StatusOK {
+				if !strings.Contains(body, tt.want) {
+					t.Errorf("body = %s, want it to mention %q", body, tt.want)
+				}
+				if len(client.batches) != 0 {
+					t.Errorf("a rejected file sent %d batches", len(client.batches))
+				}
+				return
+			}
+			var titles []string
+			for _, batch := range client.batches {
+				for _, row := range batch {
+					titles = append(titles, row.Title)
+				}
+			}
+			if got := fmt.Sprint(titles); got != tt.want {
+				t.Errorf("imported %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportErrorLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		file        string
+		imported    int
+		want        []ImportRowError
+	}{
+		{
+			"CSV parse error",
+			"text/csv",
+			"title\nA\n\"B\"x\nC\n",
+			2,
+			[]ImportRowError{{Line: 3, Message: `extraneous or missing " in quoted-field`}},
+		},
+		{
+			"CSV row after a multi-line field",
+			"text/csv",
+			"title,description\nA,\"one\ntwo\"\nB,,\n,empty title\n",
+			2,
+			[]ImportRowError{{Line: 5, Field: "title", Message: "is required"}},
+		},
+		{
+			"CSV status",
+			"text/csv",
+			"title,status\nA,TODO\nB,done\nC,IN_PROGRESS\n",
+			2,
+			[]ImportRowError{{Line: 3, Field: "status", Message: "must be one of TODO, IN_PROGRESS, DONE"}},
+		},
+		{
+			"NDJSON after blank lines",
+			"application/x-ndjson",
+			"{\"title\":\"A\"}\n\n   \n{\"title\":\"B\",\"status\":\"WONTFIX\"}\n\nnot json\n{\"title\":\"\"}\n{\"title\":\"C\"}",
+			2,
+			[]ImportRowError{
+				{Line: 4, Field: "status", Message: "must be one of TODO, IN_PROGRESS, DONE"},
+				{Line: 6, Message: "must be a JSON object with string fields"},
+				{Line: 7, Field: "title", Message: "is required"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, report, body := importFile(t, &fakeBulk{}, tt.contentType, strings.NewReader(tt.file))
+			if code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", code, body)
+			}
+			if report.Imported != tt.imported || report.Failed != len(tt.want) {
+				t.Errorf("imported %d, failed %d; want %d and %d", report.Imported, report.Failed, tt.imported, len(tt.want))
+			}
+			if !reflect.DeepEqual(report.Errors, tt.want) {
+				t.Errorf("errors = %+v\nwant     %+v", report.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportBatches(t *testing.T) {
+	var file strings.Builder
+	file.WriteString("title\n")
+	for i := 1; i <= 2*importBatchSize+50; i++ {
+		fmt.Fprintf(&file, "Task %d\n", i)
+	}
+	// A row rejected by task-service in the last batch is reported by its
+	// line in the file, not its position in the batch
+	file.WriteString("\"\"\n")
+
+	client := &fakeBulk{}
+	code, report, body := importFile(t, client, "text/csv", strings.NewReader(file.String()))
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", code, body)
+	}
+
+	var sizes []int
+	for _, batch := range client.batches {
+		sizes = append(sizes, len(batch))
+	}
+	if want := []int{importBatchSize, importBatchSize, 51}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("batch sizes = %v, want %v", sizes, want)
+	}
+	if got := client.batches[1][0].Title; got != fmt.Sprintf("Task %d", importBatchSize+1) {
+		t.Errorf("second batch starts with %q", got)
+	}
+	if report.Imported != 2*importBatchSize+50 {
+		t.Errorf("imported = %d, want %d", report.Imported, 2*importBatchSize+50)
+	}
+	want := []ImportRowError{{Line: 2*importBatchSize + 52, Field: "title", Message: "is required"}}
+	if !reflect.DeepEqual(report.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", report.Errors, want)
+	}
+}
+
+func TestImportTooLarge(t *testing.T) {
+	// Blank lines are skipped without sending a batch, so only the size
+	// limit stops this file
+	blank := bytes.Repeat([]byte(" "), 1<<20)
+	blank[len(blank)-1] = '\n'
+	parts := []io.Reader{strings.NewReader("{\"title\":\"A\"}\n")}
+	for n := 0; n <= maxImportBytes>>20; n++ {
+		parts = append(parts, bytes.NewReader(blank))
+	}
+
+	client := &fakeBulk{}
+	code, report, body := importFile(t, client, "application/x-ndjson", io.MultiReader(parts...))
+	if code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", code, body)
+	}
+	if !strings.Contains(report.Error, fmt.Sprint(maxImportBytes)) {
+		t.Errorf("error = %q, want it to name the limit", report.Error)
+	}
+	if len(client.batches) != 0 {
+		t.Errorf("%d batches sent from a file over the limit", len(client.batches))
+	}
+}
+
+func TestExportTasks(t *testing.T) {
+	export := func(target string, tasks ...*taskpb.Task) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		(&TaskHandler{client: &fakeBulk{export: tasks}}).ExportTasks(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	t.Run("CSV without tasks", func(t *testing.T) {
+		rec := export("/api/v1/tasks/export")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if got, want := rec.Body.String(), strings.Join(csvColumns, ",")+"\n"; got != want {
+			t.Errorf("body = %q, want only the header %q", got, want)
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="tasks.csv"` {
+			t.Errorf("Content-Disposition = %q", got)
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		done := testTask("t2", "user-1")
+		done.Status = taskpb.TaskStatus_DONE
+		rec := export("/api/v1/tasks/export?format=ndjson", testTask("t1", ""), done)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Type"); got != ndjsonContentType {
+			t.Errorf("Content-Type = %q, want %q", got, ndjsonContentType)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("%d lines, want 2: %q", len(lines), rec.Body)
+		}
+		var rows []map[string]interface{}
+		for _, line := range lines {
+			var row map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("decode %q: %v", line, err)
+			}
+			rows = append(rows, row)
+		}
+		if rows[0]["id"] != "t1" || rows[0]["status"] != "TODO" || rows[0]["created_at"] != "2024-05-01T12:00:00Z" {
+			t.Errorf("first row = %v", rows[0])
+		}
+		if rows[1]["id"] != "t2" || rows[1]["status"] != "DONE" || rows[1]["assignee_id"] != "user-1" {
+			t.Errorf("second row = %v", rows[1])
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if rec := export("/api/v1/tasks/export?format=xml"); rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// protoJSON is how responses render protobuf messages: timestamps as RFC
+// 3339 strings, enums by name, fields under their .proto names (the same
+// snake_case names request bodies use), and zero values included so that
+// every field is always present.
+var protoJSON = protojson.MarshalOptions{
+	UseProtoNames:   true,
+	EmitUnpopulated: true,
+}
+
+// ProtoJSON wraps a message so that encoding/json renders it with protoJSON,
+// for messages embedded in plain response structs. A nil message is null.
+type ProtoJSON struct {
+	proto.Message
+}
+
+func (p ProtoJSON) MarshalJSON() ([]byte, error) {
+	if p.Message == nil || !p.Message.ProtoReflect().IsValid() {
+		return []byte("null"), nil
+	}
+	return protoJSON.Marshal(p.Message)
+}
+
+// MarshalJSON renders the task with protoJSON and adds the assignee to it.
+func (t *TaskWithAssignee) MarshalJSON() ([]byte, error) {
+	task, err := protoJSON.Marshal(t.Task)
+	if err != nil {
+		return nil, err
+	}
+	assignee, err := json.Marshal(ProtoJSON{t.Assignee})
+	if err != nil {
+		return nil, err
+	}
+
+	// protoJSON always emits at least the task's fields, so the object is
+	// never empty and the assignee can be appended after a comma.
+	var buf bytes.Buffer
+	buf.Write(bytes.TrimSuffix(bytes.TrimSpace(task), []byte("}")))
+	buf.WriteString(`,"assignee":`)
+	buf.Write(assignee)
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func writeProto(w http.ResponseWriter, status int, msg proto.Message) {
+	body, err := protoJSON.Marshal(msg)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
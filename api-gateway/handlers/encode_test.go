@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestWriteProto(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  proto.Message
+		want map[string]interface{}
+	}{
+		{
+			name: "task",
+			msg: &taskpb.Task{
+				Id:         "t1",
+				Title:      "Write tests",
+				Status:     taskpb.TaskStatus_IN_PROGRESS,
+				AssigneeId: "user-1",
+				CreatedAt:  timestamppb.New(testCreatedAt),
+			},
+			// RFC 3339 times, enum names, .proto field names and every
+			// field present, including zero values
+			want: map[string]interface{}{
+				"id":          "t1",
+				"title":       "Write tests",
+				"description": "",
+				"status":      "IN_PROGRESS",
+				"assignee_id": "user-1",
+				"created_at":  "2024-05-01T12:00:00Z",
+				"updated_at":  nil,
+			},
+		},
+		{
+			name: "zero task",
+			msg:  &taskpb.Task{},
+			want: map[string]interface{}{
+				"id":          "",
+				"title":       "",
+				"description": "",
+				"status":      "TODO",
+				"assignee_id": "",
+				"created_at":  nil,
+				"updated_at":  nil,
+			},
+		},
+		{
+			name: "list without total",
+			msg:  &taskpb.ListTasksResponse{NextPageToken: "abc"},
+			want: map[string]interface{}{
+				"tasks":           []interface{}{},
+				"next_page_token": "abc",
+			},
+		},
+		{
+			name: "list with total",
+			msg:  &taskpb.ListTasksResponse{TotalCount: proto.Int32(0)},
+			want: map[string]interface{}{
+				"tasks":           []interface{}{},
+				"total_count":     float64(0),
+				"next_page_token": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeProto(rec, http.StatusCreated, tt.msg)
+
+			if rec.Code != http.StatusCreated {
+				t.Errorf("status = %d, want 201", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("body = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtoJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  proto.Message
+		want string
+	}{
+		{"nil", nil, `null`},
+		{"typed nil", (*userpb.User)(nil), `null`},
+		{"user", &userpb.User{Id: "user-1", Name: "Alice"}, `{"id":"user-1","name":"Alice","email":"","created_at":null}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(struct {
+				User ProtoJSON `json:"user"`
+			}{ProtoJSON{tt.msg}})
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if want := `{"user":` + tt.want + `}`; !jsonEqual(t, got, want) {
+				t.Errorf("Marshal = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestTaskWithAssigneeJSON(t *testing.T) {
+	task := testTask("t1", "user-1")
+	tests := []struct {
+		name     string
+		assignee *userpb.User
+		want     string
+	}{
+		{"assignee", &userpb.User{Id: "user-1", Name: "Alice"},
+			`{"id":"t1","title":"Task t1","description":"","status":"TODO","assignee_id":"user-1","created_at":"2024-05-01T12:00:00Z","updated_at":null,` +
+				`"assignee":{"id":"user-1","name":"Alice","email":"","created_at":null}}`},
+		{"unknown assignee", nil,
+			`{"id":"t1","title":"Task t1","description":"","status":"TODO","assignee_id":"user-1","created_at":"2024-05-01T12:00:00Z","updated_at":null,"assignee":null}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal([]*TaskWithAssignee{{Task: task, Assignee: tt.assignee}})
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if want := `[` + tt.want + `]`; !jsonEqual(t, got, want) {
+				t.Errorf("Marshal = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func jsonEqual(t *testing.T, got []byte, want string) bool {
+	t.Helper()
+	var a, b interface{}
+	if err := json.Unmarshal(got, &a); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &b); err != nil {
+		t.Fatalf("invalid JSON %s: %v", want, err)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/limiter"
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/bonyuta0204/otel-lab/api-gateway/openapi"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/validation"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unavailableRetryAfter is the Retry-After sent when a backend reports
+// itself unavailable, in seconds.
+const unavailableRetryAfter = "1"
+
+type DeadlineExceededResponse struct {
+	Error     string `json:"error"`
+	BudgetMs  int64  `json:"budget_ms,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeRPCError translates a gRPC error into an HTTP response. Validation
+// failures become the same JSON field error list the OpenAPI validator
+// produces, an exhausted time budget becomes a 504, and a request shed by
+// the concurrency limiter or refused by an unavailable backend a 503 with
+// Retry-After; other errors fall back to the given status and message.
+func writeRPCError(w http.ResponseWriter, r *http.Request, err error, fallbackStatus int, fallbackMessage string) {
+	if status.Code(err) == codes.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+		resp := DeadlineExceededResponse{
+			Error:     "request exceeded its deadline budget",
+			RequestID: requestid.FromContext(r.Context()),
+		}
+		if budget, ok := middleware.Budget(r.Context()); ok {
+			resp.BudgetMs = budget.Milliseconds()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var shed *limiter.ShedError
+	if errors.As(err, &shed) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(shed.RetryAfter.Seconds()))))
+		requestid.Error(w, r, "Service overloaded, retry later", http.StatusServiceUnavailable)
+		return
+	}
+
+	if violations := validation.FieldViolations(err); len(violations) > 0 {
+		errs := make([]openapi.FieldError, len(violations))
+		for i, v := range violations {
+			errs[i] = openapi.FieldError{
+				In:      fieldLocation(r, v.Field),
+				Field:   v.Field,
+				Message: v.Description,
+			}
+		}
+		openapi.WriteValidationError(w, r, errs)
+		return
+	}
+
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		requestid.Error(w, r, status.Convert(err).Message(), http.StatusBadRequest)
+	case codes.NotFound:
+		requestid.Error(w, r, status.Convert(err).Message(), http.StatusNotFound)
+	case codes.FailedPrecondition:
+		requestid.JSONError(w, r, status.Convert(err).Message(), http.StatusUnprocessableEntity)
+	case codes.Unavailable:
+		w.Header().Set("Retry-After", unavailableRetryAfter)
+		requestid.Error(w, r, "Service unavailable, retry later", http.StatusServiceUnavailable)
+	default:
+		requestid.Error(w, r, fallbackMessage, fallbackStatus)
+	}
+}
+
+// fieldLocation guesses where in the HTTP request a proto field came from.
+func fieldLocation(r *http.Request, field string) string {
+	if _, ok := mux.Vars(r)[field]; ok {
+		return "path"
+	}
+	if r.URL.Query().Has(field) || r.Method == http.MethodGet || r.Method == http.MethodDelete {
+		return "query"
+	}
+	return "body"
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWriteRPCErrorDeadline(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		budget bool
+	}{
+		{"gRPC DeadlineExceeded", status.Error(codes.DeadlineExceeded, "deadline exceeded"), true},
+		{"context error", fmt.Errorf("failed to list tasks: %w", context.DeadlineExceeded), true},
+		{"without a budget", status.Error(codes.DeadlineExceeded, "deadline exceeded"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeRPCError(w, r, tt.err, http.StatusInternalServerError, "Failed")
+			}))
+			if tt.budget {
+				cfg := middleware.DeadlineConfig{Default: 1500 * time.Millisecond}
+				handler = middleware.Deadline(func() middleware.DeadlineConfig { return cfg })(handler)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+			req = req.WithContext(requestid.NewContext(req.Context(), "req-1"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusGatewayTimeout {
+				t.Fatalf("status = %d, want 504", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var body DeadlineExceededResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body, err)
+			}
+			want := DeadlineExceededResponse{Error: "request exceeded its deadline budget", RequestID: "req-1"}
+			if tt.budget {
+				want.BudgetMs = 1500
+			}
+			if body != want {
+				t.Errorf("body = %+v, want %+v", body, want)
+			}
+		})
+	}
+}
+
+func TestWriteRPCErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		retryAfter string
+	}{
+		{"invalid argument", status.Error(codes.InvalidArgument, "bad"), http.StatusBadRequest, ""},
+		{"not found", status.Error(codes.NotFound, "no task"), http.StatusNotFound, ""},
+		{"unknown assignee", status.Error(codes.FailedPrecondition, "unknown assignee"), http.StatusUnprocessableEntity, ""},
+		{"unavailable", status.Error(codes.Unavailable, "cannot verify assignee user-1: user-service is unavailable"), http.StatusServiceUnavailable, "1"},
+		{"other", status.Error(codes.Internal, "boom"), http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeRPCError(rec, httptest.NewRequest(http.MethodPost, "/api/v1/tasks", nil), tt.err, http.StatusInternalServerError, "Failed")
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
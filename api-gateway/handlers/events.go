@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	sseHeartbeatInterval = 15 * time.Second
+	sseRetryMillis       = 3000
+)
+
+// TaskEventMessage is the JSON payload of one Server-Sent Event.
+type TaskEventMessage struct {
+	Sequence    uint64    `json:"sequence"`
+	Type        string    `json:"type"`
+	Task        ProtoJSON `json:"task"`
+	Traceparent string    `json:"traceparent,omitempty"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// WatchTasks streams task changes as Server-Sent Events. Clients resume with
+// the Last-Event-ID header, which EventSource sends automatically on
+// reconnect.
+func (h *TaskHandler) WatchTasks(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "WatchTasks")
+	defer span.End()
+
+	assigneeID := r.URL.Query().Get("assignee_id")
+	status := r.URL.Query().Get("status")
+	lastEventID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	span.SetAttributes(
+		attribute.String("filter.assignee_id", assigneeID),
+		attribute.String("filter.status", status),
+		attribute.Int64("sse.last_event_id", int64(lastEventID)),
+	)
+
+	pbReq := &taskpb.WatchTasksRequest{
+		AssigneeId:    assigneeID,
+		AfterSequence: lastEventID,
+	}
+
+	if status != "" {
+		s, ok := taskpb.TaskStatus_value[status]
+		if !ok {
+			span.SetStatus(codes.Error, "Invalid status")
+			requestid.Error(w, r, statusError, http.StatusBadRequest)
+			return
+		}
+		pbReq.Status = taskpb.TaskStatus(s).Enum()
+	}
+
+	stream, err := h.client.WatchTasks(ctx, pbReq)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to watch tasks")
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to watch tasks")
+		return
+	}
+
+	// The server-wide write timeout would cut the stream off.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryMillis)
+	rc.Flush()
+
+	events := make(chan *taskpb.TaskEvent)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	delivered := 0
+	for {
+		select {
+		case <-ctx.Done():
+			span.SetAttributes(attribute.Int("sse.delivered", delivered))
+			return
+		case err := <-errs:
+			span.SetAttributes(attribute.Int("sse.delivered", delivered))
+			if err != io.EOF && ctx.Err() == nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "Task event stream ended")
+			}
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			rc.Flush()
+		case event := <-events:
+			writeTaskEvent(r, w, event)
+			rc.Flush()
+			delivered++
+		}
+	}
+}
+
+// writeTaskEvent writes one event in SSE framing. Each delivery gets its own
+// span linked to the trace of the write that produced the event.
+func writeTaskEvent(r *http.Request, w io.Writer, event *taskpb.TaskEvent) {
+	writeCtx := propagation.TraceContext{}.Extract(r.Context(), propagation.MapCarrier{
+		"traceparent": event.Traceparent,
+	})
+
+	var opts []trace.SpanStartOption
+	if sc := trace.SpanContextFromContext(writeCtx); sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+
+	_, span := tracing.GetTracer().Start(r.Context(), "TaskEvent.Deliver", opts...)
+	defer span.End()
+
+	eventType := strings.ToLower(event.Type.String())
+	span.SetAttributes(
+		attribute.Int64("event.sequence", int64(event.Sequence)),
+		attribute.String("event.type", eventType),
+		attribute.String("task.id", event.Task.GetId()),
+	)
+
+	data, err := json.Marshal(TaskEventMessage{
+		Sequence:    event.Sequence,
+		Type:        eventType,
+		Task:        ProtoJSON{event.Task},
+		Traceparent: event.Traceparent,
+		OccurredAt:  event.OccurredAt.AsTime(),
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to encode event")
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: task.%s\ndata: %s\n\n", event.Sequence, eventType, data)
+}
@@ -1,27 +1,230 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	healthCacheTTL     = 2 * time.Second
+	healthCheckTimeout = time.Second
 )
 
 type HealthResponse struct {
+	Status    string                 `json:"status"`
+	Timestamp time.Time              `json:"timestamp"`
+	Service   string                 `json:"service"`
+	Version   string                 `json:"version"`
+	Checks    map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type CheckResult struct {
 	Status    string    `json:"status"`
-	Timestamp time.Time `json:"timestamp"`
-	Service   string    `json:"service"`
-	Version   string    `json:"version"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	Critical  bool      `json:"critical"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthCheck is one dependency of the gateway. A failing critical check
+// makes the gateway unready; a failing non-critical one only degrades it.
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// GRPCHealthCheck asks conn's server for the status of service through the
+// standard gRPC health protocol. An empty service is the server as a whole.
+func GRPCHealthCheck(name string, conn grpc.ClientConnInterface, service string) HealthCheck {
+	client := healthpb.NewHealthClient(conn)
+	return HealthCheck{
+		Name:     name,
+		Critical: true,
+		Run: func(ctx context.Context) error {
+			resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+			if err != nil {
+				return err
+			}
+			if resp.Status != healthpb.HealthCheckResponse_SERVING {
+				return fmt.Errorf("status %s", resp.Status)
+			}
+			return nil
+		},
+	}
+}
+
+// ExporterHealthCheck fails when the most recent span export failed.
+func ExporterHealthCheck() HealthCheck {
+	return HealthCheck{
+		Name: "trace_exporter",
+		Run: func(ctx context.Context) error {
+			return tracing.Exporter().LastError
+		},
+	}
 }
 
-func Health(w http.ResponseWriter, r *http.Request) {
+// HealthHandler serves liveness, readiness and the detailed health view.
+// Check results are cached briefly so that frequent probes do not turn
+// into a stream of RPCs against the services.
+type HealthHandler struct {
+	checks   []HealthCheck
+	draining atomic.Bool
+
+	mu        sync.Mutex
+	results   map[string]CheckResult
+	checkedAt time.Time
+}
+
+func NewHealthHandler(checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{checks: checks}
+}
+
+// Drain marks the gateway as shutting down; /readyz fails from then on so
+// that the orchestrator stops routing traffic before the listener closes.
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// Livez reports whether the process is up. It never checks dependencies,
+// so a dependency outage does not get the gateway restarted.
+func (h *HealthHandler) Livez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether the gateway should receive traffic.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	if h.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("shutting down\n"))
+		return
+	}
+
+	for name, result := range h.run(r.Context()) {
+		if result.Critical && result.Status != "up" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "%s: %s\n", name, result.Error)
+			return
+		}
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// Health summarizes the gateway's status. ?verbose adds every check with
+// its latency and error.
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	results := h.run(r.Context())
+
 	response := HealthResponse{
 		Status:    "healthy",
 		Timestamp: time.Now(),
 		Service:   "api-gateway",
 		Version:   "1.0.0",
 	}
+	for _, result := range results {
+		if result.Status == "up" {
+			continue
+		}
+		if result.Critical {
+			response.Status = "unhealthy"
+			break
+		}
+		response.Status = "degraded"
+	}
+	if h.draining.Load() {
+		response.Status = "draining"
+	}
+	if v, ok := r.URL.Query()["verbose"]; ok && (len(v) == 0 || (v[0] != "false" && v[0] != "0")) {
+		response.Checks = results
+	}
+
+	status := http.StatusOK
+	if response.Status == "unhealthy" || response.Status == "draining" {
+		status = http.StatusServiceUnavailable
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+// run returns the cached results, refreshing them concurrently once they
+// are older than healthCacheTTL.
+func (h *HealthHandler) run(ctx context.Context) map[string]CheckResult {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.results != nil && time.Since(h.checkedAt) < healthCacheTTL {
+		return h.results
+	}
+
+	// The results are shared with other callers, so a probe that hangs up
+	// must not cancel the checks.
+	ctx, span := tracing.GetTracer().Start(context.WithoutCancel(ctx), "Health.Check")
+	defer span.End()
+
+	results := make(map[string]CheckResult, len(h.checks))
+	var (
+		wg  sync.WaitGroup
+		rmu sync.Mutex
+	)
+	for _, check := range h.checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Run(checkCtx)
+			result := CheckResult{
+				Status:    "up",
+				LatencyMs: time.Since(start).Milliseconds(),
+				Critical:  check.Critical,
+				CheckedAt: start,
+			}
+			if err != nil {
+				result.Status = "down"
+				result.Error = err.Error()
+			}
+
+			rmu.Lock()
+			results[check.Name] = result
+			rmu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	var down []string
+	for name, result := range results {
+		if result.Status != "up" {
+			down = append(down, name)
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("health.checks", len(results)),
+		attribute.StringSlice("health.down", down),
+	)
+	if len(down) > 0 {
+		span.SetStatus(codes.Error, "Dependency checks failed")
+	}
+
+	h.results = results
+	h.checkedAt = time.Now()
+	return results
+}
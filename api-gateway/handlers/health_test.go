@@ -0,0 +1,184 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/handlers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func staticCheck(name string, critical bool, err error) handlers.HealthCheck {
+	return handlers.HealthCheck{
+		Name:     name,
+		Critical: critical,
+		Run:      func(ctx context.Context) error { return err },
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		name        string
+		checks      []handlers.HealthCheck
+		drain       bool
+		wantHealth  string
+		wantStatus  int // of /health
+		wantReadyz  int
+		wantMessage string // in the /readyz body
+	}{
+		{"all up", []handlers.HealthCheck{staticCheck("task_service", true, nil), staticCheck("trace_exporter", false, nil)},
+			false, "healthy", http.StatusOK, http.StatusOK, "ok"},
+		{"no checks", nil, false, "healthy", http.StatusOK, http.StatusOK, "ok"},
+		{"non-critical down", []handlers.HealthCheck{staticCheck("task_service", true, nil), staticCheck("trace_exporter", false, down)},
+			false, "degraded", http.StatusOK, http.StatusOK, "ok"},
+		{"critical down", []handlers.HealthCheck{staticCheck("task_service", true, down), staticCheck("trace_exporter", false, down)},
+			false, "unhealthy", http.StatusServiceUnavailable, http.StatusServiceUnavailable, "task_service: connection refused"},
+		{"draining", []handlers.HealthCheck{staticCheck("task_service", true, nil)},
+			true, "draining", http.StatusServiceUnavailable, http.StatusServiceUnavailable, "shutting down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handlers.NewHealthHandler(tt.checks...)
+			if tt.drain {
+				h.Drain()
+			}
+
+			rec := httptest.NewRecorder()
+			h.Health(rec, httptest.NewRequest("GET", "/health", nil))
+			var resp handlers.HealthResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body, err)
+			}
+			if rec.Code != tt.wantStatus || resp.Status != tt.wantHealth {
+				t.Errorf("/health = %d %q, want %d %q", rec.Code, resp.Status, tt.wantStatus, tt.wantHealth)
+			}
+			if resp.Checks != nil {
+				t.Errorf("/health without verbose lists checks: %v", resp.Checks)
+			}
+
+			rec = httptest.NewRecorder()
+			h.Readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+			if rec.Code != tt.wantReadyz || strings.TrimSpace(rec.Body.String()) != tt.wantMessage {
+				t.Errorf("/readyz = %d %q, want %d %q", rec.Code, rec.Body, tt.wantReadyz, tt.wantMessage)
+			}
+
+			// Liveness never depends on anything
+			rec = httptest.NewRecorder()
+			h.Livez(rec, httptest.NewRequest("GET", "/livez", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("/livez = %d, want 200", rec.Code)
+			}
+		})
+	}
+}
+
+func TestHealthVerbose(t *testing.T) {
+	h := handlers.NewHealthHandler(
+		staticCheck("task_service", true, nil),
+		staticCheck("trace_exporter", false, errors.New("export failed")),
+	)
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", false},
+		{"?verbose", true},
+		{"?verbose=true", true},
+		{"?verbose=1", true},
+		{"?verbose=false", false},
+		{"?verbose=0", false},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.Health(rec, httptest.NewRequest("GET", "/health"+tt.query, nil))
+		var resp handlers.HealthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode %q: %v", rec.Body, err)
+		}
+		if got := resp.Checks != nil; got != tt.want {
+			t.Errorf("/health%s: checks listed = %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		if !tt.want {
+			continue
+		}
+		if c := resp.Checks["task_service"]; c.Status != "up" || !c.Critical || c.Error != "" {
+			t.Errorf("/health%s: task_service = %+v", tt.query, c)
+		}
+		if c := resp.Checks["trace_exporter"]; c.Status != "down" || c.Critical || c.Error != "export failed" {
+			t.Errorf("/health%s: trace_exporter = %+v", tt.query, c)
+		}
+	}
+}
+
+func TestHealthCache(t *testing.T) {
+	var runs atomic.Int32
+	h := handlers.NewHealthHandler(handlers.HealthCheck{
+		Name:     "task_service",
+		Critical: true,
+		Run: func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		h.Readyz(httptest.NewRecorder(), httptest.NewRequest("GET", "/readyz", nil))
+		h.Health(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	}
+	if got := runs.Load(); got != 1 {
+		t.Errorf("check ran %d times for probes within the cache TTL, want 1", got)
+	}
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	srv := health.NewServer()
+	srv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	srv.SetServingStatus("postgres", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	gs := grpc.NewServer()
+	healthpb.RegisterHealthServer(gs, srv)
+	go gs.Serve(lis)
+	defer gs.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		service string
+		wantErr bool
+	}{
+		{"", false},
+		{"postgres", true},
+		{"unknown", true},
+	}
+	for _, tt := range tests {
+		check := handlers.GRPCHealthCheck("task_service", conn, tt.service)
+		if !check.Critical {
+			t.Errorf("%q: gRPC health checks must be critical", tt.service)
+		}
+		if err := check.Run(context.Background()); (err != nil) != tt.wantErr {
+			t.Errorf("%q: Run = %v, want error %v", tt.service, err, tt.wantErr)
+		}
+	}
+}
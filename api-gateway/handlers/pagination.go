@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// setPageLinks advertises the first and, if there is one, the next page of
+// a list in an RFC 8288 Link header. The links repeat the request's query
+// with only page_token replaced, so filters and page_size carry over. Links
+// already set, such as the successor-version link on a deprecated alias,
+// are kept.
+func setPageLinks(w http.ResponseWriter, r *http.Request, nextPageToken string) {
+	links := []string{pageLink(r, "", "first")}
+	if nextPageToken != "" {
+		links = append(links, pageLink(r, nextPageToken, "next"))
+	}
+	w.Header().Add("Link", strings.Join(links, ", "))
+}
+
+func pageLink(r *http.Request, pageToken, rel string) string {
+	query := r.URL.Query()
+	query.Del("page_token")
+	if pageToken != "" {
+		query.Set("page_token", pageToken)
+	}
+
+	target := r.URL.Path
+	if encoded := query.Encode(); encoded != "" {
+		target += "?" + encoded
+	}
+	return fmt.Sprintf("<%s>; rel=%q", target, rel)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/gorilla/mux"
+)
+
+func TestSetPageLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		next   string
+		want   string
+	}{
+		{
+			"last page",
+			"/api/v1/tasks",
+			"",
+			`</api/v1/tasks>; rel="first"`,
+		},
+		{
+			"next page",
+			"/api/v1/tasks",
+			"abc",
+			`</api/v1/tasks>; rel="first", </api/v1/tasks?page_token=abc>; rel="next"`,
+		},
+		{
+			"filters carry over",
+			"/api/v1/tasks?status=DONE&assignee_id=user-001&page_size=5",
+			"abc",
+			`</api/v1/tasks?assignee_id=user-001&page_size=5&status=DONE>; rel="first", ` +
+				`</api/v1/tasks?assignee_id=user-001&page_size=5&page_token=abc&status=DONE>; rel="next"`,
+		},
+		{
+			"token replaced",
+			"/api/v1/users?page_token=old&page_size=2",
+			"new",
+			`</api/v1/users?page_size=2>; rel="first", </api/v1/users?page_size=2&page_token=new>; rel="next"`,
+		},
+		{
+			"token escaped",
+			"/api/v1/users",
+			"a+b/c=",
+			`</api/v1/users>; rel="first", </api/v1/users?page_token=a%2Bb%2Fc%3D>; rel="next"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setPageLinks(w, httptest.NewRequest("GET", tt.target, nil), tt.next)
+			if got := w.Header().Get("Link"); got != tt.want {
+				t.Errorf("Link = %s\nwant   %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageLinksOnAlias(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/tasks", (&TaskHandler{client: &fakeTasks{}}).ListTasks)
+	handler := middleware.DeprecatedAlias("/api", "/v1", func() middleware.AliasConfig {
+		return middleware.AliasConfig{Enabled: true}
+	})(r)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tasks?page_size=5", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	want := []string{
+		`</api/v1/tasks>; rel="successor-version"`,
+		`</api/v1/tasks?page_size=5>; rel="first"`,
+	}
+	if got := rec.Header().Values("Link"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Link = %q\nwant   %q", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SearchResult is one hit of a cross-entity search. Type says which of Task
+// and User is set.
+type SearchResult struct {
+	Type  string     `json:"type"`
+	Score float64    `json:"score"`
+	Task  *ProtoJSON `json:"task,omitempty"`
+	User  *ProtoJSON `json:"user,omitempty"`
+}
+
+// SearchSource reports how one of the searched services answered. Status
+// is "ok" or "error"; a failed source contributes no results.
+type SearchSource struct {
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+type SearchResponse struct {
+	Query   string                  `json:"query"`
+	Results []SearchResult          `json:"results"`
+	Sources map[string]SearchSource `json:"sources"`
+	// Partial is set when some source failed and its results are missing.
+	Partial bool `json:"partial"`
+}
+
+// SearchHandler searches tasks and users at the same time and merges the
+// hits into one ranking. Both services score hits the same way, so their
+// scores can be compared directly.
+type SearchHandler struct {
+	tasks taskpb.TaskServiceClient
+	users userpb.UserServiceClient
+}
+
+func NewSearchHandler(tasks taskpb.TaskServiceClient, users userpb.UserServiceClient) *SearchHandler {
+	return &SearchHandler{tasks: tasks, users: users}
+}
+
+// searchSource is one service taking part in a search.
+type searchSource struct {
+	name   string
+	search func(ctx context.Context, query string, limit int32) ([]SearchResult, error)
+}
+
+func (h *SearchHandler) sources() []searchSource {
+	return []searchSource{
+		{name: "tasks", search: h.searchTasks},
+		{name: "users", search: h.searchUsers},
+	}
+}
+
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "Search")
+	defer span.End()
+
+	query := r.URL.Query().Get("q")
+	// The validator bounds limit, but it can be switched off
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 10
+	}
+
+	span.SetAttributes(
+		attribute.String("search.query", query),
+		attribute.Int("search.limit", limit),
+	)
+
+	if query == "" {
+		span.SetStatus(codes.Error, "Missing query")
+		requestid.Error(w, r, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	// Every source runs in its own child span, so the fan-out shows up as
+	// overlapping siblings in the trace
+	sources := h.sources()
+	results := make([][]SearchResult, len(sources))
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src searchSource) {
+			defer wg.Done()
+
+			srcCtx, srcSpan := tracing.GetTracer().Start(ctx, "Search."+src.name)
+			defer srcSpan.End()
+
+			srcSpan.SetAttributes(attribute.String("search.source", src.name))
+
+			results[i], errs[i] = src.search(srcCtx, query, int32(limit))
+			if errs[i] != nil {
+				srcSpan.RecordError(errs[i])
+				srcSpan.SetStatus(codes.Error, "Source failed")
+				return
+			}
+			srcSpan.SetAttributes(attribute.Int("result.count", len(results[i])))
+		}(i, src)
+	}
+	wg.Wait()
+
+	resp := SearchResponse{
+		Query:   query,
+		Results: []SearchResult{},
+		Sources: make(map[string]SearchSource, len(sources)),
+	}
+	var firstErr error
+	for i, src := range sources {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			resp.Partial = true
+			resp.Sources[src.name] = SearchSource{
+				Status: "error",
+				Error:  status.Convert(errs[i]).Message(),
+				Code:   status.Code(errs[i]).String(),
+			}
+			continue
+		}
+		resp.Sources[src.name] = SearchSource{Status: "ok", Count: len(results[i])}
+		resp.Results = append(resp.Results, results[i]...)
+	}
+
+	// With nothing to show, answer as a plain failure of the first source
+	if allFailed(errs) {
+		span.SetStatus(codes.Error, "All sources failed")
+		writeRPCError(w, r, firstErr, http.StatusBadGateway, "Search failed")
+		return
+	}
+
+	sort.SliceStable(resp.Results, func(i, j int) bool { return resp.Results[i].Score > resp.Results[j].Score })
+	if len(resp.Results) > limit {
+		resp.Results = resp.Results[:limit]
+	}
+
+	span.SetAttributes(
+		attribute.Int("result.count", len(resp.Results)),
+		attribute.Bool("search.partial", resp.Partial),
+	)
+	if resp.Partial {
+		span.SetStatus(codes.Error, "Some sources failed")
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *SearchHandler) searchTasks(ctx context.Context, query string, limit int32) ([]SearchResult, error) {
+	resp, err := h.tasks.SearchTasks(ctx, &taskpb.SearchTasksRequest{Query: query, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, len(resp.Hits))
+	for i, hit := range resp.Hits {
+		results[i] = SearchResult{Type: "task", Score: hit.Score, Task: &ProtoJSON{hit.Task}}
+	}
+	return results, nil
+}
+
+func (h *SearchHandler) searchUsers(ctx context.Context, query string, limit int32) ([]SearchResult, error) {
+	resp, err := h.users.SearchUsers(ctx, &userpb.SearchUsersRequest{Query: query, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, len(resp.Hits))
+	for i, hit := range resp.Hits {
+		results[i] = SearchResult{Type: "user", Score: hit.Score, User: &ProtoJSON{hit.User}}
+	}
+	return results, nil
+}
+
+func allFailed(errs []error) bool {
+	for _, err := range errs {
+		if err == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// taskSearch answers SearchTasks with fixed hits or an error.
+type taskSearch struct {
+	taskpb.TaskServiceClient
+	hits  []*taskpb.TaskHit
+	err   error
+	limit atomic.Int32 // of the last request
+}
+
+func (f *taskSearch) SearchTasks(_ context.Context, req *taskpb.SearchTasksRequest, _ ...grpc.CallOption) (*taskpb.SearchTasksResponse, error) {
+	f.limit.Store(req.Limit)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &taskpb.SearchTasksResponse{Hits: f.hits}, nil
+}
+
+// userSearch answers SearchUsers with fixed hits or an error.
+type userSearch struct {
+	userpb.UserServiceClient
+	hits  []*userpb.UserHit
+	err   error
+	limit atomic.Int32
+}
+
+func (f *userSearch) SearchUsers(_ context.Context, req *userpb.SearchUsersRequest, _ ...grpc.CallOption) (*userpb.SearchUsersResponse, error) {
+	f.limit.Store(req.Limit)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &userpb.SearchUsersResponse{Hits: f.hits}, nil
+}
+
+func newSearch(taskErr, userErr error) (*SearchHandler, *taskSearch, *userSearch) {
+	tasks := &taskSearch{err: taskErr, hits: []*taskpb.TaskHit{
+		{Task: testTask("t1", ""), Score: 0.9},
+		{Task: testTask("t2", ""), Score: 0.5},
+	}}
+	users := &userSearch{err: userErr, hits: []*userpb.UserHit{
+		{User: &userpb.User{Id: "user-1", Name: "Alice"}, Score: 0.75},
+		{User: &userpb.User{Id: "user-2", Name: "Bob"}, Score: 0.5},
+	}}
+	return NewSearchHandler(tasks, users), tasks, users
+}
+
+func serveSearch(h *SearchHandler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.Search(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// decodeSearch decodes a search response, listing its results as type:id.
+func decodeSearch(t *testing.T, rec *httptest.ResponseRecorder) (ids []string, sources map[string]SearchSource, partial bool) {
+	t.Helper()
+	var resp struct {
+		Query   string
+		Results []struct {
+			Type string
+			Task *struct{ ID string }
+			User *struct{ ID string }
+		}
+		Sources map[string]SearchSource
+		Partial bool
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body, err)
+	}
+	if resp.Query != "alice" {
+		t.Errorf("query = %q, want alice", resp.Query)
+	}
+	for _, r := range resp.Results {
+		switch {
+		case r.Type == "task" && r.Task != nil && r.User == nil:
+			ids = append(ids, "task:"+r.Task.ID)
+		case r.Type == "user" && r.User != nil && r.Task == nil:
+			ids = append(ids, "user:"+r.User.ID)
+		default:
+			t.Errorf("result of type %q sets the wrong item: %s", r.Type, rec.Body)
+		}
+	}
+	return ids, resp.Sources, resp.Partial
+}
+
+func TestSearchRanking(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		wantLimit int32 // passed on to each source
+		want      []string
+	}{
+		// Equal scores keep tasks ahead of users
+		{"merged by score", "/api/v1/search?q=alice", 10, []string{"task:t1", "user:user-1", "task:t2", "user:user-2"}},
+		{"limit", "/api/v1/search?q=alice&limit=2", 2, []string{"task:t1", "user:user-1"}},
+		{"limit above the hits", "/api/v1/search?q=alice&limit=50", 50, []string{"task:t1", "user:user-1", "task:t2", "user:user-2"}},
+		{"invalid limit", "/api/v1/search?q=alice&limit=-1", 10, []string{"task:t1", "user:user-1", "task:t2", "user:user-2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, tasks, users := newSearch(nil, nil)
+			rec := serveSearch(h, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if tasks.limit.Load() != tt.wantLimit || users.limit.Load() != tt.wantLimit {
+				t.Errorf("sources asked for %d and %d results, want %d", tasks.limit.Load(), users.limit.Load(), tt.wantLimit)
+			}
+
+			got, sources, partial := decodeSearch(t, rec)
+			if partial {
+				t.Error("partial = true without a failed source")
+			}
+			wantSources := map[string]SearchSource{"tasks": {Status: "ok", Count: 2}, "users": {Status: "ok", Count: 2}}
+			if !reflect.DeepEqual(sources, wantSources) {
+				t.Errorf("sources = %+v, want %+v", sources, wantSources)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("results = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchPartialFailure(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	tests := []struct {
+		name        string
+		taskErr     error
+		userErr     error
+		want        []string
+		wantSources map[string]SearchSource
+	}{
+		{
+			name:    "tasks failed",
+			taskErr: unavailable,
+			want:    []string{"user:user-1", "user:user-2"},
+			wantSources: map[string]SearchSource{
+				"tasks": {Status: "error", Error: "connection refused", Code: "Unavailable"},
+				"users": {Status: "ok", Count: 2},
+			},
+		},
+		{
+			name:    "users failed",
+			userErr: status.Error(codes.Internal, "failed to search users"),
+			want:    []string{"task:t1", "task:t2"},
+			wantSources: map[string]SearchSource{
+				"tasks": {Status: "ok", Count: 2},
+				"users": {Status: "error", Error: "failed to search users", Code: "Internal"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, _ := newSearch(tt.taskErr, tt.userErr)
+			rec := serveSearch(h, "/api/v1/search?q=alice")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+
+			got, sources, partial := decodeSearch(t, rec)
+			if !partial {
+				t.Error("partial = false with a failed source")
+			}
+			if !reflect.DeepEqual(sources, tt.wantSources) {
+				t.Errorf("sources = %+v, want %+v", sources, tt.wantSources)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("results = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	tests := []struct {
+		name    string
+		target  string
+		taskErr error
+		userErr error
+		want    int
+	}{
+		{"missing query", "/api/v1/search", nil, nil, http.StatusBadRequest},
+		{"empty query", "/api/v1/search?q=", nil, nil, http.StatusBadRequest},
+		{"every source unavailable", "/api/v1/search?q=alice", unavailable, unavailable, http.StatusServiceUnavailable},
+		{"every source failed", "/api/v1/search?q=alice", status.Error(codes.Internal, "boom"), unavailable, http.StatusBadGateway},
+		{"invalid query", "/api/v1/search?q=alice", status.Error(codes.InvalidArgument, "query too long"), unavailable, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, tasks, users := newSearch(tt.taskErr, tt.userErr)
+			rec := serveSearch(h, tt.target)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusBadRequest && tt.taskErr == nil && (tasks.limit.Load() != 0 || users.limit.Load() != 0) {
+				t.Error("sources searched without a query")
+			}
+		})
+	}
+}
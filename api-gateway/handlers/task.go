@@ -4,56 +4,60 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
-	"os"
 	"strconv"
-	"time"
 
 	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/api-gateway/upstream"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
 	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
 	"github.com/gorilla/mux"
-	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 type TaskHandler struct {
 	client taskpb.TaskServiceClient
-	conn   *grpc.ClientConn
+	users  userpb.UserServiceClient
+	conn   *upstream.Split
 }
 
-func NewTaskHandler() (*TaskHandler, error) {
-	taskServiceAddr := os.Getenv("TASK_SERVICE_ADDR")
-	if taskServiceAddr == "" {
-		taskServiceAddr = "localhost:8081"
-	}
-
-	conn, err := grpc.NewClient(
-		taskServiceAddr,
-		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-	if err != nil {
-		return nil, err
-	}
-
+func NewTaskHandler(conn *upstream.Split, users userpb.UserServiceClient) *TaskHandler {
 	return &TaskHandler{
 		client: taskpb.NewTaskServiceClient(conn),
+		users:  users,
 		conn:   conn,
-	}, nil
+	}
 }
 
 func (h *TaskHandler) Close() error {
 	return h.conn.Close()
 }
 
+func (h *TaskHandler) Client() taskpb.TaskServiceClient {
+	return h.client
+}
+
 type CreateTaskRequest struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	AssigneeID  string `json:"assignee_id"`
 }
 
+// TaskWithAssignee is a task with its assignee embedded, returned when the
+// client asks for ?expand=assignee. Assignee is null when the task has no
+// assignee or user-service does not know the ID.
+type TaskWithAssignee struct {
+	*taskpb.Task
+	Assignee *userpb.User `json:"assignee"`
+}
+
+type ExpandedListTasksResponse struct {
+	Tasks         []*TaskWithAssignee `json:"tasks"`
+	TotalCount    *int32              `json:"total_count,omitempty"`
+	NextPageToken string              `json:"next_page_token"`
+}
+
 type UpdateTaskRequest struct {
 	Title       string `json:"title,omitempty"`
 	Description string `json:"description,omitempty"`
@@ -61,6 +65,11 @@ type UpdateTaskRequest struct {
 	AssigneeID  string `json:"assignee_id,omitempty"`
 }
 
+// statusError is returned for a status that is not a TaskStatus name. It is
+// checked here as well as by the validation middleware, which can be turned
+// off, because an unknown name would otherwise silently mean TODO.
+const statusError = "status must be one of TODO, IN_PROGRESS, DONE"
+
 func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 	ctx, span := tracing.GetTracer().Start(r.Context(), "CreateTask")
 	defer span.End()
@@ -69,7 +78,7 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to decode request")
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
@@ -84,22 +93,17 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 		AssigneeId:  req.AssigneeID,
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
 	task, err := h.client.CreateTask(ctx, pbReq)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to create task")
-		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to create task")
 		return
 	}
 
 	span.SetAttributes(attribute.String("task.id", task.Id))
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(task)
+	writeProto(w, http.StatusCreated, task)
 }
 
 func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
@@ -113,19 +117,28 @@ func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 
 	pbReq := &taskpb.GetTaskRequest{Id: taskID}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
 	task, err := h.client.GetTask(ctx, pbReq)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to get task")
-		http.Error(w, "Task not found", http.StatusNotFound)
+		writeRPCError(w, r, err, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	if expandAssignee(r) {
+		expanded, err := h.expandAssignees(ctx, []*taskpb.Task{task})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to expand assignee")
+			writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to expand assignee")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, expanded[0])
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(task)
+	writeProto(w, http.StatusOK, task)
 }
 
 func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
@@ -133,51 +146,68 @@ func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 
 	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
-	pageNumber, _ := strconv.Atoi(r.URL.Query().Get("page_number"))
+	pageToken := r.URL.Query().Get("page_token")
+	includeTotalCount, _ := strconv.ParseBool(r.URL.Query().Get("include_total_count"))
 	assigneeID := r.URL.Query().Get("assignee_id")
 	status := r.URL.Query().Get("status")
 
 	if pageSize == 0 {
 		pageSize = 10
 	}
-	if pageNumber == 0 {
-		pageNumber = 1
-	}
 
 	span.SetAttributes(
 		attribute.Int("page.size", pageSize),
-		attribute.Int("page.number", pageNumber),
+		attribute.Bool("page.token", pageToken != ""),
 		attribute.String("filter.assignee_id", assigneeID),
 		attribute.String("filter.status", status),
 	)
 
 	pbReq := &taskpb.ListTasksRequest{
-		PageSize:   int32(pageSize),
-		PageNumber: int32(pageNumber),
-		AssigneeId: assigneeID,
+		PageSize:          int32(pageSize),
+		PageToken:         pageToken,
+		IncludeTotalCount: includeTotalCount,
+		AssigneeId:        assigneeID,
 	}
 
 	if status != "" {
-		if s, ok := taskpb.TaskStatus_value[status]; ok {
-			pbReq.Status = taskpb.TaskStatus(s)
+		s, ok := taskpb.TaskStatus_value[status]
+		if !ok {
+			span.SetStatus(codes.Error, "Invalid status")
+			requestid.Error(w, r, statusError, http.StatusBadRequest)
+			return
 		}
+		pbReq.Status = taskpb.TaskStatus(s).Enum()
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
 	resp, err := h.client.ListTasks(ctx, pbReq)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to list tasks")
-		http.Error(w, "Failed to list tasks", http.StatusInternalServerError)
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to list tasks")
 		return
 	}
 
 	span.SetAttributes(attribute.Int("result.count", len(resp.Tasks)))
+	setPageLinks(w, r, resp.NextPageToken)
+
+	if expandAssignee(r) {
+		expanded, err := h.expandAssignees(ctx, resp.Tasks)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to expand assignees")
+			writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to expand assignees")
+			return
+		}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+		writeJSON(w, http.StatusOK, ExpandedListTasksResponse{
+			Tasks:         expanded,
+			TotalCount:    resp.TotalCount,
+			NextPageToken: resp.NextPageToken,
+		})
+		return
+	}
+
+	writeProto(w, http.StatusOK, resp)
 }
 
 func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
@@ -193,7 +223,7 @@ func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to decode request")
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
@@ -205,24 +235,24 @@ func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if req.Status != "" {
-		if s, ok := taskpb.TaskStatus_value[req.Status]; ok {
-			pbReq.Status = taskpb.TaskStatus(s)
+		s, ok := taskpb.TaskStatus_value[req.Status]
+		if !ok {
+			span.SetStatus(codes.Error, "Invalid status")
+			requestid.Error(w, r, statusError, http.StatusBadRequest)
+			return
 		}
+		pbReq.Status = taskpb.TaskStatus(s)
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
 	task, err := h.client.UpdateTask(ctx, pbReq)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to update task")
-		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to update task")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(task)
+	writeProto(w, http.StatusOK, task)
 }
 
 func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
@@ -236,20 +266,63 @@ func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 
 	pbReq := &taskpb.DeleteTaskRequest{Id: taskID}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
 	_, err := h.client.DeleteTask(ctx, pbReq)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to delete task")
-		http.Error(w, "Failed to delete task", http.StatusInternalServerError)
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to delete task")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// expandAssignees resolves the assignees of all tasks with a single
+// GetUsersByIds call instead of one GetUser call per task.
+func (h *TaskHandler) expandAssignees(ctx context.Context, tasks []*taskpb.Task) ([]*TaskWithAssignee, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "ExpandAssignees")
+	defer span.End()
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, task := range tasks {
+		if task.AssigneeId != "" && !seen[task.AssigneeId] {
+			seen[task.AssigneeId] = true
+			ids = append(ids, task.AssigneeId)
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("task.count", len(tasks)),
+		attribute.Int("assignee.distinct_count", len(ids)),
+	)
+
+	users := make(map[string]*userpb.User, len(ids))
+	if len(ids) > 0 {
+		resp, err := h.users.GetUsersByIds(ctx, &userpb.GetUsersByIdsRequest{Ids: ids})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to get users by IDs")
+			return nil, err
+		}
+		for _, user := range resp.Users {
+			users[user.Id] = user
+		}
+	}
+
+	span.SetAttributes(attribute.Int("assignee.unknown_count", len(ids)-len(users)))
+
+	expanded := make([]*TaskWithAssignee, len(tasks))
+	for i, task := range tasks {
+		expanded[i] = &TaskWithAssignee{Task: task, Assignee: users[task.AssigneeId]}
+	}
+	return expanded, nil
+}
+
+func expandAssignee(r *http.Request) bool {
+	return r.URL.Query().Get("expand") == "assignee"
+}
+
 func (h *TaskHandler) GetTaskTrace(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	taskID := vars["id"]
@@ -262,6 +335,5 @@ func (h *TaskHandler) GetTaskTrace(w http.ResponseWriter, r *http.Request) {
 		"jaeger_url": "http://localhost:16686/search?service=task-service&tags={\"task.id\":\"" + taskID + "\"}",
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, http.StatusOK, response)
 }
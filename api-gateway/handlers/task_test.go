@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var testCreatedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeTasks serves a fixed set of tasks.
+type fakeTasks struct {
+	taskpb.TaskServiceClient
+	tasks []*taskpb.Task
+}
+
+func (f *fakeTasks) GetTask(_ context.Context, req *taskpb.GetTaskRequest, _ ...grpc.CallOption) (*taskpb.Task, error) {
+	for _, task := range f.tasks {
+		if task.Id == req.Id {
+			return task, nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "task not found")
+}
+
+func (f *fakeTasks) ListTasks(_ context.Context, req *taskpb.ListTasksRequest, _ ...grpc.CallOption) (*taskpb.ListTasksResponse, error) {
+	resp := &taskpb.ListTasksResponse{Tasks: f.tasks}
+	if req.IncludeTotalCount {
+		resp.TotalCount = proto.Int32(int32(len(f.tasks)))
+	}
+	return resp, nil
+}
+
+// fakeUsers knows user-1 and user-2 and records every GetUsersByIds call.
+type fakeUsers struct {
+	userpb.UserServiceClient
+	err   error
+	calls [][]string
+}
+
+func (f *fakeUsers) GetUsersByIds(_ context.Context, req *userpb.GetUsersByIdsRequest, _ ...grpc.CallOption) (*userpb.GetUsersByIdsResponse, error) {
+	f.calls = append(f.calls, req.Ids)
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := &userpb.GetUsersByIdsResponse{}
+	for _, id := range req.Ids {
+		if id == "user-1" || id == "user-2" {
+			resp.Users = append(resp.Users, &userpb.User{Id: id, Name: "Name of " + id})
+		}
+	}
+	return resp, nil
+}
+
+func testTask(id, assignee string) *taskpb.Task {
+	return &taskpb.Task{Id: id, Title: "Task " + id, AssigneeId: assignee, CreatedAt: timestamppb.New(testCreatedAt)}
+}
+
+func serveTasks(h *TaskHandler, target string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/tasks", h.ListTasks)
+	r.HandleFunc("/api/v1/tasks/{id}", h.GetTask)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestGetTaskExpandAssignee(t *testing.T) {
+	tasks := &fakeTasks{tasks: []*taskpb.Task{
+		testTask("t1", "user-1"),
+		testTask("t2", "user-9"),
+		testTask("t3", ""),
+	}}
+	tests := []struct {
+		name     string
+		target   string
+		assignee interface{} // decoded "assignee", or absent if nil
+		expanded bool
+		lookups  [][]string
+	}{
+		{"not expanded", "/api/v1/tasks/t1", nil, false, nil},
+		{"other expansion", "/api/v1/tasks/t1?expand=comments", nil, false, nil},
+		{"known assignee", "/api/v1/tasks/t1?expand=assignee", map[string]interface{}{"id": "user-1", "name": "Name of user-1", "email": "", "created_at": nil}, true, [][]string{{"user-1"}}},
+		{"unknown assignee", "/api/v1/tasks/t2?expand=assignee", nil, true, [][]string{{"user-9"}}},
+		{"no assignee", "/api/v1/tasks/t3?expand=assignee", nil, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeUsers{}
+			rec := serveTasks(&TaskHandler{client: tasks, users: users}, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body, err)
+			}
+			assignee, ok := body["assignee"]
+			if ok != tt.expanded {
+				t.Errorf("assignee present = %v, want %v", ok, tt.expanded)
+			}
+			if !reflect.DeepEqual(assignee, tt.assignee) {
+				t.Errorf("assignee = %v, want %v", assignee, tt.assignee)
+			}
+			// The task itself keeps its protojson rendering
+			if body["created_at"] != "2024-05-01T12:00:00Z" || body["status"] != "TODO" {
+				t.Errorf("task rendered as %s", rec.Body)
+			}
+			if !reflect.DeepEqual(users.calls, tt.lookups) {
+				t.Errorf("lookups = %v, want %v", users.calls, tt.lookups)
+			}
+		})
+	}
+}
+
+func TestListTasksExpandAssignee(t *testing.T) {
+	tasks := &fakeTasks{tasks: []*taskpb.Task{
+		testTask("t1", "user-1"),
+		testTask("t2", "user-2"),
+		testTask("t3", "user-1"),
+		testTask("t4", "user-9"),
+		testTask("t5", ""),
+	}}
+	users := &fakeUsers{}
+
+	rec := serveTasks(&TaskHandler{client: tasks, users: users}, "/api/v1/tasks?expand=assignee")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	// One lookup for the distinct assignees of the page
+	if len(users.calls) != 1 {
+		t.Fatalf("%d lookups, want 1", len(users.calls))
+	}
+	ids := append([]string(nil), users.calls[0]...)
+	sort.Strings(ids)
+	if want := []string{"user-1", "user-2", "user-9"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("looked up %v, want %v", ids, want)
+	}
+
+	var body struct {
+		Tasks []struct {
+			ID       string `json:"id"`
+			Assignee *struct {
+				ID string `json:"id"`
+			} `json:"assignee"`
+		} `json:"tasks"`
+		TotalCount *int32 `json:"total_count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body, err)
+	}
+	want := map[string]string{"t1": "user-1", "t2": "user-2", "t3": "user-1", "t4": "", "t5": ""}
+	if len(body.Tasks) != len(want) {
+		t.Fatalf("%d tasks, want %d", len(body.Tasks), len(want))
+	}
+	for _, task := range body.Tasks {
+		got := ""
+		if task.Assignee != nil {
+			got = task.Assignee.ID
+		}
+		if got != want[task.ID] {
+			t.Errorf("task %s: assignee %q, want %q", task.ID, got, want[task.ID])
+		}
+	}
+	if body.TotalCount != nil {
+		t.Errorf("total_count = %d without include_total_count", *body.TotalCount)
+	}
+
+	rec = serveTasks(&TaskHandler{client: tasks, users: &fakeUsers{}}, "/api/v1/tasks?expand=assignee&include_total_count=true")
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body, err)
+	}
+	if body.TotalCount == nil || *body.TotalCount != 5 {
+		t.Errorf("total_count = %v, want 5", body.TotalCount)
+	}
+}
+
+func TestExpandAssigneeFailure(t *testing.T) {
+	tasks := &fakeTasks{tasks: []*taskpb.Task{testTask("t1", "user-1")}}
+	for _, target := range []string{"/api/v1/tasks/t1?expand=assignee", "/api/v1/tasks?expand=assignee"} {
+		users := &fakeUsers{err: status.Error(codes.Unavailable, "down")}
+		rec := serveTasks(&TaskHandler{client: tasks, users: users}, target)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s with user-service down: status = %d, want 503", target, rec.Code)
+		}
+	}
+}
@@ -1,54 +1,39 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
-	"os"
 	"strconv"
-	"time"
 
 	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/api-gateway/upstream"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
 	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
 	"github.com/gorilla/mux"
-	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 type UserHandler struct {
 	client userpb.UserServiceClient
-	conn   *grpc.ClientConn
+	conn   *upstream.Conn
 }
 
-func NewUserHandler() (*UserHandler, error) {
-	userServiceAddr := os.Getenv("USER_SERVICE_ADDR")
-	if userServiceAddr == "" {
-		userServiceAddr = "localhost:8082"
-	}
-
-	conn, err := grpc.NewClient(
-		userServiceAddr,
-		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
+func NewUserHandler(conn *upstream.Conn) *UserHandler {
 	return &UserHandler{
 		client: userpb.NewUserServiceClient(conn),
 		conn:   conn,
-	}, nil
+	}
 }
 
 func (h *UserHandler) Close() error {
 	return h.conn.Close()
 }
 
+func (h *UserHandler) Client() userpb.UserServiceClient {
+	return h.client
+}
+
 type CreateUserRequest struct {
 	Name  string `json:"name"`
 	Email string `json:"email"`
@@ -62,7 +47,7 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to decode request")
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
@@ -76,22 +61,17 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		Email: req.Email,
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
 	user, err := h.client.CreateUser(ctx, pbReq)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to create user")
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to create user")
 		return
 	}
 
 	span.SetAttributes(attribute.String("user.id", user.Id))
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+	writeProto(w, http.StatusCreated, user)
 }
 
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
@@ -105,19 +85,15 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 
 	pbReq := &userpb.GetUserRequest{Id: userID}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
 	user, err := h.client.GetUser(ctx, pbReq)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to get user")
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeRPCError(w, r, err, http.StatusNotFound, "User not found")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	writeProto(w, http.StatusOK, user)
 }
 
 func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
@@ -125,38 +101,34 @@ func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 
 	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
-	pageNumber, _ := strconv.Atoi(r.URL.Query().Get("page_number"))
+	pageToken := r.URL.Query().Get("page_token")
+	includeTotalCount, _ := strconv.ParseBool(r.URL.Query().Get("include_total_count"))
 
 	if pageSize == 0 {
 		pageSize = 10
 	}
-	if pageNumber == 0 {
-		pageNumber = 1
-	}
 
 	span.SetAttributes(
 		attribute.Int("page.size", pageSize),
-		attribute.Int("page.number", pageNumber),
+		attribute.Bool("page.token", pageToken != ""),
 	)
 
 	pbReq := &userpb.ListUsersRequest{
-		PageSize:   int32(pageSize),
-		PageNumber: int32(pageNumber),
+		PageSize:          int32(pageSize),
+		PageToken:         pageToken,
+		IncludeTotalCount: includeTotalCount,
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
 	resp, err := h.client.ListUsers(ctx, pbReq)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to list users")
-		http.Error(w, "Failed to list users", http.StatusInternalServerError)
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to list users")
 		return
 	}
 
 	span.SetAttributes(attribute.Int("result.count", len(resp.Users)))
+	setPageLinks(w, r, resp.NextPageToken)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	writeProto(w, http.StatusOK, resp)
 }
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/api-gateway/upstream"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type WebhookHandler struct {
+	client taskpb.WebhookServiceClient
+}
+
+// NewWebhookHandler serves webhook subscriptions, which live in task-service
+// next to the events they deliver.
+func NewWebhookHandler(conn *upstream.Split) *WebhookHandler {
+	return &WebhookHandler{client: taskpb.NewWebhookServiceClient(conn)}
+}
+
+// WebhookRequest creates or replaces a webhook. EventTypes holds
+// TaskEventType names; empty subscribes to all of them.
+type WebhookRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	AssigneeID string   `json:"assignee_id"`
+	// Secret is only read on creation; one is generated when it is empty.
+	Secret string `json:"secret,omitempty"`
+}
+
+func (req *WebhookRequest) eventTypes() ([]taskpb.TaskEventType, error) {
+	types := make([]taskpb.TaskEventType, 0, len(req.EventTypes))
+	for _, name := range req.EventTypes {
+		t, ok := taskpb.TaskEventType_value[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown event type %q", name)
+		}
+		types = append(types, taskpb.TaskEventType(t))
+	}
+	return types, nil
+}
+
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "CreateWebhook")
+	defer span.End()
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to decode request")
+		requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	eventTypes, err := req.eventTypes()
+	if err != nil {
+		span.SetStatus(codes.Error, "Invalid event type")
+		requestid.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("webhook.url", req.URL),
+		attribute.StringSlice("webhook.event_types", req.EventTypes),
+		attribute.String("webhook.assignee_id", req.AssigneeID),
+	)
+
+	webhook, err := h.client.CreateWebhook(ctx, &taskpb.CreateWebhookRequest{
+		Url:        req.URL,
+		EventTypes: eventTypes,
+		AssigneeId: req.AssigneeID,
+		Secret:     req.Secret,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to create webhook")
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	span.SetAttributes(attribute.String("webhook.id", webhook.Id))
+
+	w.Header().Set("Location", "/api/v1/webhooks/"+webhook.Id)
+	writeProto(w, http.StatusCreated, webhook)
+}
+
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "GetWebhook")
+	defer span.End()
+
+	webhookID := mux.Vars(r)["id"]
+	span.SetAttributes(attribute.String("webhook.id", webhookID))
+
+	webhook, err := h.client.GetWebhook(ctx, &taskpb.GetWebhookRequest{Id: webhookID})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to get webhook")
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to get webhook")
+		return
+	}
+
+	writeProto(w, http.StatusOK, webhook)
+}
+
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "ListWebhooks")
+	defer span.End()
+
+	resp, err := h.client.ListWebhooks(ctx, &taskpb.ListWebhooksRequest{})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to list webhooks")
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to list webhooks")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(resp.Webhooks)))
+
+	writeProto(w, http.StatusOK, resp)
+}
+
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "UpdateWebhook")
+	defer span.End()
+
+	webhookID := mux.Vars(r)["id"]
+	span.SetAttributes(attribute.String("webhook.id", webhookID))
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to decode request")
+		requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	eventTypes, err := req.eventTypes()
+	if err != nil {
+		span.SetStatus(codes.Error, "Invalid event type")
+		requestid.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.client.UpdateWebhook(ctx, &taskpb.UpdateWebhookRequest{
+		Id:         webhookID,
+		Url:        req.URL,
+		EventTypes: eventTypes,
+		AssigneeId: req.AssigneeID,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update webhook")
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to update webhook")
+		return
+	}
+
+	writeProto(w, http.StatusOK, webhook)
+}
+
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "DeleteWebhook")
+	defer span.End()
+
+	webhookID := mux.Vars(r)["id"]
+	span.SetAttributes(attribute.String("webhook.id", webhookID))
+
+	if _, err := h.client.DeleteWebhook(ctx, &taskpb.DeleteWebhookRequest{Id: webhookID}); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete webhook")
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *WebhookHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.GetTracer().Start(r.Context(), "ListDeadLetters")
+	defer span.End()
+
+	webhookID := mux.Vars(r)["id"]
+	span.SetAttributes(attribute.String("webhook.id", webhookID))
+
+	resp, err := h.client.ListDeadLetters(ctx, &taskpb.ListDeadLettersRequest{WebhookId: webhookID})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to list dead letters")
+		writeRPCError(w, r, err, http.StatusInternalServerError, "Failed to list dead letters")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(resp.DeadLetters)))
+
+	writeProto(w, http.StatusOK, resp)
+}
@@ -0,0 +1,85 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval bounds how often expired records are purged.
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store local to one gateway process. Records do not
+// survive a restart and are not shared between replicas.
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]*record
+	lastSweep time.Time
+}
+
+type record struct {
+	fingerprint string
+	response    *Response
+	ttl         time.Duration
+	expiresAt   time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]*record),
+	}
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, key, fingerprint string, lease, ttl time.Duration) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepLocked(now)
+
+	if rec, ok := s.records[key]; ok && now.Before(rec.expiresAt) {
+		switch {
+		case rec.fingerprint != fingerprint:
+			return nil, ErrMismatch
+		case rec.response == nil:
+			return nil, ErrInFlight
+		}
+		return rec.response, nil
+	}
+
+	s.records[key] = &record{fingerprint: fingerprint, ttl: ttl, expiresAt: now.Add(lease)}
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, key string, resp *Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.records[key]; ok {
+		rec.response = resp
+		rec.expiresAt = time.Now().Add(rec.ttl)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.records[key]; ok && rec.response == nil {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, rec := range s.records {
+		if !now.Before(rec.expiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
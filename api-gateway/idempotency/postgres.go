@@ -0,0 +1,162 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore shares idempotency records between gateway replicas.
+type PostgresStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+// NewPostgresStore connects to dsn and creates the records table if needed.
+func NewPostgresStore(ctx context.Context, dsn string) (*PostgresStore, error) {
+	db, err := otelsql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	query := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key VARCHAR(512) PRIMARY KEY,
+		fingerprint VARCHAR(64) NOT NULL,
+		ttl_seconds INTEGER NOT NULL,
+		status INTEGER,
+		header JSONB,
+		body BYTEA,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+	`
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// claimAttempts bounds how often Claim retries when the record it lost the
+// key to disappears before it can be read.
+const claimAttempts = 3
+
+func (s *PostgresStore) Claim(ctx context.Context, key, fingerprint string, lease, ttl time.Duration) (*Response, error) {
+	s.purgeExpired(ctx)
+
+	for attempt := 0; attempt < claimAttempts; attempt++ {
+		resp, err := s.claim(ctx, key, fingerprint, lease, ttl)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return resp, err
+		}
+		// The holder released the key between the upsert and the read;
+		// it is free now, so try to take it again.
+	}
+	return nil, ErrInFlight
+}
+
+func (s *PostgresStore) claim(ctx context.Context, key, fingerprint string, lease, ttl time.Duration) (*Response, error) {
+	// Take the key if it is free or its record has expired. When another
+	// request holds it, the upsert changes nothing and returns no row.
+	query := `
+		INSERT INTO idempotency_keys (key, fingerprint, ttl_seconds, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4::bigint * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, ttl_seconds = EXCLUDED.ttl_seconds,
+			status = NULL, header = NULL, body = NULL, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+		RETURNING key
+	`
+	var claimed string
+	err := s.db.QueryRowContext(ctx, query, key, fingerprint, int64(ttl/time.Second), lease.Milliseconds()).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var (
+		storedFingerprint string
+		status            sql.NullInt64
+		header            []byte
+		body              []byte
+	)
+	err = s.db.QueryRowContext(ctx,
+		`SELECT fingerprint, status, header, body FROM idempotency_keys WHERE key = $1`, key,
+	).Scan(&storedFingerprint, &status, &header, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case storedFingerprint != fingerprint:
+		return nil, ErrMismatch
+	case !status.Valid:
+		return nil, ErrInFlight
+	}
+
+	resp := &Response{Status: int(status.Int64), Header: http.Header{}, Body: body}
+	if len(header) > 0 {
+		if err := json.Unmarshal(header, &resp.Header); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, key string, resp *Response) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status = $2, header = $3, body = $4, expires_at = NOW() + ttl_seconds * INTERVAL '1 second'
+		WHERE key = $1
+	`, key, resp.Status, header, resp.Body)
+	return err
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND status IS NULL`, key)
+	return err
+}
+
+// purgeExpired deletes expired records at most once per sweepInterval.
+// Claim ignores them either way; purging only keeps the table small.
+func (s *PostgresStore) purgeExpired(ctx context.Context) {
+	s.mu.Lock()
+	due := time.Since(s.lastPurge) >= sweepInterval
+	if due {
+		s.lastPurge = time.Now()
+	}
+	s.mu.Unlock()
+
+	if due {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`); err != nil {
+			slog.ErrorContext(ctx, "Failed to purge idempotency keys", "error", err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Package idempotency remembers the responses to requests that carried an
+// Idempotency-Key so that a retried request gets the original response
+// instead of being executed twice.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrInFlight means the key is claimed by a request that has not
+	// finished yet.
+	ErrInFlight = errors.New("idempotency: request with this key is in flight")
+	// ErrMismatch means the key was first used for a different request.
+	ErrMismatch = errors.New("idempotency: key reused with a different request")
+)
+
+// Response is a stored HTTP response.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Store keeps idempotency records. Keys are opaque to the store; callers
+// scope them by tenant and route.
+type Store interface {
+	// Claim reserves key for a request with the given fingerprint. The
+	// claim lapses after lease unless it is completed, so that the key of a
+	// request whose gateway died frees up again. It returns nil, nil when
+	// the key was free, the stored response when a request with the same
+	// fingerprint already completed, ErrInFlight while that request is
+	// still running and ErrMismatch when the fingerprints differ.
+	Claim(ctx context.Context, key, fingerprint string, lease, ttl time.Duration) (*Response, error)
+	// Complete stores the response for a claimed key. The record expires
+	// ttl after completion.
+	Complete(ctx context.Context, key string, resp *Response) error
+	// Release drops a claim without storing a response, so that the request
+	// can be retried with the same key.
+	Release(ctx context.Context, key string) error
+}
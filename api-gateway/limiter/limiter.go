@@ -0,0 +1,229 @@
+// Package limiter caps the number of requests in flight to an upstream with
+// an AIMD (additive increase, multiplicative decrease) limit. The limit
+// grows slowly while the upstream answers quickly and shrinks sharply when
+// it slows down or reports overload, so excess load is rejected at once
+// instead of queueing until request deadlines expire.
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Priority int
+
+const (
+	// Read requests may use the whole limit.
+	Read Priority = iota
+	// Write requests are shed first: they may not use the part of the limit
+	// reserved for reads.
+	Write
+)
+
+func (p Priority) String() string {
+	if p == Read {
+		return "read"
+	}
+	return "write"
+}
+
+type Config struct {
+	Enabled      bool
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// LatencyThreshold is the latency above which a request counts as a
+	// sign of congestion.
+	LatencyThreshold time.Duration
+	// BackoffRatio multiplies the limit on congestion, e.g. 0.9.
+	BackoffRatio float64
+	// ReadReserve is the fraction of the limit writes may not use.
+	ReadReserve float64
+	// RetryAfter is suggested to clients whose request was shed.
+	RetryAfter time.Duration
+}
+
+// ShedError is returned for a request rejected because the upstream is at
+// its limit.
+type ShedError struct {
+	Upstream   string
+	Priority   Priority
+	RetryAfter time.Duration
+}
+
+func (e *ShedError) Error() string {
+	return fmt.Sprintf("%s is overloaded; %s request shed", e.Upstream, e.Priority)
+}
+
+// Limiter is the limit for one upstream.
+type Limiter struct {
+	name   string
+	config func() Config
+
+	mu           sync.Mutex
+	limit        float64
+	inflight     int
+	lastDecrease time.Time
+
+	shed        metric.Int64Counter
+	adjustments metric.Int64Counter
+	attrs       metric.MeasurementOption
+}
+
+// New returns a limiter for the named upstream. config is read for every
+// request so that reloaded settings apply at once.
+func New(name string, config func() Config) *Limiter {
+	l := &Limiter{
+		name:   name,
+		config: config,
+		limit:  float64(config().InitialLimit),
+		attrs:  metric.WithAttributes(attribute.String("upstream", name)),
+	}
+
+	meter := tracing.GetMeter()
+	l.shed, _ = meter.Int64Counter("gateway.limiter.shed",
+		metric.WithDescription("Requests rejected because the upstream was at its concurrency limit."),
+		metric.WithUnit("{request}"))
+	l.adjustments, _ = meter.Int64Counter("gateway.limiter.adjustments",
+		metric.WithDescription("Changes of the concurrency limit, by direction."),
+		metric.WithUnit("{change}"))
+	limitGauge, _ := meter.Int64ObservableGauge("gateway.limiter.limit",
+		metric.WithDescription("Current concurrency limit."),
+		metric.WithUnit("{request}"))
+	inflightGauge, _ := meter.Int64ObservableGauge("gateway.limiter.inflight",
+		metric.WithDescription("Requests currently in flight."),
+		metric.WithUnit("{request}"))
+	meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		limit, inflight := l.Snapshot()
+		o.ObserveInt64(limitGauge, int64(limit), l.attrs)
+		o.ObserveInt64(inflightGauge, int64(inflight), l.attrs)
+		return nil
+	}, limitGauge, inflightGauge)
+
+	return l
+}
+
+// Snapshot returns the current limit and number of requests in flight.
+func (l *Limiter) Snapshot() (limit, inflight int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit), l.inflight
+}
+
+// Acquire admits a request or rejects it with a *ShedError. An admitted
+// request must call done with its outcome when it finishes.
+func (l *Limiter) Acquire(ctx context.Context, priority Priority) (done func(err error), err error) {
+	cfg := l.config()
+	if !cfg.Enabled {
+		return func(error) {}, nil
+	}
+
+	l.mu.Lock()
+	l.limit = clamp(l.limit, cfg)
+	allowed := int(l.limit)
+	if priority == Write {
+		allowed = int(math.Ceil(l.limit * (1 - cfg.ReadReserve)))
+	}
+	if l.inflight >= allowed {
+		limit := int(l.limit)
+		l.mu.Unlock()
+
+		l.shed.Add(ctx, 1, l.attrs, metric.WithAttributes(attribute.String("priority", priority.String())))
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.Bool("limiter.shed", true),
+			attribute.String("limiter.upstream", l.name),
+			attribute.String("limiter.priority", priority.String()),
+			attribute.Int("limiter.limit", limit),
+		)
+		return nil, &ShedError{Upstream: l.name, Priority: priority, RetryAfter: cfg.RetryAfter}
+	}
+	l.inflight++
+	l.mu.Unlock()
+
+	start := time.Now()
+	var budget time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		budget = deadline.Sub(start)
+	}
+	return func(err error) {
+		l.release(ctx, cfg, start, overloaded(time.Since(start), budget, cfg, err))
+	}, nil
+}
+
+func (l *Limiter) release(ctx context.Context, cfg Config, start time.Time, congested bool) {
+	l.mu.Lock()
+	before := int(l.limit)
+	l.inflight--
+	switch {
+	case congested:
+		// Requests that started before the last decrease saw the old limit;
+		// counting them again would collapse the limit in one burst.
+		if start.After(l.lastDecrease) {
+			l.limit = clamp(l.limit*cfg.BackoffRatio, cfg)
+			l.lastDecrease = time.Now()
+		}
+	case l.inflight*2 >= before:
+		// Only grow while the limit is actually in use: +1 per limit's
+		// worth of successful requests.
+		l.limit = clamp(l.limit+1/l.limit, cfg)
+	}
+	after := int(l.limit)
+	inflight := l.inflight
+	l.mu.Unlock()
+
+	if after != before {
+		direction := "increase"
+		if after < before {
+			direction = "decrease"
+		}
+		l.adjustments.Add(ctx, 1, l.attrs, metric.WithAttributes(attribute.String("direction", direction)))
+		slog.Debug("Concurrency limit changed", "upstream", l.name, "from", before, "to", after, "inflight", inflight)
+	}
+}
+
+// budgetUsed is the share of its budget a request may have used for a
+// DeadlineExceeded to still be the upstream's own. The deadline the gateway
+// forwards expires on the upstream a little before it does here, so a
+// request whose budget ran out does not end exactly at the budget.
+const budgetUsed = 0.9
+
+// overloaded reports whether a finished request indicates congestion: it
+// was slow, or the upstream said it was unavailable or out of resources.
+// budget is the time the caller's deadline left the request, zero if it
+// had none. A DeadlineExceeded only counts when the upstream gave up well
+// before that budget ran out; a caller that asked for less time than the
+// upstream needs, say with Request-Timeout, says nothing about its load
+// beyond what the latency does.
+func overloaded(latency, budget time.Duration, cfg Config, err error) bool {
+	if cfg.LatencyThreshold > 0 && latency > cfg.LatencyThreshold {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	case codes.DeadlineExceeded:
+		return budget <= 0 || float64(latency) < float64(budget)*budgetUsed
+	}
+	return false
+}
+
+func clamp(limit float64, cfg Config) float64 {
+	if limit < float64(cfg.MinLimit) {
+		return float64(cfg.MinLimit)
+	}
+	if cfg.MaxLimit > 0 && limit > float64(cfg.MaxLimit) {
+		return float64(cfg.MaxLimit)
+	}
+	return limit
+}
@@ -0,0 +1,172 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testConfig() Config {
+	return Config{
+		Enabled:          true,
+		InitialLimit:     10,
+		MinLimit:         2,
+		MaxLimit:         12,
+		LatencyThreshold: 500 * time.Millisecond,
+		BackoffRatio:     0.5,
+		ReadReserve:      0.2,
+		RetryAfter:       time.Second,
+	}
+}
+
+func TestOverloaded(t *testing.T) {
+	tests := []struct {
+		name    string
+		latency time.Duration
+		budget  time.Duration
+		err     error
+		want    bool
+	}{
+		{"fast success", 10 * time.Millisecond, 0, nil, false},
+		{"slow success", time.Second, 0, nil, true},
+		{"at the threshold", 500 * time.Millisecond, 0, nil, false},
+		{"unavailable", 10 * time.Millisecond, 0, status.Error(codes.Unavailable, ""), true},
+		{"resource exhausted", 10 * time.Millisecond, 0, status.Error(codes.ResourceExhausted, ""), true},
+		{"not found", 10 * time.Millisecond, 0, status.Error(codes.NotFound, ""), false},
+		{"canceled by the client", 10 * time.Millisecond, time.Second, status.Error(codes.Canceled, ""), false},
+		{"non-gRPC error", 10 * time.Millisecond, 0, errors.New("boom"), false},
+		{"deadline without a budget", 100 * time.Millisecond, 0, status.Error(codes.DeadlineExceeded, ""), true},
+		{"deadline well within the budget", 100 * time.Millisecond, 5 * time.Second, status.Error(codes.DeadlineExceeded, ""), true},
+		{"short client budget used up", 100 * time.Millisecond, 100 * time.Millisecond, status.Error(codes.DeadlineExceeded, ""), false},
+		{"budget expired upstream first", 95 * time.Millisecond, 100 * time.Millisecond, status.Error(codes.DeadlineExceeded, ""), false},
+		{"budget used up slowly", 5 * time.Second, 5 * time.Second, status.Error(codes.DeadlineExceeded, ""), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overloaded(tt.latency, tt.budget, testConfig(), tt.err); got != tt.want {
+				t.Errorf("overloaded(%v, %v, %v) = %v, want %v", tt.latency, tt.budget, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReleaseSteps(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     float64
+		inflight  int
+		congested bool
+		// startedBeforeDecrease marks a request admitted before the last
+		// decrease
+		startedBeforeDecrease bool
+		want                  float64
+	}{
+		{"increase by one over the limit", 10, 8, false, false, 10.1},
+		{"no increase while mostly idle", 10, 3, false, false, 10},
+		{"increase up to the maximum", 12, 12, false, false, 12},
+		{"multiplicative decrease", 10, 3, true, false, 5},
+		{"decrease down to the minimum", 3, 1, true, false, 2},
+		{"one decrease per burst", 10, 3, true, true, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			l := New("test", func() Config { return cfg })
+			l.limit = tt.limit
+			l.inflight = tt.inflight
+
+			start := time.Now()
+			l.lastDecrease = start.Add(-time.Second)
+			if tt.startedBeforeDecrease {
+				l.lastDecrease = start.Add(time.Second)
+			}
+
+			l.release(context.Background(), cfg, start, tt.congested)
+
+			if diff := l.limit - tt.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("limit = %v, want %v", l.limit, tt.want)
+			}
+			if l.inflight != tt.inflight-1 {
+				t.Errorf("inflight = %d, want %d", l.inflight, tt.inflight-1)
+			}
+		})
+	}
+}
+
+func TestAcquireSheds(t *testing.T) {
+	cfg := testConfig()
+	cfg.InitialLimit = 5
+	l := New("test", func() Config { return cfg })
+	ctx := context.Background()
+
+	// Writes may use 4 of the 5 slots, reads all of them
+	var done []func(error)
+	for i := 0; i < 4; i++ {
+		d, err := l.Acquire(ctx, Write)
+		if err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+		done = append(done, d)
+	}
+	var shed *ShedError
+	if _, err := l.Acquire(ctx, Write); !errors.As(err, &shed) || shed.Priority != Write || shed.RetryAfter != time.Second {
+		t.Fatalf("fifth write = %v, want a write ShedError", err)
+	}
+	d, err := l.Acquire(ctx, Read)
+	if err != nil {
+		t.Fatalf("read within the reserve: %v", err)
+	}
+	done = append(done, d)
+	if _, err := l.Acquire(ctx, Read); !errors.As(err, &shed) {
+		t.Fatalf("read over the limit = %v, want a ShedError", err)
+	}
+
+	done[0](status.Error(codes.Unavailable, "overloaded"))
+	if limit, inflight := l.Snapshot(); limit != 2 || inflight != 4 {
+		t.Errorf("after overload: limit %d, inflight %d; want 2, 4", limit, inflight)
+	}
+	for _, d := range done[1:] {
+		d(nil)
+	}
+	if _, inflight := l.Snapshot(); inflight != 0 {
+		t.Errorf("inflight = %d after every request finished", inflight)
+	}
+}
+
+func TestAcquireIgnoresClientBudget(t *testing.T) {
+	cfg := testConfig()
+	l := New("test", func() Config { return cfg })
+
+	// A client that allows 20ms times out, which must not cut the limit
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	done, err := l.Acquire(ctx, Read)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	<-ctx.Done()
+	done(status.Error(codes.DeadlineExceeded, "context deadline exceeded"))
+
+	if limit, _ := l.Snapshot(); limit != cfg.InitialLimit {
+		t.Errorf("limit = %d after a client timeout, want %d", limit, cfg.InitialLimit)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	cfg := testConfig()
+	cfg.Enabled = false
+	cfg.InitialLimit = 2
+	l := New("test", func() Config { return cfg })
+
+	for i := 0; i < 10; i++ {
+		if _, err := l.Acquire(context.Background(), Write); err != nil {
+			t.Fatalf("Acquire %d while disabled: %v", i, err)
+		}
+	}
+}
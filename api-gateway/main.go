@@ -10,17 +10,34 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/bonyuta0204/otel-lab/api-gateway/config"
+	"github.com/bonyuta0204/otel-lab/api-gateway/gql"
 	"github.com/bonyuta0204/otel-lab/api-gateway/handlers"
+	"github.com/bonyuta0204/otel-lab/api-gateway/idempotency"
+	"github.com/bonyuta0204/otel-lab/api-gateway/limiter"
 	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/bonyuta0204/otel-lab/api-gateway/openapi"
 	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/api-gateway/upstream"
+	"github.com/bonyuta0204/otel-lab/internal/admin"
+	"github.com/bonyuta0204/otel-lab/internal/logging"
+	"github.com/bonyuta0204/otel-lab/internal/sampling"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func main() {
 	ctx := context.Background()
 
-	// Initialize tracing
-	tp, err := tracing.InitTracer(ctx)
+	// Initialize logging and tracing; both can be adjusted at runtime
+	// through the admin API
+	logLevel := logging.Init()
+
+	sampler, err := sampling.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid sampling configuration: %v", err)
+	}
+
+	tp, err := tracing.InitTracer(ctx, sampler)
 	if err != nil {
 		log.Fatalf("Failed to initialize tracer: %v", err)
 	}
@@ -30,58 +47,258 @@ func main() {
 		}
 	}()
 
-	// Initialize handlers
-	taskHandler, err := handlers.NewTaskHandler()
+	mp, err := tracing.InitMeter(ctx)
+	if err != nil {
+		log.Fatalf("Failed to initialize meter: %v", err)
+	}
+	defer func() {
+		if err := mp.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down meter provider: %v", err)
+		}
+	}()
+
+	// Load configuration
+	manager, err := config.NewManager(configPath())
 	if err != nil {
-		log.Fatalf("Failed to initialize task handler: %v", err)
+		log.Fatalf("Failed to load config: %v", err)
 	}
-	defer taskHandler.Close()
+	cfg := manager.Current()
 
-	userHandler, err := handlers.NewUserHandler()
+	warnTenantHeader(cfg.Tenancy)
+	manager.OnChange(func(old, new *config.Config) (config.Change, error) {
+		if old.Tenancy.AllowHeader {
+			return config.Change{}, nil
+		}
+		return config.Change{Apply: func() { warnTenantHeader(new.Tenancy) }}, nil
+	})
+
+	// Initialize upstream connections
+	userConn, err := upstream.Dial(upstreamTarget(cfg.Upstreams.UserService), cfg.Upstreams.UserService.Compression)
 	if err != nil {
-		log.Fatalf("Failed to initialize user handler: %v", err)
+		log.Fatalf("Failed to connect to user service: %v", err)
+	}
+	defer userConn.Close()
+
+	taskConn, err := upstream.Dial(upstreamTarget(cfg.Upstreams.TaskService), cfg.Upstreams.TaskService.Compression)
+	if err != nil {
+		log.Fatalf("Failed to connect to task service: %v", err)
+	}
+
+	// Each upstream gets its own adaptive concurrency limit
+	concurrency := func() limiter.Config {
+		return limiter.Config(manager.Current().Concurrency)
+	}
+	userConn.SetLimiter(limiter.New("user_service", concurrency))
+	taskConn.SetLimiter(limiter.New("task_service", concurrency))
+
+	// Requests assigned to the canary variant go to the canary
+	// task-service, which is dialled once it is first enabled
+	taskSplit := upstream.NewSplit(taskConn)
+	defer taskSplit.Close()
+	prepareCanary := func(c config.CanaryConfig) (config.Change, error) {
+		if !c.Enabled {
+			return config.Change{}, nil
+		}
+		if canary := taskSplit.Canary(); canary != nil {
+			pending, err := canary.Prepare(upstreamTarget(c.Upstream), c.Upstream.Compression)
+			if err != nil {
+				return config.Change{}, err
+			}
+			return config.Change{Apply: pending.Commit, Discard: pending.Discard}, nil
+		}
+		canary, err := upstream.Dial(upstreamTarget(c.Upstream), c.Upstream.Compression)
+		if err != nil {
+			return config.Change{}, err
+		}
+		return config.Change{
+			Apply: func() {
+				canary.SetLimiter(limiter.New("task_service_canary", concurrency))
+				taskSplit.SetCanary(canary)
+			},
+			Discard: func() { canary.Close() },
+		}, nil
+	}
+	initialCanary, err := prepareCanary(cfg.Canary)
+	if err != nil {
+		log.Fatalf("Failed to connect to canary task service: %v", err)
+	}
+	if initialCanary.Apply != nil {
+		initialCanary.Apply()
+	}
+
+	// Every connection is dialled before any is switched over, so a reload
+	// that fails part way leaves all of them on the old configuration
+	manager.OnChange(func(old, new *config.Config) (config.Change, error) {
+		userNext, err := userConn.Prepare(upstreamTarget(new.Upstreams.UserService), new.Upstreams.UserService.Compression)
+		if err != nil {
+			return config.Change{}, err
+		}
+		taskNext, err := taskConn.Prepare(upstreamTarget(new.Upstreams.TaskService), new.Upstreams.TaskService.Compression)
+		if err != nil {
+			userNext.Discard()
+			return config.Change{}, err
+		}
+		canaryNext, err := prepareCanary(new.Canary)
+		if err != nil {
+			userNext.Discard()
+			taskNext.Discard()
+			return config.Change{}, err
+		}
+		return config.Change{
+			Apply: func() {
+				userNext.Commit()
+				taskNext.Commit()
+				if canaryNext.Apply != nil {
+					canaryNext.Apply()
+				}
+			},
+			Discard: func() {
+				userNext.Discard()
+				taskNext.Discard()
+				if canaryNext.Discard != nil {
+					canaryNext.Discard()
+				}
+			},
+		}, nil
+	})
+
+	// Idempotency records live in process memory unless they have to be
+	// shared between replicas
+	var idempotencyStore idempotency.Store = idempotency.NewMemoryStore()
+	if cfg.Idempotency.Store == "postgres" {
+		pgStore, err := idempotency.NewPostgresStore(ctx, os.Getenv("IDEMPOTENCY_DATABASE_URL"))
+		if err != nil {
+			log.Fatalf("Failed to connect to idempotency store: %v", err)
+		}
+		defer pgStore.Close()
+		idempotencyStore = pgStore
+	}
+
+	// Initialize handlers
+	userHandler := handlers.NewUserHandler(userConn)
+	taskHandler := handlers.NewTaskHandler(taskSplit, userHandler.Client())
+	webhookHandler := handlers.NewWebhookHandler(taskSplit)
+	searchHandler := handlers.NewSearchHandler(taskHandler.Client(), userHandler.Client())
+
+	graphqlHandler, err := gql.NewHandler(taskHandler.Client(), userHandler.Client())
+	if err != nil {
+		log.Fatalf("Failed to initialize GraphQL handler: %v", err)
 	}
-	defer userHandler.Close()
 
 	// Setup routes
 	r := mux.NewRouter()
-	
-	// Add middleware
+	spec := openapi.Spec()
+
+	// Unversioned /api paths are rewritten to /api/v1 before CORS and
+	// routing see them
+	legacyAlias := middleware.DeprecatedAlias("/api", "/v1", func() middleware.AliasConfig {
+		a := manager.Current().API.LegacyAlias
+		return middleware.AliasConfig{Enabled: a.Enabled, DeprecatedAt: a.DeprecatedAt, Sunset: a.Sunset}
+	})
+
+	// Add middleware. Each reads the active config per request, so reloads
+	// apply without rebuilding the router.
 	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
-	r.Use(middleware.CORS)
+	r.Use(middleware.TraceResponse)
+	r.Use(middleware.Toggle(func() bool { return manager.Current().Middleware.AccessLog }, middleware.Logger))
+	r.Use(middleware.Deadline(func() middleware.DeadlineConfig {
+		t := manager.Current().Timeouts
+		return middleware.DeadlineConfig{Default: t.Default, Routes: t.Routes}
+	}))
+	r.Use(middleware.Tenant(func() middleware.TenancyConfig {
+		t := manager.Current().Tenancy
+		return middleware.TenancyConfig{
+			JWTClaim:      t.JWTClaim,
+			AllowHeader:   t.AllowHeader,
+			DefaultTenant: t.DefaultTenant,
+			Secret:        []byte(t.Secret),
+		}
+	}))
+	r.Use(middleware.Toggle(func() bool { return manager.Current().Middleware.RequestValidation }, openapi.Validator(spec)))
+	r.Use(middleware.Canary(func() middleware.CanaryConfig {
+		c := manager.Current().Canary
+		return middleware.CanaryConfig{
+			Enabled:   c.Enabled,
+			Percent:   c.Percent,
+			Header:    c.Header,
+			Cookie:    c.Cookie,
+			StickyTTL: c.StickyTTL,
+		}
+	}))
+	r.Use(middleware.Idempotency(idempotencyStore, func() middleware.IdempotencyConfig {
+		i := manager.Current().Idempotency
+		return middleware.IdempotencyConfig{Enabled: i.Enabled, TTL: i.TTL, Routes: i.Routes}
+	}))
 
-	// Task routes
-	r.HandleFunc("/api/tasks", taskHandler.CreateTask).Methods("POST")
-	r.HandleFunc("/api/tasks", taskHandler.ListTasks).Methods("GET")
-	r.HandleFunc("/api/tasks/{id}", taskHandler.GetTask).Methods("GET")
-	r.HandleFunc("/api/tasks/{id}", taskHandler.UpdateTask).Methods("PUT")
-	r.HandleFunc("/api/tasks/{id}", taskHandler.DeleteTask).Methods("DELETE")
-	r.HandleFunc("/api/tasks/{id}/trace", taskHandler.GetTaskTrace).Methods("GET")
+	healthHandler := handlers.NewHealthHandler(
+		handlers.GRPCHealthCheck("task_service", taskConn, ""),
+		handlers.GRPCHealthCheck("user_service", userConn, ""),
+		handlers.GRPCHealthCheck("postgres", taskConn, "postgres"),
+		handlers.ExporterHealthCheck(),
+	)
 
-	// User routes
-	r.HandleFunc("/api/users", userHandler.CreateUser).Methods("POST")
-	r.HandleFunc("/api/users", userHandler.ListUsers).Methods("GET")
-	r.HandleFunc("/api/users/{id}", userHandler.GetUser).Methods("GET")
+	// Batch items go through the legacy alias too, so old paths keep
+	// working inside a batch
+	registerRoutes(r, routeHandlers{
+		tasks:    taskHandler,
+		users:    userHandler,
+		webhooks: webhookHandler,
+		search:   searchHandler,
+		health:   healthHandler,
+		batch:    handlers.NewBatchHandler(legacyAlias(r), 4),
+		graphql:  graphqlHandler,
+		openapi:  openapi.Handler(spec),
+	})
 
-	// Health check
-	r.HandleFunc("/health", handlers.Health).Methods("GET")
+	if err := openapi.CheckRoutes(r, spec); err != nil {
+		log.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+
+	// CORS wraps the router so that preflights reach it even for routes
+	// that are not registered for OPTIONS
+	cors := middleware.CORS(r, func(route string) middleware.CORSPolicy {
+		return middleware.CORSPolicy(manager.Current().CORS.Policy(route))
+	})
+
+	compress := middleware.Compress(func() middleware.CompressionConfig {
+		return middleware.CompressionConfig(manager.Current().Compression)
+	})
 
 	// Wrap the router with OpenTelemetry instrumentation
-	handler := otelhttp.NewHandler(r, "api-gateway")
+	handler := otelhttp.NewHandler(compress(legacyAlias(cors(r))), "api-gateway")
 
 	// Setup server
 	srv := &http.Server{
-		Addr:         ":8080",
+		Addr:         cfg.Listen.Address,
 		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.Listen.ReadTimeout,
+		WriteTimeout: cfg.Listen.WriteTimeout,
+		IdleTimeout:  cfg.Listen.IdleTimeout,
+	}
+
+	// Reload the config on SIGHUP or when the file changes
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	go manager.Watch(watchCtx)
+
+	// Start admin API
+	adminOpts := admin.OptionsFromEnv("api-gateway", ":9090")
+	adminOpts.Level = logLevel
+	adminOpts.Sampler = sampler
+	adminOpts.Config = func() interface{} {
+		return map[string]interface{}{
+			"config_path":        configPath(),
+			"config":             manager.Current().Dump(),
+			"log_level":          logLevel.Level().String(),
+			"trace_sample_ratio": sampler.Ratio(),
+		}
 	}
+	adminServer := admin.New(adminOpts)
+	adminServer.Start()
 
 	// Start server
 	go func() {
-		log.Println("API Gateway starting on :8080")
+		log.Printf("API Gateway starting on %s", cfg.Listen.Address)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed to start: %v", err)
 		}
@@ -93,12 +310,98 @@ func main() {
 	<-quit
 	log.Println("Shutting down server...")
 
+	// Fail readiness first and keep serving while the orchestrator notices
+	healthHandler.Drain()
+	time.Sleep(manager.Current().Listen.ShutdownDelay)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	adminServer.Shutdown(ctx)
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
 	log.Println("Server exited")
-}
\ No newline at end of file
+}
+
+// routeHandlers holds the handlers behind the gateway's routes.
+type routeHandlers struct {
+	tasks    *handlers.TaskHandler
+	users    *handlers.UserHandler
+	webhooks *handlers.WebhookHandler
+	search   *handlers.SearchHandler
+	health   *handlers.HealthHandler
+	batch    http.Handler
+	graphql  http.Handler
+	openapi  http.Handler
+}
+
+// registerRoutes adds every route the gateway serves. Each route must be
+// described by openapi.Spec; main_test.go checks that the two agree.
+func registerRoutes(r *mux.Router, h routeHandlers) {
+	// Task routes
+	r.HandleFunc("/api/v1/tasks", h.tasks.CreateTask).Methods("POST")
+	r.HandleFunc("/api/v1/tasks", h.tasks.ListTasks).Methods("GET")
+	r.HandleFunc("/api/v1/tasks/events", h.tasks.WatchTasks).Methods("GET")
+	r.HandleFunc("/api/v1/tasks/export", h.tasks.ExportTasks).Methods("GET")
+	r.HandleFunc("/api/v1/tasks/import", h.tasks.ImportTasks).Methods("POST")
+	r.HandleFunc("/api/v1/tasks/{id}", h.tasks.GetTask).Methods("GET")
+	r.HandleFunc("/api/v1/tasks/{id}", h.tasks.UpdateTask).Methods("PUT")
+	r.HandleFunc("/api/v1/tasks/{id}", h.tasks.DeleteTask).Methods("DELETE")
+	r.HandleFunc("/api/v1/tasks/{id}/trace", h.tasks.GetTaskTrace).Methods("GET")
+
+	// User routes
+	r.HandleFunc("/api/v1/users", h.users.CreateUser).Methods("POST")
+	r.HandleFunc("/api/v1/users", h.users.ListUsers).Methods("GET")
+	r.HandleFunc("/api/v1/users/{id}", h.users.GetUser).Methods("GET")
+
+	// Webhook routes
+	r.HandleFunc("/api/v1/webhooks", h.webhooks.CreateWebhook).Methods("POST")
+	r.HandleFunc("/api/v1/webhooks", h.webhooks.ListWebhooks).Methods("GET")
+	r.HandleFunc("/api/v1/webhooks/{id}", h.webhooks.GetWebhook).Methods("GET")
+	r.HandleFunc("/api/v1/webhooks/{id}", h.webhooks.UpdateWebhook).Methods("PUT")
+	r.HandleFunc("/api/v1/webhooks/{id}", h.webhooks.DeleteWebhook).Methods("DELETE")
+	r.HandleFunc("/api/v1/webhooks/{id}/dead-letters", h.webhooks.ListDeadLetters).Methods("GET")
+
+	// Search across tasks and users
+	r.HandleFunc("/api/v1/search", h.search.Search).Methods("GET")
+
+	// Batch
+	r.Handle("/api/v1/batch", h.batch).Methods("POST")
+
+	// GraphQL
+	r.Handle("/graphql", h.graphql).Methods("POST")
+
+	// Health checks
+	r.HandleFunc("/health", h.health.Health).Methods("GET")
+	r.HandleFunc("/livez", h.health.Livez).Methods("GET")
+	r.HandleFunc("/readyz", h.health.Readyz).Methods("GET")
+
+	// API description
+	r.Handle("/openapi.json", h.openapi).Methods("GET")
+}
+
+// warnTenantHeader logs loudly while clients may choose their tenant with
+// X-Tenant-ID, since nothing then keeps one from acting for another.
+func warnTenantHeader(t config.TenancyConfig) {
+	if t.AllowHeader {
+		log.Println("WARNING: tenancy.allow_header is on: any client can act for any tenant with X-Tenant-ID. Only enable it behind a proxy that sets the header.")
+	}
+}
+
+// configPath returns GATEWAY_CONFIG, or config.yaml when it exists in the
+// working directory. An empty path runs with the built-in defaults.
+func configPath() string {
+	if path := os.Getenv("GATEWAY_CONFIG"); path != "" {
+		return path
+	}
+	if _, err := os.Stat("config.yaml"); err == nil {
+		return "config.yaml"
+	}
+	return ""
+}
+
+func upstreamTarget(u config.UpstreamConfig) upstream.Target {
+	return upstream.Target{Address: u.Address, Balancer: u.Balancer, HealthCheck: u.HealthCheck}
+}
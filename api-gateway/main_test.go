@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/openapi"
+	"github.com/gorilla/mux"
+)
+
+// TestRoutesMatchSpec fails when a route is added without documenting it,
+// or documented without routing it.
+func TestRoutesMatchSpec(t *testing.T) {
+	r := mux.NewRouter()
+	registerRoutes(r, routeHandlers{})
+
+	if err := openapi.CheckRoutes(r, openapi.Spec()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/tracing"
+	"github.com/bonyuta0204/otel-lab/api-gateway/upstream"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type CanaryConfig struct {
+	Enabled bool
+	// Percent of the clients without an assignment that get the canary.
+	Percent float64
+	// Header and Cookie force a variant with "canary" or "stable".
+	Header string
+	Cookie string
+	// StickyTTL is how long the cookie keeps a client on its variant.
+	StickyTTL time.Duration
+}
+
+// Canary assigns every API request to the stable or the canary variant and
+// puts it into the context for upstream.Split. A request naming a variant in
+// the header or cookie gets it; any other client is placed by a hash of its
+// credentials or address, so it lands on the same variant on every replica,
+// and is told to keep it with a cookie. The variant a request's RPCs were
+// actually sent to by upstream.Split is recorded on the server span and as
+// a label of gateway.canary.requests; requests that made no such RPC, such
+// as those only served by user-service, are not counted.
+func Canary(config func() CanaryConfig) mux.MiddlewareFunc {
+	requests, _ := tracing.GetMeter().Int64Counter("gateway.canary.requests",
+		metric.WithDescription("API requests by the variant they were assigned and their response status."),
+		metric.WithUnit("{request}"))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := config()
+			if !cfg.Enabled || !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/graphql" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Batch items keep the variant of their batch.
+			if upstream.VariantFromContext(r.Context()) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			variant, source := assignVariant(r, cfg)
+			if source == "percentage" {
+				http.SetCookie(w, &http.Cookie{
+					Name:     cfg.Cookie,
+					Value:    variant,
+					Path:     "/",
+					MaxAge:   int(cfg.StickyTTL / time.Second),
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+
+			ctx, routing := upstream.WithRouting(upstream.WithVariant(r.Context(), variant))
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(wrapped, r.WithContext(ctx))
+
+			routed := routing.Variant()
+			if routed == "" {
+				return
+			}
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				attribute.String("deployment.variant", routed),
+				attribute.String("deployment.variant_source", source),
+			)
+
+			var route string
+			if current := mux.CurrentRoute(r); current != nil {
+				route, _ = current.GetPathTemplate()
+			}
+			requests.Add(r.Context(), 1, metric.WithAttributes(
+				attribute.String("variant", routed),
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.Int("http.response.status_code", wrapped.statusCode),
+			))
+		})
+	}
+}
+
+// assignVariant returns the variant for r and what decided it: "header",
+// "cookie" or "percentage".
+func assignVariant(r *http.Request, cfg CanaryConfig) (variant, source string) {
+	if v := parseVariant(r.Header.Get(cfg.Header)); v != "" {
+		return v, "header"
+	}
+	if c, err := r.Cookie(cfg.Cookie); err == nil {
+		if v := parseVariant(c.Value); v != "" {
+			return v, "cookie"
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(stickyKey(r)))
+	if float64(h.Sum32()%10000) < cfg.Percent*100 {
+		return upstream.Canary, "percentage"
+	}
+	return upstream.Stable, "percentage"
+}
+
+func parseVariant(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "canary", "true", "1":
+		return upstream.Canary
+	case "stable", "false", "0":
+		return upstream.Stable
+	}
+	return ""
+}
+
+// stickyKey identifies the client: its credentials when it sends any,
+// otherwise its address.
+func stickyKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return auth
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
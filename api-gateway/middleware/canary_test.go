@@ -0,0 +1,215 @@
+package middleware_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/bonyuta0204/otel-lab/api-gateway/upstream"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func canaryConfig(percent float64) middleware.CanaryConfig {
+	return middleware.CanaryConfig{
+		Enabled:   true,
+		Percent:   percent,
+		Header:    "X-Canary",
+		Cookie:    "canary",
+		StickyTTL: time.Hour,
+	}
+}
+
+// serveCanary returns the variant the handler saw and the response.
+func serveCanary(cfg middleware.CanaryConfig, req *http.Request) (string, *httptest.ResponseRecorder) {
+	var variant string
+	handler := middleware.Canary(func() middleware.CanaryConfig { return cfg })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			variant = upstream.VariantFromContext(r.Context())
+		}),
+	)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return variant, rec
+}
+
+func TestCanaryAssignment(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  middleware.CanaryConfig
+		path    string
+		header  string
+		cookie  string
+		want    string
+		wantSet bool
+	}{
+		{"disabled", middleware.CanaryConfig{Percent: 100, Header: "X-Canary", Cookie: "canary"}, "", "canary", "", "", false},
+		{"not an API route", canaryConfig(100), "/healthz", "", "", "", false},
+		{"header canary", canaryConfig(0), "", "canary", "", upstream.Canary, false},
+		{"header stable", canaryConfig(100), "", "stable", "", upstream.Stable, false},
+		{"header true", canaryConfig(0), "", "true", "", upstream.Canary, false},
+		{"header case and spaces", canaryConfig(0), "", " Canary ", "", upstream.Canary, false},
+		{"header over cookie", canaryConfig(0), "", "stable", "canary", upstream.Stable, false},
+		{"cookie canary", canaryConfig(0), "", "", "canary", upstream.Canary, false},
+		{"cookie stable", canaryConfig(100), "", "", "stable", upstream.Stable, false},
+		{"unknown header value falls through", canaryConfig(0), "", "maybe", "canary", upstream.Canary, false},
+		{"unknown cookie value falls through", canaryConfig(100), "", "", "maybe", upstream.Canary, true},
+		{"none get the canary at 0%", canaryConfig(0), "", "", "", upstream.Stable, true},
+		{"all get the canary at 100%", canaryConfig(100), "", "", "", upstream.Canary, true},
+		{"GraphQL", canaryConfig(100), "/graphql", "", "", upstream.Canary, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if path == "" {
+				path = "/api/v1/tasks"
+			}
+			req := httptest.NewRequest("GET", path, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Canary", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "canary", Value: tt.cookie})
+			}
+
+			got, rec := serveCanary(tt.config, req)
+			if got != tt.want {
+				t.Errorf("variant = %q, want %q", got, tt.want)
+			}
+
+			cookies := rec.Result().Cookies()
+			if !tt.wantSet {
+				if len(cookies) != 0 {
+					t.Errorf("set cookie %v, want none", cookies[0])
+				}
+				return
+			}
+			if len(cookies) != 1 || cookies[0].Name != "canary" || cookies[0].Value != tt.want {
+				t.Fatalf("cookies = %v, want canary=%s", cookies, tt.want)
+			}
+			if cookies[0].MaxAge != 3600 || !cookies[0].HttpOnly {
+				t.Errorf("cookie max-age %d, HttpOnly %v; want 3600, true", cookies[0].MaxAge, cookies[0].HttpOnly)
+			}
+		})
+	}
+}
+
+func TestCanaryKeepsBatchVariant(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	req = req.WithContext(upstream.WithVariant(req.Context(), upstream.Canary))
+
+	got, rec := serveCanary(canaryConfig(0), req)
+	if got != upstream.Canary {
+		t.Errorf("variant = %q, want the batch's canary", got)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("batch item set a cookie")
+	}
+}
+
+func TestCanaryPercentageIsStickyAndProportional(t *testing.T) {
+	cfg := canaryConfig(30)
+	canaries := 0
+	const clients = 2000
+	for i := 0; i < clients; i++ {
+		addr := fmt.Sprintf("10.0.%d.%d:1234", i/250, i%250)
+
+		req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+		req.RemoteAddr = addr
+		first, _ := serveCanary(cfg, req)
+
+		// The same client on another port, as on another replica or
+		// connection, gets the same variant
+		req = httptest.NewRequest("GET", "/api/v1/users", nil)
+		req.RemoteAddr = addr[:len(addr)-4] + "5678"
+		if again, _ := serveCanary(cfg, req); again != first {
+			t.Fatalf("client %s got %s, then %s", addr, first, again)
+		}
+
+		if first == upstream.Canary {
+			canaries++
+		}
+	}
+
+	if share := float64(canaries) / clients * 100; share < 25 || share > 35 {
+		t.Errorf("%.1f%% of clients got the canary, want about 30%%", share)
+	}
+}
+
+func TestCanaryPrefersCredentialsOverAddress(t *testing.T) {
+	cfg := canaryConfig(50)
+	variantFor := func(auth, addr string) string {
+		req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("Authorization", auth)
+		v, _ := serveCanary(cfg, req)
+		return v
+	}
+
+	// A client with credentials keeps its variant from any address
+	for i := 0; i < 50; i++ {
+		auth := fmt.Sprintf("Bearer token-%d", i)
+		if variantFor(auth, "10.0.0.1:1") != variantFor(auth, "192.168.0.1:1") {
+			t.Fatalf("%s changed variant with its address", auth)
+		}
+	}
+}
+
+func TestCanaryRecordsRoutedVariant(t *testing.T) {
+	// Nothing listens here; the RPC fails, but the Split has picked a
+	// deployment by then
+	conn, err := upstream.Dial(upstream.Target{Address: "127.0.0.1:1"}, "")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name   string
+		canary *upstream.Conn
+		rpc    bool
+		want   string
+	}{
+		{"task-service RPC on the canary", conn, true, upstream.Canary},
+		{"no canary deployment", nil, true, upstream.Stable},
+		{"no task-service RPC", conn, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			split := upstream.NewSplit(conn)
+			split.SetCanary(tt.canary)
+			handler := middleware.Canary(func() middleware.CanaryConfig { return canaryConfig(100) })(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if tt.rpc {
+						ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+						defer cancel()
+						split.Invoke(ctx, "/task.TaskService/ListTasks", nil, nil)
+					}
+				}),
+			)
+
+			spans := tracetest.NewSpanRecorder()
+			ctx, span := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test").Start(context.Background(), "server")
+			req := httptest.NewRequest("GET", "/api/v1/users", nil).WithContext(ctx)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			span.End()
+
+			var got string
+			for _, kv := range spans.Ended()[0].Attributes() {
+				if kv.Key == attribute.Key("deployment.variant") {
+					got = kv.Value.AsString()
+				}
+			}
+			if got != tt.want {
+				t.Errorf("deployment.variant = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type CompressionConfig struct {
+	Enabled bool
+	// Encodings lists the supported encodings in order of preference.
+	Encodings []string
+	// MinSize is the smallest body, in bytes, worth compressing.
+	MinSize int
+	// ContentTypes lists compressible media types; "text/*" matches a
+	// whole family.
+	ContentTypes []string
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}},
+	"zstd": {New: func() interface{} {
+		enc, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+}
+
+// Compress negotiates a response encoding from Accept-Encoding. Bodies are
+// buffered up to MinSize so that small responses go out unchanged; types
+// outside ContentTypes, such as SSE streams, are never compressed. Both
+// sizes are recorded on the request span.
+func Compress(config func() CompressionConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := config()
+			if !cfg.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), cfg.Encodings)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, cfg: cfg, encoding: encoding, status: http.StatusOK}
+			defer func() {
+				cw.close()
+				if cw.compressed {
+					trace.SpanFromContext(r.Context()).SetAttributes(
+						attribute.String("http.response.content_encoding", encoding),
+						attribute.Int64("http.response.uncompressed_size", cw.uncompressedSize),
+						attribute.Int64("http.response.compressed_size", cw.compressedSize),
+					)
+				}
+			}()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks the encoding with the highest q-value the client
+// accepts, breaking ties by the server's preference order.
+func negotiateEncoding(header string, supported []string) string {
+	if header == "" {
+		return ""
+	}
+
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range supported {
+		q, ok := accepted[enc]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	cfg      CompressionConfig
+	encoding string
+	status   int
+
+	buf        []byte
+	decided    bool
+	compressed bool
+	enc        encoder
+	counter    *countingWriter
+
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || code < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide()
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	cw.uncompressedSize += int64(len(p))
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= cw.cfg.MinSize {
+			if err := cw.decide(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if cw.compressed {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide()
+	}
+	if cw.compressed {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide commits the response headers, compressed or not, and writes out
+// whatever has been buffered so far.
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	h := cw.ResponseWriter.Header()
+
+	if len(cw.buf) >= cw.cfg.MinSize && h.Get("Content-Encoding") == "" && cw.compressible(h) {
+		cw.compressed = true
+		cw.counter = &countingWriter{w: cw.ResponseWriter}
+		cw.enc = encoderPools[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.counter)
+
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		cw.ResponseWriter.WriteHeader(cw.status)
+		_, err := cw.enc.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) compressible(h http.Header) bool {
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(cw.buf)
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range cw.cfg.ContentTypes {
+		if family, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, family+"/") {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide()
+	}
+	if cw.compressed {
+		cw.enc.Close()
+		cw.compressedSize = cw.counter.n
+		cw.enc.Reset(io.Discard)
+		encoderPools[cw.encoding].Put(cw.enc)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
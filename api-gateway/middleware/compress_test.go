@@ -0,0 +1,188 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compressionConfig() middleware.CompressionConfig {
+	return middleware.CompressionConfig{
+		Enabled:      true,
+		Encodings:    []string{"zstd", "gzip"},
+		MinSize:      100,
+		ContentTypes: []string{"application/json", "text/*"},
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"title":"task"},`, 20)
+	disabled := compressionConfig()
+	disabled.Enabled = false
+
+	tests := []struct {
+		name           string
+		config         middleware.CompressionConfig
+		method         string
+		acceptEncoding string
+		contentType    string
+		encoded        bool // the handler set Content-Encoding itself
+		status         int
+		body           string
+		want           string
+	}{
+		{"no Accept-Encoding", compressionConfig(), "GET", "", "application/json", false, 200, large, ""},
+		{"gzip", compressionConfig(), "GET", "gzip", "application/json", false, 200, large, "gzip"},
+		{"server preference breaks ties", compressionConfig(), "GET", "gzip, zstd", "application/json", false, 200, large, "zstd"},
+		{"client q-values win", compressionConfig(), "GET", "zstd;q=0.5, gzip", "application/json", false, 200, large, "gzip"},
+		{"q=0 refuses", compressionConfig(), "GET", "zstd;q=0, gzip;q=0", "application/json", false, 200, large, ""},
+		{"wildcard", compressionConfig(), "GET", "*", "application/json", false, 200, large, "zstd"},
+		{"wildcard with exclusion", compressionConfig(), "GET", "*, zstd;q=0", "application/json", false, 200, large, "gzip"},
+		{"unsupported encoding", compressionConfig(), "GET", "br", "application/json", false, 200, large, ""},
+		{"below the threshold", compressionConfig(), "GET", "gzip", "application/json", false, 200, `{"id":"1"}`, ""},
+		{"type family", compressionConfig(), "GET", "gzip", "text/csv; charset=utf-8", false, 200, large, "gzip"},
+		{"type outside the allowlist", compressionConfig(), "GET", "gzip", "image/png", false, 200, large, ""},
+		{"sniffed type", compressionConfig(), "GET", "gzip", "", false, 200, strings.Repeat("plain text ", 20), "gzip"},
+		{"already encoded", compressionConfig(), "GET", "gzip", "application/json", true, 200, large, "br"},
+		{"error status", compressionConfig(), "GET", "gzip", "application/json", false, 404, large, "gzip"},
+		{"no content", compressionConfig(), "DELETE", "gzip", "", false, 204, "", ""},
+		{"HEAD", compressionConfig(), "HEAD", "gzip", "application/json", false, 200, "", ""},
+		{"disabled", disabled, "GET", "gzip", "application/json", false, 200, large, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.config
+			handler := middleware.Compress(func() middleware.CompressionConfig { return cfg })(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if tt.contentType != "" {
+						w.Header().Set("Content-Type", tt.contentType)
+					}
+					if tt.encoded {
+						w.Header().Set("Content-Encoding", "br")
+					}
+					w.Header().Set("Content-Length", "999")
+					w.WriteHeader(tt.status)
+					io.WriteString(w, tt.body)
+				}),
+			)
+
+			req := httptest.NewRequest(tt.method, "/api/v1/tasks", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			got := rec.Header().Get("Content-Encoding")
+			if got != tt.want {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.want)
+			}
+			if cfg.Enabled && rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", rec.Header().Get("Vary"))
+			}
+			if got == "gzip" || got == "zstd" {
+				if rec.Header().Get("Content-Length") != "" {
+					t.Error("Content-Length of the uncompressed body kept")
+				}
+				if rec.Body.Len() >= len(tt.body) {
+					t.Errorf("compressed %d bytes into %d", len(tt.body), rec.Body.Len())
+				}
+			}
+			if body := decompress(t, got, rec.Body.Bytes()); body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestCompressSmallWrites(t *testing.T) {
+	// A body written a few bytes at a time is buffered until it crosses the
+	// threshold and compressed as a whole
+	cfg := compressionConfig()
+	handler := middleware.Compress(func() middleware.CompressionConfig { return cfg })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			for i := 0; i < 50; i++ {
+				io.WriteString(w, `{"i":1}`)
+			}
+		}),
+	)
+	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	if body := decompress(t, "gzip", rec.Body.Bytes()); body != strings.Repeat(`{"i":1}`, 50) {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	// A flush before the threshold commits the response uncompressed, so
+	// that a small first event of a stream is not held back
+	cfg := compressionConfig()
+	cfg.ContentTypes = append(cfg.ContentTypes, "text/event-stream")
+	handler := middleware.Compress(func() middleware.CompressionConfig { return cfg })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: 1\n\n")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, strings.Repeat("data: 2\n\n", 50))
+		}),
+	)
+	req := httptest.NewRequest("GET", "/api/v1/tasks/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+	if !rec.Flushed {
+		t.Error("Flush did not reach the client")
+	}
+	if want := "data: 1\n\n" + strings.Repeat("data: 2\n\n", 50); rec.Body.String() != want {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("gzip: %v", err)
+		}
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("zstd: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return string(body)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decompress %s: %v", encoding, err)
+	}
+	return string(out)
+}
@@ -0,0 +1,221 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSPolicy is the cross-origin policy applied to one route.
+type CORSPolicy struct {
+	// AllowedOrigins holds exact origins ("https://app.example.com"),
+	// wildcard subdomains ("https://*.example.com") or "*".
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// probeMethods are tried against the router to find which methods a path
+// supports when answering OPTIONS.
+var probeMethods = []string{"GET", "POST", "PUT", "DELETE"}
+
+// CORS wraps the router rather than being registered with Use: mux only
+// runs middleware for matched routes, and an OPTIONS request never matches
+// a route registered for GET or POST. Preflights are answered here against
+// the route the browser intends to call. policy is called with that route's
+// path template ("" when no route matches) for every request, so reloaded
+// policies apply at once.
+func CORS(router *mux.Router, policy func(route string) CORSPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				handleOptions(w, r, router, policy)
+				return
+			}
+
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			p := policy(routeTemplate(router, r))
+			w.Header().Add("Vary", "Origin")
+			if allowOrigin(w, p, origin) && len(p.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func handleOptions(w http.ResponseWriter, r *http.Request, router *mux.Router, policy func(string) CORSPolicy) {
+	methods := routeMethods(router, r)
+	if len(methods) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+
+	origin := r.Header.Get("Origin")
+	requested := r.Header.Get("Access-Control-Request-Method")
+	if origin == "" || requested == "" {
+		// A plain OPTIONS request, not a preflight.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	preflight := r.Clone(r.Context())
+	preflight.Method = requested
+	p := policy(routeTemplate(router, preflight))
+
+	w.Header().Add("Vary", "Origin")
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	if !contains(methods, requested) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !containsFold(p.AllowedMethods, requested) {
+		http.Error(w, "CORS method not allowed", http.StatusForbidden)
+		return
+	}
+	headers := splitHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+	for _, h := range headers {
+		if !containsFold(p.AllowedHeaders, h) {
+			http.Error(w, "CORS header not allowed: "+h, http.StatusForbidden)
+			return
+		}
+	}
+	if !allowOrigin(w, p, origin) {
+		http.Error(w, "CORS origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	var allowed []string
+	for _, m := range methods {
+		if containsFold(p.AllowedMethods, m) {
+			allowed = append(allowed, m)
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if p.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowOrigin sets the origin and credentials headers when origin is
+// allowed by p and reports whether it was. With credentials, "*" matches
+// nothing: echoing any origin would let every site read responses made
+// with the user's cookies, so such origins have to be listed.
+func allowOrigin(w http.ResponseWriter, p CORSPolicy, origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			if p.AllowCredentials {
+				continue
+			}
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			return true
+		}
+		if MatchOrigin(allowed, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if p.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// MatchOrigin reports whether origin matches pattern. A pattern host of
+// "*.example.com" matches any subdomain of example.com, but not
+// example.com itself; scheme and port must match exactly.
+func MatchOrigin(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.EqualFold(pattern, origin) {
+		return true
+	}
+
+	p, err := url.Parse(pattern)
+	if err != nil || !strings.HasPrefix(p.Hostname(), "*.") {
+		return false
+	}
+	o, err := url.Parse(origin)
+	if err != nil || o.Hostname() == "" {
+		return false
+	}
+	suffix := strings.TrimPrefix(p.Hostname(), "*")
+	return strings.EqualFold(p.Scheme, o.Scheme) &&
+		p.Port() == o.Port() &&
+		len(o.Hostname()) > len(suffix) &&
+		strings.HasSuffix(strings.ToLower(o.Hostname()), strings.ToLower(suffix))
+}
+
+func routeTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.Route == nil {
+		return ""
+	}
+	tpl, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return tpl
+}
+
+func routeMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	for _, m := range probeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = m
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.Route != nil {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+func splitHeaderList(s string) []string {
+	var out []string
+	for _, h := range strings.Split(s, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			out = append(out, http.CanonicalHeaderKey(h))
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
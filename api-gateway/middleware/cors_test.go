@@ -0,0 +1,221 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/gorilla/mux"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"*", "https://anything.test", true},
+		{"https://app.example.com", "https://app.example.com", true},
+		{"https://app.example.com", "HTTPS://APP.EXAMPLE.COM", true},
+		{"https://app.example.com", "http://app.example.com", false},
+		{"https://app.example.com", "https://app.example.com:8443", false},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "https://example.com.evil.test", false},
+		{"https://*.example.com", "http://app.example.com", false},
+		{"https://*.example.com", "https://app.example.com:8443", false},
+		{"http://*.example.com:3000", "http://app.example.com:3000", true},
+		{"https://*.example.com", "null", false},
+	}
+
+	for _, tt := range tests {
+		if got := middleware.MatchOrigin(tt.pattern, tt.origin); got != tt.want {
+			t.Errorf("MatchOrigin(%q, %q) = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func corsPolicy(origins ...string) middleware.CORSPolicy {
+	return middleware.CORSPolicy{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		ExposedHeaders: []string{"X-Request-ID", "traceresponse"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// serveCORS sends req through CORS in front of a router with GET and POST
+// /api/v1/tasks and GET /api/v1/admin, which has its own stricter policy.
+func serveCORS(policy middleware.CORSPolicy, req *http.Request) (*httptest.ResponseRecorder, bool) {
+	called := false
+	handler := func(w http.ResponseWriter, r *http.Request) { called = true }
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/tasks", handler).Methods("GET", "POST")
+	r.HandleFunc("/api/v1/admin", handler).Methods("GET")
+
+	admin := middleware.CORSPolicy{AllowedOrigins: []string{"https://admin.example.com"}, AllowedMethods: []string{"GET"}}
+	cors := middleware.CORS(r, func(route string) middleware.CORSPolicy {
+		if route == "/api/v1/admin" {
+			return admin
+		}
+		return policy
+	})
+
+	rec := httptest.NewRecorder()
+	cors(r).ServeHTTP(rec, req)
+	return rec, called
+}
+
+func TestCORSRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      middleware.CORSPolicy
+		path        string
+		origin      string
+		wantOrigin  string
+		wantCreds   bool
+		wantExposed bool
+	}{
+		{"same-origin request", corsPolicy("https://app.example.com"), "/api/v1/tasks", "", "", false, false},
+		{"allowed origin", corsPolicy("https://app.example.com"), "/api/v1/tasks", "https://app.example.com", "https://app.example.com", false, true},
+		{"unknown origin", corsPolicy("https://app.example.com"), "/api/v1/tasks", "https://evil.test", "", false, false},
+		{"wildcard subdomain", corsPolicy("https://*.example.com"), "/api/v1/tasks", "https://ui.example.com", "https://ui.example.com", false, true},
+		{"any origin", corsPolicy("*"), "/api/v1/tasks", "https://evil.test", "*", false, true},
+		{"credentials never allow any origin", withCredentials(corsPolicy("*")), "/api/v1/tasks", "https://evil.test", "", false, false},
+		{"credentials skip the wildcard", withCredentials(corsPolicy("*", "https://app.example.com")), "/api/v1/tasks", "https://app.example.com", "https://app.example.com", true, true},
+		{"credentials with an allowlist", withCredentials(corsPolicy("https://app.example.com")), "/api/v1/tasks", "https://app.example.com", "https://app.example.com", true, true},
+		{"route override", corsPolicy("*"), "/api/v1/admin", "https://app.example.com", "", false, false},
+		{"route override allows its origin", corsPolicy("*"), "/api/v1/admin", "https://admin.example.com", "https://admin.example.com", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec, called := serveCORS(tt.policy, req)
+
+			// CORS never blocks an actual request; the browser does
+			if !called {
+				t.Fatal("handler not called")
+			}
+			h := rec.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials") == "true"; got != tt.wantCreds {
+				t.Errorf("Allow-Credentials = %v, want %v", got, tt.wantCreds)
+			}
+			if got := h.Get("Access-Control-Expose-Headers") != ""; got != tt.wantExposed {
+				t.Errorf("Expose-Headers = %q, want set %v", h.Get("Access-Control-Expose-Headers"), tt.wantExposed)
+			}
+			if tt.origin != "" && h.Get("Vary") != "Origin" {
+				t.Errorf("Vary = %q, want Origin", h.Get("Vary"))
+			}
+		})
+	}
+}
+
+func withCredentials(p middleware.CORSPolicy) middleware.CORSPolicy {
+	p.AllowCredentials = true
+	return p
+}
+
+func TestCORSPreflight(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		origin      string
+		method      string
+		headers     string
+		wantStatus  int
+		wantMethods string
+		wantHeaders string
+	}{
+		{"allowed", "/api/v1/tasks", "https://app.example.com", "POST", "content-type, authorization", http.StatusNoContent, "GET, POST", "Content-Type, Authorization"},
+		{"no requested headers", "/api/v1/tasks", "https://app.example.com", "GET", "", http.StatusNoContent, "GET, POST", ""},
+		{"method the route does not serve", "/api/v1/tasks", "https://app.example.com", "DELETE", "", http.StatusMethodNotAllowed, "", ""},
+		{"header not allowed", "/api/v1/tasks", "https://app.example.com", "POST", "X-Debug", http.StatusForbidden, "", ""},
+		{"origin not allowed", "/api/v1/tasks", "https://evil.test", "POST", "", http.StatusForbidden, "", ""},
+		{"route override", "/api/v1/admin", "https://app.example.com", "GET", "", http.StatusForbidden, "", ""},
+		{"unknown path", "/api/v1/nothing", "https://app.example.com", "GET", "", http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			rec, called := serveCORS(corsPolicy("https://app.example.com"), req)
+
+			if called {
+				t.Error("handler called for a preflight")
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusNoContent {
+				if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+					t.Errorf("Allow-Origin = %q on a failed preflight", got)
+				}
+				return
+			}
+			h := rec.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.origin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.origin)
+			}
+			if got := h.Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			if got := h.Get("Access-Control-Allow-Headers"); got != tt.wantHeaders {
+				t.Errorf("Allow-Headers = %q, want %q", got, tt.wantHeaders)
+			}
+			if got := h.Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("Max-Age = %q, want 600", got)
+			}
+		})
+	}
+}
+
+func TestCORSPreflightCredentialsWildcard(t *testing.T) {
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/tasks", nil)
+	req.Header.Set("Origin", "https://evil.test")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec, _ := serveCORS(withCredentials(corsPolicy("*")), req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+	h := rec.Header()
+	if h.Get("Access-Control-Allow-Origin") != "" || h.Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Allow-Origin = %q, Allow-Credentials = %q; want neither", h.Get("Access-Control-Allow-Origin"), h.Get("Access-Control-Allow-Credentials"))
+	}
+}
+
+func TestCORSPlainOptions(t *testing.T) {
+	// An OPTIONS request without Origin is answered with the route's
+	// methods instead of mux's 405
+	rec, called := serveCORS(corsPolicy("*"), httptest.NewRequest(http.MethodOptions, "/api/v1/tasks", nil))
+	if called {
+		t.Error("handler called for OPTIONS")
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, POST, OPTIONS" {
+		t.Errorf("Allow = %q, want GET, POST, OPTIONS", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin = %q on a plain OPTIONS request", got)
+	}
+}
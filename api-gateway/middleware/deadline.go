@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/openapi"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const RequestTimeoutHeader = "Request-Timeout"
+
+const budgetKey contextKey = "deadline-budget"
+
+// DeadlineConfig controls how much time each request may take. Routes are
+// keyed by "METHOD /path/template"; a zero duration disables the deadline,
+// which streaming routes need. Clients may ask for less time than the route
+// allows with the Request-Timeout header, never more.
+type DeadlineConfig struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+// Deadline attaches the request's time budget to its context. The gRPC
+// clients forward the remaining budget to the services as their deadline.
+// config is called for every request so that reloaded budgets apply at once.
+func Deadline(config func() DeadlineConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := config()
+			limit := cfg.Default
+			if route := mux.CurrentRoute(r); route != nil {
+				if tpl, err := route.GetPathTemplate(); err == nil {
+					if d, ok := cfg.Routes[r.Method+" "+tpl]; ok {
+						limit = d
+					}
+				}
+			}
+
+			span := trace.SpanFromContext(r.Context())
+
+			budget := limit
+			source := "route"
+			if raw := r.Header.Get(RequestTimeoutHeader); raw != "" {
+				requested, err := parseRequestTimeout(raw)
+				if err != nil {
+					openapi.WriteValidationError(w, r, []openapi.FieldError{{
+						In:      "header",
+						Field:   RequestTimeoutHeader,
+						Message: err.Error(),
+					}})
+					return
+				}
+				if limit == 0 || requested < limit {
+					budget = requested
+					source = "header"
+				}
+			}
+
+			if budget == 0 {
+				span.SetAttributes(attribute.String("deadline.source", "none"))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			span.SetAttributes(
+				attribute.Int64("deadline.budget_ms", budget.Milliseconds()),
+				attribute.String("deadline.source", source),
+			)
+
+			ctx, cancel := context.WithTimeout(r.Context(), budget)
+			defer cancel()
+			ctx = context.WithValue(ctx, budgetKey, budget)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Budget returns the total time budget the Deadline middleware granted the
+// request.
+func Budget(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(budgetKey).(time.Duration)
+	return d, ok
+}
+
+// parseRequestTimeout accepts a Go duration ("1500ms") or a number of
+// seconds ("1.5").
+func parseRequestTimeout(raw string) (time.Duration, error) {
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		secs, ferr := strconv.ParseFloat(raw, 64)
+		if ferr != nil {
+			return 0, fmt.Errorf("must be a duration such as 500ms or a number of seconds")
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
@@ -0,0 +1,89 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/gorilla/mux"
+)
+
+func TestDeadline(t *testing.T) {
+	cfg := middleware.DeadlineConfig{
+		Default: 5 * time.Second,
+		Routes: map[string]time.Duration{
+			"POST /api/v1/batch":       10 * time.Second,
+			"GET /api/v1/tasks/events": 0,
+		},
+	}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     string
+		wantStatus int
+		want       time.Duration // 0 for no deadline
+	}{
+		{"default", "GET", "/api/v1/tasks", "", http.StatusOK, 5 * time.Second},
+		{"route budget", "POST", "/api/v1/batch", "", http.StatusOK, 10 * time.Second},
+		{"route budget is per method", "GET", "/api/v1/batch", "", http.StatusOK, 5 * time.Second},
+		{"unlimited route", "GET", "/api/v1/tasks/events", "", http.StatusOK, 0},
+		{"header shortens", "GET", "/api/v1/tasks", "1500ms", http.StatusOK, 1500 * time.Millisecond},
+		{"header in seconds", "GET", "/api/v1/tasks", "0.5", http.StatusOK, 500 * time.Millisecond},
+		{"header is capped", "GET", "/api/v1/tasks", "1m", http.StatusOK, 5 * time.Second},
+		{"header on unlimited route", "GET", "/api/v1/tasks/events", "2s", http.StatusOK, 2 * time.Second},
+		{"invalid header", "GET", "/api/v1/tasks", "soon", http.StatusBadRequest, 0},
+		{"zero header", "GET", "/api/v1/tasks", "0s", http.StatusBadRequest, 0},
+		{"negative header", "GET", "/api/v1/tasks", "-1", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var budget time.Duration
+			var remaining time.Duration
+			var hasDeadline, called bool
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				budget, _ = middleware.Budget(r.Context())
+				var deadline time.Time
+				if deadline, hasDeadline = r.Context().Deadline(); hasDeadline {
+					remaining = time.Until(deadline)
+				}
+			}
+
+			r := mux.NewRouter()
+			r.Use(middleware.Deadline(func() middleware.DeadlineConfig { return cfg }))
+			r.HandleFunc("/api/v1/tasks", handler).Methods("GET")
+			r.HandleFunc("/api/v1/tasks/events", handler).Methods("GET")
+			r.HandleFunc("/api/v1/batch", handler).Methods("GET", "POST")
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(middleware.RequestTimeoutHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if called {
+					t.Error("handler called for a rejected request")
+				}
+				return
+			}
+			if budget != tt.want {
+				t.Errorf("Budget = %v, want %v", budget, tt.want)
+			}
+			if hasDeadline != (tt.want != 0) {
+				t.Fatalf("deadline set = %v, want %v", hasDeadline, tt.want != 0)
+			}
+			if hasDeadline && (remaining > tt.want || remaining < tt.want-time.Second) {
+				t.Errorf("%v left of the budget, want about %v", remaining, tt.want)
+			}
+		})
+	}
+}
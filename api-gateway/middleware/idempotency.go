@@ -0,0 +1,218 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/idempotency"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a response served from the store.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	maxRecordedBody         = 1 << 20
+
+	// A claim is held for a few times the request's budget, so a key whose
+	// gateway died mid-request frees up soon rather than after the TTL.
+	claimLeaseFactor  = 3
+	minClaimLease     = 10 * time.Second
+	defaultClaimLease = time.Minute
+)
+
+// replayedHeaders are the response headers stored with a response. Headers
+// that describe the current request, such as X-Request-ID, are left out.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+type IdempotencyConfig struct {
+	Enabled bool
+	TTL     time.Duration
+	// Routes is keyed by "METHOD /path/template".
+	Routes []string
+}
+
+// Idempotency executes a request carrying an Idempotency-Key at most once
+// per key. A repeat with the same body gets the stored response, a repeat
+// while the first is still running gets 409 and a repeat with a different
+// body gets 422. Keys are scoped by tenant and route. Server errors are not
+// stored, so a request that failed that way can be retried with its key.
+func Idempotency(store idempotency.Store, config func() IdempotencyConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			cfg := config()
+			if key == "" || !cfg.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var route string
+			if current := mux.CurrentRoute(r); current != nil {
+				if tpl, err := current.GetPathTemplate(); err == nil {
+					route = r.Method + " " + tpl
+				}
+			}
+			if !contains(cfg.Routes, route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			span := trace.SpanFromContext(r.Context())
+			if len(key) > maxIdempotencyKeyLength || !printableASCII(key) {
+				requestid.Error(w, r, "Idempotency-Key must be 1 to 255 printable ASCII characters", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxRecordedBody+1))
+			if err != nil {
+				requestid.Error(w, r, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			if len(body) > maxRecordedBody {
+				requestid.Error(w, r, "Request body too large for an idempotent request", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			scoped := tenant.FromContext(r.Context()) + " " + route + " " + key
+			sum := sha256.Sum256(append([]byte(r.URL.RequestURI()+"\n"), body...))
+			fingerprint := hex.EncodeToString(sum[:])
+
+			stored, err := store.Claim(r.Context(), scoped, fingerprint, claimLease(r.Context(), cfg.TTL), cfg.TTL)
+			outcome := "executed"
+			switch {
+			case errors.Is(err, idempotency.ErrInFlight):
+				outcome = "in_flight"
+			case errors.Is(err, idempotency.ErrMismatch):
+				outcome = "mismatch"
+			case err != nil:
+				outcome = "store_error"
+			case stored != nil:
+				outcome = "replayed"
+			}
+			span.SetAttributes(
+				attribute.Bool("idempotency.replayed", outcome == "replayed"),
+				attribute.String("idempotency.outcome", outcome),
+			)
+
+			switch outcome {
+			case "in_flight":
+				w.Header().Set("Retry-After", "1")
+				requestid.JSONError(w, r, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+				return
+			case "mismatch":
+				requestid.JSONError(w, r, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+				return
+			case "store_error":
+				span.RecordError(err)
+				requestid.Error(w, r, "Idempotency store unavailable", http.StatusServiceUnavailable)
+				return
+			case "replayed":
+				for name, values := range stored.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+				return
+			}
+
+			rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+			// The outcome is saved even if the client has gone away, since
+			// that is exactly when it will retry.
+			storeCtx := context.WithoutCancel(r.Context())
+			completed := false
+			defer func() {
+				if !completed {
+					if err := store.Release(storeCtx, scoped); err != nil {
+						slog.ErrorContext(storeCtx, "Failed to release idempotency key", "error", err)
+					}
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+
+			if rec.status >= 500 || rec.overflow {
+				return
+			}
+			resp := &idempotency.Response{Status: rec.status, Header: http.Header{}, Body: rec.body.Bytes()}
+			for _, name := range replayedHeaders {
+				if v := rec.Header().Values(name); len(v) > 0 {
+					resp.Header[name] = v
+				}
+			}
+			if err := store.Complete(storeCtx, scoped, resp); err != nil {
+				slog.ErrorContext(storeCtx, "Failed to store idempotent response", "error", err)
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// claimLease returns how long a claim made for the request in ctx is held
+// before the key can be taken again.
+func claimLease(ctx context.Context, ttl time.Duration) time.Duration {
+	lease := defaultClaimLease
+	if deadline, ok := ctx.Deadline(); ok {
+		lease = max(claimLeaseFactor*time.Until(deadline), minClaimLease)
+	}
+	return min(lease, ttl)
+}
+
+// recordingWriter passes the response through while keeping a copy of it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.status = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(data []byte) (int, error) {
+	rw.wroteHeader = true
+	if !rw.overflow {
+		if rw.body.Len()+len(data) > maxRecordedBody {
+			rw.overflow = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(data)
+		}
+	}
+	return rw.ResponseWriter.Write(data)
+}
+
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
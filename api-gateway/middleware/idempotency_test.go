@@ -0,0 +1,315 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/idempotency"
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	"github.com/gorilla/mux"
+)
+
+// idempotencyRequest is one request of a scenario and what it must get.
+type idempotencyRequest struct {
+	tenant string
+	path   string
+	key    string
+	body   string
+	// handlerStatus is what the handler answers if the request reaches it.
+	handlerStatus int
+
+	wantStatus   int
+	wantReplayed bool
+	wantExecuted bool
+}
+
+func TestIdempotency(t *testing.T) {
+	created := func(key, body string) idempotencyRequest {
+		return idempotencyRequest{key: key, body: body, handlerStatus: http.StatusCreated, wantStatus: http.StatusCreated, wantExecuted: true}
+	}
+	replayed := func(key, body string) idempotencyRequest {
+		return idempotencyRequest{key: key, body: body, handlerStatus: http.StatusCreated, wantStatus: http.StatusCreated, wantReplayed: true}
+	}
+
+	tests := []struct {
+		name     string
+		requests []idempotencyRequest
+	}{
+		{"without a key every request executes", []idempotencyRequest{
+			created("", `{"title":"a"}`),
+			created("", `{"title":"a"}`),
+		}},
+		{"a repeat is replayed", []idempotencyRequest{
+			created("k1", `{"title":"a"}`),
+			replayed("k1", `{"title":"a"}`),
+			replayed("k1", `{"title":"a"}`),
+		}},
+		{"a different body is rejected", []idempotencyRequest{
+			created("k1", `{"title":"a"}`),
+			{key: "k1", body: `{"title":"b"}`, wantStatus: http.StatusUnprocessableEntity},
+			replayed("k1", `{"title":"a"}`),
+		}},
+		{"keys are independent", []idempotencyRequest{
+			created("k1", `{"title":"a"}`),
+			created("k2", `{"title":"a"}`),
+		}},
+		{"keys are scoped by tenant", []idempotencyRequest{
+			created("k1", `{"title":"a"}`),
+			{tenant: "globex", key: "k1", body: `{"title":"a"}`, handlerStatus: http.StatusCreated, wantStatus: http.StatusCreated, wantExecuted: true},
+		}},
+		{"client errors are stored", []idempotencyRequest{
+			{key: "k1", body: `{}`, handlerStatus: http.StatusBadRequest, wantStatus: http.StatusBadRequest, wantExecuted: true},
+			{key: "k1", body: `{}`, handlerStatus: http.StatusCreated, wantStatus: http.StatusBadRequest, wantReplayed: true},
+		}},
+		{"server errors can be retried", []idempotencyRequest{
+			{key: "k1", body: `{"title":"a"}`, handlerStatus: http.StatusBadGateway, wantStatus: http.StatusBadGateway, wantExecuted: true},
+			created("k1", `{"title":"a"}`),
+			replayed("k1", `{"title":"a"}`),
+		}},
+		{"invalid key", []idempotencyRequest{
+			{key: "bad\tkey", body: `{}`, wantStatus: http.StatusBadRequest},
+			{key: strings.Repeat("k", 256), body: `{}`, wantStatus: http.StatusBadRequest},
+		}},
+		{"other routes are not covered", []idempotencyRequest{
+			{path: "/api/v1/users", key: "k1", body: `{}`, handlerStatus: http.StatusCreated, wantStatus: http.StatusCreated, wantExecuted: true},
+			{path: "/api/v1/users", key: "k1", body: `{}`, handlerStatus: http.StatusCreated, wantStatus: http.StatusCreated, wantExecuted: true},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handlerStatus int
+			executed := false
+			r := newIdempotencyRouter(idempotency.NewMemoryStore(), func(w http.ResponseWriter, r *http.Request) {
+				executed = true
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("X-Not-Stored", "1")
+				w.WriteHeader(handlerStatus)
+				fmt.Fprintf(w, `{"status":%d}`, handlerStatus)
+			})
+
+			for i, req := range tt.requests {
+				handlerStatus = req.handlerStatus
+				executed = false
+				rec := serveIdempotent(r, req)
+
+				if rec.Code != req.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d (body %q)", i, rec.Code, req.wantStatus, rec.Body.String())
+				}
+				if executed != req.wantExecuted {
+					t.Errorf("request %d: executed = %v, want %v", i, executed, req.wantExecuted)
+				}
+				if got := rec.Header().Get(middleware.IdempotentReplayedHeader) == "true"; got != req.wantReplayed {
+					t.Errorf("request %d: replayed = %v, want %v", i, got, req.wantReplayed)
+				}
+				if req.wantReplayed {
+					if rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("X-Not-Stored") != "" {
+						t.Errorf("request %d: replayed headers %v", i, rec.Header())
+					}
+					if want := fmt.Sprintf(`{"status":%d}`, req.wantStatus); rec.Body.String() != want {
+						t.Errorf("request %d: replayed body %q, want %q", i, rec.Body.String(), want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// assertErrorBody checks that rec holds the JSON error body the OpenAPI
+// document promises for 409 and 422.
+func assertErrorBody(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%d: Content-Type = %q, want application/json", rec.Code, ct)
+	}
+	var body requestid.ErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+		t.Errorf("%d: body %q is not an error object", rec.Code, rec.Body.String())
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	r := newIdempotencyRouter(idempotency.NewMemoryStore(), func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	req := idempotencyRequest{key: "k1", body: `{"title":"a"}`}
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serveIdempotent(r, req) }()
+	<-entered
+
+	rec := serveIdempotent(r, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("concurrent repeat: status = %d, want 409", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("409 without Retry-After")
+	}
+	assertErrorBody(t, rec)
+
+	// A different body is a mismatch even while the first is running
+	rec = serveIdempotent(r, idempotencyRequest{key: "k1", body: `{"title":"b"}`})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("concurrent mismatch: status = %d, want 422", rec.Code)
+	}
+	assertErrorBody(t, rec)
+
+	close(release)
+	if rec := <-first; rec.Code != http.StatusCreated {
+		t.Fatalf("first request: status = %d, want 201", rec.Code)
+	}
+	if rec := serveIdempotent(r, req); rec.Code != http.StatusCreated || rec.Header().Get(middleware.IdempotentReplayedHeader) != "true" {
+		t.Errorf("repeat after completion: status = %d, replayed = %q", rec.Code, rec.Header().Get(middleware.IdempotentReplayedHeader))
+	}
+}
+
+func TestIdempotencyStoreUnavailable(t *testing.T) {
+	executed := false
+	r := newIdempotencyRouter(failingStore{}, func(w http.ResponseWriter, r *http.Request) {
+		executed = true
+	})
+
+	rec := serveIdempotent(r, idempotencyRequest{key: "k1", body: `{}`})
+	if rec.Code != http.StatusServiceUnavailable || executed {
+		t.Errorf("status = %d, executed = %v; want 503 without executing", rec.Code, executed)
+	}
+}
+
+func TestIdempotencyClaimLease(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{"a few times the budget", 10 * time.Second, 30 * time.Second},
+		{"at least the minimum", time.Second, 10 * time.Second},
+		{"without a deadline", 0, time.Minute},
+		{"at most the TTL", time.Hour, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &leaseStore{Store: idempotency.NewMemoryStore()}
+			r := newIdempotencyRouter(store, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			})
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{}`)).WithContext(ctx)
+			req.Header.Set(tenant.Header, "acme")
+			req.Header.Set(middleware.IdempotencyKeyHeader, "k1")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			// The deadline has been running since the request was built
+			if store.lease > tt.want || store.lease < tt.want-time.Second {
+				t.Errorf("lease = %v, want %v", store.lease, tt.want)
+			}
+			if store.ttl != time.Hour {
+				t.Errorf("ttl = %v, want 1h", store.ttl)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreLease(t *testing.T) {
+	ctx := context.Background()
+	store := idempotency.NewMemoryStore()
+
+	// An abandoned claim frees the key once its lease runs out
+	if _, err := store.Claim(ctx, "k1", "f", 10*time.Millisecond, time.Hour); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if _, err := store.Claim(ctx, "k1", "f", 10*time.Millisecond, time.Hour); !errors.Is(err, idempotency.ErrInFlight) {
+		t.Fatalf("Claim within the lease = %v, want ErrInFlight", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if stored, err := store.Claim(ctx, "k1", "f", 10*time.Millisecond, time.Hour); stored != nil || err != nil {
+		t.Fatalf("Claim after the lease = %v, %v; want the key to be free", stored, err)
+	}
+
+	// A completed response is kept for the TTL, not the lease
+	if err := store.Complete(ctx, "k1", &idempotency.Response{Status: http.StatusCreated}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if stored, err := store.Claim(ctx, "k1", "f", 10*time.Millisecond, time.Hour); err != nil || stored == nil || stored.Status != http.StatusCreated {
+		t.Errorf("Claim after completion = %v, %v; want the stored 201", stored, err)
+	}
+}
+
+// leaseStore records the lease and TTL of the last claim.
+type leaseStore struct {
+	idempotency.Store
+	lease, ttl time.Duration
+}
+
+func (s *leaseStore) Claim(ctx context.Context, key, fingerprint string, lease, ttl time.Duration) (*idempotency.Response, error) {
+	s.lease, s.ttl = lease, ttl
+	return s.Store.Claim(ctx, key, fingerprint, lease, ttl)
+}
+
+// newIdempotencyRouter serves handler on POST /api/v1/tasks, which is
+// covered by the middleware, and POST /api/v1/users, which is not. The
+// tenant comes from X-Tenant-ID.
+func newIdempotencyRouter(store idempotency.Store, handler http.HandlerFunc) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), r.Header.Get(tenant.Header))))
+		})
+	})
+	r.Use(middleware.Idempotency(store, func() middleware.IdempotencyConfig {
+		return middleware.IdempotencyConfig{Enabled: true, TTL: time.Hour, Routes: []string{"POST /api/v1/tasks"}}
+	}))
+	r.HandleFunc("/api/v1/tasks", handler).Methods("POST")
+	r.HandleFunc("/api/v1/users", handler).Methods("POST")
+	return r
+}
+
+func serveIdempotent(r http.Handler, req idempotencyRequest) *httptest.ResponseRecorder {
+	path := req.path
+	if path == "" {
+		path = "/api/v1/tasks"
+	}
+	tenantID := req.tenant
+	if tenantID == "" {
+		tenantID = "acme"
+	}
+
+	httpReq := httptest.NewRequest("POST", path, strings.NewReader(req.body))
+	httpReq.Header.Set(tenant.Header, tenantID)
+	if req.key != "" {
+		httpReq.Header.Set(middleware.IdempotencyKeyHeader, req.key)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httpReq)
+	return rec
+}
+
+type failingStore struct{}
+
+func (failingStore) Claim(context.Context, string, string, time.Duration, time.Duration) (*idempotency.Response, error) {
+	return nil, errors.New("store down")
+}
+
+func (failingStore) Complete(context.Context, string, *idempotency.Response) error { return nil }
+
+func (failingStore) Release(context.Context, string) error { return nil }
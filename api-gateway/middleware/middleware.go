@@ -1,29 +1,32 @@
 package middleware
 
 import (
-	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/gorilla/mux"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
 
 type contextKey string
 
-const RequestIDKey contextKey = "request-id"
-
+// RequestID adopts the client's X-Request-ID when it is well formed and
+// assigns a new ULID otherwise. The gRPC clients forward it to the services.
 func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := r.Header.Get("X-Request-ID")
-		if requestID == "" {
-			requestID = generateRequestID()
+		requestID := r.Header.Get(requestid.Header)
+		if !requestid.Valid(requestID) {
+			requestID = requestid.New()
 		}
-		
-		ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
-		w.Header().Set("X-Request-ID", requestID)
-		
+
+		ctx := requestid.NewContext(r.Context(), requestID)
+		trace.SpanFromContext(ctx).SetAttributes(requestid.Key.String(requestID))
+		w.Header().Set(requestid.Header, requestID)
+
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -58,7 +61,7 @@ func Logger(next http.Handler) http.Handler {
 		)
 		
 		// Log request
-		requestID := r.Context().Value(RequestIDKey).(string)
+		requestID := requestid.FromContext(r.Context())
 		log.Printf("[%s] %s %s %d %dms %d bytes",
 			requestID,
 			r.Method,
@@ -70,21 +73,32 @@ func Logger(next http.Handler) http.Handler {
 	})
 }
 
-func CORS(next http.Handler) http.Handler {
+// TraceResponse sets the W3C traceresponse header so that clients can look
+// up the trace for a request they made.
+func TraceResponse(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
-		
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+		sc := trace.SpanContextFromContext(r.Context())
+		if sc.IsValid() {
+			w.Header().Set("traceresponse", fmt.Sprintf("00-%s-%s-%s", sc.TraceID(), sc.SpanID(), sc.TraceFlags()))
 		}
-		
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Toggle applies mw only while enabled reports true.
+func Toggle(enabled func() bool, mw mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if enabled() {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -102,15 +116,14 @@ func (rw *responseWriter) Write(data []byte) (int, error) {
 	return size, err
 }
 
-func generateRequestID() string {
-	return time.Now().Format("20060102150405") + "-" + generateRandomString(8)
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
 }
 
-func generateRandomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
-	}
-	return string(b)
-}
\ No newline at end of file
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// lift the write deadline for streaming responses.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TenancyConfig controls how the gateway decides which tenant a request acts
+// for.
+type TenancyConfig struct {
+	// JWTClaim names the claim of a Bearer token that holds the tenant.
+	JWTClaim string
+	// AllowHeader lets requests name their tenant in X-Tenant-ID. When a
+	// token names one too, both must agree.
+	AllowHeader bool
+	// DefaultTenant is used when nothing names a tenant; empty rejects such
+	// requests.
+	DefaultTenant string
+	// Secret verifies HS256 tokens and their exp and nbf claims. Without
+	// one, every bearer token is rejected rather than trusted unverified.
+	Secret []byte
+}
+
+// Tenant resolves the tenant of every API request and puts it into the
+// context, the baggage and the server span; the gRPC clients forward it to
+// the services. Health and API description routes are not tenant-scoped.
+func Tenant(config func() TenancyConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/graphql" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			span := trace.SpanFromContext(r.Context())
+			id, source, status, err := resolveTenant(r, config())
+			if err != nil {
+				span.SetAttributes(attribute.String("tenant.error", err.Error()))
+				if status == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				}
+				requestid.Error(w, r, err.Error(), status)
+				return
+			}
+
+			span.SetAttributes(
+				tenant.Key.String(id),
+				attribute.String("tenant.source", source),
+			)
+			next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), id)))
+		})
+	}
+}
+
+// resolveTenant returns the tenant, where it came from ("jwt", "header" or
+// "default"), and the HTTP status to reject the request with on error.
+func resolveTenant(r *http.Request, cfg TenancyConfig) (string, string, int, error) {
+	var fromToken string
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && cfg.JWTClaim != "" {
+		claims, err := parseJWT(token, cfg.Secret, time.Now())
+		if err != nil {
+			return "", "", http.StatusUnauthorized, fmt.Errorf("invalid bearer token: %w", err)
+		}
+		if v, ok := claims[cfg.JWTClaim]; ok {
+			s, isString := v.(string)
+			if !isString || !tenant.Valid(s) {
+				return "", "", http.StatusUnauthorized, fmt.Errorf("invalid bearer token: claim %q is not a valid tenant", cfg.JWTClaim)
+			}
+			fromToken = s
+		}
+	}
+
+	fromHeader := r.Header.Get(tenant.Header)
+	if fromHeader != "" {
+		if !cfg.AllowHeader && fromToken == "" {
+			return "", "", http.StatusBadRequest, fmt.Errorf("%s is not accepted; use a bearer token", tenant.Header)
+		}
+		if !tenant.Valid(fromHeader) {
+			return "", "", http.StatusBadRequest, fmt.Errorf("invalid %s", tenant.Header)
+		}
+	}
+
+	switch {
+	case fromToken != "" && fromHeader != "" && fromHeader != fromToken:
+		return "", "", http.StatusForbidden, fmt.Errorf("%s does not match the bearer token's tenant", tenant.Header)
+	case fromToken != "":
+		return fromToken, "jwt", 0, nil
+	case fromHeader != "":
+		return fromHeader, "header", 0, nil
+	case cfg.DefaultTenant != "":
+		return cfg.DefaultTenant, "default", 0, nil
+	}
+	return "", "", http.StatusBadRequest, fmt.Errorf("a tenant is required: send a bearer token or %s", tenant.Header)
+}
+
+// parseJWT decodes a compact JWS and returns its claims. The token must be
+// HS256-signed with secret and within its validity period.
+func parseJWT(token string, secret []byte, now time.Time) (map[string]interface{}, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("no secret to verify tokens with")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed header")
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed claims")
+	}
+
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.New("signature mismatch")
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && now.Unix() >= int64(exp) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Unix() < int64(nbf) {
+		return nil, errors.New("token not yet valid")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,196 @@
+package middleware_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+)
+
+var testSecret = []byte("tenant-test-secret")
+
+// signJWT returns a token with the given alg header whose signature is an
+// HS256 MAC of secret, whatever alg says.
+func signJWT(t *testing.T, alg string, claims map[string]interface{}, secret []byte) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := segment(map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestTenant(t *testing.T) {
+	now := time.Now().Unix()
+	valid := signJWT(t, "HS256", map[string]interface{}{"tenant_id": "acme", "exp": now + 60}, testSecret)
+
+	tests := []struct {
+		name   string
+		config middleware.TenancyConfig
+		path   string
+		token  string
+		header string
+		// want is the resolved tenant, or empty when the request is
+		// rejected with wantStatus
+		want       string
+		wantStatus int
+	}{
+		{
+			name:   "verified token",
+			config: middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret},
+			token:  valid,
+			want:   "acme",
+		},
+		{
+			name:       "token without a secret to verify it",
+			config:     middleware.TenancyConfig{JWTClaim: "tenant_id", DefaultTenant: "default"},
+			token:      signJWT(t, "none", map[string]interface{}{"tenant_id": "acme"}, nil),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "bad signature",
+			config:     middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret},
+			token:      signJWT(t, "HS256", map[string]interface{}{"tenant_id": "acme"}, []byte("other")),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unsupported algorithm",
+			config:     middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret},
+			token:      signJWT(t, "none", map[string]interface{}{"tenant_id": "acme"}, testSecret),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "expired",
+			config:     middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret},
+			token:      signJWT(t, "HS256", map[string]interface{}{"tenant_id": "acme", "exp": now - 1}, testSecret),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "not yet valid",
+			config:     middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret},
+			token:      signJWT(t, "HS256", map[string]interface{}{"tenant_id": "acme", "nbf": now + 60}, testSecret),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "malformed token",
+			config:     middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret},
+			token:      "not-a-jwt",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "claim is not a tenant",
+			config:     middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret},
+			token:      signJWT(t, "HS256", map[string]interface{}{"tenant_id": 42}, testSecret),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "token without the claim falls back to the default",
+			config: middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret, DefaultTenant: "default"},
+			token:  signJWT(t, "HS256", map[string]interface{}{"sub": "ann"}, testSecret),
+			want:   "default",
+		},
+		{
+			name:   "header",
+			config: middleware.TenancyConfig{AllowHeader: true},
+			header: "acme",
+			want:   "acme",
+		},
+		{
+			name:       "header not allowed",
+			config:     middleware.TenancyConfig{DefaultTenant: "default"},
+			header:     "acme",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid header",
+			config:     middleware.TenancyConfig{AllowHeader: true},
+			header:     "not a tenant!",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "header matching the token",
+			config: middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret},
+			token:  valid,
+			header: "acme",
+			want:   "acme",
+		},
+		{
+			name:       "header contradicting the token",
+			config:     middleware.TenancyConfig{JWTClaim: "tenant_id", Secret: testSecret, AllowHeader: true},
+			token:      valid,
+			header:     "globex",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "default",
+			config: middleware.TenancyConfig{DefaultTenant: "default"},
+			want:   "default",
+		},
+		{
+			name:       "no tenant",
+			config:     middleware.TenancyConfig{AllowHeader: true},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "health routes are not tenant-scoped",
+			config: middleware.TenancyConfig{},
+			path:   "/healthz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			called := false
+			handler := middleware.Tenant(func() middleware.TenancyConfig { return tt.config })(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					called = true
+					got = tenant.FromContext(r.Context())
+				}),
+			)
+
+			path := tt.path
+			if path == "" {
+				path = "/api/v1/tasks"
+			}
+			req := httptest.NewRequest("GET", path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.header != "" {
+				req.Header.Set(tenant.Header, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.wantStatus != 0 {
+				if called || rec.Code != tt.wantStatus {
+					t.Fatalf("status = %d, handler called = %v; want %d", rec.Code, called, tt.wantStatus)
+				}
+				if tt.wantStatus == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+					t.Error("401 without WWW-Authenticate")
+				}
+				return
+			}
+			if !called {
+				t.Fatalf("request rejected with %d: %s", rec.Code, rec.Body.String())
+			}
+			if got != tt.want {
+				t.Errorf("tenant = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
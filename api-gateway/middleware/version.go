@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AliasConfig describes an unversioned path prefix kept for old clients.
+type AliasConfig struct {
+	Enabled bool
+	// DeprecatedAt and Sunset are announced in the Deprecation (RFC 9745)
+	// and Sunset (RFC 8594) headers; zero values leave a header out.
+	DeprecatedAt time.Time
+	Sunset       time.Time
+}
+
+// DeprecatedAlias serves the routes under prefix+version also under prefix
+// alone, e.g. /api/tasks as /api/v1/tasks. The path is rewritten before
+// routing, so route templates, timeouts and CORS policies only ever see the
+// versioned path. Responses on the alias carry Deprecation, Sunset and a
+// successor-version link. config is called for every request so that
+// reloads apply at once; a disabled alias answers 404 like any unknown path.
+func DeprecatedAlias(prefix, version string, config func() AliasConfig) func(http.Handler) http.Handler {
+	versioned := prefix + version
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+			if !ok || !strings.HasPrefix(rest, "/") || rest == version || strings.HasPrefix(rest, version+"/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cfg := config()
+			if !cfg.Enabled {
+				http.NotFound(w, r)
+				return
+			}
+
+			target := versioned + rest
+			if !cfg.DeprecatedAt.IsZero() {
+				w.Header().Set("Deprecation", fmt.Sprintf("@%d", cfg.DeprecatedAt.Unix()))
+			}
+			if !cfg.Sunset.IsZero() {
+				w.Header().Set("Sunset", cfg.Sunset.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, target))
+
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				attribute.Bool("api.deprecated_alias", true),
+				attribute.String("api.version", strings.TrimPrefix(version, "/")),
+			)
+
+			rewritten := r.Clone(r.Context())
+			rewritten.URL.Path = target
+			rewritten.URL.RawPath = ""
+			rewritten.RequestURI = rewritten.URL.RequestURI()
+			next.ServeHTTP(w, rewritten)
+		})
+	}
+}
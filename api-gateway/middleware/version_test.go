@@ -0,0 +1,93 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/middleware"
+)
+
+func TestDeprecatedAlias(t *testing.T) {
+	deprecatedAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2025, 1, 1, 0, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+	enabled := middleware.AliasConfig{Enabled: true, DeprecatedAt: deprecatedAt, Sunset: sunset}
+
+	tests := []struct {
+		name       string
+		config     middleware.AliasConfig
+		target     string
+		wantStatus int
+		wantURI    string // as the router sees it
+		deprecated bool
+	}{
+		{"versioned path", enabled, "/api/v1/tasks", http.StatusOK, "/api/v1/tasks", false},
+		{"version root", enabled, "/api/v1", http.StatusOK, "/api/v1", false},
+		{"alias", enabled, "/api/tasks", http.StatusOK, "/api/v1/tasks", true},
+		{"alias with ID and query", enabled, "/api/tasks/t1?expand=assignee", http.StatusOK, "/api/v1/tasks/t1?expand=assignee", true},
+		{"other prefix", enabled, "/apis/tasks", http.StatusOK, "/apis/tasks", false},
+		{"prefix alone", enabled, "/api", http.StatusOK, "/api", false},
+		{"unversioned route", enabled, "/health", http.StatusOK, "/health", false},
+		{"disabled alias", middleware.AliasConfig{}, "/api/tasks", http.StatusNotFound, "", false},
+		{"disabled alias leaves versioned paths", middleware.AliasConfig{}, "/api/v1/tasks", http.StatusOK, "/api/v1/tasks", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.config
+			var gotURI string
+			handler := middleware.DeprecatedAlias("/api", "/v1", func() middleware.AliasConfig { return cfg })(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					gotURI = r.RequestURI
+				}),
+			)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", tt.target, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotURI != tt.wantURI {
+				t.Errorf("router saw %q, want %q", gotURI, tt.wantURI)
+			}
+
+			h := rec.Header()
+			if got := h.Get("Deprecation") != ""; got != tt.deprecated {
+				t.Fatalf("Deprecation = %q, want set %v", h.Get("Deprecation"), tt.deprecated)
+			}
+			if !tt.deprecated {
+				if h.Get("Sunset") != "" || h.Get("Link") != "" {
+					t.Errorf("headers on a current path: %v", h)
+				}
+				return
+			}
+			if got := h.Get("Deprecation"); got != "@1717200000" {
+				t.Errorf("Deprecation = %q, want @1717200000", got)
+			}
+			if got := h.Get("Sunset"); got != "Tue, 31 Dec 2024 15:00:00 GMT" {
+				t.Errorf("Sunset = %q", got)
+			}
+			path, _, _ := strings.Cut(tt.wantURI, "?")
+			if got, want := h.Get("Link"), `<`+path+`>; rel="successor-version"`; got != want {
+				t.Errorf("Link = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDeprecatedAliasWithoutDates(t *testing.T) {
+	handler := middleware.DeprecatedAlias("/api", "/v1", func() middleware.AliasConfig {
+		return middleware.AliasConfig{Enabled: true}
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/tasks", nil))
+
+	if rec.Header().Get("Deprecation") != "" || rec.Header().Get("Sunset") != "" {
+		t.Errorf("zero dates announced: %v", rec.Header())
+	}
+	if rec.Header().Get("Link") != `</api/v1/tasks>; rel="successor-version"` {
+		t.Errorf("Link = %q", rec.Header().Get("Link"))
+	}
+}
@@ -0,0 +1,208 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var patterns sync.Map // string -> *regexp.Regexp
+
+// Resolve follows a local "#/components/schemas/..." reference.
+func (d *Document) Resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// validateValue checks a decoded JSON value against a schema and returns one
+// FieldError per violation. field is the dotted path of the value.
+func (d *Document) validateValue(in, field string, s *Schema, v interface{}) []FieldError {
+	s = d.Resolve(s)
+	if s == nil {
+		return nil
+	}
+
+	fail := func(format string, args ...interface{}) []FieldError {
+		return []FieldError{{In: in, Field: field, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return fail("must be one of %s", enumList(s.Enum))
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fail("must be an object")
+		}
+		var errs []FieldError
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, FieldError{In: in, Field: join(field, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				errs = append(errs, d.validateValue(in, join(field, name), prop, obj[name])...)
+			} else if s.AdditionalProperties != nil {
+				errs = append(errs, d.validateValue(in, join(field, name), s.AdditionalProperties, obj[name])...)
+			}
+		}
+		return errs
+
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fail("must be an array")
+		}
+		if s.MinItems != nil && len(arr) < *s.MinItems {
+			return fail("must contain at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(arr) > *s.MaxItems {
+			return fail("must contain at most %d items", *s.MaxItems)
+		}
+		var errs []FieldError
+		for i, item := range arr {
+			errs = append(errs, d.validateValue(in, fmt.Sprintf("%s[%d]", field, i), s.Items, item)...)
+		}
+		return errs
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		n := utf8.RuneCountInString(str)
+		if s.MinLength != nil && n < *s.MinLength {
+			if *s.MinLength == 1 {
+				return fail("must not be empty")
+			}
+			return fail("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fail("must be at most %d characters", *s.MaxLength)
+		}
+		if msg := checkFormat(s.Format, str); msg != "" {
+			return fail("%s", msg)
+		}
+		if s.Pattern != "" && !compilePattern(s.Pattern).MatchString(str) {
+			return fail("must match %s", s.Pattern)
+		}
+
+	case "integer", "number":
+		num, ok := v.(json.Number)
+		if !ok {
+			return fail("must be %s", typeNoun(s.Type))
+		}
+		f, err := num.Float64()
+		if err != nil {
+			return fail("must be %s", typeNoun(s.Type))
+		}
+		if s.Type == "integer" {
+			if _, err := num.Int64(); err != nil {
+				return fail("must be an integer")
+			}
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fail("must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fail("must be <= %v", *s.Maximum)
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fail("must be a boolean")
+		}
+	}
+
+	return nil
+}
+
+func checkFormat(format, v string) string {
+	switch format {
+	case "email":
+		if addr, err := mail.ParseAddress(v); err != nil || addr.Address != v {
+			return "must be a valid email address"
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(v) {
+			return "must be a UUID"
+		}
+	case "uri":
+		if u, err := url.Parse(v); err != nil || !u.IsAbs() {
+			return "must be an absolute URI"
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return "must be an RFC 3339 date-time"
+		}
+	}
+	return ""
+}
+
+func compilePattern(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patterns.Store(pattern, re)
+	return re
+}
+
+func typeNoun(t string) string {
+	if t == "integer" {
+		return "an integer"
+	}
+	return "a " + t
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	if num, ok := v.(json.Number); ok {
+		for _, e := range enum {
+			if fmt.Sprint(e) == num.String() {
+				return true
+			}
+		}
+		return false
+	}
+	for _, e := range enum {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []interface{}) string {
+	parts := make([]string, len(enum))
+	for i, e := range enum {
+		parts[i] = fmt.Sprint(e)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func join(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,849 @@
+package openapi
+
+const (
+	jsonContentType = "application/json"
+	textContentType = "text/plain"
+)
+
+const userIDPattern = "^user-[0-9]+$"
+
+var taskStatusNames = []interface{}{"TODO", "IN_PROGRESS", "DONE"}
+
+var taskEventTypeNames = []interface{}{"CREATED", "UPDATED", "DELETED"}
+
+// Spec builds the OpenAPI document describing every route served by the
+// gateway. CheckRoutes keeps it in sync with the router.
+func Spec() *Document {
+	return &Document{
+		OpenAPI: "3.1.0",
+		Info: Info{
+			Title: "OtelLab API Gateway",
+			Description: "HTTP front door for the task and user services.\n\n" +
+				"- Request-Timeout (e.g. 500ms) shortens, but never extends, a route's budget; exceeding it fails with 504.\n" +
+				"- Requests act for the tenant in the bearer token's tenant claim or X-Tenant-ID, else the default tenant.\n" +
+				"- POST /api/v1/tasks and /api/v1/users replay the original response for a repeated Idempotency-Key.\n" +
+				"- /api is a deprecated alias of /api/v1.\n" +
+				"- Fields use snake_case names, timestamps are RFC 3339 and enums are rendered by name.",
+			Version: "1.0.0",
+		},
+		Servers: []Server{
+			{URL: "http://localhost:8080", Description: "Local docker-compose stack"},
+		},
+		Paths: map[string]*PathItem{
+			"/api/v1/tasks": {
+				Get: &Operation{
+					OperationID: "listTasks",
+					Summary:     "List tasks",
+					Tags:        []string{"tasks"},
+					Parameters: []*Parameter{
+						queryParam("page_size", "Number of tasks per page.", &Schema{Type: "integer", Minimum: float(1), Maximum: float(100)}),
+						queryParam("page_token", "next_page_token of the previous page; omit for the first page.", &Schema{Type: "string", MaxLength: length(512)}),
+						queryParam("include_total_count", "Also count every matching task, which costs an extra query.", &Schema{Type: "boolean"}),
+						queryParam("assignee_id", "Only return tasks assigned to this user.", assigneeID()),
+						queryParam("status", "Only return tasks in this status.", &Schema{Type: "string", Enum: taskStatusNames}),
+						expandParam(),
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("A page of tasks.", ref("ListTasksResponse")),
+						"400": validationErrorResponse(),
+						"500": textResponse("The task service failed."),
+					},
+				},
+				Post: &Operation{
+					OperationID: "createTask",
+					Summary:     "Create a task",
+					Tags:        []string{"tasks"},
+					RequestBody: jsonBody(ref("CreateTaskRequest")),
+					Responses: map[string]*Response{
+						"201": jsonResponse("The created task.", ref("Task")),
+						"400": validationErrorResponse(),
+						"413": bodyTooLargeResponse(),
+						"409": errorResponse("A request with the same Idempotency-Key is still in progress."),
+						"422": errorResponse("The assignee does not exist, or the Idempotency-Key was already used with a different request body."),
+						"500": textResponse("The task service failed."),
+						"503": textResponse("The assignee could not be verified. Retry after the Retry-After delay."),
+					},
+				},
+			},
+			"/api/v1/tasks/events": {
+				Get: &Operation{
+					OperationID: "watchTasks",
+					Summary:     "Stream task changes as Server-Sent Events",
+					Tags:        []string{"tasks"},
+					Parameters: []*Parameter{
+						queryParam("assignee_id", "Only stream events for tasks assigned to this user.", assigneeID()),
+						queryParam("status", "Only stream events for tasks in this status.", &Schema{Type: "string", Enum: taskStatusNames}),
+						{
+							Name:        "Last-Event-ID",
+							In:          "header",
+							Description: "Resume after this event sequence number.",
+							Schema:      &Schema{Type: "string"},
+						},
+					},
+					Responses: map[string]*Response{
+						"200": {
+							Description: "A stream of task.created, task.updated and task.deleted events whose data is a TaskEvent.",
+							Content: map[string]*MediaType{
+								"text/event-stream": {Schema: ref("TaskEvent")},
+							},
+						},
+						"400": validationErrorResponse(),
+						"500": textResponse("The task service failed."),
+					},
+				},
+			},
+			"/api/v1/tasks/export": {
+				Get: &Operation{
+					OperationID: "exportTasks",
+					Summary:     "Export tasks as CSV or NDJSON",
+					Description: "Streams every task matching the filters, oldest first. CSV exports start with the header " +
+						"id,title,description,status,assignee_id,created_at,updated_at; NDJSON exports have one Task per line.",
+					Tags: []string{"tasks"},
+					Parameters: []*Parameter{
+						queryParam("format", "File format; defaults to csv.", &Schema{Type: "string", Enum: []interface{}{"csv", "ndjson"}}),
+						queryParam("assignee_id", "Only export tasks assigned to this user.", assigneeID()),
+						queryParam("status", "Only export tasks in this status.", &Schema{Type: "string", Enum: taskStatusNames}),
+					},
+					Responses: map[string]*Response{
+						"200": {
+							Description: "The tasks. A failure after the first row ends the body early.",
+							Content: map[string]*MediaType{
+								"text/csv":             {Schema: &Schema{Type: "string"}},
+								"application/x-ndjson": {Schema: ref("Task")},
+							},
+						},
+						"400": validationErrorResponse(),
+						"500": textResponse("The task service failed."),
+					},
+				},
+			},
+			"/api/v1/tasks/import": {
+				Post: &Operation{
+					OperationID: "importTasks",
+					Summary:     "Import tasks from CSV or NDJSON",
+					Description: "CSV files need a header row with a title column; description, status and assignee_id " +
+						"are optional and other columns, such as those of an export, are ignored. NDJSON rows are objects " +
+						"with the same fields. The format comes from the format parameter or the Content-Type. Rows are " +
+						"inserted in transactions of 100; invalid rows are skipped and reported by line.",
+					Tags: []string{"tasks"},
+					Parameters: []*Parameter{
+						queryParam("format", "File format; defaults to the one named by Content-Type.", &Schema{Type: "string", Enum: []interface{}{"csv", "ndjson"}}),
+					},
+					RequestBody: &RequestBody{
+						Required: true,
+						Content: map[string]*MediaType{
+							"text/csv":             {Schema: &Schema{Type: "string"}},
+							"application/x-ndjson": {Schema: ref("ImportTask")},
+						},
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("The import report.", ref("ImportTasksResponse")),
+						"400": jsonResponse("The file could not be read; rows before the failure may have been imported.", ref("ImportTasksResponse")),
+						"413": jsonResponse("The file is larger than 64 MiB; rows before the limit were imported.", ref("ImportTasksResponse")),
+						"415": textResponse("The format is neither CSV nor NDJSON."),
+						"500": jsonResponse("The task service failed; batches before the failure were imported.", ref("ImportTasksResponse")),
+					},
+				},
+			},
+			"/api/v1/tasks/{id}": {
+				Get: &Operation{
+					OperationID: "getTask",
+					Summary:     "Get a task",
+					Tags:        []string{"tasks"},
+					Parameters:  []*Parameter{taskIDParam(), expandParam()},
+					Responses: map[string]*Response{
+						"200": jsonResponse("The task.", ref("Task")),
+						"400": validationErrorResponse(),
+						"404": textResponse("The task does not exist."),
+						"500": textResponse("The assignee could not be expanded."),
+					},
+				},
+				Put: &Operation{
+					OperationID: "updateTask",
+					Summary:     "Update a task",
+					Tags:        []string{"tasks"},
+					Parameters:  []*Parameter{taskIDParam()},
+					RequestBody: jsonBody(ref("UpdateTaskRequest")),
+					Responses: map[string]*Response{
+						"200": jsonResponse("The updated task.", ref("Task")),
+						"400": validationErrorResponse(),
+						"413": bodyTooLargeResponse(),
+						"422": errorResponse("The assignee does not exist."),
+						"500": textResponse("The task service failed."),
+						"503": textResponse("The assignee could not be verified. Retry after the Retry-After delay."),
+					},
+				},
+				Delete: &Operation{
+					OperationID: "deleteTask",
+					Summary:     "Delete a task",
+					Tags:        []string{"tasks"},
+					Parameters:  []*Parameter{taskIDParam()},
+					Responses: map[string]*Response{
+						"204": {Description: "The task was deleted."},
+						"400": validationErrorResponse(),
+						"500": textResponse("The task service failed."),
+					},
+				},
+			},
+			"/api/v1/tasks/{id}/trace": {
+				Get: &Operation{
+					OperationID: "getTaskTrace",
+					Summary:     "Get a Jaeger search link for a task",
+					Tags:        []string{"tasks"},
+					Parameters:  []*Parameter{taskIDParam()},
+					Responses: map[string]*Response{
+						"200": jsonResponse("Trace lookup information.", ref("TaskTrace")),
+						"400": validationErrorResponse(),
+					},
+				},
+			},
+			"/api/v1/users": {
+				Get: &Operation{
+					OperationID: "listUsers",
+					Summary:     "List users",
+					Tags:        []string{"users"},
+					Parameters: []*Parameter{
+						queryParam("page_size", "Number of users per page.", &Schema{Type: "integer", Minimum: float(1), Maximum: float(100)}),
+						queryParam("page_token", "next_page_token of the previous page; omit for the first page.", &Schema{Type: "string", MaxLength: length(512)}),
+						queryParam("include_total_count", "Also count every matching user, which costs an extra query.", &Schema{Type: "boolean"}),
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("A page of users.", ref("ListUsersResponse")),
+						"400": validationErrorResponse(),
+						"500": textResponse("The user service failed."),
+					},
+				},
+				Post: &Operation{
+					OperationID: "createUser",
+					Summary:     "Create a user",
+					Tags:        []string{"users"},
+					RequestBody: jsonBody(ref("CreateUserRequest")),
+					Responses: map[string]*Response{
+						"201": jsonResponse("The created user.", ref("User")),
+						"400": validationErrorResponse(),
+						"413": bodyTooLargeResponse(),
+						"409": errorResponse("A request with the same Idempotency-Key is still in progress."),
+						"422": errorResponse("The Idempotency-Key was already used with a different request body."),
+						"500": textResponse("The user service failed."),
+					},
+				},
+			},
+			"/api/v1/users/{id}": {
+				Get: &Operation{
+					OperationID: "getUser",
+					Summary:     "Get a user",
+					Tags:        []string{"users"},
+					Parameters:  []*Parameter{userIDParam()},
+					Responses: map[string]*Response{
+						"200": jsonResponse("The user.", ref("User")),
+						"400": validationErrorResponse(),
+						"404": textResponse("The user does not exist."),
+					},
+				},
+			},
+			"/api/v1/webhooks": {
+				Get: &Operation{
+					OperationID: "listWebhooks",
+					Summary:     "List webhooks",
+					Tags:        []string{"webhooks"},
+					Responses: map[string]*Response{
+						"200": jsonResponse("The tenant's webhooks, without their secrets.", ref("ListWebhooksResponse")),
+						"500": textResponse("The task service failed."),
+					},
+				},
+				Post: &Operation{
+					OperationID: "createWebhook",
+					Summary:     "Subscribe a URL to task events",
+					Description: "Deliveries are POSTed as JSON with X-Webhook-Event, X-Webhook-Delivery, X-Webhook-Timestamp " +
+						"and X-Webhook-Signature: sha256=<hex HMAC-SHA256 of \"<timestamp>.<body>\" keyed with the secret>. " +
+						"Failed deliveries are retried with exponential backoff and end up as dead letters.",
+					Tags:        []string{"webhooks"},
+					RequestBody: jsonBody(ref("CreateWebhookRequest")),
+					Responses: map[string]*Response{
+						"201": jsonResponse("The created webhook. This is the only response that includes the secret.", ref("Webhook")),
+						"400": validationErrorResponse(),
+						"413": bodyTooLargeResponse(),
+						"500": textResponse("The task service failed."),
+					},
+				},
+			},
+			"/api/v1/webhooks/{id}": {
+				Get: &Operation{
+					OperationID: "getWebhook",
+					Summary:     "Get a webhook",
+					Tags:        []string{"webhooks"},
+					Parameters:  []*Parameter{webhookIDParam()},
+					Responses: map[string]*Response{
+						"200": jsonResponse("The webhook.", ref("Webhook")),
+						"400": validationErrorResponse(),
+						"404": textResponse("The webhook does not exist."),
+						"500": textResponse("The task service failed."),
+					},
+				},
+				Put: &Operation{
+					OperationID: "updateWebhook",
+					Summary:     "Replace a webhook's URL and filters",
+					Tags:        []string{"webhooks"},
+					Parameters:  []*Parameter{webhookIDParam()},
+					RequestBody: jsonBody(ref("UpdateWebhookRequest")),
+					Responses: map[string]*Response{
+						"200": jsonResponse("The updated webhook.", ref("Webhook")),
+						"400": validationErrorResponse(),
+						"413": bodyTooLargeResponse(),
+						"404": textResponse("The webhook does not exist."),
+						"500": textResponse("The task service failed."),
+					},
+				},
+				Delete: &Operation{
+					OperationID: "deleteWebhook",
+					Summary:     "Delete a webhook with its pending deliveries and dead letters",
+					Tags:        []string{"webhooks"},
+					Parameters:  []*Parameter{webhookIDParam()},
+					Responses: map[string]*Response{
+						"204": {Description: "The webhook was deleted."},
+						"400": validationErrorResponse(),
+						"404": textResponse("The webhook does not exist."),
+						"500": textResponse("The task service failed."),
+					},
+				},
+			},
+			"/api/v1/webhooks/{id}/dead-letters": {
+				Get: &Operation{
+					OperationID: "listDeadLetters",
+					Summary:     "List deliveries that failed on every attempt",
+					Tags:        []string{"webhooks"},
+					Parameters:  []*Parameter{webhookIDParam()},
+					Responses: map[string]*Response{
+						"200": jsonResponse("The 100 most recent dead letters, newest first.", ref("ListDeadLettersResponse")),
+						"400": validationErrorResponse(),
+						"404": textResponse("The webhook does not exist."),
+						"500": textResponse("The task service failed."),
+					},
+				},
+			},
+			"/api/v1/search": {
+				Get: &Operation{
+					OperationID: "search",
+					Summary:     "Search tasks and users",
+					Description: "Searches task titles and descriptions and user names and emails at the same time. Every word " +
+						"of q has to match; hits from both are ranked together by score. If one source fails, the other's " +
+						"results are returned with partial set and the failure reported under sources.",
+					Tags: []string{"search"},
+					Parameters: []*Parameter{
+						{
+							Name:        "q",
+							In:          "query",
+							Description: "Words to search for.",
+							Required:    true,
+							Schema:      &Schema{Type: "string", MinLength: length(1), MaxLength: length(200)},
+						},
+						queryParam("limit", "Maximum number of results.", &Schema{Type: "integer", Minimum: float(1), Maximum: float(50)}),
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("The ranked results, best first.", ref("SearchResponse")),
+						"400": validationErrorResponse(),
+						"502": textResponse("Every source failed."),
+					},
+				},
+			},
+			"/api/v1/batch": {
+				Post: &Operation{
+					OperationID: "batch",
+					Summary:     "Execute several API requests concurrently",
+					Tags:        []string{"batch"},
+					RequestBody: jsonBody(ref("BatchRequest")),
+					Responses: map[string]*Response{
+						"200": jsonResponse("One result per sub-request, in request order.", ref("BatchResponse")),
+						"400": validationErrorResponse(),
+						"413": bodyTooLargeResponse(),
+					},
+				},
+			},
+			"/graphql": {
+				Post: &Operation{
+					OperationID: "graphql",
+					Summary:     "Execute a GraphQL query or mutation",
+					Tags:        []string{"graphql"},
+					RequestBody: jsonBody(ref("GraphQLRequest")),
+					Responses: map[string]*Response{
+						"200": jsonResponse("The GraphQL result, including any field errors.", ref("GraphQLResponse")),
+						"400": validationErrorResponse(),
+						"413": bodyTooLargeResponse(),
+					},
+				},
+			},
+			"/health": {
+				Get: &Operation{
+					OperationID: "health",
+					Summary:     "Gateway health check",
+					Description: "Checks task-service, user-service and Postgres through the gRPC health protocol, " +
+						"and the trace exporter. Results are cached for two seconds.",
+					Tags: []string{"meta"},
+					Parameters: []*Parameter{
+						queryParam("verbose", "Include every check with its latency and error; any value but false or 0.", &Schema{Type: "string"}),
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("The gateway and its critical dependencies are up.", ref("Health")),
+						"503": jsonResponse("A critical dependency is down or the gateway is shutting down.", ref("Health")),
+					},
+				},
+			},
+			"/livez": {
+				Get: &Operation{
+					OperationID: "livez",
+					Summary:     "Liveness probe",
+					Tags:        []string{"meta"},
+					Responses: map[string]*Response{
+						"200": textResponse("The process is running."),
+					},
+				},
+			},
+			"/readyz": {
+				Get: &Operation{
+					OperationID: "readyz",
+					Summary:     "Readiness probe",
+					Tags:        []string{"meta"},
+					Responses: map[string]*Response{
+						"200": textResponse("The gateway can serve traffic."),
+						"503": textResponse("A critical dependency is down or the gateway is shutting down."),
+					},
+				},
+			},
+			"/openapi.json": {
+				Get: &Operation{
+					OperationID: "getOpenAPI",
+					Summary:     "This document",
+					Tags:        []string{"meta"},
+					Responses: map[string]*Response{
+						"200": jsonResponse("The OpenAPI document.", &Schema{Type: "object"}),
+					},
+				},
+			},
+		},
+		Components: Components{
+			Schemas: map[string]*Schema{
+				"Timestamp": {
+					Type:        "string",
+					Format:      "date-time",
+					Description: "RFC 3339 timestamp in UTC.",
+				},
+				"Task": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"id":          {Type: "string", Format: "uuid"},
+						"title":       {Type: "string"},
+						"description": {Type: "string"},
+						"status":      {Type: "string", Enum: taskStatusNames},
+						"assignee_id": {Type: "string"},
+						"assignee": {
+							Description: "Present only with expand=assignee. Null when the assignee is unset or unknown.",
+							Ref:         "#/components/schemas/User",
+						},
+						"created_at": ref("Timestamp"),
+						"updated_at": ref("Timestamp"),
+					},
+				},
+				"ListTasksResponse": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"tasks":           {Type: "array", Items: ref("Task")},
+						"total_count":     {Type: "integer", Description: "Only present with include_total_count=true."},
+						"next_page_token": {Type: "string", Description: "Token for the next page; empty on the last page."},
+					},
+				},
+				"ImportTask": {
+					Type:     "object",
+					Required: []string{"title"},
+					Properties: map[string]*Schema{
+						"title":       {Type: "string", MinLength: length(1), MaxLength: length(255)},
+						"description": {Type: "string"},
+						"status":      {Type: "string", Enum: taskStatusNames},
+						"assignee_id": assigneeID(),
+					},
+				},
+				"ImportTasksResponse": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"imported": {Type: "integer", Description: "Rows inserted."},
+						"failed":   {Type: "integer", Description: "Rows skipped because of errors."},
+						"errors": {
+							Type: "array",
+							Items: &Schema{
+								Type: "object",
+								Properties: map[string]*Schema{
+									"line":    {Type: "integer", Description: "Line the row starts on; the CSV header is line 1."},
+									"field":   {Type: "string"},
+									"message": {Type: "string"},
+								},
+							},
+						},
+						"error": {Type: "string", Description: "Why the import stopped before the end of the file."},
+					},
+				},
+				"CreateTaskRequest": {
+					Type:     "object",
+					Required: []string{"title"},
+					Properties: map[string]*Schema{
+						"title":       {Type: "string", MinLength: length(1), MaxLength: length(255)},
+						"description": {Type: "string"},
+						"assignee_id": assigneeID(),
+					},
+				},
+				"UpdateTaskRequest": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"title":       {Type: "string", MaxLength: length(255)},
+						"description": {Type: "string"},
+						"status":      {Type: "string", Enum: taskStatusNames},
+						"assignee_id": assigneeID(),
+					},
+				},
+				"TaskEvent": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"sequence":    {Type: "integer"},
+						"type":        {Type: "string", Enum: []interface{}{"created", "updated", "deleted"}},
+						"task":        ref("Task"),
+						"traceparent": {Type: "string", Description: "W3C traceparent of the write that produced the event."},
+						"occurred_at": {Type: "string", Format: "date-time"},
+					},
+				},
+				"Webhook": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"id":          {Type: "string", Format: "uuid"},
+						"url":         {Type: "string", Format: "uri"},
+						"event_types": {Type: "array", Items: &Schema{Type: "string", Enum: taskEventTypeNames}},
+						"assignee_id": {Type: "string"},
+						"secret":      {Type: "string", Description: "Only set in the response to createWebhook."},
+						"created_at":  ref("Timestamp"),
+						"updated_at":  ref("Timestamp"),
+					},
+				},
+				"ListWebhooksResponse": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"webhooks": {Type: "array", Items: ref("Webhook")},
+					},
+				},
+				"CreateWebhookRequest": {
+					Type:     "object",
+					Required: []string{"url"},
+					Properties: map[string]*Schema{
+						"url":         webhookURL(),
+						"event_types": webhookEventTypes(),
+						"assignee_id": assigneeID(),
+						"secret": {
+							Type:        "string",
+							MinLength:   length(16),
+							MaxLength:   length(255),
+							Description: "Signing key; generated when omitted.",
+						},
+					},
+				},
+				"UpdateWebhookRequest": {
+					Type:     "object",
+					Required: []string{"url"},
+					Properties: map[string]*Schema{
+						"url":         webhookURL(),
+						"event_types": webhookEventTypes(),
+						"assignee_id": assigneeID(),
+					},
+				},
+				"DeadLetter": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"id":         {Type: "string", Format: "uuid"},
+						"webhook_id": {Type: "string", Format: "uuid"},
+						"event_type": {Type: "string", Enum: taskEventTypeNames},
+						"task_id":    {Type: "string", Format: "uuid"},
+						"attempts":   {Type: "integer"},
+						"last_error": {Type: "string"},
+						"payload":    {Type: "string", Description: "The JSON body that was sent."},
+						"created_at": ref("Timestamp"),
+						"failed_at":  ref("Timestamp"),
+					},
+				},
+				"ListDeadLettersResponse": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"dead_letters": {Type: "array", Items: ref("DeadLetter")},
+					},
+				},
+				"TaskTrace": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"task_id":    {Type: "string"},
+						"message":    {Type: "string"},
+						"jaeger_url": {Type: "string", Format: "uri"},
+					},
+				},
+				"User": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"id":         {Type: "string"},
+						"name":       {Type: "string"},
+						"email":      {Type: "string", Format: "email"},
+						"created_at": ref("Timestamp"),
+					},
+				},
+				"ListUsersResponse": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"users":           {Type: "array", Items: ref("User")},
+						"total_count":     {Type: "integer", Description: "Only present with include_total_count=true."},
+						"next_page_token": {Type: "string", Description: "Token for the next page; empty on the last page."},
+					},
+				},
+				"CreateUserRequest": {
+					Type:     "object",
+					Required: []string{"name", "email"},
+					Properties: map[string]*Schema{
+						"name":  {Type: "string", MinLength: length(1), MaxLength: length(255)},
+						"email": {Type: "string", Format: "email", MaxLength: length(255)},
+					},
+				},
+				"BatchRequest": {
+					Type:     "object",
+					Required: []string{"requests"},
+					Properties: map[string]*Schema{
+						"requests": {
+							Type:     "array",
+							MinItems: length(1),
+							MaxItems: length(20),
+							Items: &Schema{
+								Type:     "object",
+								Required: []string{"method", "path"},
+								Properties: map[string]*Schema{
+									"method": {Type: "string", Enum: []interface{}{"GET", "POST", "PUT", "DELETE"}},
+									"path":   {Type: "string", MinLength: length(1), Description: "An /api/v1/ route, including any query string, other than /api/v1/batch and the /api/v1/tasks/events and /api/v1/tasks/export streams."},
+									"body":   {Description: "JSON request body for POST and PUT."},
+								},
+							},
+						},
+					},
+				},
+				"BatchResponse": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"responses": {
+							Type: "array",
+							Items: &Schema{
+								Type: "object",
+								Properties: map[string]*Schema{
+									"status": {Type: "integer"},
+									"body":   {Description: "The sub-response body. Plain-text errors are returned as a JSON string."},
+								},
+							},
+						},
+					},
+				},
+				"SearchResponse": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"query": {Type: "string"},
+						"results": {
+							Type: "array",
+							Items: &Schema{
+								Type:     "object",
+								Required: []string{"type", "score"},
+								Properties: map[string]*Schema{
+									"type":  {Type: "string", Enum: []interface{}{"task", "user"}},
+									"score": {Type: "number", Description: "Between 0 and 1."},
+									"task":  {Description: "Set when type is task.", Ref: "#/components/schemas/Task"},
+									"user":  {Description: "Set when type is user.", Ref: "#/components/schemas/User"},
+								},
+							},
+						},
+						"sources": {
+							Type: "object",
+							AdditionalProperties: &Schema{
+								Type: "object",
+								Properties: map[string]*Schema{
+									"status": {Type: "string", Enum: []interface{}{"ok", "error"}},
+									"count":  {Type: "integer"},
+									"error":  {Type: "string"},
+									"code":   {Type: "string", Description: "gRPC status code of a failed source."},
+								},
+							},
+						},
+						"partial": {Type: "boolean", Description: "Some source failed and its results are missing."},
+					},
+				},
+				"GraphQLRequest": {
+					Type:     "object",
+					Required: []string{"query"},
+					Properties: map[string]*Schema{
+						"query":         {Type: "string", MinLength: length(1)},
+						"operationName": {Type: "string"},
+						"variables":     {Type: "object"},
+					},
+				},
+				"GraphQLResponse": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"data":   {Type: "object"},
+						"errors": {Type: "array", Items: &Schema{Type: "object"}},
+					},
+				},
+				"Health": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"status":    {Type: "string", Enum: []interface{}{"healthy", "degraded", "unhealthy", "draining"}},
+						"timestamp": {Type: "string", Format: "date-time"},
+						"service":   {Type: "string"},
+						"version":   {Type: "string"},
+						"checks":    {Type: "object", Description: "Present with ?verbose, keyed by check name.", AdditionalProperties: ref("HealthCheck")},
+					},
+				},
+				"HealthCheck": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"status":     {Type: "string", Enum: []interface{}{"up", "down"}},
+						"latency_ms": {Type: "integer"},
+						"error":      {Type: "string"},
+						"critical":   {Type: "boolean"},
+						"checked_at": {Type: "string", Format: "date-time"},
+					},
+				},
+				"FieldError": {
+					Type:     "object",
+					Required: []string{"in", "field", "message"},
+					Properties: map[string]*Schema{
+						"in":      {Type: "string", Enum: []interface{}{"path", "query", "header", "body"}},
+						"field":   {Type: "string"},
+						"message": {Type: "string"},
+					},
+				},
+				"DeadlineExceeded": {
+					Type:     "object",
+					Required: []string{"error"},
+					Properties: map[string]*Schema{
+						"error":      {Type: "string"},
+						"budget_ms":  {Type: "integer"},
+						"request_id": {Type: "string"},
+					},
+				},
+				"Error": {
+					Type:     "object",
+					Required: []string{"error"},
+					Properties: map[string]*Schema{
+						"error":      {Type: "string"},
+						"request_id": {Type: "string"},
+					},
+				},
+				"ValidationError": {
+					Type:     "object",
+					Required: []string{"error", "details"},
+					Properties: map[string]*Schema{
+						"error":      {Type: "string"},
+						"details":    {Type: "array", Items: ref("FieldError")},
+						"request_id": {Type: "string"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func length(v int) *int {
+	return &v
+}
+
+func taskIDParam() *Parameter {
+	return &Parameter{
+		Name:        "id",
+		In:          "path",
+		Description: "Task ID.",
+		Required:    true,
+		Schema:      &Schema{Type: "string", Format: "uuid"},
+	}
+}
+
+func userIDParam() *Parameter {
+	return &Parameter{
+		Name:        "id",
+		In:          "path",
+		Description: "User ID.",
+		Required:    true,
+		Schema:      &Schema{Type: "string", Pattern: userIDPattern},
+	}
+}
+
+func webhookIDParam() *Parameter {
+	return &Parameter{
+		Name:        "id",
+		In:          "path",
+		Description: "Webhook ID.",
+		Required:    true,
+		Schema:      &Schema{Type: "string", Format: "uuid"},
+	}
+}
+
+func webhookURL() *Schema {
+	return &Schema{Type: "string", Format: "uri", Pattern: "^https?://", MaxLength: length(2048)}
+}
+
+// webhookEventTypes subscribes to every event type when empty.
+func webhookEventTypes() *Schema {
+	return &Schema{Type: "array", MaxItems: length(3), Items: &Schema{Type: "string", Enum: taskEventTypeNames}}
+}
+
+// assigneeID allows the empty string, which leaves a task unassigned.
+func assigneeID() *Schema {
+	return &Schema{Type: "string", Pattern: "^(user-[0-9]+)?$"}
+}
+
+func queryParam(name, description string, schema *Schema) *Parameter {
+	return &Parameter{
+		Name:        name,
+		In:          "query",
+		Description: description,
+		Schema:      schema,
+	}
+}
+
+func expandParam() *Parameter {
+	return queryParam("expand", "Embed related objects in the response.", &Schema{Type: "string", Enum: []interface{}{"assignee"}})
+}
+
+func jsonBody(schema *Schema) *RequestBody {
+	return &RequestBody{
+		Required: true,
+		Content:  map[string]*MediaType{jsonContentType: {Schema: schema}},
+	}
+}
+
+func jsonResponse(description string, schema *Schema) *Response {
+	return &Response{
+		Description: description,
+		Content:     map[string]*MediaType{jsonContentType: {Schema: schema}},
+	}
+}
+
+func textResponse(description string) *Response {
+	return &Response{
+		Description: description,
+		Content:     map[string]*MediaType{textContentType: {Schema: &Schema{Type: "string"}}},
+	}
+}
+
+func errorResponse(description string) *Response {
+	return jsonResponse(description, ref("Error"))
+}
+
+func validationErrorResponse() *Response {
+	return jsonResponse("The request did not match the API contract.", ref("ValidationError"))
+}
+
+func bodyTooLargeResponse() *Response {
+	return textResponse("The JSON request body is larger than 1 MiB.")
+}
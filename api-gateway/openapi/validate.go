@@ -0,0 +1,248 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const maxBodyBytes = 1 << 20
+
+// FieldError describes a single contract violation in a request.
+type FieldError struct {
+	In      string `json:"in"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Error     string       `json:"error"`
+	Details   []FieldError `json:"details"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// Handler serves the document as JSON.
+func Handler(doc *Document) http.HandlerFunc {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("openapi: failed to marshal document: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", jsonContentType)
+		w.Write(body)
+	}
+}
+
+// Validator returns middleware that checks path parameters, query parameters
+// and JSON request bodies against the operation matched by the router.
+// Requests that violate the contract are rejected with a ValidationError.
+func Validator(doc *Document) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			op := doc.operationFor(r)
+			if op == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			errs := doc.validateParameters(op, r)
+
+			if op.RequestBody != nil {
+				bodyErrs, err := doc.validateBody(op.RequestBody, w, r)
+				var tooLarge *http.MaxBytesError
+				switch {
+				case errors.As(err, &tooLarge):
+					requestid.Error(w, r, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+					return
+				case err != nil:
+					requestid.Error(w, r, "Failed to read request body", http.StatusBadRequest)
+					return
+				}
+				errs = append(errs, bodyErrs...)
+			}
+
+			if len(errs) > 0 {
+				span := trace.SpanFromContext(r.Context())
+				span.SetAttributes(
+					attribute.String("openapi.operation_id", op.OperationID),
+					attribute.Int("validation.error_count", len(errs)),
+				)
+				span.SetStatus(codes.Error, "Request validation failed")
+				WriteValidationError(w, r, errs)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WriteValidationError renders field errors as a 400 response.
+func WriteValidationError(w http.ResponseWriter, r *http.Request, errs []FieldError) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(ValidationError{
+		Error:     "request validation failed",
+		Details:   errs,
+		RequestID: requestid.FromContext(r.Context()),
+	})
+}
+
+// CheckRoutes reports routes registered on the router that are missing from
+// the document, and operations in the document that no route serves.
+func CheckRoutes(router *mux.Router, doc *Document) error {
+	served := map[string]bool{}
+	var problems []string
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			served[method+" "+tpl] = true
+			item, ok := doc.Paths[tpl]
+			if !ok || item.Operation(method) == nil {
+				problems = append(problems, fmt.Sprintf("%s %s is not documented", method, tpl))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for tpl, item := range doc.Paths {
+		for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+			if item.Operation(method) != nil && !served[method+" "+tpl] {
+				problems = append(problems, fmt.Sprintf("%s %s is documented but not routed", method, tpl))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("openapi document out of sync with router: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (d *Document) operationFor(r *http.Request) *Operation {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+	item, ok := d.Paths[tpl]
+	if !ok {
+		return nil
+	}
+	return item.Operation(r.Method)
+}
+
+func (d *Document) validateParameters(op *Operation, r *http.Request) []FieldError {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	var errs []FieldError
+	for _, p := range op.Parameters {
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw, present = vars[p.Name]
+		case "query":
+			present = query.Has(p.Name)
+			raw = query.Get(p.Name)
+		default:
+			continue
+		}
+
+		if !present {
+			if p.Required {
+				errs = append(errs, FieldError{In: p.In, Field: p.Name, Message: "is required"})
+			}
+			continue
+		}
+
+		errs = append(errs, d.validateValue(p.In, p.Name, p.Schema, parameterValue(d.Resolve(p.Schema), raw))...)
+	}
+	return errs
+}
+
+// parameterValue converts a raw path or query string into the JSON value the
+// schema expects, so that numeric constraints can be checked.
+func parameterValue(s *Schema, raw string) interface{} {
+	if s == nil {
+		return raw
+	}
+	switch s.Type {
+	case "integer", "number":
+		var num json.Number
+		if err := json.Unmarshal([]byte(raw), &num); err != nil {
+			return raw
+		}
+		return num
+	case "boolean":
+		switch raw {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	}
+	return raw
+}
+
+// validateBody only buffers JSON bodies; others, such as import files, are
+// left for the handler to stream.
+func (d *Document) validateBody(body *RequestBody, w http.ResponseWriter, r *http.Request) ([]FieldError, error) {
+	media, ok := body.Content[jsonContentType]
+	if !ok {
+		return nil, nil
+	}
+
+	// Reading past the limit fails rather than truncating, so an oversized
+	// body is not reported as invalid JSON
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			return []FieldError{{In: "body", Field: "", Message: "request body is required"}}, nil
+		}
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return []FieldError{{In: "body", Field: "", Message: "must be valid JSON"}}, nil
+	}
+
+	return d.validateValue("body", "", media.Schema, v), nil
+}
@@ -0,0 +1,97 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestValidatorBody(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Validator(Spec()))
+	r.HandleFunc("/api/v1/tasks", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("POST")
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"valid", `{"title": "Write tests"}`, http.StatusCreated},
+		{"schema violation", `{"title": ""}`, http.StatusBadRequest},
+		{"malformed", `{"title": `, http.StatusBadRequest},
+		{"too large", `{"title": "x", "description": "` + strings.Repeat("x", maxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", jsonContentType)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidatorParameters(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Validator(Spec()))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	r.HandleFunc("/api/v1/tasks", ok).Methods("GET")
+	r.HandleFunc("/api/v1/tasks/{id}", ok).Methods("GET")
+	r.HandleFunc("/api/v1/search", ok).Methods("GET")
+
+	tests := []struct {
+		name   string
+		target string
+		// want is the field list of the 400, or nil if the request passes
+		want []FieldError
+	}{
+		{"valid list", "/api/v1/tasks?page_size=100&status=DONE", nil},
+		{"page_size too small", "/api/v1/tasks?page_size=0", []FieldError{{"query", "page_size", "must be >= 1"}}},
+		{"page_size too large", "/api/v1/tasks?page_size=101", []FieldError{{"query", "page_size", "must be <= 100"}}},
+		{"page_size not an integer", "/api/v1/tasks?page_size=ten", []FieldError{{"query", "page_size", "must be an integer"}}},
+		{"page_size fraction", "/api/v1/tasks?page_size=2.5", []FieldError{{"query", "page_size", "must be an integer"}}},
+		{"unknown status", "/api/v1/tasks?status=ARCHIVED", []FieldError{{"query", "status", "must be one of TODO, IN_PROGRESS, DONE"}}},
+		{"every problem reported", "/api/v1/tasks?page_size=0&status=todo", []FieldError{{"query", "page_size", "must be >= 1"}, {"query", "status", "must be one of TODO, IN_PROGRESS, DONE"}}},
+		{"valid task ID", "/api/v1/tasks/8f14e45f-ceea-467a-9575-6d5b0c3c6b1e", nil},
+		{"task ID not a UUID", "/api/v1/tasks/task-1", []FieldError{{"path", "id", "must be a UUID"}}},
+		{"valid search", "/api/v1/search?q=report", nil},
+		{"missing q", "/api/v1/search?limit=5", []FieldError{{"query", "q", "is required"}}},
+		{"empty q", "/api/v1/search?q=", []FieldError{{"query", "q", "must not be empty"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("GET", tt.target, nil))
+
+			if tt.want == nil {
+				if rec.Code != http.StatusOK {
+					t.Errorf("status = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+				}
+				return
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400 (body %q)", rec.Code, rec.Body.String())
+			}
+			var body ValidationError
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body, err)
+			}
+			if !reflect.DeepEqual(body.Details, tt.want) {
+				t.Errorf("details = %+v\nwant      %+v", body.Details, tt.want)
+			}
+		})
+	}
+}
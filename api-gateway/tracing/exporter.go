@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ExporterStatus describes the outcome of the most recent span export.
+type ExporterStatus struct {
+	LastExport time.Time
+	LastError  error
+}
+
+var (
+	exporterMu     sync.Mutex
+	exporterStatus ExporterStatus
+)
+
+// Exporter reports the status of the span exporter for health checks.
+// The zero value means nothing has been exported yet.
+func Exporter() ExporterStatus {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	return exporterStatus
+}
+
+// statusExporter records the result of every export so that a broken
+// collector shows up in /health instead of only in the logs.
+type statusExporter struct {
+	tracesdk.SpanExporter
+}
+
+func (e statusExporter) ExportSpans(ctx context.Context, spans []tracesdk.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+
+	exporterMu.Lock()
+	exporterStatus = ExporterStatus{LastExport: time.Now(), LastError: err}
+	exporterMu.Unlock()
+
+	return err
+}
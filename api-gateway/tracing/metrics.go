@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	metricsdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const metricsInterval = 15 * time.Second
+
+// InitMeter installs the global meter provider. Metrics are pushed over
+// OTLP/HTTP to METRICS_ENDPOINT; without it they are recorded but not
+// exported, since Jaeger only accepts traces.
+func InitMeter(ctx context.Context) (*metricsdk.MeterProvider, error) {
+	serviceName := os.Getenv("SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "api-gateway"
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []metricsdk.Option{metricsdk.WithResource(res)}
+	if endpoint := os.Getenv("METRICS_ENDPOINT"); endpoint != "" {
+		exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, metricsdk.WithReader(
+			metricsdk.NewPeriodicReader(exporter, metricsdk.WithInterval(metricsInterval)),
+		))
+	}
+
+	mp := metricsdk.NewMeterProvider(opts...)
+	otel.SetMeterProvider(mp)
+	return mp, nil
+}
+
+func GetMeter() metric.Meter {
+	return otel.Meter("api-gateway")
+}
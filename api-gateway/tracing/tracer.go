@@ -14,7 +14,9 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-func InitTracer(ctx context.Context) (*tracesdk.TracerProvider, error) {
+// InitTracer installs the global tracer provider. sampler decides which
+// root spans are recorded; pass a sampling.Swappable to adjust it at runtime.
+func InitTracer(ctx context.Context, sampler tracesdk.Sampler) (*tracesdk.TracerProvider, error) {
 	jaegerEndpoint := os.Getenv("JAEGER_ENDPOINT")
 	if jaegerEndpoint == "" {
 		jaegerEndpoint = "http://localhost:4318/v1/traces"
@@ -47,9 +49,9 @@ func InitTracer(ctx context.Context) (*tracesdk.TracerProvider, error) {
 
 	// Create tracer provider
 	tp := tracesdk.NewTracerProvider(
-		tracesdk.WithBatcher(exporter),
+		tracesdk.WithBatcher(statusExporter{exporter}),
 		tracesdk.WithResource(res),
-		tracesdk.WithSampler(tracesdk.AlwaysSample()),
+		tracesdk.WithSampler(sampler),
 	)
 
 	// Set global tracer provider
@@ -0,0 +1,252 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/limiter"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	_ "github.com/bonyuta0204/otel-lab/internal/zstdcodec"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer/leastrequest"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/encoding/gzip"
+	// Registers the client side of the gRPC health protocol used by
+	// healthCheckConfig.
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// drainPeriod is how long a replaced connection stays open so that RPCs
+// already in flight on it can finish.
+const drainPeriod = 30 * time.Second
+
+// Target describes where an upstream's instances are and how RPCs are
+// spread over them.
+type Target struct {
+	// Address is host:port, a comma-separated list of them, or a gRPC target
+	// such as dns:///task-service:8081. A plain host:port is resolved
+	// through DNS, so every A record of the name becomes an endpoint.
+	Address string
+	// Balancer is round_robin or least_request.
+	Balancer string
+	// HealthCheck probes every endpoint with grpc.health.v1 and sends RPCs
+	// only to those reporting SERVING.
+	HealthCheck bool
+}
+
+// Conn is a gRPC client connection whose target can be changed at runtime.
+// Generated clients built on it keep working when a Pending change is
+// committed: new RPCs go to the new target while in-flight ones finish on
+// the old connection.
+type Conn struct {
+	mu         sync.RWMutex
+	target     Target
+	cc         *grpc.ClientConn
+	compressor string
+	limiter    *limiter.Limiter
+}
+
+// Dial connects to target. compressor names a registered gRPC compressor
+// ("gzip", "zstd") used for requests; "" or "none" sends them uncompressed.
+func Dial(target Target, compressor string) (*Conn, error) {
+	cc, err := newClient(target)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{target: target, cc: cc}
+	c.SetCompressor(compressor)
+	return c, nil
+}
+
+func newClient(target Target) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithDefaultServiceConfig(serviceConfig(target))}
+
+	addr := target.Address
+	switch {
+	case strings.Contains(addr, ","):
+		// A static list: hand the addresses to the balancer through a
+		// resolver private to this connection.
+		r := manual.NewBuilderWithScheme("static")
+		var state resolver.State
+		for _, a := range strings.Split(addr, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				state.Endpoints = append(state.Endpoints, resolver.Endpoint{Addresses: []resolver.Address{{Addr: a}}})
+			}
+		}
+		r.InitialState(state)
+		opts = append(opts, grpc.WithResolvers(r))
+		addr = "static:///" + target.Address
+	case !strings.Contains(addr, "://"):
+		addr = "dns:///" + addr
+	}
+
+	opts = append(opts,
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(
+			// Message events carry the compressed and uncompressed size of
+			// every message.
+			otelgrpc.WithMessageEvents(otelgrpc.ReceivedEvents, otelgrpc.SentEvents),
+		)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(requestid.UnaryClientInterceptor(), tenant.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(requestid.StreamClientInterceptor(), tenant.StreamClientInterceptor()),
+	)
+	return grpc.NewClient(addr, opts...)
+}
+
+func serviceConfig(target Target) string {
+	policy := `{"round_robin":{}}`
+	if target.Balancer == "least_request" {
+		policy = fmt.Sprintf(`{%q:{"choiceCount":2}}`, leastrequest.Name)
+	}
+	config := `{"loadBalancingConfig":[` + policy + `]`
+	if target.HealthCheck {
+		// The empty service name asks for the server's overall health.
+		config += `,"healthCheckConfig":{"serviceName":""}`
+	}
+	return config + "}"
+}
+
+// SetCompressor changes the compressor used for new RPCs.
+func (c *Conn) SetCompressor(name string) {
+	if name == "none" {
+		name = ""
+	}
+	c.mu.Lock()
+	c.compressor = name
+	c.mu.Unlock()
+}
+
+// SetLimiter caps the unary RPCs in flight on the connection. Streams are
+// long-lived and not counted.
+func (c *Conn) SetLimiter(l *limiter.Limiter) {
+	c.mu.Lock()
+	c.limiter = l
+	c.mu.Unlock()
+}
+
+func (c *Conn) Target() Target {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.target
+}
+
+// Pending is a change to a connection's target and compressor that has been
+// prepared but not yet applied. Applying it cannot fail, so several can be
+// prepared first and then applied together.
+type Pending struct {
+	c          *Conn
+	target     Target
+	compressor string
+	// cc is the client for the new target, or nil if the target is
+	// unchanged.
+	cc *grpc.ClientConn
+}
+
+// Prepare dials target without switching the connection to it. Nothing
+// changes until Commit; Discard closes what was dialled.
+func (c *Conn) Prepare(target Target, compressor string) (*Pending, error) {
+	if compressor == "none" {
+		compressor = ""
+	}
+	p := &Pending{c: c, target: target, compressor: compressor}
+	if target == c.Target() {
+		return p, nil
+	}
+
+	cc, err := newClient(target)
+	if err != nil {
+		return nil, err
+	}
+	p.cc = cc
+	return p, nil
+}
+
+// Commit switches the connection to the prepared target and compressor. New
+// RPCs use them at once; RPCs in flight on the old connection have
+// drainPeriod to finish before it is closed.
+func (p *Pending) Commit() {
+	c := p.c
+	c.mu.Lock()
+	c.compressor = p.compressor
+	old := c.cc
+	if p.cc != nil {
+		c.cc = p.cc
+		c.target = p.target
+	}
+	c.mu.Unlock()
+
+	if p.cc == nil {
+		return
+	}
+	time.AfterFunc(drainPeriod, func() {
+		if err := old.Close(); err != nil {
+			slog.Error("Error closing drained connection", "error", err)
+		}
+	})
+}
+
+// Discard abandons the change and closes the connection it dialled.
+func (p *Pending) Discard() {
+	if p.cc != nil {
+		p.cc.Close()
+	}
+}
+
+func (c *Conn) current() (*grpc.ClientConn, []grpc.CallOption) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.compressor == "" {
+		return c.cc, nil
+	}
+	return c.cc, []grpc.CallOption{grpc.UseCompressor(c.compressor)}
+}
+
+func (c *Conn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	cc, defaults := c.current()
+
+	c.mu.RLock()
+	lim := c.limiter
+	c.mu.RUnlock()
+	if lim == nil || strings.HasPrefix(method, "/grpc.health.v1.") {
+		return cc.Invoke(ctx, method, args, reply, append(defaults, opts...)...)
+	}
+
+	done, err := lim.Acquire(ctx, priorityOf(method))
+	if err != nil {
+		return err
+	}
+	err = cc.Invoke(ctx, method, args, reply, append(defaults, opts...)...)
+	done(err)
+	return err
+}
+
+// priorityOf classifies an RPC by its name: lookups are reads, everything
+// else is a write.
+func priorityOf(method string) limiter.Priority {
+	name := path.Base(method)
+	for _, prefix := range []string{"Get", "List", "Search", "Watch"} {
+		if strings.HasPrefix(name, prefix) {
+			return limiter.Read
+		}
+	}
+	return limiter.Write
+}
+
+func (c *Conn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cc, defaults := c.current()
+	return cc.NewStream(ctx, desc, method, append(defaults, opts...)...)
+}
+
+func (c *Conn) Close() error {
+	cc, _ := c.current()
+	return cc.Close()
+}
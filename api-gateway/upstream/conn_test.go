@@ -0,0 +1,243 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/api-gateway/limiter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// backend is a gRPC server that only speaks the health protocol and counts
+// the Check calls it answers.
+type backend struct {
+	*health.Server
+	addr   string
+	checks atomic.Int32
+}
+
+func (b *backend) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	b.checks.Add(1)
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func startBackends(t *testing.T, n int) []*backend {
+	t.Helper()
+	backends := make([]*backend, n)
+	for i := range backends {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		b := &backend{Server: health.NewServer(), addr: lis.Addr().String()}
+		srv := grpc.NewServer()
+		healthpb.RegisterHealthServer(srv, b)
+		go srv.Serve(lis)
+		t.Cleanup(srv.Stop)
+		backends[i] = b
+	}
+	return backends
+}
+
+func addresses(backends []*backend) string {
+	addrs := make([]string, len(backends))
+	for i, b := range backends {
+		addrs[i] = b.addr
+	}
+	return strings.Join(addrs, ", ")
+}
+
+// check sends n health checks through conn, which with a health checking
+// balancer only reach SERVING endpoints.
+func check(t *testing.T, conn *Conn, n int) {
+	t.Helper()
+	client := healthpb.NewHealthClient(conn)
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		cancel()
+		if err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+	}
+}
+
+func TestBalancing(t *testing.T) {
+	for _, balancer := range []string{"round_robin", "least_request"} {
+		t.Run(balancer, func(t *testing.T) {
+			backends := startBackends(t, 3)
+			conn, err := Dial(Target{Address: addresses(backends), Balancer: balancer}, "")
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+
+			// Wait until every endpoint is connected, then spread calls
+			deadline := time.Now().Add(5 * time.Second)
+			for !allCalled(backends) {
+				if time.Now().After(deadline) {
+					t.Fatalf("calls never reached every backend: %v", counts(backends))
+				}
+				check(t, conn, 1)
+			}
+			for _, b := range backends {
+				b.checks.Store(0)
+			}
+
+			check(t, conn, 30)
+			for i, n := range counts(backends) {
+				if balancer == "round_robin" && n != 10 {
+					t.Errorf("backend %d got %d of 30 calls, want 10", i, n)
+				}
+				if n == 0 {
+					t.Errorf("backend %d got no calls", i)
+				}
+			}
+		})
+	}
+}
+
+func TestBalancingSkipsUnhealthyEndpoints(t *testing.T) {
+	backends := startBackends(t, 3)
+	backends[1].SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	conn, err := Dial(Target{Address: addresses(backends), Balancer: "round_robin", HealthCheck: true}, "")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for backends[0].checks.Load() == 0 || backends[2].checks.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("calls never reached the healthy backends: %v", counts(backends))
+		}
+		check(t, conn, 1)
+	}
+	check(t, conn, 20)
+	if n := backends[1].checks.Load(); n != 0 {
+		t.Errorf("NOT_SERVING backend got %d calls", n)
+	}
+
+	// Once it recovers it gets traffic again
+	backends[1].SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	for backends[1].checks.Load() == 0 {
+		if time.Now().After(deadline.Add(5 * time.Second)) {
+			t.Fatal("recovered backend never got a call")
+		}
+		check(t, conn, 1)
+	}
+}
+
+func TestServerAddressOnSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	backends := startBackends(t, 2)
+	conn, err := Dial(Target{Address: addresses(backends)}, "")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	seen := map[string]bool{}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(seen) < len(backends) {
+		if time.Now().After(deadline) {
+			t.Fatalf("server.address only ever was %v", seen)
+		}
+		check(t, conn, 1)
+		for _, span := range recorder.Ended() {
+			if host, port := attr(span.Attributes(), semconv.ServerAddressKey), attr(span.Attributes(), semconv.ServerPortKey); host != "" {
+				seen[net.JoinHostPort(host, port)] = true
+			}
+		}
+	}
+	for _, b := range backends {
+		if !seen[b.addr] {
+			t.Errorf("no span with server.address of %s in %v", b.addr, seen)
+		}
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	tests := []struct {
+		target Target
+		want   string
+	}{
+		{Target{}, `{"loadBalancingConfig":[{"round_robin":{}}]}`},
+		{Target{Balancer: "round_robin"}, `{"loadBalancingConfig":[{"round_robin":{}}]}`},
+		{Target{Balancer: "least_request"}, `{"loadBalancingConfig":[{"least_request_experimental":{"choiceCount":2}}]}`},
+		{Target{HealthCheck: true}, `{"loadBalancingConfig":[{"round_robin":{}}],"healthCheckConfig":{"serviceName":""}}`},
+	}
+	for _, tt := range tests {
+		got := serviceConfig(tt.target)
+		if !json.Valid([]byte(got)) {
+			t.Errorf("serviceConfig(%+v) is not JSON: %s", tt.target, got)
+		}
+		if got != tt.want {
+			t.Errorf("serviceConfig(%+v) = %s, want %s", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestPriorityOf(t *testing.T) {
+	tests := []struct {
+		method string
+		want   limiter.Priority
+	}{
+		{"/task.TaskService/GetTask", limiter.Read},
+		{"/task.TaskService/ListTasks", limiter.Read},
+		{"/task.TaskService/SearchTasks", limiter.Read},
+		{"/task.TaskService/WatchTasks", limiter.Read},
+		{"/user.UserService/GetUsersByIds", limiter.Read},
+		{"/task.TaskService/CreateTask", limiter.Write},
+		{"/task.TaskService/ImportTasks", limiter.Write},
+		{"/task.TaskService/DeleteTask", limiter.Write},
+	}
+	for _, tt := range tests {
+		if got := priorityOf(tt.method); got != tt.want {
+			t.Errorf("priorityOf(%s) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
+
+func allCalled(backends []*backend) bool {
+	for _, b := range backends {
+		if b.checks.Load() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func counts(backends []*backend) []int32 {
+	n := make([]int32, len(backends))
+	for i, b := range backends {
+		n[i] = b.checks.Load()
+	}
+	return n
+}
+
+func attr(attrs []attribute.KeyValue, key attribute.Key) string {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	Stable = "stable"
+	Canary = "canary"
+)
+
+type variantKey struct{}
+
+// WithVariant records which deployment of an upstream the request is
+// assigned to.
+func WithVariant(ctx context.Context, variant string) context.Context {
+	return context.WithValue(ctx, variantKey{}, variant)
+}
+
+// VariantFromContext returns the variant the request was assigned, or ""
+// if it was not assigned one.
+func VariantFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(variantKey{}).(string)
+	return v
+}
+
+type routingKey struct{}
+
+// Routing records where the RPCs of a request were sent by a Split.
+type Routing struct {
+	mu      sync.Mutex
+	variant string
+}
+
+// WithRouting returns a context whose RPCs through a Split are recorded in
+// the returned Routing.
+func WithRouting(ctx context.Context) (context.Context, *Routing) {
+	r := &Routing{}
+	return context.WithValue(ctx, routingKey{}, r), r
+}
+
+// Variant returns the deployment the request's RPCs went to: "" if none
+// went through a Split, Canary if any went to a canary deployment and
+// Stable otherwise.
+func (r *Routing) Variant() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.variant
+}
+
+func (r *Routing) record(variant string) {
+	r.mu.Lock()
+	if r.variant != Canary {
+		r.variant = variant
+	}
+	r.mu.Unlock()
+}
+
+// Split sends the RPCs of requests assigned to the canary variant to a
+// canary deployment and all others to the stable one. Without a canary
+// connection everything goes to the stable one.
+type Split struct {
+	stable *Conn
+
+	mu     sync.RWMutex
+	canary *Conn
+}
+
+func NewSplit(stable *Conn) *Split {
+	return &Split{stable: stable}
+}
+
+// SetCanary replaces the canary connection; nil sends all RPCs to the
+// stable deployment.
+func (s *Split) SetCanary(c *Conn) {
+	s.mu.Lock()
+	s.canary = c
+	s.mu.Unlock()
+}
+
+// Canary returns the canary connection, if any.
+func (s *Split) Canary() *Conn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.canary
+}
+
+func (s *Split) pick(ctx context.Context) *Conn {
+	conn, variant := s.stable, Stable
+	if VariantFromContext(ctx) == Canary {
+		if c := s.Canary(); c != nil {
+			conn, variant = c, Canary
+		}
+	}
+	if r, ok := ctx.Value(routingKey{}).(*Routing); ok {
+		r.record(variant)
+	}
+	return conn
+}
+
+func (s *Split) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	return s.pick(ctx).Invoke(ctx, method, args, reply, opts...)
+}
+
+func (s *Split) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return s.pick(ctx).NewStream(ctx, desc, method, opts...)
+}
+
+func (s *Split) Close() error {
+	if c := s.Canary(); c != nil {
+		c.Close()
+	}
+	return s.stable.Close()
+}
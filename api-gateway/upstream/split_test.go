@@ -0,0 +1,50 @@
+package upstream
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSplitRecordsRouting(t *testing.T) {
+	stable, canary := &Conn{}, &Conn{}
+	tests := []struct {
+		name    string
+		variant string
+		canary  *Conn
+		want    *Conn
+		routed  string
+	}{
+		{"stable", Stable, canary, stable, Stable},
+		{"canary", Canary, canary, canary, Canary},
+		{"canary without a canary deployment", Canary, nil, stable, Stable},
+		{"unassigned", "", canary, stable, Stable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSplit(stable)
+			s.SetCanary(tt.canary)
+			ctx, routing := WithRouting(WithVariant(context.Background(), tt.variant))
+			if routing.Variant() != "" {
+				t.Fatalf("routed to %q before any RPC", routing.Variant())
+			}
+			if got := s.pick(ctx); got != tt.want {
+				t.Errorf("picked the wrong connection")
+			}
+			if got := routing.Variant(); got != tt.routed {
+				t.Errorf("routed = %q, want %q", got, tt.routed)
+			}
+		})
+	}
+
+	// A request with RPCs on both deployments counts as canary
+	s := NewSplit(stable)
+	s.SetCanary(canary)
+	ctx, routing := WithRouting(WithVariant(context.Background(), Canary))
+	s.pick(ctx)
+	s.SetCanary(nil)
+	s.pick(ctx)
+	if got := routing.Variant(); got != Canary {
+		t.Errorf("routed = %q after a canary RPC, want canary", got)
+	}
+}
@@ -0,0 +1,171 @@
+// Package admin serves a small authenticated HTTP API, separate from the
+// public listener, for changing a running service's log level and trace
+// sampling ratio and for inspecting its effective configuration. Every
+// change is written to an audit log that the log level cannot silence.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/sampling"
+)
+
+type Options struct {
+	Service string
+	// Addr is the listen address, e.g. ":9090".
+	Addr string
+	// Token must be presented as "Authorization: Bearer <token>".
+	Token   string
+	Level   *slog.LevelVar
+	Sampler *sampling.Swappable
+	// Config returns the effective configuration with secrets removed.
+	Config func() interface{}
+}
+
+type Server struct {
+	opts  Options
+	srv   *http.Server
+	audit *slog.Logger
+}
+
+type levelBody struct {
+	Level string `json:"level"`
+}
+
+type samplingBody struct {
+	Ratio *float64 `json:"ratio"`
+}
+
+// OptionsFromEnv fills Addr and Token from ADMIN_ADDR and ADMIN_TOKEN.
+func OptionsFromEnv(service, defaultAddr string) Options {
+	addr := os.Getenv("ADMIN_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+	return Options{Service: service, Addr: addr, Token: os.Getenv("ADMIN_TOKEN")}
+}
+
+func New(opts Options) *Server {
+	s := &Server{
+		opts: opts,
+		// The audit log bypasses the adjustable level on purpose.
+		audit: slog.New(slog.NewJSONHandler(os.Stderr, nil)).With("log", "audit", "service", opts.Service),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/loglevel", s.getLevel)
+	mux.HandleFunc("PUT /admin/loglevel", s.setLevel)
+	mux.HandleFunc("GET /admin/sampling", s.getSampling)
+	mux.HandleFunc("PUT /admin/sampling", s.setSampling)
+	mux.HandleFunc("GET /admin/config", s.getConfig)
+
+	s.srv = &http.Server{
+		Addr:              opts.Addr,
+		Handler:           s.authenticate(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Start serves in the background. Without a token the listener is not
+// started at all, so an unconfigured deployment exposes nothing.
+func (s *Server) Start() {
+	if s.opts.Token == "" {
+		slog.Info("Admin API disabled; set ADMIN_TOKEN to enable it")
+		return
+	}
+	go func() {
+		slog.Info("Admin API starting", "addr", s.opts.Addr)
+		if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Admin API failed", "error", err)
+		}
+	}()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+			s.audit.Warn("admin request rejected", "method", r.Method, "path", r.URL.Path, "remote", remoteHost(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) getLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, levelBody{Level: s.opts.Level.Level().String()})
+}
+
+func (s *Server) setLevel(w http.ResponseWriter, r *http.Request) {
+	var body levelBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(body.Level))); err != nil {
+		http.Error(w, "level must be one of debug, info, warn, error", http.StatusBadRequest)
+		return
+	}
+
+	old := s.opts.Level.Level()
+	s.opts.Level.Set(level)
+	s.audit.Info("log level changed", "old", old.String(), "new", level.String(), "remote", remoteHost(r))
+	writeJSON(w, levelBody{Level: level.String()})
+}
+
+func (s *Server) getSampling(w http.ResponseWriter, r *http.Request) {
+	ratio := s.opts.Sampler.Ratio()
+	writeJSON(w, samplingBody{Ratio: &ratio})
+}
+
+func (s *Server) setSampling(w http.ResponseWriter, r *http.Request) {
+	var body samplingBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Ratio == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if *body.Ratio < 0 || *body.Ratio > 1 {
+		http.Error(w, "ratio must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+
+	old := s.opts.Sampler.Ratio()
+	s.opts.Sampler.SetRatio(*body.Ratio)
+	s.audit.Info("sampling ratio changed", "old", old, "new", *body.Ratio, "remote", remoteHost(r))
+	writeJSON(w, body)
+}
+
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	s.audit.Info("config dumped", "remote", remoteHost(r))
+	writeJSON(w, s.opts.Config())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,185 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/internal/sampling"
+)
+
+// newTestServer returns a server whose audit log is captured in a buffer.
+func newTestServer() (*Server, *bytes.Buffer) {
+	level := new(slog.LevelVar)
+	s := New(Options{
+		Service: "task-service",
+		Token:   "secret-token",
+		Level:   level,
+		Sampler: sampling.New(1),
+		Config:  func() interface{} { return map[string]string{"db_host": "postgres"} },
+	})
+	var audit bytes.Buffer
+	s.audit = slog.New(slog.NewJSONHandler(&audit, nil))
+	return s, &audit
+}
+
+func serveAdmin(s *Server, method, path, auth, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = "10.0.0.7:51234"
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name string
+		auth string
+		want int
+	}{
+		{"no credentials", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		{"token prefix", "Bearer secret", http.StatusUnauthorized},
+		{"not a bearer token", "Basic c2VjcmV0LXRva2Vu", http.StatusUnauthorized},
+		{"bare token", "secret-token", http.StatusUnauthorized},
+		{"valid token", "Bearer secret-token", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, audit := newTestServer()
+			rec := serveAdmin(s, "GET", "/admin/loglevel", tt.auth, "")
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized {
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Error("401 without WWW-Authenticate")
+				}
+				if !strings.Contains(audit.String(), `"msg":"admin request rejected"`) || !strings.Contains(audit.String(), `"remote":"10.0.0.7"`) {
+					t.Errorf("rejection not audited: %s", audit)
+				}
+			}
+		})
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	tests := []struct {
+		body      string
+		want      int
+		wantLevel slog.Level
+	}{
+		{`{"level":"debug"}`, http.StatusOK, slog.LevelDebug},
+		{`{"level":"WARN"}`, http.StatusOK, slog.LevelWarn},
+		{`{"level":"error"}`, http.StatusOK, slog.LevelError},
+		{`{"level":"loud"}`, http.StatusBadRequest, slog.LevelInfo},
+		{`{"level":""}`, http.StatusBadRequest, slog.LevelInfo},
+		{`level=debug`, http.StatusBadRequest, slog.LevelInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			s, audit := newTestServer()
+			rec := serveAdmin(s, "PUT", "/admin/loglevel", "Bearer secret-token", tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := s.opts.Level.Level(); got != tt.wantLevel {
+				t.Errorf("level = %v, want %v", got, tt.wantLevel)
+			}
+
+			changed := strings.Contains(audit.String(), `"msg":"log level changed"`)
+			if changed != (tt.want == http.StatusOK) {
+				t.Errorf("audit log = %s", audit)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var body levelBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Level != tt.wantLevel.String() {
+				t.Errorf("body = %s, want level %v", rec.Body, tt.wantLevel)
+			}
+			rec = serveAdmin(s, "GET", "/admin/loglevel", "Bearer secret-token", "")
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Level != tt.wantLevel.String() {
+				t.Errorf("GET after PUT = %s, want level %v", rec.Body, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestSetSampling(t *testing.T) {
+	tests := []struct {
+		body      string
+		want      int
+		wantRatio float64
+	}{
+		{`{"ratio":0.25}`, http.StatusOK, 0.25},
+		{`{"ratio":0}`, http.StatusOK, 0},
+		{`{"ratio":1}`, http.StatusOK, 1},
+		{`{"ratio":1.5}`, http.StatusBadRequest, 1},
+		{`{"ratio":-0.1}`, http.StatusBadRequest, 1},
+		{`{}`, http.StatusBadRequest, 1},
+		{`{"ratio":"all"}`, http.StatusBadRequest, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			s, audit := newTestServer()
+			rec := serveAdmin(s, "PUT", "/admin/sampling", "Bearer secret-token", tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := s.opts.Sampler.Ratio(); got != tt.wantRatio {
+				t.Errorf("ratio = %v, want %v", got, tt.wantRatio)
+			}
+
+			changed := strings.Contains(audit.String(), `"msg":"sampling ratio changed"`)
+			if changed != (tt.want == http.StatusOK) {
+				t.Errorf("audit log = %s", audit)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			rec = serveAdmin(s, "GET", "/admin/sampling", "Bearer secret-token", "")
+			var body samplingBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Ratio == nil || *body.Ratio != tt.wantRatio {
+				t.Errorf("GET after PUT = %s, want ratio %v", rec.Body, tt.wantRatio)
+			}
+		})
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	s, audit := newTestServer()
+	rec := serveAdmin(s, "GET", "/admin/config", "Bearer secret-token", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"db_host":"postgres"}` {
+		t.Errorf("body = %s", got)
+	}
+	if !strings.Contains(audit.String(), `"msg":"config dumped"`) {
+		t.Errorf("config dump not audited: %s", audit)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	s, _ := newTestServer()
+	for _, tt := range []struct{ method, path string }{
+		{"POST", "/admin/loglevel"},
+		{"DELETE", "/admin/sampling"},
+		{"PUT", "/admin/config"},
+	} {
+		if rec := serveAdmin(s, tt.method, tt.path, "Bearer secret-token", "{}"); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s = %d, want 405", tt.method, tt.path, rec.Code)
+		}
+	}
+}
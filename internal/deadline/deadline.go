@@ -0,0 +1,67 @@
+// Package deadline records and enforces the time budget a request has left.
+// gRPC already carries the caller's deadline to the server; the interceptors
+// here make that budget visible on spans and turn work that ran out of time
+// into DeadlineExceeded instead of a generic Internal error.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const RemainingKey = attribute.Key("deadline.remaining_ms")
+
+// Remaining reports how much of the context's budget is left.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(d), true
+}
+
+// Record sets deadline.remaining_ms on the span in ctx. Contexts without a
+// deadline are left unannotated.
+func Record(ctx context.Context) {
+	if remaining, ok := Remaining(ctx); ok {
+		trace.SpanFromContext(ctx).SetAttributes(RemainingKey.Int64(remaining.Milliseconds()))
+	}
+}
+
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		Record(ctx)
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+
+		resp, err := handler(ctx, req)
+		return resp, fromContext(ctx, err)
+	}
+}
+
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		Record(ss.Context())
+		return fromContext(ss.Context(), handler(srv, ss))
+	}
+}
+
+// fromContext reports handler failures caused by an expired budget as
+// DeadlineExceeded, whatever code the handler chose.
+func fromContext(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if status.Code(err) == codes.DeadlineExceeded {
+		return err
+	}
+	return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+}
@@ -0,0 +1,106 @@
+package deadline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/deadline"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	internal := status.Error(codes.Internal, "query failed")
+	tests := []struct {
+		name       string
+		budget     time.Duration // 0 for no deadline
+		handlerErr error
+		sleep      time.Duration // how long the handler takes
+		want       codes.Code
+		wantCalled bool
+	}{
+		{"no deadline", 0, nil, 0, codes.OK, true},
+		{"within budget", time.Second, nil, 0, codes.OK, true},
+		{"handler error within budget", time.Second, internal, 0, codes.Internal, true},
+		{"out of time before the handler", -time.Second, nil, 0, codes.DeadlineExceeded, false},
+		{"handler error after the budget ran out", 10 * time.Millisecond, internal, 30 * time.Millisecond, codes.DeadlineExceeded, true},
+		{"plain context error after the budget ran out", 10 * time.Millisecond, context.DeadlineExceeded, 30 * time.Millisecond, codes.DeadlineExceeded, true},
+		{"success after the budget ran out", 10 * time.Millisecond, nil, 30 * time.Millisecond, codes.OK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.budget != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.budget)
+				defer cancel()
+			}
+
+			called := false
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				time.Sleep(tt.sleep)
+				return "ok", tt.handlerErr
+			}
+			_, err := deadline.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/task.TaskService/GetTask"}, handler)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	ctx, span := tracer.Start(context.Background(), "without deadline")
+	deadline.Record(ctx)
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ctx, span = tracer.Start(ctx, "with deadline")
+	deadline.Record(ctx)
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans, want 2", len(spans))
+	}
+	if v, ok := remainingMs(spans[0].Attributes()); ok {
+		t.Errorf("span without deadline has %s = %d", deadline.RemainingKey, v)
+	}
+	if v, ok := remainingMs(spans[1].Attributes()); !ok || v <= 1000 || v > 2000 {
+		t.Errorf("%s = %d (set %v), want about 2000", deadline.RemainingKey, v, ok)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	if _, ok := deadline.Remaining(context.Background()); ok {
+		t.Error("Remaining reports a budget for a context without deadline")
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if d, ok := deadline.Remaining(ctx); !ok || d >= 0 {
+		t.Errorf("Remaining = %v, %v; want a negative budget", d, ok)
+	}
+}
+
+func remainingMs(attrs []attribute.KeyValue) (int64, bool) {
+	for _, kv := range attrs {
+		if kv.Key == deadline.RemainingKey {
+			return kv.Value.AsInt64(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,54 @@
+// Package healthcheck keeps a gRPC health server in line with the
+// dependencies a service needs, so that callers using the standard health
+// protocol see an outage instead of a service that claims to be serving.
+package healthcheck
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const checkTimeout = 2 * time.Second
+
+// Monitor runs check every interval until ctx is cancelled and reports its
+// result as the status of service, and of the server as a whole when
+// critical is set. Status changes are logged.
+func Monitor(ctx context.Context, srv *health.Server, service string, critical bool, interval time.Duration, check func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := check(checkCtx)
+		cancel()
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if status != last {
+			if err != nil {
+				log.Printf("Health: %s is %s: %v", service, status, err)
+			} else {
+				log.Printf("Health: %s is %s", service, status)
+			}
+			last = status
+		}
+
+		srv.SetServingStatus(service, status)
+		if critical {
+			srv.SetServingStatus("", status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package healthcheck_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/healthcheck"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestMonitor(t *testing.T) {
+	tests := []struct {
+		name     string
+		critical bool
+	}{
+		{"critical", true},
+		{"non-critical", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := health.NewServer()
+			var failing atomic.Bool
+			check := func(ctx context.Context) error {
+				if failing.Load() {
+					return errors.New("connection refused")
+				}
+				return nil
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				healthcheck.Monitor(ctx, srv, "postgres", tt.critical, 5*time.Millisecond, check)
+				close(done)
+			}()
+
+			// health.NewServer starts the server as a whole SERVING
+			waitForStatus(t, srv, "postgres", healthpb.HealthCheckResponse_SERVING)
+			waitForStatus(t, srv, "", healthpb.HealthCheckResponse_SERVING)
+
+			failing.Store(true)
+			waitForStatus(t, srv, "postgres", healthpb.HealthCheckResponse_NOT_SERVING)
+			overall := healthpb.HealthCheckResponse_SERVING
+			if tt.critical {
+				overall = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+			waitForStatus(t, srv, "", overall)
+
+			failing.Store(false)
+			waitForStatus(t, srv, "postgres", healthpb.HealthCheckResponse_SERVING)
+			waitForStatus(t, srv, "", healthpb.HealthCheckResponse_SERVING)
+
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Monitor did not return after ctx was cancelled")
+			}
+		})
+	}
+}
+
+func waitForStatus(t *testing.T, srv *health.Server, service string, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, err := srv.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err == nil && resp.Status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status of %q = %v, %v; want %v", service, resp.GetStatus(), err, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
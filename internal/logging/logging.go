@@ -0,0 +1,25 @@
+// Package logging installs the process-wide slog logger. The standard log
+// package is routed through it as well, so the level set here filters
+// every log line the service writes.
+package logging
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Init installs a text handler on stderr whose level starts at LOG_LEVEL
+// (debug, info, warn or error; info by default) and returns the level so
+// that it can be changed at runtime.
+func Init() *slog.LevelVar {
+	level := new(slog.LevelVar)
+	if raw := os.Getenv("LOG_LEVEL"); raw != "" {
+		if err := level.UnmarshalText([]byte(strings.ToUpper(raw))); err != nil {
+			slog.Warn("Ignoring invalid LOG_LEVEL", "value", raw)
+		}
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	return level
+}
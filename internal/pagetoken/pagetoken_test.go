@@ -0,0 +1,101 @@
+package pagetoken_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/pagetoken"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	tests := []struct {
+		name   string
+		cursor pagetoken.Cursor
+		filter string
+	}{
+		{"no filter", pagetoken.Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), ID: "task-1"}, ""},
+		{"with filter", pagetoken.Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), ID: "task-1"}, pagetoken.Filter("DONE", "user-1")},
+		{"nanoseconds", pagetoken.Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC), ID: "a"}, ""},
+		{"other time zone", pagetoken.Cursor{CreatedAt: time.Date(2024, 5, 1, 21, 0, 0, 0, tokyo), ID: "b"}, ""},
+		{"UUID", pagetoken.Cursor{CreatedAt: time.Unix(1700000000, 0), ID: "8f14e45f-ceea-467a-9575-6d5b0c3c6b1e"}, pagetoken.Filter("TODO")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := pagetoken.Encode(tt.cursor, tt.filter)
+			got, err := pagetoken.Decode(token, tt.filter)
+			if err != nil {
+				t.Fatalf("Decode(%q): %v", token, err)
+			}
+			if !got.CreatedAt.Equal(tt.cursor.CreatedAt) || got.ID != tt.cursor.ID {
+				t.Errorf("Decode = %+v, want %+v", got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	cursor := pagetoken.Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), ID: "task-1"}
+	done := pagetoken.Filter("DONE", "")
+	tests := []struct {
+		name   string
+		token  string
+		filter string
+	}{
+		{"other filter", pagetoken.Encode(cursor, done), pagetoken.Filter("TODO", "")},
+		{"filter added", pagetoken.Encode(cursor, ""), done},
+		{"filter removed", pagetoken.Encode(cursor, done), ""},
+		{"not base64", "!!!", ""},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte("task-1")), ""},
+		{"no ID", base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2024-05-01T12:00:00Z"}`)), ""},
+		{"bad time", base64.RawURLEncoding.EncodeToString([]byte(`{"t":"yesterday","i":"x"}`)), ""},
+		{"truncated", pagetoken.Encode(cursor, "")[:10], ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := pagetoken.Decode(tt.token, tt.filter); !errors.Is(err, pagetoken.ErrInvalid) {
+				t.Errorf("Decode(%q) = %+v, %v; want ErrInvalid", tt.token, c, err)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	if pagetoken.Filter("DONE", "user-1") != pagetoken.Filter("DONE", "user-1") {
+		t.Error("Filter is not deterministic")
+	}
+	for _, other := range [][]string{{"DONE", "user-2"}, {"TODO", "user-1"}, {"user-1", "DONE"}, {"DONEuser-1"}} {
+		if pagetoken.Filter(other...) == pagetoken.Filter("DONE", "user-1") {
+			t.Errorf("Filter(%q) matches Filter(DONE, user-1)", other)
+		}
+	}
+}
+
+func TestCursorBefore(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := pagetoken.Cursor{CreatedAt: at, ID: "m"}
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		id        string
+		want      bool
+	}{
+		{"older", at.Add(-time.Second), "z", true},
+		{"newer", at.Add(time.Second), "a", false},
+		{"same time, smaller ID", at, "a", true},
+		{"same time, larger ID", at, "z", false},
+		{"the cursor itself", at, "m", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Before(tt.createdAt, tt.id); got != tt.want {
+				t.Errorf("Before(%v, %q) = %v, want %v", tt.createdAt, tt.id, got, tt.want)
+			}
+		})
+	}
+}
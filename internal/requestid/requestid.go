@@ -0,0 +1,186 @@
+// Package requestid generates request IDs and carries them across process
+// boundaries. The gateway assigns an ID per HTTP request; the client
+// interceptors forward it as gRPC metadata and the server interceptors put
+// it back into the context, onto the span and into the log line of every
+// RPC.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Header is the HTTP header clients may set and the gateway echoes.
+	Header = "X-Request-ID"
+	// MetadataKey is the gRPC metadata key the ID travels under.
+	MetadataKey = "x-request-id"
+	// Key is the span attribute holding the ID.
+	Key = attribute.Key("request.id")
+
+	maxLength = 128
+)
+
+type contextKey struct{}
+
+// crockford is the ULID alphabet: Crockford's base32 without I, L, O, U.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// New returns a ULID: a 48-bit millisecond timestamp followed by 80 bits
+// from crypto/rand, encoded as 26 characters that sort by creation time.
+func New() string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(id[6:]); err != nil {
+		panic("requestid: crypto/rand failed: " + err.Error())
+	}
+
+	var out [26]byte
+	// 128 bits are encoded from the most significant end in 5-bit groups,
+	// with two leading zero bits to make 130.
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// Valid reports whether a caller-supplied ID is safe to adopt: short and
+// limited to characters that need no escaping in logs or headers.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID in ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Error replies like http.Error, with the request ID appended to the
+// message so that a client can quote it when reporting a problem.
+func Error(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if id := FromContext(r.Context()); id != "" {
+		message = fmt.Sprintf("%s (request ID %s)", message, id)
+	}
+	http.Error(w, message, code)
+}
+
+// ErrorBody is the JSON body JSONError replies with.
+type ErrorBody struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// JSONError replies like Error with an ErrorBody, for errors a client is
+// expected to tell apart by more than their status code.
+func JSONError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ErrorBody{Error: message, RequestID: FromContext(r.Context())})
+}
+
+func outgoing(ctx context.Context) context.Context {
+	if id := FromContext(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+	}
+	return ctx
+}
+
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
+
+// incoming adopts the caller's request ID, or assigns one to callers that
+// did not send a usable one, and records it on the RPC's span.
+func incoming(ctx context.Context) (context.Context, string) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(MetadataKey); len(values) > 0 && Valid(values[0]) {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		id = New()
+	}
+	trace.SpanFromContext(ctx).SetAttributes(Key.String(id))
+	return NewContext(ctx, id), id
+}
+
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, id := incoming(ctx)
+		resp, err := handler(ctx, req)
+		logRPC(id, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, id := incoming(ss.Context())
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		logRPC(id, info.FullMethod, start, err)
+		return err
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// logRPC writes one line per RPC. Health probes are left out so that they
+// do not drown the log.
+func logRPC(id, method string, start time.Time, err error) {
+	if strings.HasPrefix(method, "/grpc.health.v1.") {
+		return
+	}
+	log.Printf("[%s] %s %s %dms", id, method, status.Code(err), time.Since(start).Milliseconds())
+}
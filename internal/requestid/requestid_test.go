@@ -0,0 +1,229 @@
+package requestid_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func TestNew(t *testing.T) {
+	const n = 10000
+	seen := make(map[string]bool, n)
+	prev := ""
+	for i := 0; i < n; i++ {
+		id := requestid.New()
+		if len(id) != 26 {
+			t.Fatalf("New() = %q, %d characters, want 26", id, len(id))
+		}
+		if strings.Trim(id, crockford) != "" {
+			t.Fatalf("New() = %q, want only Crockford base32", id)
+		}
+		// The first character holds the top bits of the 48-bit timestamp,
+		// which leave it at most 7
+		if id[0] > '7' {
+			t.Fatalf("New() = %q overflows 128 bits", id)
+		}
+		if !requestid.Valid(id) {
+			t.Fatalf("Valid(New()) = false for %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("New() returned %q twice", id)
+		}
+		seen[id] = true
+
+		// IDs from different milliseconds sort by time
+		if prev != "" && id[:10] < prev[:10] {
+			t.Fatalf("New() = %q after %q, want increasing timestamps", id, prev)
+		}
+		prev = id
+	}
+
+	first := requestid.New()
+	time.Sleep(2 * time.Millisecond)
+	if second := requestid.New(); second <= first {
+		t.Errorf("New() = %q a few milliseconds after %q, want it to sort later", second, first)
+	}
+}
+
+func TestNewTimestamp(t *testing.T) {
+	before := time.Now().UnixMilli()
+	id := requestid.New()
+	after := time.Now().UnixMilli()
+
+	var ms int64
+	for _, c := range id[:10] {
+		ms = ms<<5 | int64(strings.IndexRune(crockford, c))
+	}
+	if ms < before || ms > after {
+		t.Errorf("New() = %q encodes %d ms, want between %d and %d", id, ms, before, after)
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{requestid.New(), true},
+		{"abc-123_DEF.4", true},
+		{"3f2504e0-4f89-11d3-9a0c-0305e82c3301", true},
+		{strings.Repeat("a", 128), true},
+		{"", false},
+		{strings.Repeat("a", 129), false},
+		{"has space", false},
+		{"new\nline", false},
+		{"tab\there", false},
+		{"quote\"", false},
+		{"semi;colon", false},
+		{"slash/", false},
+		{"<script>", false},
+		{"ünïcode", false},
+		{"%0d%0a", false},
+	}
+
+	for _, tt := range tests {
+		if got := requestid.Valid(tt.id); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+// serverSide turns the metadata an RPC was sent with into the incoming
+// context its server sees.
+func serverSide(sent context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(sent)
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func TestUnaryInterceptorsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		// client is the ID in the client's context; "" sends none
+		client string
+		// want is the ID the server must see; "" means a new one
+		want string
+	}{
+		{"propagated", "01HZX3J8Q4N4WJ2V7C6Y5K9M0P", "01HZX3J8Q4N4WJ2V7C6Y5K9M0P"},
+		{"caller supplied", "client-req.42", "client-req.42"},
+		{"none sent", "", ""},
+		{"invalid replaced", "bad id\r\nX-Injected: 1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.client != "" {
+				ctx = requestid.NewContext(ctx, tt.client)
+			}
+
+			var sent context.Context
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				sent = ctx
+				return nil
+			}
+			if err := requestid.UnaryClientInterceptor()(ctx, "/user.UserService/GetUser", nil, nil, nil, invoker); err != nil {
+				t.Fatalf("client interceptor: %v", err)
+			}
+
+			var got string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				got = requestid.FromContext(ctx)
+				return nil, nil
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/GetUser"}
+			if _, err := requestid.UnaryServerInterceptor()(serverSide(sent), nil, info, handler); err != nil {
+				t.Fatalf("server interceptor: %v", err)
+			}
+
+			switch {
+			case tt.want != "" && got != tt.want:
+				t.Errorf("server saw %q, want %q", got, tt.want)
+			case tt.want == "" && (len(got) != 26 || got == tt.client):
+				t.Errorf("server saw %q, want a newly assigned ID", got)
+			}
+		})
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamInterceptorsRoundTrip(t *testing.T) {
+	const id = "01HZX3J8Q4N4WJ2V7C6Y5K9M0P"
+	ctx := requestid.NewContext(context.Background(), id)
+
+	var sent context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		sent = ctx
+		return nil, nil
+	}
+	if _, err := requestid.StreamClientInterceptor()(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/task.TaskService/WatchTasks", streamer); err != nil {
+		t.Fatalf("client interceptor: %v", err)
+	}
+
+	var got string
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		got = requestid.FromContext(ss.Context())
+		return nil
+	}
+	info := &grpc.StreamServerInfo{FullMethod: "/task.TaskService/WatchTasks", IsServerStream: true}
+	if err := requestid.StreamServerInterceptor()(nil, &fakeServerStream{ctx: serverSide(sent)}, info, handler); err != nil {
+		t.Fatalf("server interceptor: %v", err)
+	}
+	if got != id {
+		t.Errorf("server saw %q, want %q", got, id)
+	}
+}
+
+func TestErrorBodies(t *testing.T) {
+	const id = "01HZX3J8Q4N4WJ2V7C6Y5K9M0P"
+	withID := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+	withID = withID.WithContext(requestid.NewContext(withID.Context(), id))
+	withoutID := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+
+	rec := httptest.NewRecorder()
+	requestid.Error(rec, withID, "Task not found", http.StatusNotFound)
+	if got, want := rec.Body.String(), "Task not found (request ID "+id+")\n"; got != want {
+		t.Errorf("Error body = %q, want %q", got, want)
+	}
+	rec = httptest.NewRecorder()
+	requestid.Error(rec, withoutID, "Task not found", http.StatusNotFound)
+	if got := rec.Body.String(); got != "Task not found\n" {
+		t.Errorf("Error body without an ID = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	requestid.JSONError(rec, withID, "conflict", http.StatusConflict)
+	if rec.Code != http.StatusConflict || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("JSONError replied %d with %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body requestid.ErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body, err)
+	}
+	if body != (requestid.ErrorBody{Error: "conflict", RequestID: id}) {
+		t.Errorf("JSONError body = %+v", body)
+	}
+
+	rec = httptest.NewRecorder()
+	requestid.JSONError(rec, withoutID, "conflict", http.StatusConflict)
+	if strings.Contains(rec.Body.String(), "request_id") {
+		t.Errorf("JSONError body without an ID = %s", rec.Body)
+	}
+}
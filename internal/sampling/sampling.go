@@ -0,0 +1,70 @@
+// Package sampling provides a trace sampler whose ratio can be changed
+// while the process runs, so that sampling can be raised to debug an issue
+// without a redeploy.
+package sampling
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Swappable samples root spans at a ratio and follows the parent's decision
+// otherwise, like ParentBased(TraceIDRatioBased(ratio)).
+type Swappable struct {
+	current atomic.Pointer[state]
+}
+
+type state struct {
+	ratio   float64
+	sampler tracesdk.Sampler
+}
+
+func New(ratio float64) *Swappable {
+	s := &Swappable{}
+	s.SetRatio(ratio)
+	return s
+}
+
+// FromEnv reads the initial ratio from TRACE_SAMPLE_RATIO, defaulting to
+// sampling everything.
+func FromEnv() (*Swappable, error) {
+	raw := os.Getenv("TRACE_SAMPLE_RATIO")
+	if raw == "" {
+		return New(1), nil
+	}
+	ratio, err := strconv.ParseFloat(raw, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("TRACE_SAMPLE_RATIO must be a number between 0 and 1, got %q", raw)
+	}
+	return New(ratio), nil
+}
+
+// SetRatio replaces the sampler. ratio is clamped to [0, 1].
+func (s *Swappable) SetRatio(ratio float64) {
+	if ratio < 0 {
+		ratio = 0
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	s.current.Store(&state{
+		ratio:   ratio,
+		sampler: tracesdk.ParentBased(tracesdk.TraceIDRatioBased(ratio)),
+	})
+}
+
+func (s *Swappable) Ratio() float64 {
+	return s.current.Load().ratio
+}
+
+func (s *Swappable) ShouldSample(p tracesdk.SamplingParameters) tracesdk.SamplingResult {
+	return s.current.Load().sampler.ShouldSample(p)
+}
+
+func (s *Swappable) Description() string {
+	return fmt.Sprintf("Swappable{%s}", s.current.Load().sampler.Description())
+}
@@ -0,0 +1,101 @@
+package sampling_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/internal/sampling"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetRatio(t *testing.T) {
+	tests := []struct {
+		ratio float64
+		want  float64
+	}{
+		{0, 0},
+		{0.25, 0.25},
+		{1, 1},
+		{-1, 0},
+		{2, 1},
+	}
+	for _, tt := range tests {
+		s := sampling.New(0.5)
+		s.SetRatio(tt.ratio)
+		if got := s.Ratio(); got != tt.want {
+			t.Errorf("SetRatio(%v): Ratio = %v, want %v", tt.ratio, got, tt.want)
+		}
+	}
+}
+
+func TestShouldSample(t *testing.T) {
+	traceID := trace.TraceID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	parent := func(sampled bool) trace.SpanContext {
+		cfg := trace.SpanContextConfig{TraceID: traceID, SpanID: trace.SpanID{1}, Remote: true}
+		if sampled {
+			cfg.TraceFlags = trace.FlagsSampled
+		}
+		return trace.NewSpanContext(cfg)
+	}
+
+	tests := []struct {
+		name   string
+		ratio  float64
+		parent *trace.SpanContext
+		want   tracesdk.SamplingDecision
+	}{
+		{"root at 1", 1, nil, tracesdk.RecordAndSample},
+		{"root at 0", 0, nil, tracesdk.Drop},
+		{"sampled parent at 0", 0, ptr(parent(true)), tracesdk.RecordAndSample},
+		{"unsampled parent at 1", 1, ptr(parent(false)), tracesdk.Drop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := sampling.New(0.5)
+			s.SetRatio(tt.ratio)
+
+			ctx := context.Background()
+			if tt.parent != nil {
+				ctx = trace.ContextWithRemoteSpanContext(ctx, *tt.parent)
+			}
+			got := s.ShouldSample(tracesdk.SamplingParameters{ParentContext: ctx, TraceID: traceID, Name: "span"})
+			if got.Decision != tt.want {
+				t.Errorf("decision = %v, want %v", got.Decision, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"", 1, false},
+		{"0.1", 0.1, false},
+		{"0", 0, false},
+		{"1", 1, false},
+		{"1.1", 0, true},
+		{"-0.5", 0, true},
+		{"half", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("TRACE_SAMPLE_RATIO", tt.value)
+			s, err := sampling.FromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromEnv = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && s.Ratio() != tt.want {
+				t.Errorf("Ratio = %v, want %v", s.Ratio(), tt.want)
+			}
+		})
+	}
+}
+
+func ptr(sc trace.SpanContext) *trace.SpanContext {
+	return &sc
+}
@@ -0,0 +1,105 @@
+// Package search scores free-text matches the same way in every service, so
+// that hits from different services can be merged into one ranking.
+//
+// A query is split into lower-cased terms and every term has to match one
+// of the fields. A term scores best as a whole word, less as the start of a
+// word and least anywhere inside one; the field's weight scales that. The
+// score of a hit is the mean over its terms, between 0 and 1.
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	wordMatch   = 1.0
+	prefixMatch = 0.75
+	infixMatch  = 0.5
+)
+
+// Field is a piece of text to search in. Weight is at most 1.
+type Field struct {
+	Text   string
+	Weight float64
+}
+
+// Terms splits a query into the lower-cased terms Score expects. Duplicates
+// are dropped.
+func Terms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, term := range strings.FieldsFunc(strings.ToLower(query), isSeparator) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Score rates how well fields match terms. It is 0 unless every term
+// matches some field.
+func Score(terms []string, fields ...Field) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+
+	words := make([][]string, len(fields))
+	for i, f := range fields {
+		words[i] = strings.FieldsFunc(strings.ToLower(f.Text), isSeparator)
+	}
+
+	total := 0.0
+	for _, term := range terms {
+		best := 0.0
+		for i, f := range fields {
+			if s := f.Weight * match(term, words[i]); s > best {
+				best = s
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total / float64(len(terms))
+}
+
+func match(term string, words []string) float64 {
+	best := 0.0
+	for _, word := range words {
+		switch {
+		case word == term:
+			return wordMatch
+		case strings.HasPrefix(word, term):
+			best = max(best, prefixMatch)
+		case strings.Contains(word, term):
+			best = max(best, infixMatch)
+		}
+	}
+	return best
+}
+
+// Hit is a scored search result.
+type Hit[T any] struct {
+	Item  T
+	Score float64
+}
+
+// Rank sorts hits best first, keeping the original order between equal
+// scores, and keeps at most limit of them.
+func Rank[T any](hits []Hit[T], limit int) []Hit[T] {
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// Email addresses split at their punctuation, so that "alice" and
+// "example" both match alice@example.com.
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
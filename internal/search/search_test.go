@@ -0,0 +1,95 @@
+package search_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/internal/search"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"deploy", []string{"deploy"}},
+		{"Deploy the API-gateway", []string{"deploy", "the", "api", "gateway"}},
+		{"deploy, DEPLOY!", []string{"deploy"}},
+		{"alice@example.com", []string{"alice", "example", "com"}},
+		{"タスク 2", []string{"タスク", "2"}},
+		{"  ", nil},
+		{"-- !!", nil},
+	}
+	for _, tt := range tests {
+		if got := search.Terms(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Terms(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	title := func(text string) search.Field { return search.Field{Text: text, Weight: 1} }
+	description := func(text string) search.Field { return search.Field{Text: text, Weight: 0.5} }
+
+	tests := []struct {
+		name   string
+		query  string
+		fields []search.Field
+		want   float64
+	}{
+		{"whole word", "gateway", []search.Field{title("Deploy the gateway")}, 1},
+		{"case insensitive", "GATEWAY", []search.Field{title("gateway")}, 1},
+		{"word prefix", "gate", []search.Field{title("Deploy the gateway")}, 0.75},
+		{"inside a word", "way", []search.Field{title("Deploy the gateway")}, 0.5},
+		{"field weight", "gateway", []search.Field{description("Deploy the gateway")}, 0.5},
+		{"best field wins", "gate", []search.Field{title("gateway"), description("gate")}, 0.75},
+		{"mean over terms", "deploy gate", []search.Field{title("Deploy the gateway")}, 0.875},
+		{"terms across fields", "deploy docs", []search.Field{title("Deploy"), description("docs")}, 0.75},
+		{"punctuation splits words", "example", []search.Field{{Text: "alice@example.com", Weight: 0.8}}, 0.8},
+		{"a term without a match", "deploy missing", []search.Field{title("Deploy the gateway")}, 0},
+		{"no match", "missing", []search.Field{title("Deploy the gateway")}, 0},
+		{"no terms", "", []search.Field{title("Deploy the gateway")}, 0},
+		{"no fields", "deploy", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := search.Score(search.Terms(tt.query), tt.fields...); got != tt.want {
+				t.Errorf("Score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRank(t *testing.T) {
+	hits := func(scores ...float64) []search.Hit[int] {
+		h := make([]search.Hit[int], len(scores))
+		for i, s := range scores {
+			h[i] = search.Hit[int]{Item: i, Score: s}
+		}
+		return h
+	}
+
+	tests := []struct {
+		name  string
+		hits  []search.Hit[int]
+		limit int
+		want  []int // items in ranked order
+	}{
+		{"best first", hits(0.5, 1, 0.75), 10, []int{1, 2, 0}},
+		{"ties keep their order", hits(0.5, 1, 0.5, 1), 10, []int{1, 3, 0, 2}},
+		{"limit", hits(0.5, 1, 0.75), 2, []int{1, 2}},
+		{"limit of zero", hits(0.5, 1), 0, []int{}},
+		{"no hits", nil, 10, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []int{}
+			for _, hit := range search.Rank(tt.hits, tt.limit) {
+				got = append(got, hit.Item)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rank = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
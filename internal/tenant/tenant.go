@@ -0,0 +1,147 @@
+// Package tenant carries the tenant a request acts for. The gateway resolves
+// it once per HTTP request; the client interceptors forward it as gRPC
+// metadata and the server interceptors refuse any RPC that arrives without
+// one, so that every repository call can scope its data by tenant.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Header is the HTTP header a client may name its tenant in.
+	Header = "X-Tenant-ID"
+	// MetadataKey is the gRPC metadata key the tenant travels under. It is
+	// the authoritative source for the services.
+	MetadataKey = "x-tenant-id"
+	// BaggageKey is the baggage member holding the tenant, for consumers
+	// further downstream that only see the trace context.
+	BaggageKey = "tenant.id"
+	// Key is the span attribute holding the tenant.
+	Key = attribute.Key("tenant.id")
+	// Default is the tenant that data from before multi-tenancy belongs to.
+	Default = "default"
+)
+
+var pattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ErrMissing is returned by Require for a context without a tenant.
+var ErrMissing = errors.New("tenant is required")
+
+type contextKey struct{}
+
+// Valid reports whether id is a well-formed tenant ID: lower-case letters,
+// digits, '-' and '_', starting with a letter or digit, at most 63
+// characters.
+func Valid(id string) bool {
+	return pattern.MatchString(id)
+}
+
+// NewContext returns ctx carrying id, both as a value and as baggage.
+func NewContext(ctx context.Context, id string) context.Context {
+	if member, err := baggage.NewMember(BaggageKey, id); err == nil {
+		if bag, err := baggage.FromContext(ctx).SetMember(member); err == nil {
+			ctx = baggage.ContextWithBaggage(ctx, bag)
+		}
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant in ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Require returns the tenant in ctx or ErrMissing. Data access goes through
+// it so that a query can never run unscoped.
+func Require(ctx context.Context) (string, error) {
+	id := FromContext(ctx)
+	if id == "" {
+		return "", ErrMissing
+	}
+	return id, nil
+}
+
+func outgoing(ctx context.Context) context.Context {
+	if id := FromContext(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+	}
+	return ctx
+}
+
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
+
+// incoming takes the tenant from the caller's metadata. Health checks are
+// not tenant-scoped and pass without one.
+func incoming(ctx context.Context, method string) (context.Context, error) {
+	if strings.HasPrefix(method, "/grpc.health.v1.") {
+		return ctx, nil
+	}
+
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(MetadataKey); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		return nil, status.Error(codes.Unauthenticated, ErrMissing.Error())
+	}
+	if !Valid(id) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid tenant %q", id)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(Key.String(id))
+	return NewContext(ctx, id), nil
+}
+
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := incoming(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := incoming(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
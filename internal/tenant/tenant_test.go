@@ -0,0 +1,168 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	"go.opentelemetry.io/otel/baggage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequire(t *testing.T) {
+	if _, err := tenant.Require(context.Background()); !errors.Is(err, tenant.ErrMissing) {
+		t.Errorf("Require without tenant = %v, want ErrMissing", err)
+	}
+	if _, err := tenant.Require(tenant.NewContext(context.Background(), "")); !errors.Is(err, tenant.ErrMissing) {
+		t.Errorf("Require with an empty tenant = %v, want ErrMissing", err)
+	}
+	if id, err := tenant.Require(tenant.NewContext(context.Background(), "acme")); err != nil || id != "acme" {
+		t.Errorf("Require = %q, %v; want acme", id, err)
+	}
+}
+
+func TestNewContextBaggage(t *testing.T) {
+	ctx := tenant.NewContext(context.Background(), "acme")
+	if got := baggage.FromContext(ctx).Member(tenant.BaggageKey).Value(); got != "acme" {
+		t.Errorf("baggage %s = %q, want acme", tenant.BaggageKey, got)
+	}
+
+	// Other baggage members are kept
+	member, _ := baggage.NewMember("user.id", "user-001")
+	bag, _ := baggage.New(member)
+	ctx = tenant.NewContext(baggage.ContextWithBaggage(context.Background(), bag), "acme")
+	if got := baggage.FromContext(ctx).Member("user.id").Value(); got != "user-001" {
+		t.Errorf("baggage user.id = %q after NewContext, want user-001", got)
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"acme", true},
+		{"tenant-1_b", true},
+		{"0", true},
+		{"", false},
+		{"-acme", false},
+		{"Acme", false},
+		{"acme corp", false},
+		{"../default", false},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+	}
+	for _, tt := range tests {
+		if got := tenant.Valid(tt.id); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+// serverSide turns the metadata an RPC was sent with into the incoming
+// context its server sees.
+func serverSide(sent context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(sent)
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func TestUnaryInterceptors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		// client is the tenant in the client's context; "" sends none
+		client string
+		want   codes.Code
+	}{
+		{"propagated", "/user.UserService/GetUser", "acme", codes.OK},
+		{"missing", "/user.UserService/GetUser", "", codes.Unauthenticated},
+		{"invalid", "/user.UserService/GetUser", "Not A Tenant", codes.InvalidArgument},
+		{"health check without tenant", "/grpc.health.v1.Health/Check", "", codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.client != "" {
+				ctx = tenant.NewContext(ctx, tt.client)
+			}
+
+			var sent context.Context
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				sent = ctx
+				return nil
+			}
+			if err := tenant.UnaryClientInterceptor()(ctx, tt.method, nil, nil, nil, invoker); err != nil {
+				t.Fatalf("client interceptor: %v", err)
+			}
+
+			called := false
+			var got string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				got = tenant.FromContext(ctx)
+				return nil, nil
+			}
+			_, err := tenant.UnaryServerInterceptor()(serverSide(sent), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if code := status.Code(err); code != tt.want {
+				t.Fatalf("server interceptor = %v, want %v", err, tt.want)
+			}
+			if called != (tt.want == codes.OK) {
+				t.Errorf("handler called = %v for %v", called, tt.want)
+			}
+			if tt.want == codes.OK && got != tt.client {
+				t.Errorf("handler saw tenant %q, want %q", got, tt.client)
+			}
+		})
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamInterceptors(t *testing.T) {
+	var sent context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		sent = ctx
+		return nil, nil
+	}
+	ctx := tenant.NewContext(context.Background(), "acme")
+	if _, err := tenant.StreamClientInterceptor()(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/task.TaskService/WatchTasks", streamer); err != nil {
+		t.Fatalf("client interceptor: %v", err)
+	}
+
+	info := &grpc.StreamServerInfo{FullMethod: "/task.TaskService/WatchTasks", IsServerStream: true}
+	var got string
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		got = tenant.FromContext(ss.Context())
+		return nil
+	}
+	if err := tenant.StreamServerInterceptor()(nil, &fakeServerStream{ctx: serverSide(sent)}, info, handler); err != nil {
+		t.Fatalf("server interceptor: %v", err)
+	}
+	if got != "acme" {
+		t.Errorf("handler saw tenant %q, want acme", got)
+	}
+
+	// A stream without a tenant never reaches the handler
+	called := false
+	handler = func(srv interface{}, ss grpc.ServerStream) error {
+		called = true
+		return nil
+	}
+	err := tenant.StreamServerInterceptor()(nil, &fakeServerStream{ctx: context.Background()}, info, handler)
+	if status.Code(err) != codes.Unauthenticated || called {
+		t.Errorf("stream without tenant = %v (handler called %v), want Unauthenticated", err, called)
+	}
+}
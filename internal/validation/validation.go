@@ -0,0 +1,216 @@
+// Package validation enforces the (validate.rules) field options declared in
+// the proto files. Both services install its interceptors so that every RPC
+// request is checked before it reaches a handler.
+package validation
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+
+	validatepb "github.com/bonyuta0204/otel-lab/proto/validatepb"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var patterns sync.Map // string -> *regexp.Regexp
+
+// Validate checks msg against its declared field rules. It returns nil or an
+// InvalidArgument status carrying a google.rpc.BadRequest detail with one
+// field violation per broken rule.
+func Validate(msg proto.Message) error {
+	violations := validateMessage("", msg.ProtoReflect())
+	if len(violations) == 0 {
+		return nil
+	}
+
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("invalid %s", msg.ProtoReflect().Descriptor().Name()))
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if msg, ok := req.(proto.Message); ok {
+			if err := Validate(msg); err != nil {
+				recordViolations(ctx, err)
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingStream{ServerStream: ss})
+	}
+}
+
+type validatingStream struct {
+	grpc.ServerStream
+}
+
+func (s *validatingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if msg, ok := m.(proto.Message); ok {
+		if err := Validate(msg); err != nil {
+			recordViolations(s.Context(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// FieldViolations extracts the BadRequest field violations from an error
+// returned by a service, or nil if it carries none.
+func FieldViolations(err error) []*errdetails.BadRequest_FieldViolation {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.InvalidArgument {
+		return nil
+	}
+	for _, detail := range st.Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			return br.FieldViolations
+		}
+	}
+	return nil
+}
+
+func recordViolations(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	violations := FieldViolations(err)
+	for _, v := range violations {
+		span.AddEvent("validation.violation", trace.WithAttributes(
+			attribute.String("validation.field", v.Field),
+			attribute.String("validation.description", v.Description),
+		))
+	}
+	span.SetAttributes(attribute.Int("validation.violation_count", len(violations)))
+	span.SetStatus(otelcodes.Error, "Request validation failed")
+}
+
+func validateMessage(prefix string, m protoreflect.Message) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := prefix + string(fd.Name())
+
+		if rules, ok := proto.GetExtension(fd.Options(), validatepb.E_Rules).(*validatepb.FieldRules); ok && rules != nil {
+			for _, desc := range checkField(m, fd, rules) {
+				violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: name, Description: desc})
+			}
+		}
+
+		if fd.Kind() == protoreflect.MessageKind && !fd.IsList() && !fd.IsMap() && m.Has(fd) {
+			violations = append(violations, validateMessage(name+".", m.Get(fd).Message())...)
+		}
+	}
+
+	return violations
+}
+
+func checkField(m protoreflect.Message, fd protoreflect.FieldDescriptor, rules *validatepb.FieldRules) []string {
+	if fd.IsList() {
+		list := m.Get(fd).List()
+		if rules.Required && list.Len() == 0 {
+			return []string{"is required"}
+		}
+		if rules.MaxItems > 0 && uint32(list.Len()) > rules.MaxItems {
+			return []string{fmt.Sprintf("must contain at most %d items", rules.MaxItems)}
+		}
+		var problems []string
+		for i := 0; i < list.Len(); i++ {
+			for _, p := range checkValue(fd, list.Get(i), true, rules) {
+				problems = append(problems, fmt.Sprintf("item %d %s", i, p))
+			}
+		}
+		return problems
+	}
+
+	return checkValue(fd, m.Get(fd), !fd.HasPresence() || m.Has(fd), rules)
+}
+
+func checkValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, present bool, rules *validatepb.FieldRules) []string {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		s := v.String()
+		if s == "" {
+			if rules.Required {
+				return []string{"is required"}
+			}
+			return nil
+		}
+		n := uint32(utf8.RuneCountInString(s))
+		if rules.MinLen > 0 && n < rules.MinLen {
+			return []string{fmt.Sprintf("must be at least %d characters", rules.MinLen)}
+		}
+		if rules.MaxLen > 0 && n > rules.MaxLen {
+			return []string{fmt.Sprintf("must be at most %d characters", rules.MaxLen)}
+		}
+		if rules.Email {
+			if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+				return []string{"must be a valid email address"}
+			}
+		}
+		if rules.Uuid && !uuidPattern.MatchString(s) {
+			return []string{"must be a UUID"}
+		}
+		if rules.Pattern != "" && !compile(rules.Pattern).MatchString(s) {
+			return []string{fmt.Sprintf("must match %s", rules.Pattern)}
+		}
+
+	case protoreflect.EnumKind:
+		if !present {
+			return nil
+		}
+		if rules.DefinedOnly && fd.Enum().Values().ByNumber(v.Enum()) == nil {
+			return []string{fmt.Sprintf("%d is not a valid %s", v.Enum(), fd.Enum().Name())}
+		}
+
+	case protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Sint32Kind, protoreflect.Sint64Kind,
+		protoreflect.Sfixed32Kind, protoreflect.Sfixed64Kind:
+		n := v.Int()
+		if rules.Gte != nil && n < *rules.Gte {
+			return []string{fmt.Sprintf("must be >= %d", *rules.Gte)}
+		}
+		if rules.Lte != nil && n > *rules.Lte {
+			return []string{fmt.Sprintf("must be <= %d", *rules.Lte)}
+		}
+
+	case protoreflect.MessageKind:
+		if rules.Required && !present {
+			return []string{"is required"}
+		}
+	}
+
+	return nil
+}
+
+func compile(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patterns.Store(pattern, re)
+	return re
+}
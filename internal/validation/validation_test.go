@@ -0,0 +1,111 @@
+package validation_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/internal/validation"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const taskID = "0b6f6a52-5d8e-4d0f-9a53-6f1b0c3d2e11"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  proto.Message
+		// want lists the fields with a violation, in order
+		want []string
+	}{
+		{"valid", &taskpb.CreateTaskRequest{Title: "Write tests", AssigneeId: "user-001"}, nil},
+		{"required string", &taskpb.CreateTaskRequest{}, []string{"title"}},
+		{"max_len", &taskpb.CreateTaskRequest{Title: strings.Repeat("x", 256)}, []string{"title"}},
+		{"max_len counts characters", &taskpb.CreateTaskRequest{Title: strings.Repeat("タ", 255)}, nil},
+		{"pattern", &taskpb.CreateTaskRequest{Title: "a", AssigneeId: "bob"}, []string{"assignee_id"}},
+		{"uuid", &taskpb.GetTaskRequest{Id: "not-a-uuid"}, []string{"id"}},
+		{"several fields", &taskpb.UpdateTaskRequest{Id: "x", AssigneeId: "bob"}, []string{"id", "assignee_id"}},
+		{"gte", &taskpb.ListTasksRequest{PageSize: -1}, []string{"page_size"}},
+		{"lte", &taskpb.ListTasksRequest{PageSize: 101}, []string{"page_size"}},
+		{"lte boundary", &taskpb.ListTasksRequest{PageSize: 100}, nil},
+		{"defined_only", &taskpb.ListTasksRequest{Status: taskpb.TaskStatus(42).Enum()}, []string{"status"}},
+		{"defined_only optional unset", &taskpb.WatchTasksRequest{}, nil},
+		{"defined_only optional set", &taskpb.WatchTasksRequest{Status: taskpb.TaskStatus(42).Enum()}, []string{"status"}},
+		{"email", &userpb.CreateUserRequest{Name: "Ann", Email: "Ann <ann@example.com>"}, []string{"email"}},
+		{"min_len", &taskpb.CreateWebhookRequest{Url: "https://example.com/hook", Secret: "short"}, []string{"secret"}},
+		{"required list", &taskpb.ImportTasksRequest{}, []string{"tasks"}},
+		{"max_items", &userpb.GetUsersByIdsRequest{Ids: make([]string, 101)}, []string{"ids"}},
+		{"list items", &taskpb.CreateWebhookRequest{
+			Url:        "https://example.com/hook",
+			EventTypes: []taskpb.TaskEventType{taskpb.TaskEventType_CREATED, taskpb.TaskEventType(9)},
+		}, []string{"event_types"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validation.Validate(tt.msg)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("Validate = %v, want InvalidArgument", err)
+			}
+
+			violations := validation.FieldViolations(err)
+			got := make([]string, len(violations))
+			for i, v := range violations {
+				got[i] = v.Field
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("violations on %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateNestedMessages(t *testing.T) {
+	err := validation.Validate(&taskpb.ImportTasksRequest{
+		Tasks: []*taskpb.ImportTask{{Title: "ok"}, {Title: ""}},
+	})
+	// Items of a repeated message field are not descended into; task-service
+	// reports them as row errors instead
+	if err != nil {
+		t.Errorf("Validate = %v, want nil", err)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := validation.UnaryServerInterceptor()
+	called := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return req, nil
+	}
+
+	_, err := interceptor(context.Background(), &taskpb.GetTaskRequest{Id: "x"}, &grpc.UnaryServerInfo{}, handler)
+	if status.Code(err) != codes.InvalidArgument || called {
+		t.Errorf("invalid request: err = %v, handler called = %v", err, called)
+	}
+
+	_, err = interceptor(context.Background(), &taskpb.GetTaskRequest{Id: taskID}, &grpc.UnaryServerInfo{}, handler)
+	if err != nil || !called {
+		t.Errorf("valid request: err = %v, handler called = %v", err, called)
+	}
+}
+
+func TestFieldViolations(t *testing.T) {
+	if v := validation.FieldViolations(status.Error(codes.NotFound, "task not found")); v != nil {
+		t.Errorf("FieldViolations of NotFound = %v, want nil", v)
+	}
+	if v := validation.FieldViolations(status.Error(codes.InvalidArgument, "bad")); v != nil {
+		t.Errorf("FieldViolations without details = %v, want nil", v)
+	}
+}
@@ -0,0 +1,85 @@
+// Package zstdcodec registers a zstd compressor with gRPC. Import it for its
+// side effect in every process that sends or receives zstd-compressed
+// messages; gRPC's built-in gzip lives in google.golang.org/grpc/encoding/gzip.
+package zstdcodec
+
+import (
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the grpc-encoding value negotiated for zstd.
+const Name = "zstd"
+
+func init() {
+	encoding.RegisterCompressor(&compressor{})
+}
+
+type compressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func (c *compressor) Name() string {
+	return Name
+}
+
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if enc, ok := c.encoders.Get().(*zstd.Encoder); ok {
+		enc.Reset(w)
+		return &writer{Encoder: enc, pool: &c.encoders}, nil
+	}
+	enc, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &writer{Encoder: enc, pool: &c.encoders}, nil
+}
+
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	if dec, ok := c.decoders.Get().(*zstd.Decoder); ok {
+		if err := dec.Reset(r); err != nil {
+			c.decoders.Put(dec)
+			return nil, err
+		}
+		return &reader{dec: dec, pool: &c.decoders}, nil
+	}
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &reader{dec: dec, pool: &c.decoders}, nil
+}
+
+// writer returns its encoder to the pool once the message is complete.
+type writer struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *writer) Close() error {
+	err := w.Encoder.Close()
+	w.pool.Put(w.Encoder)
+	return err
+}
+
+// reader returns its decoder to the pool after reading to EOF.
+type reader struct {
+	dec  *zstd.Decoder
+	pool *sync.Pool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.dec == nil {
+		return 0, io.EOF
+	}
+	n, err := r.dec.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r.dec)
+		r.dec = nil
+	}
+	return n, err
+}
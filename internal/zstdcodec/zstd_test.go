@@ -0,0 +1,66 @@
+package zstdcodec_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/internal/zstdcodec"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c := encoding.GetCompressor(zstdcodec.Name)
+	if c == nil {
+		t.Fatalf("no %q compressor registered", zstdcodec.Name)
+	}
+
+	// Several messages in a row reuse pooled encoders and decoders
+	messages := []string{
+		"",
+		"a",
+		strings.Repeat(`{"id":"task-1","title":"write tests"}`, 100),
+		"after a large message",
+	}
+	for _, msg := range messages {
+		var buf bytes.Buffer
+		w, err := c.Compress(&buf)
+		if err != nil {
+			t.Fatalf("Compress: %v", err)
+		}
+		if _, err := io.WriteString(w, msg); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		r, err := c.Decompress(&buf)
+		if err != nil {
+			t.Fatalf("Decompress: %v", err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if string(got) != msg {
+			t.Errorf("round trip of %d bytes returned %d bytes", len(msg), len(got))
+		}
+		// A drained reader stays at EOF after its decoder went back to the pool
+		if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("Read after EOF = %d, %v", n, err)
+		}
+	}
+}
+
+func TestDecompressInvalid(t *testing.T) {
+	c := encoding.GetCompressor(zstdcodec.Name)
+	r, err := c.Decompress(strings.NewReader("not zstd"))
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if err == nil {
+		t.Error("invalid input decompressed without error")
+	}
+}
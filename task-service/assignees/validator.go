@@ -0,0 +1,227 @@
+// Package assignees checks with user-service that the assignee of a task
+// exists before the task is written.
+package assignees
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Policy decides what happens to a write when user-service cannot answer.
+type Policy string
+
+const (
+	// FailClosed rejects the write with Unavailable.
+	FailClosed Policy = "fail-closed"
+	// FailOpen lets the write through with the assignee unverified.
+	FailOpen Policy = "fail-open"
+)
+
+type Config struct {
+	// Addr is user-service's address. Empty disables validation.
+	Addr   string `json:"addr"`
+	Policy Policy `json:"policy"`
+	// CacheTTL is how long an assignee stays known after user-service
+	// confirmed it. Unknown IDs are never cached, so a user created a
+	// moment ago can be assigned right away.
+	CacheTTL time.Duration `json:"cache_ttl"`
+	// Timeout bounds a lookup, so that a slow user-service does not use up
+	// the whole budget of the write.
+	Timeout time.Duration `json:"timeout"`
+}
+
+// ConfigFromEnv reads USER_SERVICE_ADDR, ASSIGNEE_VALIDATION_POLICY,
+// ASSIGNEE_CACHE_TTL and ASSIGNEE_LOOKUP_TIMEOUT.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Addr:     os.Getenv("USER_SERVICE_ADDR"),
+		Policy:   FailClosed,
+		CacheTTL: 30 * time.Second,
+		Timeout:  time.Second,
+	}
+
+	if raw := os.Getenv("ASSIGNEE_VALIDATION_POLICY"); raw != "" {
+		cfg.Policy = Policy(raw)
+		if cfg.Policy != FailClosed && cfg.Policy != FailOpen {
+			return Config{}, fmt.Errorf("ASSIGNEE_VALIDATION_POLICY must be %s or %s, got %q", FailClosed, FailOpen, raw)
+		}
+	}
+
+	for name, d := range map[string]*time.Duration{
+		"ASSIGNEE_CACHE_TTL":      &cfg.CacheTTL,
+		"ASSIGNEE_LOOKUP_TIMEOUT": &cfg.Timeout,
+	} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		v, err := time.ParseDuration(raw)
+		if err != nil || v < 0 {
+			return Config{}, fmt.Errorf("%s must be a non-negative duration, got %q", name, raw)
+		}
+		*d = v
+	}
+
+	return cfg, nil
+}
+
+// Validator looks assignees up in user-service and remembers the ones that
+// exist for a while. A nil Validator accepts every assignee.
+type Validator struct {
+	conn     *grpc.ClientConn
+	client   userpb.UserServiceClient
+	policy   Policy
+	cacheTTL time.Duration
+	timeout  time.Duration
+
+	mu        sync.Mutex
+	known     map[cacheKey]time.Time // expiry
+	lastSweep time.Time
+}
+
+type cacheKey struct {
+	tenant string
+	id     string
+}
+
+// New connects to user-service. Calls carry the request ID and tenant of
+// the write, so the lookup shows up in the same trace and tenant.
+func New(cfg Config) (*Validator, error) {
+	conn, err := grpc.NewClient(cfg.Addr,
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(requestid.UnaryClientInterceptor(), tenant.UnaryClientInterceptor()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to user-service: %w", err)
+	}
+
+	v := NewWithClient(userpb.NewUserServiceClient(conn), cfg)
+	v.conn = conn
+	return v, nil
+}
+
+// NewWithClient returns a validator that looks assignees up with client,
+// which the caller owns. cfg.Addr is ignored.
+func NewWithClient(client userpb.UserServiceClient, cfg Config) *Validator {
+	return &Validator{
+		client:   client,
+		policy:   cfg.Policy,
+		cacheTTL: cfg.CacheTTL,
+		timeout:  cfg.Timeout,
+		known:    make(map[cacheKey]time.Time),
+	}
+}
+
+func (v *Validator) Close() error {
+	if v == nil || v.conn == nil {
+		return nil
+	}
+	return v.conn.Close()
+}
+
+// Validate returns nil if assigneeID is empty or names an existing user of
+// the tenant in ctx, and a FailedPrecondition status if user-service does
+// not know it. When user-service cannot answer, the policy decides between
+// nil and an Unavailable status.
+func (v *Validator) Validate(ctx context.Context, assigneeID string) error {
+	if v == nil || assigneeID == "" {
+		return nil
+	}
+
+	ctx, span := tracing.GetTracer().Start(ctx, "Assignees.Validate")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("assignee.id", assigneeID),
+		attribute.String("assignee.policy", string(v.policy)),
+	)
+
+	key := cacheKey{tenant.FromContext(ctx), assigneeID}
+	if v.cached(key) {
+		span.SetAttributes(attribute.Bool("assignee.cache_hit", true))
+		return nil
+	}
+	span.SetAttributes(attribute.Bool("assignee.cache_hit", false))
+
+	lookupCtx := ctx
+	if v.timeout > 0 {
+		var cancel context.CancelFunc
+		lookupCtx, cancel = context.WithTimeout(ctx, v.timeout)
+		defer cancel()
+	}
+
+	_, err := v.client.GetUser(lookupCtx, &userpb.GetUserRequest{Id: assigneeID})
+	switch {
+	case err == nil:
+		v.remember(key)
+		return nil
+	case status.Code(err) == codes.NotFound:
+		span.SetStatus(otelcodes.Error, "Unknown assignee")
+		return status.Errorf(codes.FailedPrecondition, "assignee %s does not exist", assigneeID)
+	case ctx.Err() != nil:
+		// The write itself ran out of time; that is not user-service's fault
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, "Write cancelled")
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	span.RecordError(err)
+	if v.policy == FailOpen {
+		span.AddEvent("assignee.unverified", trace.WithAttributes(
+			attribute.String("error", status.Convert(err).Message()),
+		))
+		span.SetAttributes(attribute.Bool("assignee.verified", false))
+		return nil
+	}
+	span.SetStatus(otelcodes.Error, "User service unavailable")
+	return status.Errorf(codes.Unavailable, "cannot verify assignee %s: user-service is unavailable", assigneeID)
+}
+
+func (v *Validator) cached(key cacheKey) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	expiry, ok := v.known[key]
+	return ok && time.Now().Before(expiry)
+}
+
+func (v *Validator) remember(key cacheKey) {
+	if v.cacheTTL <= 0 {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	v.known[key] = now.Add(v.cacheTTL)
+
+	// Drop expired IDs about once per TTL so the cache does not keep every
+	// assignee ever seen
+	if now.Sub(v.lastSweep) < v.cacheTTL {
+		return
+	}
+	v.lastSweep = now
+	for k, expiry := range v.known {
+		if !now.Before(expiry) {
+			delete(v.known, k)
+		}
+	}
+}
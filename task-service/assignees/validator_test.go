@@ -0,0 +1,216 @@
+package assignees_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"github.com/bonyuta0204/otel-lab/task-service/assignees"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeUsers is a user-service that knows users per tenant and counts the
+// lookups it answers.
+type fakeUsers struct {
+	userpb.UserServiceClient
+
+	mu    sync.Mutex
+	users map[string]map[string]bool // tenant -> user IDs
+	err   error                      // returned for every lookup if set
+	delay time.Duration
+	calls int
+}
+
+func (f *fakeUsers) GetUser(ctx context.Context, req *userpb.GetUserRequest, _ ...grpc.CallOption) (*userpb.User, error) {
+	f.mu.Lock()
+	f.calls++
+	err, delay := f.err, f.delay
+	known := f.users[tenant.FromContext(ctx)][req.Id]
+	f.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		return nil, status.Errorf(codes.NotFound, "user %s not found", req.Id)
+	}
+	return &userpb.User{Id: req.Id}, nil
+}
+
+func (f *fakeUsers) lookups() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func newFakeUsers() *fakeUsers {
+	return &fakeUsers{users: map[string]map[string]bool{
+		"acme":   {"user-1": true},
+		"globex": {"user-2": true},
+	}}
+}
+
+func testConfig(policy assignees.Policy) assignees.Config {
+	return assignees.Config{Policy: policy, CacheTTL: time.Hour, Timeout: 50 * time.Millisecond}
+}
+
+func TestValidate(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	tests := []struct {
+		name     string
+		policy   assignees.Policy
+		tenant   string
+		assignee string
+		err      error
+		delay    time.Duration
+		want     codes.Code
+		lookups  int
+	}{
+		{"no assignee", assignees.FailClosed, "acme", "", nil, 0, codes.OK, 0},
+		{"existing user", assignees.FailClosed, "acme", "user-1", nil, 0, codes.OK, 1},
+		{"unknown user", assignees.FailClosed, "acme", "user-9", nil, 0, codes.FailedPrecondition, 1},
+		{"unknown user, fail-open", assignees.FailOpen, "acme", "user-9", nil, 0, codes.FailedPrecondition, 1},
+		{"user of another tenant", assignees.FailClosed, "acme", "user-2", nil, 0, codes.FailedPrecondition, 1},
+		{"unavailable, fail-closed", assignees.FailClosed, "acme", "user-1", unavailable, 0, codes.Unavailable, 1},
+		{"unavailable, fail-open", assignees.FailOpen, "acme", "user-1", unavailable, 0, codes.OK, 1},
+		{"internal error, fail-closed", assignees.FailClosed, "acme", "user-1", status.Error(codes.Internal, "boom"), 0, codes.Unavailable, 1},
+		{"lookup timeout, fail-closed", assignees.FailClosed, "acme", "user-1", nil, time.Second, codes.Unavailable, 1},
+		{"lookup timeout, fail-open", assignees.FailOpen, "acme", "user-1", nil, time.Second, codes.OK, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newFakeUsers()
+			users.err = tt.err
+			users.delay = tt.delay
+			v := assignees.NewWithClient(users, testConfig(tt.policy))
+
+			err := v.Validate(tenant.NewContext(context.Background(), tt.tenant), tt.assignee)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("Validate = %v, want %v", err, tt.want)
+			}
+			if got := users.lookups(); got != tt.lookups {
+				t.Errorf("%d lookups, want %d", got, tt.lookups)
+			}
+		})
+	}
+}
+
+func TestValidateWriteCancelled(t *testing.T) {
+	// A write that runs out of time fails with its own error, whatever the
+	// policy, and is not reported as user-service being unavailable
+	for _, policy := range []assignees.Policy{assignees.FailClosed, assignees.FailOpen} {
+		t.Run(string(policy), func(t *testing.T) {
+			users := newFakeUsers()
+			users.delay = time.Second
+			cfg := testConfig(policy)
+			cfg.Timeout = 0
+			v := assignees.NewWithClient(users, cfg)
+
+			ctx, cancel := context.WithTimeout(tenant.NewContext(context.Background(), "acme"), 20*time.Millisecond)
+			defer cancel()
+			if err := v.Validate(ctx, "user-1"); status.Code(err) != codes.DeadlineExceeded {
+				t.Errorf("Validate = %v, want DeadlineExceeded", err)
+			}
+		})
+	}
+}
+
+func TestNilValidator(t *testing.T) {
+	var v *assignees.Validator
+	if err := v.Validate(context.Background(), "user-1"); err != nil {
+		t.Errorf("nil Validator = %v, want every assignee accepted", err)
+	}
+	if err := v.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
+
+func TestValidateCache(t *testing.T) {
+	users := newFakeUsers()
+	users.users["globex"]["user-1"] = true
+	v := assignees.NewWithClient(users, testConfig(assignees.FailClosed))
+	acme := tenant.NewContext(context.Background(), "acme")
+	globex := tenant.NewContext(context.Background(), "globex")
+
+	steps := []struct {
+		name    string
+		ctx     context.Context
+		id      string
+		want    codes.Code
+		lookups int
+	}{
+		{"first lookup", acme, "user-1", codes.OK, 1},
+		{"cache hit", acme, "user-1", codes.OK, 1},
+		{"same ID in another tenant", globex, "user-1", codes.OK, 2},
+		{"cache hit in that tenant", globex, "user-1", codes.OK, 2},
+		{"unknown ID", acme, "user-9", codes.FailedPrecondition, 3},
+		{"unknown IDs are not cached", acme, "user-9", codes.FailedPrecondition, 4},
+	}
+	for _, step := range steps {
+		err := v.Validate(step.ctx, step.id)
+		if got := status.Code(err); got != step.want {
+			t.Errorf("%s: Validate = %v, want %v", step.name, err, step.want)
+		}
+		if got := users.lookups(); got != step.lookups {
+			t.Errorf("%s: %d lookups, want %d", step.name, got, step.lookups)
+		}
+	}
+
+	// A cached user keeps passing while user-service is down
+	users.err = status.Error(codes.Unavailable, "down")
+	if err := v.Validate(acme, "user-1"); err != nil {
+		t.Errorf("cached user while user-service is down: %v", err)
+	}
+}
+
+func TestValidateCacheExpiry(t *testing.T) {
+	users := newFakeUsers()
+	cfg := testConfig(assignees.FailClosed)
+	cfg.CacheTTL = 10 * time.Millisecond
+	v := assignees.NewWithClient(users, cfg)
+	ctx := tenant.NewContext(context.Background(), "acme")
+
+	if err := v.Validate(ctx, "user-1"); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The user was deleted since; once the entry expires that is noticed
+	delete(users.users["acme"], "user-1")
+	if err := v.Validate(ctx, "user-1"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Validate after expiry = %v, want FailedPrecondition", err)
+	}
+	if got := users.lookups(); got != 2 {
+		t.Errorf("%d lookups, want 2", got)
+	}
+}
+
+func TestValidateWithoutCache(t *testing.T) {
+	users := newFakeUsers()
+	cfg := testConfig(assignees.FailClosed)
+	cfg.CacheTTL = 0
+	v := assignees.NewWithClient(users, cfg)
+	ctx := tenant.NewContext(context.Background(), "acme")
+
+	for i := 0; i < 3; i++ {
+		if err := v.Validate(ctx, "user-1"); err != nil {
+			t.Fatalf("Validate: %v", err)
+		}
+	}
+	if got := users.lookups(); got != 3 {
+		t.Errorf("%d lookups with caching off, want 3", got)
+	}
+}
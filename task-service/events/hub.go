@@ -0,0 +1,239 @@
+// Package events streams task changes to WatchTasks. Changes are read from
+// the store's event log, which every replica shares, so a watcher sees
+// writes made on any replica and can resume on any replica from the last
+// sequence number it saw.
+package events
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/storage"
+	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	subscriberBuffer = 64
+	// readBatch is the number of events read from the log at a time.
+	readBatch = 500
+)
+
+// Log is the event log the hub reads; storage.TaskStore implements it.
+type Log interface {
+	LastEventSequence(ctx context.Context) (uint64, error)
+	EventsAfter(ctx context.Context, after uint64, limit int) ([]storage.TaskEvent, error)
+	TenantEventsAfter(ctx context.Context, after uint64, limit int) ([]*taskpb.TaskEvent, error)
+	PruneEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Hub follows the event log and fans new events out to WatchTasks streams.
+// Events are only ever delivered to subscribers of the tenant they were
+// recorded for.
+type Hub struct {
+	log  Log
+	wake chan struct{}
+
+	// PollInterval bounds how late the hub sees a write when no
+	// notification about it arrives.
+	PollInterval time.Duration
+	// Retention is how long events stay in the log, and so how far back
+	// a stream can resume.
+	Retention time.Duration
+
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives events read after it was created. C is closed
+// when the subscriber falls too far behind or is cancelled.
+type Subscription struct {
+	C      <-chan *taskpb.TaskEvent
+	ch     chan *taskpb.TaskEvent
+	hub    *Hub
+	tenant string
+	closed bool
+}
+
+func NewHub(log Log) *Hub {
+	return &Hub{
+		log:          log,
+		wake:         make(chan struct{}, 1),
+		PollInterval: time.Second,
+		Retention:    24 * time.Hour,
+		subscribers:  make(map[*Subscription]struct{}),
+	}
+}
+
+// Wake makes the hub read the log now instead of at its next poll. It is
+// called after local writes and for notifications about other replicas'.
+func (h *Hub) Wake() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run follows the log until ctx is cancelled. It starts at the end of the
+// log; older events only reach streams through Replay.
+func (h *Hub) Run(ctx context.Context) {
+	poll := time.NewTicker(h.PollInterval)
+	defer poll.Stop()
+
+	var last uint64
+	for {
+		var err error
+		if last, err = h.log.LastEventSequence(ctx); err == nil {
+			break
+		}
+		slog.Error("Failed to read the end of the event log", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		}
+	}
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		last = h.catchUp(ctx, last)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.wake:
+		case <-poll.C:
+		case <-prune.C:
+			if n, err := h.log.PruneEvents(ctx, time.Now().Add(-h.Retention)); err != nil {
+				slog.Error("Failed to prune the event log", "error", err)
+			} else if n > 0 {
+				slog.Info("Pruned the event log", "events", n)
+			}
+		}
+	}
+}
+
+// catchUp delivers every event after last and returns the new last
+// sequence.
+func (h *Hub) catchUp(ctx context.Context, last uint64) uint64 {
+	for {
+		events, err := h.log.EventsAfter(ctx, last, readBatch)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Failed to read the event log", "error", err)
+			}
+			return last
+		}
+		if len(events) > 0 {
+			h.fanOut(ctx, events)
+			last = events[len(events)-1].Event.Sequence
+		}
+		if len(events) < readBatch {
+			return last
+		}
+	}
+}
+
+// fanOut hands events to their tenant's subscribers. Its span starts a
+// trace of its own, linked to the writes that recorded the events.
+func (h *Hub) fanOut(ctx context.Context, events []storage.TaskEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subscribers) == 0 {
+		return
+	}
+
+	var links []trace.Link
+	for _, e := range events {
+		writeCtx := propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": e.Event.Traceparent})
+		if sc := trace.SpanContextFromContext(writeCtx); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+
+	_, span := tracing.GetTracer().Start(ctx, "Hub.FanOut", trace.WithNewRoot(), trace.WithLinks(links...))
+	defer span.End()
+
+	delivered, dropped := 0, 0
+	for _, e := range events {
+		for sub := range h.subscribers {
+			if sub.tenant != e.TenantID {
+				continue
+			}
+			select {
+			case sub.ch <- e.Event:
+				delivered++
+			default:
+				// The subscriber cannot keep up. Closing its channel ends the
+				// stream; the client resumes with its last sequence number.
+				h.removeLocked(sub)
+				dropped++
+			}
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("event.count", len(events)),
+		attribute.Int64("event.first_sequence", int64(events[0].Event.Sequence)),
+		attribute.Int64("event.last_sequence", int64(events[len(events)-1].Event.Sequence)),
+		attribute.Int("subscriber.deliveries", delivered),
+		attribute.Int("subscriber.dropped", dropped),
+	)
+}
+
+// Subscribe returns a subscription for the tenant's events that the hub
+// reads from now on.
+func (h *Hub) Subscribe(tenantID string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan *taskpb.TaskEvent, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, hub: h, tenant: tenantID}
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Replay passes the events of the tenant in ctx with a sequence above after
+// to fn, oldest first, and stops at the end of the log or at fn's first
+// error, which it returns unchanged.
+func (h *Hub) Replay(ctx context.Context, after uint64, fn func(*taskpb.TaskEvent) error) error {
+	for {
+		events, err := h.log.TenantEventsAfter(ctx, after, readBatch)
+		if err != nil {
+			return fmt.Errorf("failed to replay events: %w", err)
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+			after = event.Sequence
+		}
+		if len(events) < readBatch {
+			return nil
+		}
+	}
+}
+
+func (s *Subscription) Cancel() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.removeLocked(s)
+}
+
+func (h *Hub) removeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(h.subscribers, sub)
+	close(sub.ch)
+}
@@ -0,0 +1,192 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/events"
+	"github.com/bonyuta0204/otel-lab/task-service/storage"
+)
+
+// startHub runs a hub over store until the test ends. Every store write is
+// followed by Wake, as the server does.
+func startHub(t *testing.T, store *storage.MemoryTaskStore) *events.Hub {
+	t.Helper()
+	hub := events.NewHub(store)
+	hub.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hub.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Run starts at the end of the log; wait until it got there so that
+	// the test's writes are read as new
+	waitFor(t, func() bool {
+		sub := hub.Subscribe("probe")
+		defer sub.Cancel()
+		mustCreate(t, tenant.NewContext(context.Background(), "probe"), store, "probe")
+		hub.Wake()
+		select {
+		case <-sub.C:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	})
+	return hub
+}
+
+func TestHubDeliversOnlyTheTenantsEvents(t *testing.T) {
+	store := storage.NewMemoryTaskStore()
+	hub := startHub(t, store)
+
+	a := hub.Subscribe("tenant-a")
+	defer a.Cancel()
+	b := hub.Subscribe("tenant-b")
+	defer b.Cancel()
+
+	task := mustCreate(t, tenant.NewContext(context.Background(), "tenant-a"), store, "for a")
+	hub.Wake()
+
+	event := receive(t, a)
+	if event.Type != taskpb.TaskEventType_CREATED || event.Task.Id != task.Id {
+		t.Errorf("tenant-a received %v %s, want CREATED %s", event.Type, event.Task.Id, task.Id)
+	}
+
+	select {
+	case event := <-b.C:
+		t.Errorf("tenant-b received an event for %s", event.Task.Id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHubSeesWritesWithoutWake(t *testing.T) {
+	store := storage.NewMemoryTaskStore()
+	hub := startHub(t, store)
+
+	sub := hub.Subscribe("tenant-a")
+	defer sub.Cancel()
+
+	// A write on another replica only reaches this hub through its poll
+	task := mustCreate(t, tenant.NewContext(context.Background(), "tenant-a"), store, "elsewhere")
+
+	if event := receive(t, sub); event.Task.Id != task.Id {
+		t.Errorf("received %s, want %s", event.Task.Id, task.Id)
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	store := storage.NewMemoryTaskStore()
+	hub := startHub(t, store)
+
+	sub := hub.Subscribe("tenant-a")
+	defer sub.Cancel()
+
+	ctx := tenant.NewContext(context.Background(), "tenant-a")
+	rows := make([]*taskpb.ImportTask, 100)
+	for i := range rows {
+		rows[i] = &taskpb.ImportTask{Title: "bulk"}
+	}
+	if _, err := store.ImportTasks(ctx, rows); err != nil {
+		t.Fatalf("ImportTasks: %v", err)
+	}
+	hub.Wake()
+
+	timeout := time.After(time.Second)
+	received := 0
+	for {
+		select {
+		case _, ok := <-sub.C:
+			if !ok {
+				if received >= len(rows) {
+					t.Errorf("subscriber was closed after receiving all %d events", received)
+				}
+				return
+			}
+			received++
+		case <-timeout:
+			t.Fatalf("subscriber was not closed; received %d events", received)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	store := storage.NewMemoryTaskStore()
+	hub := events.NewHub(store)
+
+	ctx := tenant.NewContext(context.Background(), "tenant-a")
+	first := mustCreate(t, ctx, store, "first")
+	mustCreate(t, tenant.NewContext(context.Background(), "tenant-b"), store, "other")
+	second := mustCreate(t, ctx, store, "second")
+
+	var replayed []*taskpb.TaskEvent
+	err := hub.Replay(ctx, 0, func(event *taskpb.TaskEvent) error {
+		replayed = append(replayed, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(replayed) != 2 || replayed[0].Task.Id != first.Id || replayed[1].Task.Id != second.Id {
+		t.Fatalf("Replay returned %d events, want the tenant's 2 in order", len(replayed))
+	}
+
+	var rest []*taskpb.TaskEvent
+	err = hub.Replay(ctx, replayed[0].Sequence, func(event *taskpb.TaskEvent) error {
+		rest = append(rest, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(rest) != 1 || rest[0].Task.Id != second.Id {
+		t.Errorf("Replay after the first event returned %d events, want the second", len(rest))
+	}
+
+	if err := hub.Replay(context.Background(), 0, func(*taskpb.TaskEvent) error { return nil }); err == nil {
+		t.Error("Replay without a tenant succeeded")
+	}
+}
+
+func mustCreate(t *testing.T, ctx context.Context, store storage.TaskStore, title string) *taskpb.Task {
+	t.Helper()
+	task, err := store.CreateTask(ctx, &taskpb.CreateTaskRequest{Title: title})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	return task
+}
+
+func receive(t *testing.T, sub *events.Subscription) *taskpb.TaskEvent {
+	t.Helper()
+	select {
+	case event, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
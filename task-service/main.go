@@ -7,20 +7,44 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/bonyuta0204/otel-lab/internal/admin"
+	"github.com/bonyuta0204/otel-lab/internal/deadline"
+	"github.com/bonyuta0204/otel-lab/internal/healthcheck"
+	"github.com/bonyuta0204/otel-lab/internal/logging"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/sampling"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	"github.com/bonyuta0204/otel-lab/internal/validation"
+	_ "github.com/bonyuta0204/otel-lab/internal/zstdcodec"
 	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/assignees"
+	"github.com/bonyuta0204/otel-lab/task-service/events"
 	"github.com/bonyuta0204/otel-lab/task-service/server"
 	"github.com/bonyuta0204/otel-lab/task-service/storage"
 	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"github.com/bonyuta0204/otel-lab/task-service/webhooks"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
 	ctx := context.Background()
 
-	// Initialize tracing
-	tp, err := tracing.InitTracer(ctx)
+	// Initialize logging and tracing; both can be adjusted at runtime
+	// through the admin API
+	logLevel := logging.Init()
+
+	sampler, err := sampling.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid sampling configuration: %v", err)
+	}
+
+	tp, err := tracing.InitTracer(ctx, sampler)
 	if err != nil {
 		log.Fatalf("Failed to initialize tracer: %v", err)
 	}
@@ -30,23 +54,94 @@ func main() {
 		}
 	}()
 
-	// Initialize database
-	db, err := storage.NewPostgresDB()
+	// TASK_STORE selects where tasks live. The memory store needs no
+	// database but also has no webhooks, whose queue is kept in Postgres.
+	storeKind := os.Getenv("TASK_STORE")
+	if storeKind == "" {
+		storeKind = "postgres"
+	}
+
+	var (
+		repo        storage.TaskStore
+		webhookRepo *storage.WebhookRepository
+		dbConfig    interface{}
+		db          *storage.PostgresDB
+	)
+	ping := func(context.Context) error { return nil }
+	stopDispatcher := func() {}
+
+	// Webhooks may only call public addresses, and the networks the
+	// operator allowed
+	webhookGuard, err := webhooks.GuardFromEnv()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Invalid webhook configuration: %v", err)
 	}
-	defer db.Close()
 
-	// Run migrations
-	if err := db.Migrate(); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	switch storeKind {
+	case "postgres":
+		// Initialize database
+		pgConfig := storage.PostgresConfigFromEnv()
+		db, err = storage.NewPostgresDB(pgConfig)
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer db.Close()
+
+		// Run migrations
+		if err := db.Migrate(); err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		repo = storage.NewTaskRepository(db)
+		dbConfig = pgConfig
+		ping = db.Ping
+
+		// Webhook deliveries are queued in Postgres from the task event log
+		// and sent in the background
+		webhookRepo = storage.NewWebhookRepository(db)
+		dispatcher := webhooks.NewDispatcher(webhookRepo, webhookGuard)
+		var dispatchCtx context.Context
+		dispatchCtx, stopDispatcher = context.WithCancel(ctx)
+		defer stopDispatcher()
+		go dispatcher.Run(dispatchCtx)
+	case "memory":
+		repo = storage.NewMemoryTaskStore()
+		log.Println("Keeping tasks in memory; webhooks are disabled")
+	default:
+		log.Fatalf("Unknown TASK_STORE %q (want postgres or memory)", storeKind)
 	}
 
-	// Initialize repository
-	repo := storage.NewTaskRepository(db)
+	// WatchTasks streams follow the store's event log. Other replicas'
+	// writes are announced through Postgres notifications; the hub also
+	// polls in case one is missed.
+	hub := events.NewHub(repo)
+	hubCtx, stopHub := context.WithCancel(ctx)
+	defer stopHub()
+	go hub.Run(hubCtx)
+	if db != nil {
+		if err := db.Listen(hubCtx, storage.EventsChannel, hub.Wake); err != nil {
+			log.Fatalf("Failed to listen for task events: %v", err)
+		}
+	}
+
+	// Assignees are checked with user-service before tasks are written
+	assigneeConfig, err := assignees.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid assignee validation configuration: %v", err)
+	}
+	var validator *assignees.Validator
+	if assigneeConfig.Addr != "" {
+		validator, err = assignees.New(assigneeConfig)
+		if err != nil {
+			log.Fatalf("Failed to initialize assignee validation: %v", err)
+		}
+		defer validator.Close()
+	} else {
+		log.Println("USER_SERVICE_ADDR is not set; assignees are not validated")
+	}
 
 	// Initialize server
-	taskServer := server.NewTaskServer(repo)
+	taskServer := server.NewTaskServer(repo, hub, validator)
 
 	// Setup gRPC server
 	lis, err := net.Listen("tcp", ":8081")
@@ -54,9 +149,38 @@ func main() {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	s := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithMessageEvents(otelgrpc.ReceivedEvents, otelgrpc.SentEvents),
+		)),
+		grpc.ChainUnaryInterceptor(
+			requestid.UnaryServerInterceptor(),
+			tenant.UnaryServerInterceptor(),
+			deadline.UnaryServerInterceptor(),
+			validation.UnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			requestid.StreamServerInterceptor(),
+			tenant.StreamServerInterceptor(),
+			deadline.StreamServerInterceptor(),
+			validation.StreamServerInterceptor(),
+		),
+	)
 
 	taskpb.RegisterTaskServiceServer(s, taskServer)
+	if webhookRepo != nil {
+		taskpb.RegisterWebhookServiceServer(s, server.NewWebhookServer(webhookRepo, webhookGuard))
+	}
+
+	// Health reflects the store's availability, both as "postgres", which
+	// the gateway probes whichever store is in use, and for the service as
+	// a whole. The memory store is always available.
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	defer stopMonitor()
+	go healthcheck.Monitor(monitorCtx, healthServer, "postgres", true, 5*time.Second, ping)
 
 	// Start server
 	go func() {
@@ -66,12 +190,35 @@ func main() {
 		}
 	}()
 
+	// Start admin API
+	adminOpts := admin.OptionsFromEnv("task-service", ":9091")
+	adminOpts.Level = logLevel
+	adminOpts.Sampler = sampler
+	adminOpts.Config = func() interface{} {
+		return map[string]interface{}{
+			"listen":              ":8081",
+			"task_store":          storeKind,
+			"database":            dbConfig,
+			"assignee_validation": assigneeConfig,
+			"jaeger_endpoint":     os.Getenv("JAEGER_ENDPOINT"),
+			"log_level":           logLevel.Level().String(),
+			"trace_sample_ratio":  sampler.Ratio(),
+		}
+	}
+	adminServer := admin.New(adminOpts)
+	adminServer.Start()
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
 
+	stopMonitor()
+	stopHub()
+	stopDispatcher()
+	adminServer.Shutdown(ctx)
+	healthServer.Shutdown()
 	s.GracefulStop()
 	log.Println("Server exited")
 }
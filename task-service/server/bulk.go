@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+
+	"github.com/bonyuta0204/otel-lab/internal/validation"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *TaskServer) ExportTasks(req *taskpb.ExportTasksRequest, stream taskpb.TaskService_ExportTasksServer) error {
+	ctx, span := tracing.GetTracer().Start(stream.Context(), "TaskServer.ExportTasks")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("filter.assignee_id", req.AssigneeId))
+	if req.Status != nil {
+		span.SetAttributes(attribute.String("filter.status", req.Status.String()))
+	}
+
+	exported, err := s.repo.ExportTasks(ctx, req, stream.Send)
+	span.SetAttributes(attribute.Int("export.count", exported))
+	if err != nil {
+		span.RecordError(err)
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		span.SetStatus(otelcodes.Error, "Failed to export tasks")
+		return status.Error(codes.Internal, "failed to export tasks")
+	}
+
+	return nil
+}
+
+// ImportTasks validates every row on its own, including that its assignee
+// exists, and inserts the valid ones as one batch. Invalid rows are
+// reported by their index and do not fail the others.
+func (s *TaskServer) ImportTasks(ctx context.Context, req *taskpb.ImportTasksRequest) (*taskpb.ImportTasksResponse, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "TaskServer.ImportTasks")
+	defer span.End()
+
+	resp := &taskpb.ImportTasksResponse{}
+	valid := make([]*taskpb.ImportTask, 0, len(req.Tasks))
+	// Rows often share assignees; each is looked up once per batch
+	assigneeErrs := make(map[string]error)
+	for i, row := range req.Tasks {
+		err := validation.Validate(row)
+		if err == nil {
+			assigneeErr, checked := assigneeErrs[row.AssigneeId]
+			if !checked {
+				assigneeErr = s.assignees.Validate(ctx, row.AssigneeId)
+				if ctx.Err() != nil {
+					span.RecordError(assigneeErr)
+					return nil, status.FromContextError(ctx.Err()).Err()
+				}
+				assigneeErrs[row.AssigneeId] = assigneeErr
+			}
+			if assigneeErr != nil {
+				resp.Errors = append(resp.Errors, &taskpb.ImportRowError{
+					Index:   int32(i),
+					Field:   "assignee_id",
+					Message: status.Convert(assigneeErr).Message(),
+				})
+				continue
+			}
+			valid = append(valid, row)
+			continue
+		}
+		violations := validation.FieldViolations(err)
+		if len(violations) == 0 {
+			resp.Errors = append(resp.Errors, &taskpb.ImportRowError{Index: int32(i), Message: status.Convert(err).Message()})
+		}
+		for _, v := range violations {
+			resp.Errors = append(resp.Errors, &taskpb.ImportRowError{
+				Index:   int32(i),
+				Field:   v.Field,
+				Message: v.Description,
+			})
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("import.batch_size", len(req.Tasks)),
+		attribute.Int("import.rejected", len(req.Tasks)-len(valid)),
+	)
+
+	if len(valid) > 0 {
+		tasks, err := s.repo.ImportTasks(ctx, valid)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, "Failed to import tasks")
+			return nil, status.Error(codes.Internal, "failed to import tasks")
+		}
+		resp.Tasks = tasks
+		s.events.Wake()
+	}
+
+	span.SetAttributes(attribute.Int("import.inserted", len(resp.Tasks)))
+
+	return resp, nil
+}
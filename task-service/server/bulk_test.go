@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"github.com/bonyuta0204/otel-lab/task-service/assignees"
+	"github.com/bonyuta0204/otel-lab/task-service/events"
+	"github.com/bonyuta0204/otel-lab/task-service/server"
+	"github.com/bonyuta0204/otel-lab/task-service/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeUsers knows user-1 and user-2, and counts lookups.
+type fakeUsers struct {
+	userpb.UserServiceClient
+	err   error
+	calls int
+}
+
+func (f *fakeUsers) GetUser(_ context.Context, req *userpb.GetUserRequest, _ ...grpc.CallOption) (*userpb.User, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if req.Id != "user-1" && req.Id != "user-2" {
+		return nil, status.Errorf(codes.NotFound, "user %s not found", req.Id)
+	}
+	return &userpb.User{Id: req.Id}, nil
+}
+
+func TestImportTasksValidatesAssignees(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   assignees.Policy
+		err      error
+		rows     []*taskpb.ImportTask
+		imported int
+		errors   []*taskpb.ImportRowError
+		lookups  int
+	}{
+		{
+			name:   "unknown assignees are row errors",
+			policy: assignees.FailClosed,
+			rows: []*taskpb.ImportTask{
+				{Title: "a", AssigneeId: "user-1"},
+				{Title: "b", AssigneeId: "user-9"},
+				{Title: "c"},
+				{Title: "d", AssigneeId: "user-9"},
+				{Title: "e", AssigneeId: "user-2"},
+			},
+			imported: 3,
+			errors: []*taskpb.ImportRowError{
+				{Index: 1, Field: "assignee_id", Message: "assignee user-9 does not exist"},
+				{Index: 3, Field: "assignee_id", Message: "assignee user-9 does not exist"},
+			},
+			// user-1, user-9 and user-2 once each
+			lookups: 3,
+		},
+		{
+			name:   "invalid rows are not looked up",
+			policy: assignees.FailClosed,
+			rows: []*taskpb.ImportTask{
+				{AssigneeId: "user-9"},
+				{Title: "b", AssigneeId: "user-1"},
+			},
+			imported: 1,
+			errors:   []*taskpb.ImportRowError{{Index: 0, Field: "title"}},
+			lookups:  1,
+		},
+		{
+			name:   "user-service down, fail-closed",
+			policy: assignees.FailClosed,
+			err:    status.Error(codes.Unavailable, "down"),
+			rows: []*taskpb.ImportTask{
+				{Title: "a", AssigneeId: "user-1"},
+				{Title: "b"},
+			},
+			imported: 1,
+			errors: []*taskpb.ImportRowError{
+				{Index: 0, Field: "assignee_id", Message: "cannot verify assignee user-1: user-service is unavailable"},
+			},
+			lookups: 1,
+		},
+		{
+			name:   "user-service down, fail-open",
+			policy: assignees.FailOpen,
+			err:    status.Error(codes.Unavailable, "down"),
+			rows: []*taskpb.ImportTask{
+				{Title: "a", AssigneeId: "user-1"},
+				{Title: "b"},
+			},
+			imported: 2,
+			lookups:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeUsers{err: tt.err}
+			validator := assignees.NewWithClient(users, assignees.Config{Policy: tt.policy, CacheTTL: time.Minute, Timeout: time.Second})
+			repo := storage.NewMemoryTaskStore()
+			s := server.NewTaskServer(repo, events.NewHub(repo), validator)
+
+			ctx := tenant.NewContext(context.Background(), "acme")
+			resp, err := s.ImportTasks(ctx, &taskpb.ImportTasksRequest{Tasks: tt.rows})
+			if err != nil {
+				t.Fatalf("ImportTasks: %v", err)
+			}
+
+			if len(resp.Tasks) != tt.imported {
+				t.Errorf("imported %d tasks, want %d", len(resp.Tasks), tt.imported)
+			}
+			if len(resp.Errors) != len(tt.errors) {
+				t.Fatalf("errors = %v, want %v", resp.Errors, tt.errors)
+			}
+			for i, want := range tt.errors {
+				got := resp.Errors[i]
+				if got.Index != want.Index || got.Field != want.Field || (want.Message != "" && got.Message != want.Message) {
+					t.Errorf("error %d = %v, want %v", i, got, want)
+				}
+			}
+			if users.calls != tt.lookups {
+				t.Errorf("%d lookups, want %d", users.calls, tt.lookups)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+
+	"github.com/bonyuta0204/otel-lab/internal/search"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *TaskServer) SearchTasks(ctx context.Context, req *taskpb.SearchTasksRequest) (*taskpb.SearchTasksResponse, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "TaskServer.SearchTasks")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("search.query", req.Query),
+		attribute.Int("search.limit", int(req.Limit)),
+	)
+
+	if req.Limit <= 0 {
+		req.Limit = 10
+	}
+
+	// A query of punctuation only has nothing to match
+	terms := search.Terms(req.Query)
+	if len(terms) == 0 {
+		return &taskpb.SearchTasksResponse{}, nil
+	}
+
+	hits, err := s.repo.SearchTasks(ctx, terms, int(req.Limit))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, "Failed to search tasks")
+		return nil, status.Error(codes.Internal, "failed to search tasks")
+	}
+
+	resp := &taskpb.SearchTasksResponse{Hits: make([]*taskpb.TaskHit, len(hits))}
+	for i, hit := range hits {
+		resp.Hits[i] = &taskpb.TaskHit{Task: hit.Item, Score: hit.Score}
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(resp.Hits)))
+
+	return resp, nil
+}
@@ -2,8 +2,13 @@ package server
 
 import (
 	"context"
+	"errors"
 
+	"github.com/bonyuta0204/otel-lab/internal/pagetoken"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
 	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/assignees"
+	"github.com/bonyuta0204/otel-lab/task-service/events"
 	"github.com/bonyuta0204/otel-lab/task-service/storage"
 	"github.com/bonyuta0204/otel-lab/task-service/tracing"
 	"go.opentelemetry.io/otel/attribute"
@@ -15,12 +20,16 @@ import (
 
 type TaskServer struct {
 	taskpb.UnimplementedTaskServiceServer
-	repo *storage.TaskRepository
+	repo      storage.TaskStore
+	events    *events.Hub
+	assignees *assignees.Validator
 }
 
-func NewTaskServer(repo *storage.TaskRepository) *TaskServer {
+func NewTaskServer(repo storage.TaskStore, hub *events.Hub, validator *assignees.Validator) *TaskServer {
 	return &TaskServer{
-		repo: repo,
+		repo:      repo,
+		events:    hub,
+		assignees: validator,
 	}
 }
 
@@ -38,6 +47,12 @@ func (s *TaskServer) CreateTask(ctx context.Context, req *taskpb.CreateTaskReque
 		return nil, status.Error(codes.InvalidArgument, "title is required")
 	}
 
+	if err := s.assignees.Validate(ctx, req.AssigneeId); err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, "Invalid assignee")
+		return nil, err
+	}
+
 	task, err := s.repo.CreateTask(ctx, req)
 	if err != nil {
 		span.RecordError(err)
@@ -47,6 +62,8 @@ func (s *TaskServer) CreateTask(ctx context.Context, req *taskpb.CreateTaskReque
 
 	span.SetAttributes(attribute.String("task.id", task.Id))
 
+	s.events.Wake()
+
 	return task, nil
 }
 
@@ -64,7 +81,7 @@ func (s *TaskServer) GetTask(ctx context.Context, req *taskpb.GetTaskRequest) (*
 	task, err := s.repo.GetTask(ctx, req.Id)
 	if err != nil {
 		span.RecordError(err)
-		if err.Error() == "task not found" {
+		if errors.Is(err, storage.ErrTaskNotFound) {
 			span.SetStatus(otelcodes.Error, "Task not found")
 			return nil, status.Error(codes.NotFound, "task not found")
 		}
@@ -81,31 +98,49 @@ func (s *TaskServer) ListTasks(ctx context.Context, req *taskpb.ListTasksRequest
 
 	span.SetAttributes(
 		attribute.Int("page.size", int(req.PageSize)),
-		attribute.Int("page.number", int(req.PageNumber)),
+		attribute.Bool("page.token", req.PageToken != ""),
 		attribute.String("filter.assignee_id", req.AssigneeId),
-		attribute.Int("filter.status", int(req.Status)),
 	)
+	statusFilter := ""
+	if req.Status != nil {
+		statusFilter = req.Status.String()
+		span.SetAttributes(attribute.String("filter.status", statusFilter))
+	}
 
 	if req.PageSize <= 0 {
 		req.PageSize = 10
 	}
-	if req.PageNumber <= 0 {
-		req.PageNumber = 1
+
+	// A token only continues the query it was issued for
+	filter := pagetoken.Filter(req.AssigneeId, statusFilter)
+	var after *pagetoken.Cursor
+	if req.PageToken != "" {
+		cursor, err := pagetoken.Decode(req.PageToken, filter)
+		if err != nil {
+			span.SetStatus(otelcodes.Error, "Invalid page token")
+			return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+		after = &cursor
 	}
 
-	tasks, totalCount, err := s.repo.ListTasks(ctx, req)
+	page, err := s.repo.ListTasks(ctx, req, after)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(otelcodes.Error, "Failed to list tasks")
 		return nil, status.Error(codes.Internal, "failed to list tasks")
 	}
 
-	span.SetAttributes(attribute.Int("result.count", len(tasks)))
+	span.SetAttributes(attribute.Int("result.count", len(page.Tasks)))
 
-	return &taskpb.ListTasksResponse{
-		Tasks:      tasks,
-		TotalCount: totalCount,
-	}, nil
+	resp := &taskpb.ListTasksResponse{
+		Tasks:      page.Tasks,
+		TotalCount: page.TotalCount,
+	}
+	if page.Next != nil {
+		resp.NextPageToken = pagetoken.Encode(*page.Next, filter)
+	}
+
+	return resp, nil
 }
 
 func (s *TaskServer) UpdateTask(ctx context.Context, req *taskpb.UpdateTaskRequest) (*taskpb.Task, error) {
@@ -119,10 +154,16 @@ func (s *TaskServer) UpdateTask(ctx context.Context, req *taskpb.UpdateTaskReque
 		return nil, status.Error(codes.InvalidArgument, "task id is required")
 	}
 
+	if err := s.assignees.Validate(ctx, req.AssigneeId); err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, "Invalid assignee")
+		return nil, err
+	}
+
 	task, err := s.repo.UpdateTask(ctx, req)
 	if err != nil {
 		span.RecordError(err)
-		if err.Error() == "task not found" {
+		if errors.Is(err, storage.ErrTaskNotFound) {
 			span.SetStatus(otelcodes.Error, "Task not found")
 			return nil, status.Error(codes.NotFound, "task not found")
 		}
@@ -130,6 +171,8 @@ func (s *TaskServer) UpdateTask(ctx context.Context, req *taskpb.UpdateTaskReque
 		return nil, status.Error(codes.Internal, "failed to update task")
 	}
 
+	s.events.Wake()
+
 	return task, nil
 }
 
@@ -144,10 +187,10 @@ func (s *TaskServer) DeleteTask(ctx context.Context, req *taskpb.DeleteTaskReque
 		return nil, status.Error(codes.InvalidArgument, "task id is required")
 	}
 
-	err := s.repo.DeleteTask(ctx, req.Id)
+	_, err := s.repo.DeleteTask(ctx, req.Id)
 	if err != nil {
 		span.RecordError(err)
-		if err.Error() == "task not found" {
+		if errors.Is(err, storage.ErrTaskNotFound) {
 			span.SetStatus(otelcodes.Error, "Task not found")
 			return nil, status.Error(codes.NotFound, "task not found")
 		}
@@ -155,5 +198,87 @@ func (s *TaskServer) DeleteTask(ctx context.Context, req *taskpb.DeleteTaskReque
 		return nil, status.Error(codes.Internal, "failed to delete task")
 	}
 
+	s.events.Wake()
+
 	return &emptypb.Empty{}, nil
+}
+
+func (s *TaskServer) WatchTasks(req *taskpb.WatchTasksRequest, stream taskpb.TaskService_WatchTasksServer) error {
+	ctx, span := tracing.GetTracer().Start(stream.Context(), "TaskServer.WatchTasks")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("filter.assignee_id", req.AssigneeId),
+		attribute.Int64("watch.after_sequence", int64(req.AfterSequence)),
+	)
+	if req.Status != nil {
+		span.SetAttributes(attribute.String("filter.status", req.Status.String()))
+	}
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(otelcodes.Error, "Missing tenant")
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	// Subscribe before replaying so that no event falls in between; events
+	// seen in both are only sent once
+	sub := s.events.Subscribe(tenantID)
+	defer sub.Cancel()
+
+	last := req.AfterSequence
+	sent := 0
+	var sendErr error
+	send := func(event *taskpb.TaskEvent) error {
+		if event.Sequence <= last {
+			return nil
+		}
+		last = event.Sequence
+		if !matchesWatch(req, event) {
+			return nil
+		}
+		sent++
+		sendErr = stream.Send(event)
+		return sendErr
+	}
+
+	if req.AfterSequence > 0 {
+		if err := s.events.Replay(ctx, req.AfterSequence, send); err != nil {
+			span.RecordError(err)
+			if sendErr != nil {
+				return err
+			}
+			span.SetStatus(otelcodes.Error, "Failed to replay events")
+			return status.Error(codes.Internal, "failed to replay events")
+		}
+		span.SetAttributes(attribute.Int("watch.replayed", sent))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			span.SetAttributes(attribute.Int("watch.sent", sent))
+			return nil
+		case event, ok := <-sub.C:
+			if !ok {
+				span.SetAttributes(attribute.Int("watch.sent", sent))
+				span.SetStatus(otelcodes.Error, "Subscriber fell behind")
+				return status.Error(codes.Unavailable, "watcher fell behind, resume from the last sequence")
+			}
+			if err := send(event); err != nil {
+				span.RecordError(err)
+				return err
+			}
+		}
+	}
+}
+
+func matchesWatch(req *taskpb.WatchTasksRequest, event *taskpb.TaskEvent) bool {
+	if req.AssigneeId != "" && event.Task.AssigneeId != req.AssigneeId {
+		return false
+	}
+	if req.Status != nil && event.Task.Status != *req.Status {
+		return false
+	}
+	return true
 }
\ No newline at end of file
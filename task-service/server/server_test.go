@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/events"
+	"github.com/bonyuta0204/otel-lab/task-service/server"
+	"github.com/bonyuta0204/otel-lab/task-service/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListTasksStatusFilter(t *testing.T) {
+	repo := storage.NewMemoryTaskStore()
+	s := server.NewTaskServer(repo, events.NewHub(repo), nil)
+	ctx := tenant.NewContext(context.Background(), "acme")
+
+	for _, title := range []string{"a", "b", "c"} {
+		if _, err := s.CreateTask(ctx, &taskpb.CreateTaskRequest{Title: title}); err != nil {
+			t.Fatalf("CreateTask: %v", err)
+		}
+	}
+	created, err := s.CreateTask(ctx, &taskpb.CreateTaskRequest{Title: "d"})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	if _, err := s.UpdateTask(ctx, &taskpb.UpdateTaskRequest{Id: created.Id, Title: "d", Status: taskpb.TaskStatus_DONE}); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		status *taskpb.TaskStatus
+		want   int
+	}{
+		{"unset", nil, 4},
+		{"TODO", taskpb.TaskStatus_TODO.Enum(), 3},
+		{"DONE", taskpb.TaskStatus_DONE.Enum(), 1},
+		{"IN_PROGRESS", taskpb.TaskStatus_IN_PROGRESS.Enum(), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.ListTasks(ctx, &taskpb.ListTasksRequest{Status: tt.status, IncludeTotalCount: true})
+			if err != nil {
+				t.Fatalf("ListTasks: %v", err)
+			}
+			if len(resp.Tasks) != tt.want || resp.GetTotalCount() != int32(tt.want) {
+				t.Errorf("%d tasks, total %d; want %d", len(resp.Tasks), resp.GetTotalCount(), tt.want)
+			}
+		})
+	}
+
+	// A token for the unfiltered list does not continue the TODO list
+	first, err := s.ListTasks(ctx, &taskpb.ListTasksRequest{PageSize: 1})
+	if err != nil {
+		t.Fatalf("ListTasks: %v", err)
+	}
+	_, err = s.ListTasks(ctx, &taskpb.ListTasksRequest{PageSize: 1, PageToken: first.NextPageToken, Status: taskpb.TaskStatus_TODO.Enum()})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ListTasks with another filter's token = %v, want InvalidArgument", err)
+	}
+}
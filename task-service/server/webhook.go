@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/storage"
+	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"github.com/bonyuta0204/otel-lab/task-service/webhooks"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type WebhookServer struct {
+	taskpb.UnimplementedWebhookServiceServer
+	repo  *storage.WebhookRepository
+	guard *webhooks.Guard
+}
+
+func NewWebhookServer(repo *storage.WebhookRepository, guard *webhooks.Guard) *WebhookServer {
+	return &WebhookServer{repo: repo, guard: guard}
+}
+
+func (s *WebhookServer) CreateWebhook(ctx context.Context, req *taskpb.CreateWebhookRequest) (*taskpb.Webhook, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookServer.CreateWebhook")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("webhook.url", req.Url))
+
+	if err := s.checkURL(ctx, span, req.Url); err != nil {
+		return nil, err
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, "Failed to generate secret")
+			return nil, status.Error(codes.Internal, "failed to generate secret")
+		}
+		secret = hex.EncodeToString(buf)
+	}
+	req.Secret = secret
+
+	webhook, err := s.repo.CreateWebhook(ctx, req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, "Failed to create webhook")
+		return nil, status.Error(codes.Internal, "failed to create webhook")
+	}
+
+	span.SetAttributes(attribute.String("webhook.id", webhook.Id))
+
+	// The secret is only ever shown once
+	webhook.Secret = secret
+	return webhook, nil
+}
+
+func (s *WebhookServer) GetWebhook(ctx context.Context, req *taskpb.GetWebhookRequest) (*taskpb.Webhook, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookServer.GetWebhook")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("webhook.id", req.Id))
+
+	webhook, err := s.repo.GetWebhook(ctx, req.Id)
+	if err != nil {
+		return nil, webhookError(span, err, "get")
+	}
+
+	return webhook, nil
+}
+
+func (s *WebhookServer) ListWebhooks(ctx context.Context, req *taskpb.ListWebhooksRequest) (*taskpb.ListWebhooksResponse, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookServer.ListWebhooks")
+	defer span.End()
+
+	webhooks, err := s.repo.ListWebhooks(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, "Failed to list webhooks")
+		return nil, status.Error(codes.Internal, "failed to list webhooks")
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(webhooks)))
+
+	return &taskpb.ListWebhooksResponse{Webhooks: webhooks}, nil
+}
+
+func (s *WebhookServer) UpdateWebhook(ctx context.Context, req *taskpb.UpdateWebhookRequest) (*taskpb.Webhook, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookServer.UpdateWebhook")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("webhook.id", req.Id))
+
+	if err := s.checkURL(ctx, span, req.Url); err != nil {
+		return nil, err
+	}
+
+	webhook, err := s.repo.UpdateWebhook(ctx, req)
+	if err != nil {
+		return nil, webhookError(span, err, "update")
+	}
+
+	return webhook, nil
+}
+
+func (s *WebhookServer) DeleteWebhook(ctx context.Context, req *taskpb.DeleteWebhookRequest) (*emptypb.Empty, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookServer.DeleteWebhook")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("webhook.id", req.Id))
+
+	if err := s.repo.DeleteWebhook(ctx, req.Id); err != nil {
+		return nil, webhookError(span, err, "delete")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *WebhookServer) ListDeadLetters(ctx context.Context, req *taskpb.ListDeadLettersRequest) (*taskpb.ListDeadLettersResponse, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookServer.ListDeadLetters")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("webhook.id", req.WebhookId))
+
+	// An unknown webhook is a 404 rather than an empty list
+	if _, err := s.repo.GetWebhook(ctx, req.WebhookId); err != nil {
+		return nil, webhookError(span, err, "get")
+	}
+
+	letters, err := s.repo.ListDeadLetters(ctx, req.WebhookId)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, "Failed to list dead letters")
+		return nil, status.Error(codes.Internal, "failed to list dead letters")
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(letters)))
+
+	return &taskpb.ListDeadLettersResponse{DeadLetters: letters}, nil
+}
+
+// checkURL rejects a webhook URL whose host is, or resolves to, an address
+// webhooks may not call.
+func (s *WebhookServer) checkURL(ctx context.Context, span trace.Span, url string) error {
+	err := s.guard.CheckURL(ctx, url)
+	if err == nil {
+		return nil
+	}
+	span.RecordError(err)
+	span.SetStatus(otelcodes.Error, "Forbidden webhook URL")
+	if errors.Is(err, webhooks.ErrForbiddenAddress) {
+		return status.Error(codes.InvalidArgument, "url must point to a public address")
+	}
+	return status.Error(codes.InvalidArgument, "url host could not be resolved")
+}
+
+// webhookError maps a repository error to a gRPC status.
+func webhookError(span trace.Span, err error, action string) error {
+	span.RecordError(err)
+	if errors.Is(err, storage.ErrWebhookNotFound) {
+		span.SetStatus(otelcodes.Error, "Webhook not found")
+		return status.Error(codes.NotFound, "webhook not found")
+	}
+	span.SetStatus(otelcodes.Error, "Failed to "+action+" webhook")
+	return status.Error(codes.Internal, "failed to "+action+" webhook")
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/deadline"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// EventsChannel is notified whenever events get their sequence numbers.
+const EventsChannel = "task_events"
+
+// eventsLock is the advisory lock held while events are numbered, so that
+// sequence numbers become visible in the order they were assigned.
+const eventsLock = 0x7461736b // "task"
+
+const eventColumns = `sequence, tenant_id, event_type, task, traceparent, occurred_at`
+
+// traceparent returns the W3C traceparent of the span in ctx, so that
+// consumers of an event can link back to the write.
+func traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// appendEvents records an event per task in the write's transaction. The
+// events have no sequence number, and so stay invisible to readers, until
+// sequenceEvents runs after the write commits.
+func appendEvents(ctx context.Context, tx *sql.Tx, tenantID string, eventType taskpb.TaskEventType, tasks ...*taskpb.Task) error {
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO task_events (tenant_id, event_type, task, traceparent)
+		VALUES ($1, $2, $3, $4)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare event insert: %w", err)
+	}
+	defer stmt.Close()
+
+	parent := traceparent(ctx)
+	for _, task := range tasks {
+		data, err := proto.Marshal(task)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		if _, err := stmt.ExecContext(ctx, tenantID, int32(eventType), data, parent); err != nil {
+			return fmt.Errorf("failed to record event: %w", err)
+		}
+	}
+	return nil
+}
+
+// sequenceEvents numbers the events of every committed write that has none
+// yet, in the order they were recorded, continuing from the counter in
+// task_event_sequence, and notifies EventsChannel. It runs in a short
+// transaction of its own under eventsLock: numbers are assigned only to
+// committed events, one batch at a time, so a reader that has seen a number
+// never finds a smaller one later, and writes only wait for each other
+// while their events are numbered.
+func (r *TaskRepository) sequenceEvents(ctx context.Context) error {
+	tx, err := r.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin event numbering: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, eventsLock); err != nil {
+		return fmt.Errorf("failed to lock event log: %w", err)
+	}
+
+	var last int64
+	if err := tx.QueryRowContext(ctx, `SELECT last_sequence FROM task_event_sequence WHERE id = 1 FOR UPDATE`).Scan(&last); err != nil {
+		return fmt.Errorf("failed to read last event sequence: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE task_events e
+		SET sequence = pending.sequence
+		FROM (
+			SELECT id, $1::BIGINT + row_number() OVER (ORDER BY id) AS sequence
+			FROM task_events
+			WHERE sequence IS NULL
+		) pending
+		WHERE e.id = pending.id
+	`, last)
+	if err != nil {
+		return fmt.Errorf("failed to number events: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE task_event_sequence SET last_sequence = $1 WHERE id = 1`, last+n); err != nil {
+		return fmt.Errorf("failed to record last event sequence: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, '')`, EventsChannel); err != nil {
+		return fmt.Errorf("failed to notify event listeners: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit event numbering: %w", err)
+	}
+	return nil
+}
+
+// publishEvents numbers the events of a write that has just committed. The
+// write stands even if that fails; the hub numbers them on its next read.
+func (r *TaskRepository) publishEvents(ctx context.Context) {
+	if err := r.sequenceEvents(context.WithoutCancel(ctx)); err != nil {
+		slog.ErrorContext(ctx, "Failed to number task events", "error", err)
+	}
+}
+
+func scanEvent(row interface{ Scan(...interface{}) error }) (TaskEvent, error) {
+	var e TaskEvent
+	var sequence int64
+	var eventType int32
+	var data []byte
+	var traceparent string
+	var occurredAt time.Time
+
+	if err := row.Scan(&sequence, &e.TenantID, &eventType, &data, &traceparent, &occurredAt); err != nil {
+		return TaskEvent{}, err
+	}
+
+	var task taskpb.Task
+	if err := proto.Unmarshal(data, &task); err != nil {
+		return TaskEvent{}, fmt.Errorf("failed to decode event %d: %w", sequence, err)
+	}
+
+	e.Event = &taskpb.TaskEvent{
+		Sequence:    uint64(sequence),
+		Type:        taskpb.TaskEventType(eventType),
+		Task:        &task,
+		Traceparent: traceparent,
+		OccurredAt:  timestamppb.New(occurredAt),
+	}
+	return e, nil
+}
+
+func (r *TaskRepository) queryEvents(ctx context.Context, query string, args ...interface{}) ([]TaskEvent, error) {
+	rows, err := r.db.DB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []TaskEvent
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// LastEventSequence reads the counter events are numbered from, which
+// unlike the log itself is not emptied by PruneEvents.
+func (r *TaskRepository) LastEventSequence(ctx context.Context) (uint64, error) {
+	var sequence int64
+	err := r.db.DB().QueryRowContext(ctx, `SELECT last_sequence FROM task_event_sequence WHERE id = 1`).Scan(&sequence)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read last event sequence: %w", err)
+	}
+	return uint64(sequence), nil
+}
+
+// EventsAfter is polled by every replica's hub, so unlike the tenant's
+// calls it records no span. It first numbers the events of writers that
+// stopped between committing and numbering them.
+func (r *TaskRepository) EventsAfter(ctx context.Context, after uint64, limit int) ([]TaskEvent, error) {
+	if err := r.sequenceEvents(ctx); err != nil {
+		return nil, err
+	}
+	return r.queryEvents(ctx, `
+		SELECT `+eventColumns+`
+		FROM task_events
+		WHERE sequence > $1
+		ORDER BY sequence
+		LIMIT $2
+	`, int64(after), limit)
+}
+
+func (r *TaskRepository) TenantEventsAfter(ctx context.Context, after uint64, limit int) ([]*taskpb.TaskEvent, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "TaskRepository.TenantEventsAfter")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("event.after_sequence", int64(after)))
+
+	events, err := r.queryEvents(ctx, `
+		SELECT `+eventColumns+`
+		FROM task_events
+		WHERE tenant_id = $1 AND sequence > $2
+		ORDER BY sequence
+		LIMIT $3
+	`, tenantID, int64(after), limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to read events")
+		return nil, err
+	}
+
+	out := make([]*taskpb.TaskEvent, len(events))
+	for i, e := range events {
+		out[i] = e.Event
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(out)))
+
+	return out, nil
+}
+
+// PruneEvents keeps events the webhook relay has not queued yet, however
+// old they are.
+func (r *TaskRepository) PruneEvents(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.DB().ExecContext(ctx, `
+		DELETE FROM task_events
+		WHERE occurred_at < $1
+			AND sequence <= (SELECT last_sequence FROM webhook_relay WHERE id = 1)
+	`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+	return result.RowsAffected()
+}
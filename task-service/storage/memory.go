@@ -0,0 +1,493 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/deadline"
+	"github.com/bonyuta0204/otel-lab/internal/pagetoken"
+	"github.com/bonyuta0204/otel-lab/internal/search"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MemoryTaskStore is a TaskStore in process memory, for running
+// task-service without a database. Tasks do not survive a restart.
+//
+// It mirrors what TaskRepository gets from Postgres: random UUIDs,
+// timestamps with microsecond precision, one timestamp for a whole import
+// and UUIDs ordered as strings. Tasks are copied on the way in and out, so
+// callers never share them with the store.
+type MemoryTaskStore struct {
+	mu       sync.RWMutex
+	tasks    map[string]map[string]*taskpb.Task // tenant -> id -> task
+	events   []TaskEvent                        // in sequence order
+	sequence uint64
+}
+
+func NewMemoryTaskStore() *MemoryTaskStore {
+	return &MemoryTaskStore{
+		tasks: make(map[string]map[string]*taskpb.Task),
+	}
+}
+
+// now is the current time at the precision Postgres stores.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func (s *MemoryTaskStore) CreateTask(ctx context.Context, req *taskpb.CreateTaskRequest) (*taskpb.Task, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "MemoryTaskStore.CreateTask")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.String("task.title", req.Title),
+		attribute.String("task.assignee_id", req.AssigneeId),
+	)
+
+	at := now()
+	task := &taskpb.Task{
+		Id:          uuid.NewString(),
+		Title:       req.Title,
+		Description: req.Description,
+		Status:      taskpb.TaskStatus_TODO,
+		AssigneeId:  req.AssigneeId,
+		CreatedAt:   timestamppb.New(at),
+		UpdatedAt:   timestamppb.New(at),
+	}
+
+	s.mu.Lock()
+	s.partitionLocked(tenantID, true)[task.Id] = task
+	s.appendEventsLocked(ctx, tenantID, taskpb.TaskEventType_CREATED, at, task)
+	s.mu.Unlock()
+
+	span.SetAttributes(attribute.String("task.id", task.Id))
+
+	return clone(task), nil
+}
+
+func (s *MemoryTaskStore) GetTask(ctx context.Context, id string) (*taskpb.Task, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "MemoryTaskStore.GetTask")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("task.id", id))
+
+	s.mu.RLock()
+	task, ok := s.partitionLocked(tenantID, false)[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		span.SetStatus(codes.Error, "Task not found")
+		return nil, ErrTaskNotFound
+	}
+
+	return clone(task), nil
+}
+
+func (s *MemoryTaskStore) ListTasks(ctx context.Context, req *taskpb.ListTasksRequest, after *pagetoken.Cursor) (*TaskPage, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "MemoryTaskStore.ListTasks")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("page.size", int(req.PageSize)),
+		attribute.Bool("page.after_cursor", after != nil),
+		attribute.String("filter.assignee_id", req.AssigneeId),
+	)
+	if req.Status != nil {
+		span.SetAttributes(attribute.Int("filter.status", int(*req.Status)))
+	}
+
+	tasks := s.collect(tenantID, func(task *taskpb.Task) bool {
+		if req.AssigneeId != "" && task.AssigneeId != req.AssigneeId {
+			return false
+		}
+		return req.Status == nil || task.Status == *req.Status
+	})
+	sort.Slice(tasks, func(i, j int) bool { return newerThan(tasks[i], tasks[j]) })
+
+	page := &TaskPage{}
+	if req.IncludeTotalCount {
+		totalCount := int32(len(tasks))
+		page.TotalCount = &totalCount
+	}
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(tasks), func(i int) bool {
+			return after.Before(tasks[i].CreatedAt.AsTime(), tasks[i].Id)
+		})
+	}
+
+	end := start + int(req.PageSize)
+	if end < len(tasks) {
+		last := tasks[end-1]
+		page.Next = &pagetoken.Cursor{CreatedAt: last.CreatedAt.AsTime(), ID: last.Id}
+	} else {
+		end = len(tasks)
+	}
+	page.Tasks = tasks[start:end]
+
+	span.SetAttributes(
+		attribute.Int("result.count", len(page.Tasks)),
+		attribute.Bool("page.has_next", page.Next != nil),
+	)
+
+	return page, nil
+}
+
+func (s *MemoryTaskStore) UpdateTask(ctx context.Context, req *taskpb.UpdateTaskRequest) (*taskpb.Task, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "MemoryTaskStore.UpdateTask")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("task.id", req.Id))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.partitionLocked(tenantID, false)[req.Id]
+	if !ok {
+		span.SetStatus(codes.Error, "Task not found")
+		return nil, ErrTaskNotFound
+	}
+
+	task.Title = req.Title
+	task.Description = req.Description
+	task.Status = req.Status
+	task.AssigneeId = req.AssigneeId
+	at := now()
+	task.UpdatedAt = timestamppb.New(at)
+	s.appendEventsLocked(ctx, tenantID, taskpb.TaskEventType_UPDATED, at, task)
+
+	return clone(task), nil
+}
+
+func (s *MemoryTaskStore) DeleteTask(ctx context.Context, id string) (*taskpb.Task, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "MemoryTaskStore.DeleteTask")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("task.id", id))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	partition := s.partitionLocked(tenantID, false)
+	task, ok := partition[id]
+	if !ok {
+		span.SetStatus(codes.Error, "Task not found")
+		return nil, ErrTaskNotFound
+	}
+	delete(partition, id)
+	s.appendEventsLocked(ctx, tenantID, taskpb.TaskEventType_DELETED, now(), task)
+
+	return task, nil
+}
+
+// ExportTasks takes a snapshot of the matching tasks and calls fn without
+// holding the lock, so a slow consumer does not block writers.
+func (s *MemoryTaskStore) ExportTasks(ctx context.Context, req *taskpb.ExportTasksRequest, fn func(*taskpb.Task) error) (int, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "MemoryTaskStore.ExportTasks")
+	defer span.End()
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return 0, err
+	}
+
+	span.SetAttributes(attribute.String("filter.assignee_id", req.AssigneeId))
+	if req.Status != nil {
+		span.SetAttributes(attribute.Int("filter.status", int(*req.Status)))
+	}
+
+	tasks := s.collect(tenantID, func(task *taskpb.Task) bool {
+		if req.AssigneeId != "" && task.AssigneeId != req.AssigneeId {
+			return false
+		}
+		return req.Status == nil || task.Status == *req.Status
+	})
+	sort.Slice(tasks, func(i, j int) bool { return newerThan(tasks[j], tasks[i]) })
+
+	exported := 0
+	for _, task := range tasks {
+		if err := ctx.Err(); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Export aborted")
+			return exported, err
+		}
+		if err := fn(task); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Export aborted")
+			return exported, err
+		}
+		exported++
+	}
+
+	span.SetAttributes(attribute.Int("result.count", exported))
+
+	return exported, nil
+}
+
+func (s *MemoryTaskStore) ImportTasks(ctx context.Context, rows []*taskpb.ImportTask) ([]*taskpb.Task, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "MemoryTaskStore.ImportTasks")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("import.batch_size", len(rows)))
+
+	// Like NOW() in the import's transaction
+	at := now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	partition := s.partitionLocked(tenantID, true)
+	tasks := make([]*taskpb.Task, 0, len(rows))
+	stored := make([]*taskpb.Task, 0, len(rows))
+	for _, row := range rows {
+		task := &taskpb.Task{
+			Id:          uuid.NewString(),
+			Title:       row.Title,
+			Description: row.Description,
+			Status:      row.Status,
+			AssigneeId:  row.AssigneeId,
+			CreatedAt:   timestamppb.New(at),
+			UpdatedAt:   timestamppb.New(at),
+		}
+		partition[task.Id] = task
+		stored = append(stored, task)
+		tasks = append(tasks, clone(task))
+	}
+	s.appendEventsLocked(ctx, tenantID, taskpb.TaskEventType_CREATED, at, stored...)
+
+	return tasks, nil
+}
+
+func (s *MemoryTaskStore) SearchTasks(ctx context.Context, terms []string, limit int) ([]search.Hit[*taskpb.Task], error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "MemoryTaskStore.SearchTasks")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("search.terms", len(terms)),
+		attribute.Int("search.limit", limit),
+	)
+
+	// The same candidates the ILIKE query selects
+	tasks := s.collect(tenantID, func(task *taskpb.Task) bool {
+		title, description := strings.ToLower(task.Title), strings.ToLower(task.Description)
+		for _, term := range terms {
+			if !strings.Contains(title, term) && !strings.Contains(description, term) {
+				return false
+			}
+		}
+		return true
+	})
+	sort.Slice(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if !a.UpdatedAt.AsTime().Equal(b.UpdatedAt.AsTime()) {
+			return a.UpdatedAt.AsTime().After(b.UpdatedAt.AsTime())
+		}
+		return a.Id > b.Id
+	})
+	if len(tasks) > searchCandidates {
+		tasks = tasks[:searchCandidates]
+	}
+
+	var hits []search.Hit[*taskpb.Task]
+	for _, task := range tasks {
+		score := search.Score(terms,
+			search.Field{Text: task.Title, Weight: 1},
+			search.Field{Text: task.Description, Weight: 0.5},
+		)
+		if score > 0 {
+			hits = append(hits, search.Hit[*taskpb.Task]{Item: task, Score: score})
+		}
+	}
+
+	span.SetAttributes(attribute.Int("search.candidates", len(hits)))
+	hits = search.Rank(hits, limit)
+	span.SetAttributes(attribute.Int("result.count", len(hits)))
+
+	return hits, nil
+}
+
+func (s *MemoryTaskStore) LastEventSequence(ctx context.Context) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sequence, nil
+}
+
+func (s *MemoryTaskStore) EventsAfter(ctx context.Context, after uint64, limit int) ([]TaskEvent, error) {
+	return s.eventsAfter(after, limit, func(TaskEvent) bool { return true }), nil
+}
+
+func (s *MemoryTaskStore) TenantEventsAfter(ctx context.Context, after uint64, limit int) ([]*taskpb.TaskEvent, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "MemoryTaskStore.TenantEventsAfter")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("event.after_sequence", int64(after)))
+
+	events := s.eventsAfter(after, limit, func(e TaskEvent) bool { return e.TenantID == tenantID })
+	out := make([]*taskpb.TaskEvent, len(events))
+	for i, e := range events {
+		out[i] = e.Event
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(out)))
+
+	return out, nil
+}
+
+func (s *MemoryTaskStore) PruneEvents(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := sort.Search(len(s.events), func(i int) bool {
+		return !s.events[i].Event.OccurredAt.AsTime().Before(before)
+	})
+	s.events = append([]TaskEvent(nil), s.events[n:]...)
+	return int64(n), nil
+}
+
+// appendEventsLocked records an event per task. Holding the write lock
+// while drawing sequence numbers orders them like commits in Postgres.
+func (s *MemoryTaskStore) appendEventsLocked(ctx context.Context, tenantID string, eventType taskpb.TaskEventType, at time.Time, tasks ...*taskpb.Task) {
+	parent := traceparent(ctx)
+	for _, task := range tasks {
+		s.sequence++
+		s.events = append(s.events, TaskEvent{
+			TenantID: tenantID,
+			Event: &taskpb.TaskEvent{
+				Sequence:    s.sequence,
+				Type:        eventType,
+				Task:        clone(task),
+				Traceparent: parent,
+				OccurredAt:  timestamppb.New(at),
+			},
+		})
+	}
+}
+
+// eventsAfter returns copies of up to limit matching events after the
+// sequence.
+func (s *MemoryTaskStore) eventsAfter(after uint64, limit int, match func(TaskEvent) bool) []TaskEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := sort.Search(len(s.events), func(i int) bool { return s.events[i].Event.Sequence > after })
+	var events []TaskEvent
+	for _, e := range s.events[start:] {
+		if len(events) == limit {
+			break
+		}
+		if match(e) {
+			events = append(events, TaskEvent{TenantID: e.TenantID, Event: proto.Clone(e.Event).(*taskpb.TaskEvent)})
+		}
+	}
+	return events
+}
+
+// collect returns copies of the tenant's tasks that match.
+func (s *MemoryTaskStore) collect(tenantID string, match func(*taskpb.Task) bool) []*taskpb.Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tasks []*taskpb.Task
+	for _, task := range s.partitionLocked(tenantID, false) {
+		if match(task) {
+			tasks = append(tasks, clone(task))
+		}
+	}
+	return tasks
+}
+
+// partitionLocked returns the tenant's tasks, creating the map if asked to.
+// A missing partition without create is nil, which reads as empty.
+func (s *MemoryTaskStore) partitionLocked(tenantID string, create bool) map[string]*taskpb.Task {
+	partition, ok := s.tasks[tenantID]
+	if !ok && create {
+		partition = make(map[string]*taskpb.Task)
+		s.tasks[tenantID] = partition
+	}
+	return partition
+}
+
+// newerThan orders tasks newest first by (created_at, id).
+func newerThan(a, b *taskpb.Task) bool {
+	return (pagetoken.Cursor{CreatedAt: a.CreatedAt.AsTime(), ID: a.Id}).Before(b.CreatedAt.AsTime(), b.Id)
+}
+
+func clone(task *taskpb.Task) *taskpb.Task {
+	return proto.Clone(task).(*taskpb.Task)
+}
@@ -1,46 +1,43 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/XSAM/otelsql"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresDB struct {
-	db *sql.DB
+	db  *sql.DB
+	dsn string
 }
 
-func NewPostgresDB() (*PostgresDB, error) {
-	host := os.Getenv("DB_HOST")
-	if host == "" {
-		host = "localhost"
-	}
-
-	port := os.Getenv("DB_PORT")
-	if port == "" {
-		port = "5432"
-	}
-
-	user := os.Getenv("DB_USER")
-	if user == "" {
-		user = "otellab"
-	}
-
-	password := os.Getenv("DB_PASSWORD")
-	if password == "" {
-		password = "otellab123"
-	}
+type PostgresConfig struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"-"`
+	Name     string `json:"name"`
+}
 
-	dbname := os.Getenv("DB_NAME")
-	if dbname == "" {
-		dbname = "taskdb"
+// PostgresConfigFromEnv reads the DB_* environment variables.
+func PostgresConfigFromEnv() PostgresConfig {
+	return PostgresConfig{
+		Host:     getenv("DB_HOST", "localhost"),
+		Port:     getenv("DB_PORT", "5432"),
+		User:     getenv("DB_USER", "otellab"),
+		Password: getenv("DB_PASSWORD", "otellab123"),
+		Name:     getenv("DB_NAME", "taskdb"),
 	}
+}
 
+func NewPostgresDB(cfg PostgresConfig) (*PostgresDB, error) {
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name)
 
 	db, err := otelsql.Open("postgres", dsn)
 	if err != nil {
@@ -51,7 +48,7 @@ func NewPostgresDB() (*PostgresDB, error) {
 		return nil, err
 	}
 
-	return &PostgresDB{db: db}, nil
+	return &PostgresDB{db: db, dsn: dsn}, nil
 }
 
 func (p *PostgresDB) Close() error {
@@ -74,6 +71,11 @@ func (p *PostgresDB) Migrate() error {
 	CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
 	CREATE INDEX IF NOT EXISTS idx_tasks_created_at ON tasks(created_at);
 
+	-- Tasks that predate multi-tenancy belong to the default tenant
+	ALTER TABLE tasks ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+	-- Serves the (created_at, id) keyset of ListTasks
+	CREATE INDEX IF NOT EXISTS idx_tasks_tenant_created_at_id ON tasks(tenant_id, created_at, id);
+
 	-- Function to automatically update updated_at column
 	CREATE OR REPLACE FUNCTION update_updated_at_column()
 	RETURNS TRIGGER AS $$
@@ -89,12 +91,131 @@ func (p *PostgresDB) Migrate() error {
 		BEFORE UPDATE ON tasks
 		FOR EACH ROW
 		EXECUTE FUNCTION update_updated_at_column();
+
+	-- Webhook subscriptions. An empty event_types or assignee_id matches all.
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id VARCHAR(63) NOT NULL,
+		url TEXT NOT NULL,
+		event_types INTEGER[] NOT NULL DEFAULT '{}',
+		assignee_id VARCHAR(255) NOT NULL DEFAULT '',
+		secret VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhooks_tenant_id ON webhooks(tenant_id);
+
+	DROP TRIGGER IF EXISTS update_webhooks_updated_at ON webhooks;
+	CREATE TRIGGER update_webhooks_updated_at
+		BEFORE UPDATE ON webhooks
+		FOR EACH ROW
+		EXECUTE FUNCTION update_updated_at_column();
+
+	-- Delivery queue. Deliveries that run out of attempts stay as dead
+	-- letters until their webhook is deleted.
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		tenant_id VARCHAR(63) NOT NULL,
+		event_type INTEGER NOT NULL,
+		task_id UUID NOT NULL,
+		payload BYTEA NOT NULL,
+		traceparent VARCHAR(55) NOT NULL DEFAULT '',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		dead BOOLEAN NOT NULL DEFAULT FALSE,
+		next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		failed_at TIMESTAMP WITH TIME ZONE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE NOT dead;
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_dead ON webhook_deliveries(webhook_id, failed_at) WHERE dead;
+
+	-- Task changes in commit order, shared by every replica. WatchTasks
+	-- streams and resumes from it. Writes record their events without a
+	-- sequence, which is assigned once they have committed.
+	CREATE TABLE IF NOT EXISTS task_events (
+		id BIGSERIAL PRIMARY KEY,
+		sequence BIGINT UNIQUE,
+		tenant_id VARCHAR(63) NOT NULL,
+		event_type INTEGER NOT NULL,
+		task BYTEA NOT NULL,
+		traceparent VARCHAR(55) NOT NULL DEFAULT '',
+		occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_task_events_tenant_sequence ON task_events(tenant_id, sequence);
+	CREATE INDEX IF NOT EXISTS idx_task_events_occurred_at ON task_events(occurred_at);
+	CREATE INDEX IF NOT EXISTS idx_task_events_unsequenced ON task_events(id) WHERE sequence IS NULL;
+
+	-- How far the webhook relay has queued deliveries from task_events. It
+	-- starts at the end of the log, so events from before webhooks existed
+	-- are not delivered.
+	CREATE TABLE IF NOT EXISTS webhook_relay (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		last_sequence BIGINT NOT NULL
+	);
+	INSERT INTO webhook_relay (id, last_sequence)
+	SELECT 1, COALESCE(MAX(sequence), 0) FROM task_events
+	ON CONFLICT (id) DO NOTHING;
+
+	-- The last sequence number given to an event. Numbers come from here
+	-- rather than from task_events, which pruning can empty, so they never
+	-- go back to numbers readers have already seen.
+	CREATE TABLE IF NOT EXISTS task_event_sequence (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		last_sequence BIGINT NOT NULL
+	);
+	INSERT INTO task_event_sequence (id, last_sequence)
+	SELECT 1, GREATEST(
+		(SELECT COALESCE(MAX(sequence), 0) FROM task_events),
+		(SELECT last_sequence FROM webhook_relay WHERE id = 1)
+	)
+	ON CONFLICT (id) DO NOTHING;
 	`
 
 	_, err := p.db.Exec(query)
 	return err
 }
 
+// Ping checks that the database is reachable.
+func (p *PostgresDB) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// Listen calls fn for every notification on channel until ctx is
+// cancelled. fn is also called after the listener reconnects, since
+// notifications sent in between are lost.
+func (p *PostgresDB) Listen(ctx context.Context, channel string, fn func()) error {
+	listener := pq.NewListener(p.dsn, time.Second, time.Minute, nil)
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen on %s: %w", channel, err)
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-listener.Notify:
+				fn()
+			}
+		}
+	}()
+	return nil
+}
+
 func (p *PostgresDB) DB() *sql.DB {
 	return p.db
 }
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -6,6 +6,10 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/bonyuta0204/otel-lab/internal/deadline"
+	"github.com/bonyuta0204/otel-lab/internal/pagetoken"
+	"github.com/bonyuta0204/otel-lab/internal/search"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
 	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
 	"github.com/bonyuta0204/otel-lab/task-service/tracing"
 	"go.opentelemetry.io/otel/attribute"
@@ -13,6 +17,9 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// TaskRepository is the TaskStore backed by Postgres. It scopes every query
+// by the tenant in the context; a call without one fails instead of seeing
+// every tenant's tasks.
 type TaskRepository struct {
 	db *PostgresDB
 }
@@ -25,63 +32,84 @@ func (r *TaskRepository) CreateTask(ctx context.Context, req *taskpb.CreateTaskR
 	ctx, span := tracing.GetTracer().Start(ctx, "TaskRepository.CreateTask")
 	defer span.End()
 
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
 	span.SetAttributes(
 		attribute.String("task.title", req.Title),
 		attribute.String("task.assignee_id", req.AssigneeId),
 	)
 
+	tx, err := r.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO tasks (title, description, assignee_id)
-		VALUES ($1, $2, $3)
+		INSERT INTO tasks (tenant_id, title, description, assignee_id)
+		VALUES ($1, $2, $3, $4)
 		RETURNING id, title, description, status, assignee_id, created_at, updated_at
 	`
 
-	var task taskpb.Task
-	var createdAt, updatedAt time.Time
-	var status int32
-
-	err := r.db.DB().QueryRowContext(ctx, query, req.Title, req.Description, req.AssigneeId).Scan(
-		&task.Id,
-		&task.Title,
-		&task.Description,
-		&status,
-		&task.AssigneeId,
-		&createdAt,
-		&updatedAt,
-	)
-
+	task, err := scanTask(tx.QueryRowContext(ctx, query, tenantID, req.Title, req.Description, req.AssigneeId))
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to create task")
 		return nil, fmt.Errorf("failed to create task: %w", err)
 	}
 
-	task.Status = taskpb.TaskStatus(status)
-	task.CreatedAt = timestamppb.New(createdAt)
-	task.UpdatedAt = timestamppb.New(updatedAt)
+	if err := appendEvents(ctx, tx, tenantID, taskpb.TaskEventType_CREATED, task); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record event")
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit task")
+		return nil, fmt.Errorf("failed to commit task: %w", err)
+	}
+
+	r.publishEvents(ctx)
 
 	span.SetAttributes(attribute.String("task.id", task.Id))
 
-	return &task, nil
+	return task, nil
 }
 
 func (r *TaskRepository) GetTask(ctx context.Context, id string) (*taskpb.Task, error) {
 	ctx, span := tracing.GetTracer().Start(ctx, "TaskRepository.GetTask")
 	defer span.End()
 
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
 	span.SetAttributes(attribute.String("task.id", id))
 
 	query := `
 		SELECT id, title, description, status, assignee_id, created_at, updated_at
 		FROM tasks
-		WHERE id = $1
+		WHERE tenant_id = $1 AND id = $2
 	`
 
 	var task taskpb.Task
 	var createdAt, updatedAt time.Time
 	var status int32
 
-	err := r.db.DB().QueryRowContext(ctx, query, id).Scan(
+	err = r.db.DB().QueryRowContext(ctx, query, tenantID, id).Scan(
 		&task.Id,
 		&task.Title,
 		&task.Description,
@@ -94,7 +122,7 @@ func (r *TaskRepository) GetTask(ctx context.Context, id string) (*taskpb.Task,
 	if err != nil {
 		if err == sql.ErrNoRows {
 			span.SetStatus(codes.Error, "Task not found")
-			return nil, fmt.Errorf("task not found")
+			return nil, ErrTaskNotFound
 		}
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to get task")
@@ -108,21 +136,39 @@ func (r *TaskRepository) GetTask(ctx context.Context, id string) (*taskpb.Task,
 	return &task, nil
 }
 
-func (r *TaskRepository) ListTasks(ctx context.Context, req *taskpb.ListTasksRequest) ([]*taskpb.Task, int32, error) {
+// TaskPage is one page of ListTasks. Next is nil on the last page and
+// TotalCount unless it was requested.
+type TaskPage struct {
+	Tasks      []*taskpb.Task
+	Next       *pagetoken.Cursor
+	TotalCount *int32
+}
+
+// ListTasks returns the page of tasks after the cursor, newest first. The
+// keyset condition on (created_at, id) keeps pages stable while tasks are
+// added, and unlike OFFSET costs the same on every page.
+func (r *TaskRepository) ListTasks(ctx context.Context, req *taskpb.ListTasksRequest, after *pagetoken.Cursor) (*TaskPage, error) {
 	ctx, span := tracing.GetTracer().Start(ctx, "TaskRepository.ListTasks")
 	defer span.End()
 
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
 	span.SetAttributes(
 		attribute.Int("page.size", int(req.PageSize)),
-		attribute.Int("page.number", int(req.PageNumber)),
+		attribute.Bool("page.after_cursor", after != nil),
 		attribute.String("filter.assignee_id", req.AssigneeId),
-		attribute.Int("filter.status", int(req.Status)),
 	)
 
 	// Build WHERE clause
-	whereClause := "WHERE 1=1"
-	args := []interface{}{}
-	argCount := 0
+	whereClause := "WHERE tenant_id = $1"
+	args := []interface{}{tenantID}
+	argCount := 1
 
 	if req.AssigneeId != "" {
 		argCount++
@@ -130,160 +176,435 @@ func (r *TaskRepository) ListTasks(ctx context.Context, req *taskpb.ListTasksReq
 		args = append(args, req.AssigneeId)
 	}
 
-	if req.Status != taskpb.TaskStatus_TODO {
+	if req.Status != nil {
+		span.SetAttributes(attribute.Int("filter.status", int(*req.Status)))
 		argCount++
 		whereClause += fmt.Sprintf(" AND status = $%d", argCount)
-		args = append(args, int32(req.Status))
+		args = append(args, int32(*req.Status))
 	}
 
-	// Count query
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM tasks %s", whereClause)
-	var totalCount int32
-	err := r.db.DB().QueryRowContext(ctx, countQuery, args...).Scan(&totalCount)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "Failed to count tasks")
-		return nil, 0, fmt.Errorf("failed to count tasks: %w", err)
+	page := &TaskPage{}
+
+	// The total ignores the cursor, so it is the same on every page
+	if req.IncludeTotalCount {
+		countQuery := fmt.Sprintf("SELECT COUNT(*) FROM tasks %s", whereClause)
+		var totalCount int32
+		err = r.db.DB().QueryRowContext(ctx, countQuery, args...).Scan(&totalCount)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to count tasks")
+			return nil, fmt.Errorf("failed to count tasks: %w", err)
+		}
+		page.TotalCount = &totalCount
 	}
 
-	// Main query with pagination
-	offset := (req.PageNumber - 1) * req.PageSize
-	argCount++
-	limitClause := fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", argCount)
-	args = append(args, req.PageSize)
+	if after != nil {
+		whereClause += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", argCount+1, argCount+2)
+		args = append(args, after.CreatedAt, after.ID)
+		argCount += 2
+	}
 
+	// One extra row tells whether there is a next page
 	argCount++
-	offsetClause := fmt.Sprintf(" OFFSET $%d", argCount)
-	args = append(args, offset)
+	args = append(args, req.PageSize+1)
 
 	query := fmt.Sprintf(`
 		SELECT id, title, description, status, assignee_id, created_at, updated_at
-		FROM tasks %s%s%s
-	`, whereClause, limitClause, offsetClause)
+		FROM tasks %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d
+	`, whereClause, argCount)
 
 	rows, err := r.db.DB().QueryContext(ctx, query, args...)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to list tasks")
-		return nil, 0, fmt.Errorf("failed to list tasks: %w", err)
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
 	}
 	defer rows.Close()
 
-	var tasks []*taskpb.Task
 	for rows.Next() {
-		var task taskpb.Task
-		var createdAt, updatedAt time.Time
-		var status int32
-
-		err := rows.Scan(
-			&task.Id,
-			&task.Title,
-			&task.Description,
-			&status,
-			&task.AssigneeId,
-			&createdAt,
-			&updatedAt,
-		)
+		task, err := scanTask(rows)
 		if err != nil {
 			span.RecordError(err)
 			span.SetStatus(codes.Error, "Failed to scan task")
-			return nil, 0, fmt.Errorf("failed to scan task: %w", err)
+			return nil, fmt.Errorf("failed to scan task: %w", err)
 		}
-
-		task.Status = taskpb.TaskStatus(status)
-		task.CreatedAt = timestamppb.New(createdAt)
-		task.UpdatedAt = timestamppb.New(updatedAt)
-
-		tasks = append(tasks, &task)
+		page.Tasks = append(page.Tasks, task)
 	}
 
 	if err := rows.Err(); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Row iteration error")
-		return nil, 0, fmt.Errorf("row iteration error: %w", err)
+		return nil, fmt.Errorf("row iteration error: %w", err)
 	}
 
-	span.SetAttributes(attribute.Int("result.count", len(tasks)))
+	if len(page.Tasks) > int(req.PageSize) {
+		page.Tasks = page.Tasks[:req.PageSize]
+		last := page.Tasks[len(page.Tasks)-1]
+		page.Next = &pagetoken.Cursor{CreatedAt: last.CreatedAt.AsTime(), ID: last.Id}
+	}
 
-	return tasks, totalCount, nil
+	span.SetAttributes(
+		attribute.Int("result.count", len(page.Tasks)),
+		attribute.Bool("page.has_next", page.Next != nil),
+	)
+
+	return page, nil
 }
 
 func (r *TaskRepository) UpdateTask(ctx context.Context, req *taskpb.UpdateTaskRequest) (*taskpb.Task, error) {
 	ctx, span := tracing.GetTracer().Start(ctx, "TaskRepository.UpdateTask")
 	defer span.End()
 
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
 	span.SetAttributes(attribute.String("task.id", req.Id))
 
+	tx, err := r.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `
 		UPDATE tasks 
-		SET title = $2, description = $3, status = $4, assignee_id = $5
-		WHERE id = $1
+		SET title = $3, description = $4, status = $5, assignee_id = $6
+		WHERE tenant_id = $1 AND id = $2
 		RETURNING id, title, description, status, assignee_id, created_at, updated_at
 	`
 
-	var task taskpb.Task
-	var createdAt, updatedAt time.Time
-	var status int32
-
-	err := r.db.DB().QueryRowContext(ctx, query,
+	task, err := scanTask(tx.QueryRowContext(ctx, query,
+		tenantID,
 		req.Id,
 		req.Title,
 		req.Description,
 		int32(req.Status),
 		req.AssigneeId,
-	).Scan(
-		&task.Id,
-		&task.Title,
-		&task.Description,
-		&status,
-		&task.AssigneeId,
-		&createdAt,
-		&updatedAt,
-	)
-
+	))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			span.SetStatus(codes.Error, "Task not found")
-			return nil, fmt.Errorf("task not found")
+			return nil, ErrTaskNotFound
 		}
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to update task")
 		return nil, fmt.Errorf("failed to update task: %w", err)
 	}
 
-	task.Status = taskpb.TaskStatus(status)
-	task.CreatedAt = timestamppb.New(createdAt)
-	task.UpdatedAt = timestamppb.New(updatedAt)
+	if err := appendEvents(ctx, tx, tenantID, taskpb.TaskEventType_UPDATED, task); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record event")
+		return nil, err
+	}
 
-	return &task, nil
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit task")
+		return nil, fmt.Errorf("failed to commit task: %w", err)
+	}
+
+	r.publishEvents(ctx)
+
+	return task, nil
 }
 
-func (r *TaskRepository) DeleteTask(ctx context.Context, id string) error {
+func (r *TaskRepository) DeleteTask(ctx context.Context, id string) (*taskpb.Task, error) {
 	ctx, span := tracing.GetTracer().Start(ctx, "TaskRepository.DeleteTask")
 	defer span.End()
 
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
 	span.SetAttributes(attribute.String("task.id", id))
 
-	query := `DELETE FROM tasks WHERE id = $1`
+	tx, err := r.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	result, err := r.db.DB().ExecContext(ctx, query, id)
+	query := `
+		DELETE FROM tasks
+		WHERE tenant_id = $1 AND id = $2
+		RETURNING id, title, description, status, assignee_id, created_at, updated_at
+	`
+
+	task, err := scanTask(tx.QueryRowContext(ctx, query, tenantID, id))
 	if err != nil {
+		if err == sql.ErrNoRows {
+			span.SetStatus(codes.Error, "Task not found")
+			return nil, ErrTaskNotFound
+		}
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to delete task")
-		return fmt.Errorf("failed to delete task: %w", err)
+		return nil, fmt.Errorf("failed to delete task: %w", err)
+	}
+
+	if err := appendEvents(ctx, tx, tenantID, taskpb.TaskEventType_DELETED, task); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record event")
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit deletion")
+		return nil, fmt.Errorf("failed to commit deletion: %w", err)
+	}
+
+	r.publishEvents(ctx)
+
+	return task, nil
+}
+
+// ExportTasks calls fn for every task matching the filters, oldest first.
+// Rows are streamed from the database rather than collected, so the export
+// size is not bounded by memory; an error from fn stops the export.
+func (r *TaskRepository) ExportTasks(ctx context.Context, req *taskpb.ExportTasksRequest, fn func(*taskpb.Task) error) (int, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "TaskRepository.ExportTasks")
+	defer span.End()
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return 0, err
+	}
+
+	span.SetAttributes(attribute.String("filter.assignee_id", req.AssigneeId))
+
+	whereClause := "WHERE tenant_id = $1"
+	args := []interface{}{tenantID}
+
+	if req.AssigneeId != "" {
+		args = append(args, req.AssigneeId)
+		whereClause += fmt.Sprintf(" AND assignee_id = $%d", len(args))
+	}
+
+	if req.Status != nil {
+		span.SetAttributes(attribute.Int("filter.status", int(*req.Status)))
+		args = append(args, int32(*req.Status))
+		whereClause += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, title, description, status, assignee_id, created_at, updated_at
+		FROM tasks %s
+		ORDER BY created_at, id
+	`, whereClause)
+
+	rows, err := r.db.DB().QueryContext(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to export tasks")
+		return 0, fmt.Errorf("failed to export tasks: %w", err)
+	}
+	defer rows.Close()
+
+	exported := 0
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to scan task")
+			return exported, fmt.Errorf("failed to scan task: %w", err)
+		}
+		if err := fn(task); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Export aborted")
+			return exported, err
+		}
+		exported++
+	}
+
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Row iteration error")
+		return exported, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("result.count", exported))
+
+	return exported, nil
+}
+
+// ImportTasks inserts the tasks and their events in one transaction, so a
+// batch is either imported completely or not at all.
+func (r *TaskRepository) ImportTasks(ctx context.Context, rows []*taskpb.ImportTask) ([]*taskpb.Task, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "TaskRepository.ImportTasks")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("import.batch_size", len(rows)))
+
+	tx, err := r.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO tasks (tenant_id, title, description, status, assignee_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, title, description, status, assignee_id, created_at, updated_at
+	`)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to prepare insert")
+		return nil, fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	tasks := make([]*taskpb.Task, 0, len(rows))
+	for _, row := range rows {
+		task, err := scanTask(stmt.QueryRowContext(ctx,
+			tenantID, row.Title, row.Description, int32(row.Status), row.AssigneeId))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to insert task")
+			return nil, fmt.Errorf("failed to insert task: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	if err := appendEvents(ctx, tx, tenantID, taskpb.TaskEventType_CREATED, tasks...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record events")
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit import")
+		return nil, fmt.Errorf("failed to commit import: %w", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	r.publishEvents(ctx)
+
+	return tasks, nil
+}
+
+// searchCandidates caps how many matching tasks SearchTasks scores, most
+// recently updated first.
+const searchCandidates = 200
+
+// SearchTasks returns the tasks whose title or description contains every
+// term, best match first.
+func (r *TaskRepository) SearchTasks(ctx context.Context, terms []string, limit int) ([]search.Hit[*taskpb.Task], error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "TaskRepository.SearchTasks")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
 	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("search.terms", len(terms)),
+		attribute.Int("search.limit", limit),
+	)
+
+	// Terms are letters and digits only, so they need no LIKE escaping
+	whereClause := "WHERE tenant_id = $1"
+	args := []interface{}{tenantID}
+	for _, term := range terms {
+		args = append(args, "%"+term+"%")
+		whereClause += fmt.Sprintf(" AND (title ILIKE $%d OR description ILIKE $%d)", len(args), len(args))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, title, description, status, assignee_id, created_at, updated_at
+		FROM tasks %s
+		ORDER BY updated_at DESC, id DESC
+		LIMIT %d
+	`, whereClause, searchCandidates)
+
+	rows, err := r.db.DB().QueryContext(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to search tasks")
+		return nil, fmt.Errorf("failed to search tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []search.Hit[*taskpb.Task]
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to scan task")
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		score := search.Score(terms,
+			search.Field{Text: task.Title, Weight: 1},
+			search.Field{Text: task.Description, Weight: 0.5},
+		)
+		if score > 0 {
+			hits = append(hits, search.Hit[*taskpb.Task]{Item: task, Score: score})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
 		span.RecordError(err)
-		span.SetStatus(codes.Error, "Failed to get rows affected")
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		span.SetStatus(codes.Error, "Row iteration error")
+		return nil, fmt.Errorf("row iteration error: %w", err)
 	}
 
-	if rowsAffected == 0 {
-		span.SetStatus(codes.Error, "Task not found")
-		return fmt.Errorf("task not found")
+	span.SetAttributes(attribute.Int("search.candidates", len(hits)))
+	hits = search.Rank(hits, limit)
+	span.SetAttributes(attribute.Int("result.count", len(hits)))
+
+	return hits, nil
+}
+
+func scanTask(row interface{ Scan(...interface{}) error }) (*taskpb.Task, error) {
+	var task taskpb.Task
+	var createdAt, updatedAt time.Time
+	var status int32
+
+	err := row.Scan(
+		&task.Id,
+		&task.Title,
+		&task.Description,
+		&status,
+		&task.AssigneeId,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
-}
\ No newline at end of file
+	task.Status = taskpb.TaskStatus(status)
+	task.CreatedAt = timestamppb.New(createdAt)
+	task.UpdatedAt = timestamppb.New(updatedAt)
+
+	return &task, nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/pagetoken"
+	"github.com/bonyuta0204/otel-lab/internal/search"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+)
+
+// ErrTaskNotFound is returned for a task ID that does not exist in the
+// tenant of the context.
+var ErrTaskNotFound = errors.New("task not found")
+
+// TaskEvent is an event from the event log together with its tenant.
+type TaskEvent struct {
+	TenantID string
+	Event    *taskpb.TaskEvent
+}
+
+// TaskStore keeps the tasks of every tenant. TaskRepository stores them in
+// Postgres and MemoryTaskStore in process memory; both scope every call by
+// the tenant in the context and order and filter tasks the same way.
+//
+// Every write also appends an event per task it changed to the store's
+// event log, atomically with the write. Sequence numbers are shared by all
+// tenants and increase in commit order, so a reader that has seen one
+// never finds a smaller one later.
+type TaskStore interface {
+	CreateTask(ctx context.Context, req *taskpb.CreateTaskRequest) (*taskpb.Task, error)
+	GetTask(ctx context.Context, id string) (*taskpb.Task, error)
+	// ListTasks returns the page after the cursor, newest first by
+	// (created_at, id). An unset status matches every status.
+	ListTasks(ctx context.Context, req *taskpb.ListTasksRequest, after *pagetoken.Cursor) (*TaskPage, error)
+	// UpdateTask replaces every field of the task and bumps updated_at.
+	UpdateTask(ctx context.Context, req *taskpb.UpdateTaskRequest) (*taskpb.Task, error)
+	// DeleteTask returns the task as it was before deletion.
+	DeleteTask(ctx context.Context, id string) (*taskpb.Task, error)
+	// ExportTasks calls fn for every matching task, oldest first by
+	// (created_at, id), and returns how many it passed to fn.
+	ExportTasks(ctx context.Context, req *taskpb.ExportTasksRequest, fn func(*taskpb.Task) error) (int, error)
+	// ImportTasks creates all rows or none. They share one created_at.
+	ImportTasks(ctx context.Context, rows []*taskpb.ImportTask) ([]*taskpb.Task, error)
+	// SearchTasks scores the most recently updated tasks whose title or
+	// description contains every term and returns the best limit of them.
+	SearchTasks(ctx context.Context, terms []string, limit int) ([]search.Hit[*taskpb.Task], error)
+
+	// LastEventSequence returns the last sequence number given to an event,
+	// or zero. Pruning events does not lower it.
+	LastEventSequence(ctx context.Context) (uint64, error)
+	// EventsAfter returns up to limit events of every tenant with a
+	// sequence above after, in sequence order.
+	EventsAfter(ctx context.Context, after uint64, limit int) ([]TaskEvent, error)
+	// TenantEventsAfter is EventsAfter for the tenant in the context.
+	TenantEventsAfter(ctx context.Context, after uint64, limit int) ([]*taskpb.TaskEvent, error)
+	// PruneEvents deletes the events that occurred before the given time,
+	// except those the webhook relay has yet to queue.
+	PruneEvents(ctx context.Context, before time.Time) (int64, error)
+}
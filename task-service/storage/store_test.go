@@ -0,0 +1,626 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/pagetoken"
+	"github.com/bonyuta0204/otel-lab/internal/search"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/storage"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMemoryTaskStore(t *testing.T) {
+	testTaskStore(t, storage.NewMemoryTaskStore())
+}
+
+// TestTaskRepository runs against the database named by the DB_*
+// variables. Every test works in a tenant of its own, so the database does
+// not have to be empty.
+func TestTaskRepository(t *testing.T) {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST is not set; skipping the Postgres store")
+	}
+
+	db, err := storage.NewPostgresDB(storage.PostgresConfigFromEnv())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	testTaskStore(t, relayingStore{storage.NewTaskRepository(db), storage.NewWebhookRepository(db)})
+}
+
+// relayingStore lets the webhook relay catch up before pruning, as the
+// dispatcher does in the service, since events it has not seen are kept.
+type relayingStore struct {
+	storage.TaskStore
+	webhooks *storage.WebhookRepository
+}
+
+func (s relayingStore) PruneEvents(ctx context.Context, before time.Time) (int64, error) {
+	for {
+		n, err := s.webhooks.RelayEvents(ctx, 500, func(storage.TaskEvent) ([]byte, error) { return []byte("{}"), nil })
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return s.TaskStore.PruneEvents(ctx, before)
+		}
+	}
+}
+
+// testTaskStore is the behaviour every TaskStore has to share.
+func testTaskStore(t *testing.T, store storage.TaskStore) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, ctx context.Context, store storage.TaskStore)
+	}{
+		{"RequiresTenant", testRequiresTenant},
+		{"CreateAndGet", testCreateAndGet},
+		{"NotFound", testNotFound},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"TenantIsolation", testTenantIsolation},
+		{"ListOrderAndPages", testListOrderAndPages},
+		{"ListFilters", testListFilters},
+		{"Export", testExport},
+		{"Import", testImport},
+		{"Search", testSearch},
+		{"Events", testEvents},
+		{"PruneEvents", testPruneEvents},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newTenant(), store)
+		})
+	}
+}
+
+func newTenant() context.Context {
+	return tenant.NewContext(context.Background(), "conformance-"+uuid.NewString()[:8])
+}
+
+func testRequiresTenant(t *testing.T, _ context.Context, store storage.TaskStore) {
+	ctx := context.Background()
+
+	if _, err := store.CreateTask(ctx, &taskpb.CreateTaskRequest{Title: "a"}); err == nil {
+		t.Error("CreateTask without tenant succeeded")
+	}
+	if _, err := store.GetTask(ctx, uuid.NewString()); err == nil || errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("GetTask without tenant = %v, want a tenant error", err)
+	}
+	if _, err := store.ListTasks(ctx, &taskpb.ListTasksRequest{PageSize: 10}, nil); err == nil {
+		t.Error("ListTasks without tenant succeeded")
+	}
+}
+
+func testCreateAndGet(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	created, err := store.CreateTask(ctx, &taskpb.CreateTaskRequest{
+		Title:       "Write docs",
+		Description: "For the store",
+		AssigneeId:  "user-001",
+	})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	if _, err := uuid.Parse(created.Id); err != nil {
+		t.Errorf("ID %q is not a UUID", created.Id)
+	}
+	if created.Title != "Write docs" || created.Description != "For the store" || created.AssigneeId != "user-001" {
+		t.Errorf("CreateTask returned %v", created)
+	}
+	if created.Status != taskpb.TaskStatus_TODO {
+		t.Errorf("status = %v, want TODO", created.Status)
+	}
+	if !created.CreatedAt.AsTime().Equal(created.UpdatedAt.AsTime()) {
+		t.Errorf("created_at %v != updated_at %v", created.CreatedAt.AsTime(), created.UpdatedAt.AsTime())
+	}
+
+	got, err := store.GetTask(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	assertSameTask(t, got, created)
+
+	// Callers must not be able to change stored tasks
+	got.Title = "changed"
+	again, err := store.GetTask(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if again.Title != "Write docs" {
+		t.Errorf("stored title changed to %q through a returned task", again.Title)
+	}
+}
+
+func testNotFound(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	id := uuid.NewString()
+
+	if _, err := store.GetTask(ctx, id); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("GetTask = %v, want ErrTaskNotFound", err)
+	}
+	if _, err := store.UpdateTask(ctx, &taskpb.UpdateTaskRequest{Id: id, Title: "a"}); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("UpdateTask = %v, want ErrTaskNotFound", err)
+	}
+	if _, err := store.DeleteTask(ctx, id); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("DeleteTask = %v, want ErrTaskNotFound", err)
+	}
+}
+
+func testUpdate(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	created := mustCreate(t, ctx, store, "Old title", "user-001")
+
+	updated, err := store.UpdateTask(ctx, &taskpb.UpdateTaskRequest{
+		Id:          created.Id,
+		Title:       "New title",
+		Description: "New description",
+		Status:      taskpb.TaskStatus_IN_PROGRESS,
+		AssigneeId:  "user-002",
+	})
+	if err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	want := &taskpb.Task{
+		Id:          created.Id,
+		Title:       "New title",
+		Description: "New description",
+		Status:      taskpb.TaskStatus_IN_PROGRESS,
+		AssigneeId:  "user-002",
+		CreatedAt:   created.CreatedAt,
+		UpdatedAt:   updated.UpdatedAt,
+	}
+	assertSameTask(t, updated, want)
+	if updated.UpdatedAt.AsTime().Before(created.UpdatedAt.AsTime()) {
+		t.Errorf("updated_at went back from %v to %v", created.UpdatedAt.AsTime(), updated.UpdatedAt.AsTime())
+	}
+
+	got, err := store.GetTask(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	assertSameTask(t, got, updated)
+}
+
+func testDelete(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	created := mustCreate(t, ctx, store, "Doomed", "")
+
+	deleted, err := store.DeleteTask(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	assertSameTask(t, deleted, created)
+
+	if _, err := store.GetTask(ctx, created.Id); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("GetTask after delete = %v, want ErrTaskNotFound", err)
+	}
+	if _, err := store.DeleteTask(ctx, created.Id); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("second DeleteTask = %v, want ErrTaskNotFound", err)
+	}
+}
+
+func testTenantIsolation(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	created := mustCreate(t, ctx, store, "Private", "user-001")
+	other := newTenant()
+
+	if _, err := store.GetTask(other, created.Id); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("GetTask from another tenant = %v, want ErrTaskNotFound", err)
+	}
+	if _, err := store.UpdateTask(other, &taskpb.UpdateTaskRequest{Id: created.Id, Title: "stolen"}); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("UpdateTask from another tenant = %v, want ErrTaskNotFound", err)
+	}
+	if _, err := store.DeleteTask(other, created.Id); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("DeleteTask from another tenant = %v, want ErrTaskNotFound", err)
+	}
+
+	page, err := store.ListTasks(other, &taskpb.ListTasksRequest{PageSize: 10, IncludeTotalCount: true}, nil)
+	if err != nil {
+		t.Fatalf("ListTasks: %v", err)
+	}
+	if len(page.Tasks) != 0 || *page.TotalCount != 0 {
+		t.Errorf("another tenant lists %d tasks (total %d), want none", len(page.Tasks), *page.TotalCount)
+	}
+}
+
+func testListOrderAndPages(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	// Imported tasks share created_at, so the ID has to break the ties
+	var all []*taskpb.Task
+	imported, err := store.ImportTasks(ctx, []*taskpb.ImportTask{{Title: "i1"}, {Title: "i2"}, {Title: "i3"}})
+	if err != nil {
+		t.Fatalf("ImportTasks: %v", err)
+	}
+	all = append(all, imported...)
+	for _, title := range []string{"c1", "c2", "c3", "c4"} {
+		all = append(all, mustCreate(t, ctx, store, title, ""))
+	}
+
+	want := sortedNewestFirst(all)
+
+	var got []*taskpb.Task
+	var after *pagetoken.Cursor
+	for pages := 1; ; pages++ {
+		page, err := store.ListTasks(ctx, &taskpb.ListTasksRequest{PageSize: 2, IncludeTotalCount: pages == 1}, after)
+		if err != nil {
+			t.Fatalf("ListTasks page %d: %v", pages, err)
+		}
+		if pages == 1 {
+			if page.TotalCount == nil || *page.TotalCount != int32(len(all)) {
+				t.Errorf("total count = %v, want %d", page.TotalCount, len(all))
+			}
+		} else if page.TotalCount != nil {
+			t.Errorf("page %d has a total count without asking for it", pages)
+		}
+		got = append(got, page.Tasks...)
+
+		if page.Next == nil {
+			break
+		}
+		if len(page.Tasks) != 2 {
+			t.Fatalf("page %d has %d tasks and a next page", pages, len(page.Tasks))
+		}
+		if pages > len(all) {
+			t.Fatal("pagination does not end")
+		}
+		after = page.Next
+	}
+
+	assertIDs(t, got, want)
+}
+
+func testListFilters(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	alice := mustCreate(t, ctx, store, "Alice's", "user-001")
+	bob := mustCreate(t, ctx, store, "Bob's", "user-002")
+	done, err := store.UpdateTask(ctx, &taskpb.UpdateTaskRequest{Id: bob.Id, Title: bob.Title, Status: taskpb.TaskStatus_DONE, AssigneeId: "user-002"})
+	if err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	list := func(req *taskpb.ListTasksRequest) []*taskpb.Task {
+		t.Helper()
+		req.PageSize = 10
+		page, err := store.ListTasks(ctx, req, nil)
+		if err != nil {
+			t.Fatalf("ListTasks: %v", err)
+		}
+		return page.Tasks
+	}
+
+	assertIDs(t, list(&taskpb.ListTasksRequest{AssigneeId: "user-001"}), []*taskpb.Task{alice})
+	assertIDs(t, list(&taskpb.ListTasksRequest{Status: taskpb.TaskStatus_DONE.Enum()}), []*taskpb.Task{done})
+	assertIDs(t, list(&taskpb.ListTasksRequest{AssigneeId: "user-001", Status: taskpb.TaskStatus_DONE.Enum()}), nil)
+	assertIDs(t, list(&taskpb.ListTasksRequest{Status: taskpb.TaskStatus_TODO.Enum()}), []*taskpb.Task{alice})
+	assertIDs(t, list(&taskpb.ListTasksRequest{}), sortedNewestFirst([]*taskpb.Task{alice, done}))
+}
+
+func testExport(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	a := mustCreate(t, ctx, store, "a", "user-001")
+	b := mustCreate(t, ctx, store, "b", "user-002")
+	c := mustCreate(t, ctx, store, "c", "user-001")
+
+	export := func(req *taskpb.ExportTasksRequest) []*taskpb.Task {
+		t.Helper()
+		var tasks []*taskpb.Task
+		n, err := store.ExportTasks(ctx, req, func(task *taskpb.Task) error {
+			tasks = append(tasks, task)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ExportTasks: %v", err)
+		}
+		if n != len(tasks) {
+			t.Errorf("ExportTasks reported %d tasks, passed %d", n, len(tasks))
+		}
+		return tasks
+	}
+
+	oldestFirst := sortedNewestFirst([]*taskpb.Task{a, b, c})
+	for i, j := 0, len(oldestFirst)-1; i < j; i, j = i+1, j-1 {
+		oldestFirst[i], oldestFirst[j] = oldestFirst[j], oldestFirst[i]
+	}
+	assertIDs(t, export(&taskpb.ExportTasksRequest{}), oldestFirst)
+
+	var withUser1 []*taskpb.Task
+	for _, task := range oldestFirst {
+		if task.AssigneeId == "user-001" {
+			withUser1 = append(withUser1, task)
+		}
+	}
+	assertIDs(t, export(&taskpb.ExportTasksRequest{AssigneeId: "user-001"}), withUser1)
+
+	// Unlike ListTasks, an explicit TODO filter is a filter
+	todo := taskpb.TaskStatus_TODO
+	if _, err := store.UpdateTask(ctx, &taskpb.UpdateTaskRequest{Id: b.Id, Title: "b", Status: taskpb.TaskStatus_DONE}); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	assertIDs(t, export(&taskpb.ExportTasksRequest{Status: &todo}), withUser1)
+
+	stop := errors.New("stop")
+	n, err := store.ExportTasks(ctx, &taskpb.ExportTasksRequest{}, func(*taskpb.Task) error { return stop })
+	if !errors.Is(err, stop) || n != 0 {
+		t.Errorf("ExportTasks with failing fn = (%d, %v), want (0, stop)", n, err)
+	}
+}
+
+func testImport(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	tasks, err := store.ImportTasks(ctx, []*taskpb.ImportTask{
+		{Title: "one", Description: "first", Status: taskpb.TaskStatus_DONE, AssigneeId: "user-001"},
+		{Title: "two"},
+	})
+	if err != nil {
+		t.Fatalf("ImportTasks: %v", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("ImportTasks returned %d tasks, want 2", len(tasks))
+	}
+
+	first := tasks[0]
+	if first.Title != "one" || first.Description != "first" || first.Status != taskpb.TaskStatus_DONE || first.AssigneeId != "user-001" {
+		t.Errorf("imported %v", first)
+	}
+	if !tasks[0].CreatedAt.AsTime().Equal(tasks[1].CreatedAt.AsTime()) {
+		t.Errorf("one import has created_at %v and %v", tasks[0].CreatedAt.AsTime(), tasks[1].CreatedAt.AsTime())
+	}
+
+	for _, task := range tasks {
+		got, err := store.GetTask(ctx, task.Id)
+		if err != nil {
+			t.Fatalf("GetTask: %v", err)
+		}
+		assertSameTask(t, got, task)
+	}
+}
+
+func testSearch(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	inTitle := mustCreate(t, ctx, store, "Deploy the Gateway", "")
+	inDescription, err := store.ImportTasks(ctx, []*taskpb.ImportTask{{Title: "Rollout", Description: "gateway deploy checklist"}})
+	if err != nil {
+		t.Fatalf("ImportTasks: %v", err)
+	}
+	mustCreate(t, ctx, store, "Unrelated", "")
+
+	hits, err := store.SearchTasks(ctx, search.Terms("GATEWAY deploy"), 10)
+	if err != nil {
+		t.Fatalf("SearchTasks: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("SearchTasks found %d tasks, want 2", len(hits))
+	}
+	if hits[0].Item.Id != inTitle.Id || hits[1].Item.Id != inDescription[0].Id {
+		t.Errorf("SearchTasks ranked %s, %s; want the title match first", hits[0].Item.Title, hits[1].Item.Title)
+	}
+	if hits[0].Score <= hits[1].Score {
+		t.Errorf("scores %v, %v are not descending", hits[0].Score, hits[1].Score)
+	}
+
+	hits, err = store.SearchTasks(ctx, search.Terms("gateway"), 1)
+	if err != nil {
+		t.Fatalf("SearchTasks: %v", err)
+	}
+	if len(hits) != 1 || hits[0].Item.Id != inTitle.Id {
+		t.Errorf("SearchTasks with limit 1 = %d hits, want the title match", len(hits))
+	}
+
+	hits, err = store.SearchTasks(ctx, search.Terms("gateway missing"), 10)
+	if err != nil {
+		t.Fatalf("SearchTasks: %v", err)
+	}
+	if len(hits) != 0 {
+		t.Errorf("SearchTasks matched %d tasks without every term", len(hits))
+	}
+}
+
+func testEvents(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	start, err := store.LastEventSequence(ctx)
+	if err != nil {
+		t.Fatalf("LastEventSequence: %v", err)
+	}
+
+	created := mustCreate(t, ctx, store, "Watched", "user-001")
+	other := newTenant()
+	elsewhere := mustCreate(t, other, store, "Elsewhere", "")
+	if _, err := store.UpdateTask(ctx, &taskpb.UpdateTaskRequest{Id: created.Id, Title: "Watched", Status: taskpb.TaskStatus_DONE}); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	imported, err := store.ImportTasks(ctx, []*taskpb.ImportTask{{Title: "one"}, {Title: "two"}})
+	if err != nil {
+		t.Fatalf("ImportTasks: %v", err)
+	}
+	if _, err := store.DeleteTask(ctx, created.Id); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+
+	type event struct {
+		kind   taskpb.TaskEventType
+		taskID string
+	}
+	want := []event{
+		{taskpb.TaskEventType_CREATED, created.Id},
+		{taskpb.TaskEventType_UPDATED, created.Id},
+		{taskpb.TaskEventType_CREATED, imported[0].Id},
+		{taskpb.TaskEventType_CREATED, imported[1].Id},
+		{taskpb.TaskEventType_DELETED, created.Id},
+	}
+
+	events, err := store.TenantEventsAfter(ctx, start, 100)
+	if err != nil {
+		t.Fatalf("TenantEventsAfter: %v", err)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("TenantEventsAfter returned %d events, want %d", len(events), len(want))
+	}
+	last := start
+	for i, e := range events {
+		if got := (event{e.Type, e.Task.Id}); got != want[i] {
+			t.Errorf("event %d = %v, want %v", i, got, want[i])
+		}
+		if e.Sequence <= last {
+			t.Errorf("event %d has sequence %d after %d", i, e.Sequence, last)
+		}
+		last = e.Sequence
+	}
+	if events[1].Task.Status != taskpb.TaskStatus_DONE {
+		t.Errorf("update event carries status %v, want DONE", events[1].Task.Status)
+	}
+
+	// Reading on from the middle returns the rest
+	rest, err := store.TenantEventsAfter(ctx, events[2].Sequence, 100)
+	if err != nil {
+		t.Fatalf("TenantEventsAfter: %v", err)
+	}
+	if len(rest) != 2 || rest[0].Sequence != events[3].Sequence {
+		t.Errorf("TenantEventsAfter from the middle returned %d events", len(rest))
+	}
+
+	// The unscoped log has every tenant's events in sequence order
+	all, err := store.EventsAfter(context.Background(), start, 100)
+	if err != nil {
+		t.Fatalf("EventsAfter: %v", err)
+	}
+	tenants := map[string]int{}
+	last = start
+	for _, e := range all {
+		if e.Event.Sequence <= last {
+			t.Errorf("EventsAfter has sequence %d after %d", e.Event.Sequence, last)
+		}
+		last = e.Event.Sequence
+		if e.Event.Task.Id == elsewhere.Id {
+			otherID := tenant.FromContext(other)
+			if e.TenantID != otherID {
+				t.Errorf("event for %s recorded for tenant %q, want %q", elsewhere.Id, e.TenantID, otherID)
+			}
+		}
+		tenants[e.TenantID]++
+	}
+	ownID := tenant.FromContext(ctx)
+	if tenants[ownID] != len(want) {
+		t.Errorf("EventsAfter has %d of the tenant's events, want %d", tenants[ownID], len(want))
+	}
+
+	limited, err := store.EventsAfter(context.Background(), start, 2)
+	if err != nil {
+		t.Fatalf("EventsAfter: %v", err)
+	}
+	if len(limited) != 2 {
+		t.Errorf("EventsAfter with limit 2 returned %d events", len(limited))
+	}
+}
+
+func testPruneEvents(t *testing.T, ctx context.Context, store storage.TaskStore) {
+	start, err := store.LastEventSequence(ctx)
+	if err != nil {
+		t.Fatalf("LastEventSequence: %v", err)
+	}
+	mustCreate(t, ctx, store, "Short-lived", "")
+
+	events, err := store.TenantEventsAfter(ctx, start, 10)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("TenantEventsAfter = %d events, %v; want 1", len(events), err)
+	}
+
+	if _, err := store.PruneEvents(ctx, events[0].OccurredAt.AsTime()); err != nil {
+		t.Fatalf("PruneEvents: %v", err)
+	}
+	if kept, err := store.TenantEventsAfter(ctx, start, 10); err != nil || len(kept) != 1 {
+		t.Errorf("PruneEvents removed an event that was not older than the cutoff")
+	}
+
+	n, err := store.PruneEvents(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("PruneEvents: %v", err)
+	}
+	if n < 1 {
+		t.Errorf("PruneEvents removed %d events, want at least 1", n)
+	}
+	if left, err := store.TenantEventsAfter(ctx, start, 10); err != nil || len(left) != 0 {
+		t.Errorf("TenantEventsAfter after pruning = %d events, %v; want none", len(left), err)
+	}
+
+	// Numbering carries on past the pruned events, or watchers and the
+	// webhook relay, which are already past them, would skip the new ones
+	last, err := store.LastEventSequence(ctx)
+	if err != nil {
+		t.Fatalf("LastEventSequence: %v", err)
+	}
+	if last < events[0].Sequence {
+		t.Errorf("LastEventSequence after pruning = %d, want at least %d", last, events[0].Sequence)
+	}
+	mustCreate(t, ctx, store, "After pruning", "")
+	next, err := store.TenantEventsAfter(ctx, last, 10)
+	if err != nil || len(next) != 1 {
+		t.Fatalf("TenantEventsAfter(%d) after pruning = %d events, %v; want 1", last, len(next), err)
+	}
+	if next[0].Sequence <= last {
+		t.Errorf("event after pruning has sequence %d, want more than %d", next[0].Sequence, last)
+	}
+	if got, err := store.LastEventSequence(ctx); err != nil || got != next[0].Sequence {
+		t.Errorf("LastEventSequence = %d, %v; want %d", got, err, next[0].Sequence)
+	}
+}
+
+func mustCreate(t *testing.T, ctx context.Context, store storage.TaskStore, title, assigneeID string) *taskpb.Task {
+	t.Helper()
+	task, err := store.CreateTask(ctx, &taskpb.CreateTaskRequest{Title: title, AssigneeId: assigneeID})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	return task
+}
+
+func sortedNewestFirst(tasks []*taskpb.Task) []*taskpb.Task {
+	sorted := append([]*taskpb.Task(nil), tasks...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if !a.CreatedAt.AsTime().Equal(b.CreatedAt.AsTime()) {
+			return a.CreatedAt.AsTime().After(b.CreatedAt.AsTime())
+		}
+		return a.Id > b.Id
+	})
+	return sorted
+}
+
+func assertIDs(t *testing.T, got, want []*taskpb.Task) {
+	t.Helper()
+	ids := func(tasks []*taskpb.Task) []string {
+		out := make([]string, len(tasks))
+		for i, task := range tasks {
+			out[i] = task.Id
+		}
+		return out
+	}
+	g, w := ids(got), ids(want)
+	if len(g) != len(w) {
+		t.Fatalf("got tasks %v, want %v", g, w)
+	}
+	for i := range g {
+		if g[i] != w[i] {
+			t.Fatalf("got tasks %v, want %v", g, w)
+		}
+	}
+}
+
+// assertSameTask compares timestamps as instants, since Postgres returns
+// them in the session's time zone.
+func assertSameTask(t *testing.T, got, want *taskpb.Task) {
+	t.Helper()
+	g, w := proto.Clone(got).(*taskpb.Task), proto.Clone(want).(*taskpb.Task)
+	if !g.CreatedAt.AsTime().Equal(w.CreatedAt.AsTime()) || !g.UpdatedAt.AsTime().Equal(w.UpdatedAt.AsTime()) {
+		t.Errorf("timestamps %v/%v, want %v/%v", g.CreatedAt.AsTime(), g.UpdatedAt.AsTime(), w.CreatedAt.AsTime(), w.UpdatedAt.AsTime())
+	}
+	g.CreatedAt, g.UpdatedAt, w.CreatedAt, w.UpdatedAt = nil, nil, nil, nil
+	if !proto.Equal(g, w) {
+		t.Errorf("got task %v, want %v", g, w)
+	}
+}
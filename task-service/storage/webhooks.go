@@ -0,0 +1,476 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/deadline"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ErrWebhookNotFound is returned for a webhook ID that does not exist in the
+// tenant of the context.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// maxDeadLetters bounds how many dead letters ListDeadLetters returns.
+const maxDeadLetters = 100
+
+// WebhookRepository stores webhook subscriptions and their delivery queue.
+// Subscriptions are scoped by the tenant in the context like tasks; the
+// delivery queue is worked on for all tenants at once.
+type WebhookRepository struct {
+	db *PostgresDB
+}
+
+func NewWebhookRepository(db *PostgresDB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+// Delivery is a queued webhook call together with where it goes.
+type Delivery struct {
+	ID          string
+	WebhookID   string
+	TenantID    string
+	EventType   taskpb.TaskEventType
+	TaskID      string
+	Payload     []byte
+	Traceparent string
+	// Attempts counts the current one.
+	Attempts int
+	URL      string
+	Secret   string
+}
+
+const webhookColumns = `id, url, event_types, assignee_id, created_at, updated_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*taskpb.Webhook, error) {
+	var webhook taskpb.Webhook
+	var eventTypes []int64
+	var createdAt, updatedAt time.Time
+
+	err := row.Scan(&webhook.Id, &webhook.Url, pq.Array(&eventTypes), &webhook.AssigneeId, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range eventTypes {
+		webhook.EventTypes = append(webhook.EventTypes, taskpb.TaskEventType(t))
+	}
+	webhook.CreatedAt = timestamppb.New(createdAt)
+	webhook.UpdatedAt = timestamppb.New(updatedAt)
+	return &webhook, nil
+}
+
+func eventTypeArray(types []taskpb.TaskEventType) interface{} {
+	out := make([]int64, len(types))
+	for i, t := range types {
+		out[i] = int64(t)
+	}
+	return pq.Array(out)
+}
+
+func (r *WebhookRepository) CreateWebhook(ctx context.Context, req *taskpb.CreateWebhookRequest) (*taskpb.Webhook, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookRepository.CreateWebhook")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO webhooks (tenant_id, url, event_types, assignee_id, secret)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + webhookColumns
+
+	webhook, err := scanWebhook(r.db.DB().QueryRowContext(ctx, query,
+		tenantID, req.Url, eventTypeArray(req.EventTypes), req.AssigneeId, req.Secret))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to create webhook")
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	span.SetAttributes(attribute.String("webhook.id", webhook.Id))
+
+	return webhook, nil
+}
+
+func (r *WebhookRepository) GetWebhook(ctx context.Context, id string) (*taskpb.Webhook, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookRepository.GetWebhook")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("webhook.id", id))
+
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE tenant_id = $1 AND id = $2`
+
+	webhook, err := scanWebhook(r.db.DB().QueryRowContext(ctx, query, tenantID, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			span.SetStatus(codes.Error, "Webhook not found")
+			return nil, ErrWebhookNotFound
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to get webhook")
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+func (r *WebhookRepository) ListWebhooks(ctx context.Context) ([]*taskpb.Webhook, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookRepository.ListWebhooks")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE tenant_id = $1 ORDER BY created_at`
+
+	rows, err := r.db.DB().QueryContext(ctx, query, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to list webhooks")
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []*taskpb.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to scan webhook")
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Row iteration error")
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(webhooks)))
+
+	return webhooks, nil
+}
+
+func (r *WebhookRepository) UpdateWebhook(ctx context.Context, req *taskpb.UpdateWebhookRequest) (*taskpb.Webhook, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookRepository.UpdateWebhook")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("webhook.id", req.Id))
+
+	query := `
+		UPDATE webhooks
+		SET url = $3, event_types = $4, assignee_id = $5
+		WHERE tenant_id = $1 AND id = $2
+		RETURNING ` + webhookColumns
+
+	webhook, err := scanWebhook(r.db.DB().QueryRowContext(ctx, query,
+		tenantID, req.Id, req.Url, eventTypeArray(req.EventTypes), req.AssigneeId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			span.SetStatus(codes.Error, "Webhook not found")
+			return nil, ErrWebhookNotFound
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update webhook")
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook removes the webhook together with its queued deliveries and
+// dead letters.
+func (r *WebhookRepository) DeleteWebhook(ctx context.Context, id string) error {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookRepository.DeleteWebhook")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return err
+	}
+
+	span.SetAttributes(attribute.String("webhook.id", id))
+
+	result, err := r.db.DB().ExecContext(ctx, `DELETE FROM webhooks WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete webhook")
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		span.SetStatus(codes.Error, "Webhook not found")
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+// ListDeadLetters returns the most recent deliveries to the webhook that
+// failed on every attempt.
+func (r *WebhookRepository) ListDeadLetters(ctx context.Context, webhookID string) ([]*taskpb.DeadLetter, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "WebhookRepository.ListDeadLetters")
+	defer span.End()
+
+	deadline.Record(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("webhook.id", webhookID))
+
+	query := `
+		SELECT id, webhook_id, event_type, task_id, attempts, last_error, payload, created_at, failed_at
+		FROM webhook_deliveries
+		WHERE tenant_id = $1 AND webhook_id = $2 AND dead
+		ORDER BY failed_at DESC
+		LIMIT $3
+	`
+
+	rows, err := r.db.DB().QueryContext(ctx, query, tenantID, webhookID, maxDeadLetters)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to list dead letters")
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var letters []*taskpb.DeadLetter
+	for rows.Next() {
+		var letter taskpb.DeadLetter
+		var eventType int32
+		var payload []byte
+		var createdAt, failedAt time.Time
+
+		err := rows.Scan(
+			&letter.Id,
+			&letter.WebhookId,
+			&eventType,
+			&letter.TaskId,
+			&letter.Attempts,
+			&letter.LastError,
+			&payload,
+			&createdAt,
+			&failedAt,
+		)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to scan dead letter")
+			return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+		}
+
+		letter.EventType = taskpb.TaskEventType(eventType)
+		letter.Payload = string(payload)
+		letter.CreatedAt = timestamppb.New(createdAt)
+		letter.FailedAt = timestamppb.New(failedAt)
+
+		letters = append(letters, &letter)
+	}
+
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Row iteration error")
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(letters)))
+
+	return letters, nil
+}
+
+// RelayEvents queues deliveries for up to limit task events the relay has
+// not seen yet, for every webhook of the event's tenant that subscribes to
+// its type and whose assignee filter matches. payload builds the body of an
+// event's deliveries. The events are read from the event log, which writes
+// append to in their own transaction, and the relay's cursor moves in the
+// same transaction as the deliveries are queued, so every committed write
+// is queued exactly once, whichever replica relays it. It returns the
+// number of events read.
+func (r *WebhookRepository) RelayEvents(ctx context.Context, limit int, payload func(TaskEvent) ([]byte, error)) (int, error) {
+	tx, err := r.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin relay: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Locking the cursor keeps other replicas from relaying the same events
+	var cursor int64
+	if err := tx.QueryRowContext(ctx, `SELECT last_sequence FROM webhook_relay WHERE id = 1 FOR UPDATE`).Scan(&cursor); err != nil {
+		return 0, fmt.Errorf("failed to read relay cursor: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM task_events
+		WHERE sequence > $1
+		ORDER BY sequence
+		LIMIT $2
+	`, cursor, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read events: %w", err)
+	}
+	var events []TaskEvent
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read events: %w", err)
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, tenant_id, event_type, task_id, payload, traceparent)
+		SELECT id, tenant_id, $2, $3, $4, $5
+		FROM webhooks
+		WHERE tenant_id = $1
+			AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))
+			AND (assignee_id = '' OR assignee_id = $6)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare delivery insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		body, err := payload(e)
+		if err != nil {
+			return 0, fmt.Errorf("failed to build payload of event %d: %w", e.Event.Sequence, err)
+		}
+		task := e.Event.Task
+		_, err = stmt.ExecContext(ctx, e.TenantID, int32(e.Event.Type), task.Id, body, e.Event.Traceparent, task.AssigneeId)
+		if err != nil {
+			return 0, fmt.Errorf("failed to queue webhook deliveries: %w", err)
+		}
+	}
+
+	last := events[len(events)-1].Event.Sequence
+	if _, err := tx.ExecContext(ctx, `UPDATE webhook_relay SET last_sequence = $1 WHERE id = 1`, int64(last)); err != nil {
+		return 0, fmt.Errorf("failed to move relay cursor: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit relay: %w", err)
+	}
+	return len(events), nil
+}
+
+// ClaimDeliveries takes up to limit due deliveries and counts an attempt for
+// each. They are hidden from other workers for lease; a worker that dies
+// mid-delivery leaves them to be retried after it.
+func (r *WebhookRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*Delivery, error) {
+	query := `
+		WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE NOT dead AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		), claimed AS (
+			UPDATE webhook_deliveries d
+			SET attempts = d.attempts + 1, next_attempt_at = NOW() + $2::integer * INTERVAL '1 second'
+			FROM due
+			WHERE d.id = due.id
+			RETURNING d.id, d.webhook_id, d.tenant_id, d.event_type, d.task_id, d.payload, d.traceparent, d.attempts
+		)
+		SELECT c.id, c.webhook_id, c.tenant_id, c.event_type, c.task_id, c.payload, c.traceparent, c.attempts, w.url, w.secret
+		FROM claimed c
+		JOIN webhooks w ON w.id = c.webhook_id
+	`
+
+	rows, err := r.db.DB().QueryContext(ctx, query, limit, int64(lease/time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*Delivery
+	for rows.Next() {
+		var d Delivery
+		var eventType int32
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.TenantID, &eventType, &d.TaskID,
+			&d.Payload, &d.Traceparent, &d.Attempts, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		d.EventType = taskpb.TaskEventType(eventType)
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, rows.Err()
+}
+
+// CompleteDelivery removes a delivery the receiver accepted.
+func (r *WebhookRepository) CompleteDelivery(ctx context.Context, id string) error {
+	_, err := r.db.DB().ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE id = $1`, id)
+	return err
+}
+
+// RetryDelivery schedules another attempt after backoff.
+func (r *WebhookRepository) RetryDelivery(ctx context.Context, id, lastError string, backoff time.Duration) error {
+	_, err := r.db.DB().ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET last_error = $2, next_attempt_at = NOW() + $3::integer * INTERVAL '1 millisecond'
+		WHERE id = $1
+	`, id, lastError, backoff.Milliseconds())
+	return err
+}
+
+// KillDelivery moves a delivery that ran out of attempts to the dead
+// letters.
+func (r *WebhookRepository) KillDelivery(ctx context.Context, id, lastError string) error {
+	_, err := r.db.DB().ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET dead = TRUE, last_error = $2, failed_at = NOW()
+		WHERE id = $1
+	`, id, lastError)
+	return err
+}
@@ -14,7 +14,9 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-func InitTracer(ctx context.Context) (*sdktrace.TracerProvider, error) {
+// InitTracer installs the global tracer provider. sampler decides which
+// root spans are recorded; pass a sampling.Swappable to adjust it at runtime.
+func InitTracer(ctx context.Context, sampler sdktrace.Sampler) (*sdktrace.TracerProvider, error) {
 	jaegerEndpoint := os.Getenv("JAEGER_ENDPOINT")
 	if jaegerEndpoint == "" {
 		jaegerEndpoint = "http://localhost:4318/v1/traces"
@@ -49,7 +51,7 @@ func InitTracer(ctx context.Context) (*sdktrace.TracerProvider, error) {
 	tp := sdktrace.NewTracerProvider(
 		sdktrace.WithBatcher(exporter),
 		sdktrace.WithResource(res),
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSampler(sampler),
 	)
 
 	// Set global tracer provider
@@ -0,0 +1,306 @@
+// Package webhooks delivers task events to subscribed HTTP endpoints.
+// Deliveries are queued in Postgres from the task event log, which every
+// write appends to in its own transaction, so a committed write is never
+// lost to a crash before its deliveries are queued. A background worker
+// sends them, retries failures with exponential backoff and keeps
+// deliveries that never succeed as dead letters. Delivery is at least
+// once: a receiver may see a delivery again, with the same
+// X-Webhook-Delivery, if the worker stops between sending it and recording
+// the outcome.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/storage"
+	"github.com/bonyuta0204/otel-lab/task-service/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the webhook's secret.
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	// maxErrorBody is how much of a failed response is kept as last_error.
+	maxErrorBody = 512
+)
+
+var taskJSON = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// Queue is the delivery queue the dispatcher works on;
+// storage.WebhookRepository implements it.
+type Queue interface {
+	RelayEvents(ctx context.Context, limit int, payload func(storage.TaskEvent) ([]byte, error)) (int, error)
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*storage.Delivery, error)
+	CompleteDelivery(ctx context.Context, id string) error
+	RetryDelivery(ctx context.Context, id, lastError string, backoff time.Duration) error
+	KillDelivery(ctx context.Context, id, lastError string) error
+}
+
+// Dispatcher queues and delivers webhook calls.
+type Dispatcher struct {
+	repo   Queue
+	client *http.Client
+
+	// MaxAttempts is the number of attempts before a delivery becomes a
+	// dead letter.
+	MaxAttempts int
+	// The n-th retry waits about BaseBackoff * 2^(n-1), at most MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// PollInterval is how often the queue is checked for due deliveries.
+	PollInterval time.Duration
+	// BatchSize is the number of deliveries sent concurrently.
+	BatchSize int
+	// RelayBatch is the number of events queued per transaction.
+	RelayBatch int
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+}
+
+// NewDispatcher returns a dispatcher whose connections guard checks. It
+// does not use a proxy, which would make the connections on its behalf.
+func NewDispatcher(repo Queue, guard *Guard) *Dispatcher {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   guard.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Dispatcher{
+		repo:         repo,
+		client:       &http.Client{Transport: otelhttp.NewTransport(transport)},
+		MaxAttempts:  8,
+		BaseBackoff:  5 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: time.Second,
+		BatchSize:    16,
+		RelayBatch:   500,
+		Timeout:      10 * time.Second,
+	}
+}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	Type       string          `json:"type"`
+	TenantID   string          `json:"tenant_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Task       json.RawMessage `json:"task"`
+}
+
+// EventName is the event type as it appears in payloads and the event
+// header, e.g. "task.created".
+func EventName(t taskpb.TaskEventType) string {
+	return "task." + strings.ToLower(t.String())
+}
+
+// payload builds the body of the deliveries for an event.
+func payload(e storage.TaskEvent) ([]byte, error) {
+	taskBody, err := taskJSON.Marshal(e.Event.Task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode task: %w", err)
+	}
+	return json.Marshal(Payload{
+		Type:       EventName(e.Event.Type),
+		TenantID:   e.TenantID,
+		OccurredAt: e.Event.OccurredAt.AsTime().UTC(),
+		Task:       taskBody,
+	})
+}
+
+// Run works on the delivery queue until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.relay(ctx)
+
+		// Keep going while full batches are due, so a backlog drains
+		// faster than one batch per interval
+		for {
+			deliveries, err := d.repo.ClaimDeliveries(ctx, d.BatchSize, d.Timeout+d.PollInterval)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Failed to claim webhook deliveries", "error", err)
+				}
+				break
+			}
+
+			var wg sync.WaitGroup
+			for _, delivery := range deliveries {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					d.deliver(ctx, delivery)
+				}()
+			}
+			wg.Wait()
+
+			if len(deliveries) < d.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// relay queues the deliveries for every event written since the last
+// relay.
+func (d *Dispatcher) relay(ctx context.Context) {
+	for {
+		n, err := d.repo.RelayEvents(ctx, d.RelayBatch, payload)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Failed to queue webhook deliveries", "error", err)
+			}
+			return
+		}
+		if n < d.RelayBatch {
+			return
+		}
+	}
+}
+
+// deliver makes one attempt. Its span starts a trace of its own, linked to
+// the trace of the write that queued the delivery.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *storage.Delivery) {
+	opts := []trace.SpanStartOption{trace.WithNewRoot(), trace.WithSpanKind(trace.SpanKindProducer)}
+	writeCtx := propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": delivery.Traceparent})
+	if sc := trace.SpanContextFromContext(writeCtx); sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{
+			SpanContext: sc,
+			Attributes:  []attribute.KeyValue{attribute.String("link.type", "task.write")},
+		}))
+	}
+
+	ctx = tenant.NewContext(ctx, delivery.TenantID)
+	ctx, span := tracing.GetTracer().Start(ctx, "Webhooks.Deliver", opts...)
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("webhook.id", delivery.WebhookID),
+		attribute.String("webhook.delivery_id", delivery.ID),
+		attribute.Int("webhook.attempt", delivery.Attempts),
+		attribute.String("event.type", EventName(delivery.EventType)),
+		attribute.String("task.id", delivery.TaskID),
+		tenant.Key.String(delivery.TenantID),
+	)
+
+	// The outcome is recorded even when shutdown interrupts the attempt.
+	storeCtx := context.WithoutCancel(ctx)
+
+	err := d.send(ctx, delivery)
+	if err == nil {
+		if err := d.repo.CompleteDelivery(storeCtx, delivery.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to complete webhook delivery", "delivery_id", delivery.ID, "error", err)
+		}
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, "Webhook delivery failed")
+
+	if delivery.Attempts >= d.MaxAttempts {
+		span.SetAttributes(attribute.Bool("webhook.dead_letter", true))
+		slog.WarnContext(ctx, "Webhook delivery moved to dead letters",
+			"webhook_id", delivery.WebhookID, "delivery_id", delivery.ID, "attempts", delivery.Attempts, "error", err)
+		err = d.repo.KillDelivery(storeCtx, delivery.ID, err.Error())
+	} else {
+		backoff := d.backoff(delivery.Attempts)
+		span.SetAttributes(attribute.Int64("webhook.retry_in_ms", backoff.Milliseconds()))
+		err = d.repo.RetryDelivery(storeCtx, delivery.ID, err.Error(), backoff)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to update webhook delivery", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery *storage.Delivery) error {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "otel-lab-webhooks/1.0")
+	req.Header.Set(EventHeader, EventName(delivery.EventType))
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return fmt.Errorf("receiver responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// backoff returns the wait before the retry following the given attempt,
+// randomised by up to half so that failed deliveries do not retry in step.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	wait := d.MaxBackoff
+	if attempt < 32 {
+		if b := d.BaseBackoff << (attempt - 1); b > 0 && b < wait {
+			wait = b
+		}
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// Sign returns the signature header value for body sent at timestamp.
+// Receivers recompute it with their copy of the secret and compare in
+// constant time.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
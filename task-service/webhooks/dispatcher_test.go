@@ -0,0 +1,377 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	taskpb "github.com/bonyuta0204/otel-lab/proto/taskpb"
+	"github.com/bonyuta0204/otel-lab/task-service/storage"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// fakeQueue records how the dispatcher settled each delivery.
+type fakeQueue struct {
+	mu        sync.Mutex
+	completed []string
+	retried   map[string]time.Duration
+	killed    []string
+	lastError string
+}
+
+func newFakeQueue() *fakeQueue {
+	return &fakeQueue{retried: make(map[string]time.Duration)}
+}
+
+func (q *fakeQueue) RelayEvents(context.Context, int, func(storage.TaskEvent) ([]byte, error)) (int, error) {
+	return 0, nil
+}
+
+func (q *fakeQueue) ClaimDeliveries(context.Context, int, time.Duration) ([]*storage.Delivery, error) {
+	return nil, nil
+}
+
+func (q *fakeQueue) CompleteDelivery(_ context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.completed = append(q.completed, id)
+	return nil
+}
+
+func (q *fakeQueue) RetryDelivery(_ context.Context, id, lastError string, backoff time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.retried[id] = backoff
+	q.lastError = lastError
+	return nil
+}
+
+func (q *fakeQueue) KillDelivery(_ context.Context, id, lastError string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.killed = append(q.killed, id)
+	q.lastError = lastError
+	return nil
+}
+
+// receiver is a webhook endpoint that answers with status and keeps the
+// last request it got.
+func receiver(t *testing.T, status int) (*httptest.Server, func() (*http.Request, []byte)) {
+	t.Helper()
+	var mu sync.Mutex
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got, body = r, b
+		mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, "receiver says no")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() (*http.Request, []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		return got, body
+	}
+}
+
+// testDispatcher returns a dispatcher allowed to call the test receivers
+// on loopback.
+func testDispatcher(q Queue) *Dispatcher {
+	return NewDispatcher(q, NewGuard(netip.MustParsePrefix("127.0.0.1/32")))
+}
+
+func testDelivery(url string, attempts int) *storage.Delivery {
+	return &storage.Delivery{
+		ID:          "delivery-1",
+		WebhookID:   "webhook-1",
+		TenantID:    "acme",
+		EventType:   taskpb.TaskEventType_CREATED,
+		TaskID:      "task-1",
+		Payload:     []byte(`{"type":"task.created"}`),
+		Traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		Attempts:    attempts,
+		URL:         url,
+		Secret:      "s3cret",
+	}
+}
+
+func TestSign(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("1700000000.{}"))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := Sign("s3cret", "1700000000", []byte("{}")); got != want {
+		t.Errorf("Sign = %q, want %q", got, want)
+	}
+	if Sign("other", "1700000000", []byte("{}")) == want {
+		t.Error("signature does not depend on the secret")
+	}
+	if Sign("s3cret", "1700000001", []byte("{}")) == want {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
+
+func TestDeliverHeaders(t *testing.T) {
+	srv, last := receiver(t, http.StatusNoContent)
+	q := newFakeQueue()
+	delivery := testDelivery(srv.URL, 1)
+
+	testDispatcher(q).deliver(context.Background(), delivery)
+
+	req, body := last()
+	if req == nil {
+		t.Fatal("receiver got no request")
+	}
+	if req.Method != http.MethodPost || string(body) != string(delivery.Payload) {
+		t.Errorf("got %s %q, want POST %q", req.Method, body, delivery.Payload)
+	}
+	for header, want := range map[string]string{
+		"Content-Type": "application/json",
+		"User-Agent":   "otel-lab-webhooks/1.0",
+		EventHeader:    "task.created",
+		DeliveryHeader: "delivery-1",
+	} {
+		if got := req.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	timestamp := req.Header.Get(TimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("%s = %q, want the current Unix time", TimestampHeader, timestamp)
+	}
+	if got, want := req.Header.Get(SignatureHeader), Sign("s3cret", timestamp, body); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+}
+
+func TestDeliverOutcome(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		attempts  int
+		completed bool
+		retried   bool
+		killed    bool
+	}{
+		{"2xx completes", http.StatusOK, 1, true, false, false},
+		{"2xx on the last attempt completes", http.StatusAccepted, 8, true, false, false},
+		{"non-2xx retries", http.StatusInternalServerError, 1, false, true, false},
+		{"redirect is not a success", http.StatusMovedPermanently, 3, false, true, false},
+		{"last attempt goes to dead letters", http.StatusBadRequest, 8, false, false, true},
+		{"past the last attempt goes to dead letters", http.StatusBadGateway, 9, false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := receiver(t, tt.status)
+			q := newFakeQueue()
+			d := testDispatcher(q)
+			d.client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+			d.deliver(context.Background(), testDelivery(srv.URL, tt.attempts))
+
+			if got := len(q.completed) == 1; got != tt.completed {
+				t.Errorf("completed = %v, want %v", got, tt.completed)
+			}
+			if _, got := q.retried["delivery-1"]; got != tt.retried {
+				t.Errorf("retried = %v, want %v", got, tt.retried)
+			}
+			if got := len(q.killed) == 1; got != tt.killed {
+				t.Errorf("killed = %v, want %v", got, tt.killed)
+			}
+			if !tt.completed && q.lastError == "" {
+				t.Error("failure recorded without last_error")
+			}
+		})
+	}
+}
+
+func TestDeliverRetryBackoff(t *testing.T) {
+	srv, _ := receiver(t, http.StatusServiceUnavailable)
+	q := newFakeQueue()
+	d := testDispatcher(q)
+
+	d.deliver(context.Background(), testDelivery(srv.URL, 3))
+
+	// The third attempt waits about 5s << 2
+	if got := q.retried["delivery-1"]; got < 10*time.Second || got > 20*time.Second {
+		t.Errorf("retry in %v, want between 10s and 20s", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(newFakeQueue(), NewGuard())
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 2500 * time.Millisecond, 5 * time.Second},
+		{2, 5 * time.Second, 10 * time.Second},
+		{4, 20 * time.Second, 40 * time.Second},
+		{10, 21*time.Minute + 20*time.Second, 42*time.Minute + 40*time.Second},
+		{12, 30 * time.Minute, time.Hour},
+		{40, 30 * time.Minute, time.Hour},
+		{1000, 30 * time.Minute, time.Hour},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := d.backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestDeliverLinksToWrite(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	srv, _ := receiver(t, http.StatusOK)
+	delivery := testDelivery(srv.URL, 1)
+	testDispatcher(newFakeQueue()).deliver(context.Background(), delivery)
+
+	var span sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "Webhooks.Deliver" {
+			span = s
+		}
+	}
+	if span == nil {
+		t.Fatal("no Webhooks.Deliver span")
+	}
+	if span.Parent().IsValid() {
+		t.Error("delivery span has a parent, want a new root")
+	}
+
+	links := span.Links()
+	if len(links) != 1 {
+		t.Fatalf("span has %d links, want 1", len(links))
+	}
+	link := links[0].SpanContext
+	if link.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || link.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("link to %s/%s, want the write's traceparent", link.TraceID(), link.SpanID())
+	}
+	if span.SpanContext().TraceID() == link.TraceID() {
+		t.Error("delivery span shares the write's trace")
+	}
+}
+
+func TestDeliverRefusesForbiddenAddress(t *testing.T) {
+	srv, last := receiver(t, http.StatusOK)
+	q := newFakeQueue()
+
+	// Without the allowlist, the loopback receiver must not be called
+	NewDispatcher(q, NewGuard()).deliver(context.Background(), testDelivery(srv.URL, 1))
+
+	if req, _ := last(); req != nil {
+		t.Fatal("dispatcher called a loopback address")
+	}
+	if _, ok := q.retried["delivery-1"]; !ok {
+		t.Error("refused delivery was not scheduled for retry")
+	}
+}
+
+func TestGuardCheck(t *testing.T) {
+	guard := NewGuard(netip.MustParsePrefix("10.20.0.0/16"))
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"10.20.3.4", true},
+		{"::ffff:10.20.3.4", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := guard.Check(netip.MustParseAddr(tt.addr))
+			if tt.allowed && err != nil {
+				t.Errorf("Check(%s) = %v, want allowed", tt.addr, err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrForbiddenAddress) {
+				t.Errorf("Check(%s) = %v, want ErrForbiddenAddress", tt.addr, err)
+			}
+		})
+	}
+}
+
+func TestGuardCheckURL(t *testing.T) {
+	guard := NewGuard()
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://93.184.216.34/hook", true},
+		{"http://127.0.0.1:8080/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://localhost/hook", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := guard.CheckURL(context.Background(), tt.url)
+			if tt.allowed && err != nil {
+				t.Errorf("CheckURL(%s) = %v, want allowed", tt.url, err)
+			}
+			if !tt.allowed && err == nil {
+				t.Errorf("CheckURL(%s) allowed, want an error", tt.url)
+			}
+		})
+	}
+}
+
+func TestGuardFromEnv(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOWED_NETWORKS", " 10.20.0.0/16, fd00::/8 ,")
+	guard, err := GuardFromEnv()
+	if err != nil {
+		t.Fatalf("GuardFromEnv: %v", err)
+	}
+	if err := guard.Check(netip.MustParseAddr("10.20.1.1")); err != nil {
+		t.Errorf("allowed network refused: %v", err)
+	}
+	if err := guard.Check(netip.MustParseAddr("10.21.1.1")); err == nil {
+		t.Error("network outside the allowlist allowed")
+	}
+
+	t.Setenv("WEBHOOK_ALLOWED_NETWORKS", "10.20.0.0")
+	if _, err := GuardFromEnv(); err == nil {
+		t.Error("GuardFromEnv accepted an address without a prefix length")
+	}
+}
@@ -0,0 +1,119 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned for a webhook target in a network the
+// service must not be made to call, such as its own host or the private
+// network it runs in.
+var ErrForbiddenAddress = errors.New("webhook target is in a forbidden network")
+
+// forbiddenNetworks are the ranges that are never public beyond those the
+// netip predicates cover.
+var forbiddenNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// Guard keeps webhooks from reaching loopback, private, link-local and
+// other non-public addresses, unless the operator allowed them. It checks
+// the address every connection is actually made to, after DNS resolution,
+// so a name that resolves to a public address when the webhook is saved
+// and to a private one later is still refused.
+type Guard struct {
+	allowed []netip.Prefix
+}
+
+// NewGuard returns a guard that lets the allowed networks through.
+func NewGuard(allowed ...netip.Prefix) *Guard {
+	return &Guard{allowed: allowed}
+}
+
+// GuardFromEnv reads WEBHOOK_ALLOWED_NETWORKS, a comma-separated list of
+// CIDRs webhooks may reach even though they are not public, such as a
+// receiver on the same private network.
+func GuardFromEnv() (*Guard, error) {
+	var allowed []netip.Prefix
+	for _, raw := range strings.Split(os.Getenv("WEBHOOK_ALLOWED_NETWORKS"), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return nil, fmt.Errorf("WEBHOOK_ALLOWED_NETWORKS: %w", err)
+		}
+		allowed = append(allowed, prefix.Masked())
+	}
+	return NewGuard(allowed...), nil
+}
+
+// Check returns ErrForbiddenAddress unless addr may be called.
+func (g *Guard) Check(addr netip.Addr) error {
+	addr = addr.Unmap()
+	for _, prefix := range g.allowed {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addr)
+	}
+	for _, prefix := range forbiddenNetworks {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%w: %s", ErrForbiddenAddress, addr)
+		}
+	}
+	return nil
+}
+
+// CheckURL resolves the host of a webhook URL and checks every address it
+// resolves to. It catches mistakes when a webhook is saved; deliveries are
+// checked again when they connect.
+func (g *Guard) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return g.Check(addr)
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if err := g.Check(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// control is a net.Dialer Control function that refuses connections to
+// forbidden addresses. It sees the resolved address being dialed.
+func (g *Guard) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: unexpected address %q", ErrForbiddenAddress, address)
+	}
+	return g.Check(addrPort.Addr())
+}
@@ -8,19 +8,38 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/bonyuta0204/otel-lab/internal/admin"
+	"github.com/bonyuta0204/otel-lab/internal/deadline"
+	"github.com/bonyuta0204/otel-lab/internal/logging"
+	"github.com/bonyuta0204/otel-lab/internal/requestid"
+	"github.com/bonyuta0204/otel-lab/internal/sampling"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	"github.com/bonyuta0204/otel-lab/internal/validation"
+	_ "github.com/bonyuta0204/otel-lab/internal/zstdcodec"
 	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
 	"github.com/bonyuta0204/otel-lab/user-service/server"
 	"github.com/bonyuta0204/otel-lab/user-service/storage"
 	"github.com/bonyuta0204/otel-lab/user-service/tracing"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
 	ctx := context.Background()
 
-	// Initialize tracing
-	tp, err := tracing.InitTracer(ctx)
+	// Initialize logging and tracing; both can be adjusted at runtime
+	// through the admin API
+	logLevel := logging.Init()
+
+	sampler, err := sampling.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid sampling configuration: %v", err)
+	}
+
+	tp, err := tracing.InitTracer(ctx, sampler)
 	if err != nil {
 		log.Fatalf("Failed to initialize tracer: %v", err)
 	}
@@ -36,8 +55,8 @@ func main() {
 	// Initialize repository
 	repo := storage.NewUserRepository(userStore)
 
-	// Seed some initial data
-	repo.SeedInitialData(ctx)
+	// Seed some initial data for the default tenant
+	repo.SeedInitialData(tenant.NewContext(ctx, tenant.Default))
 
 	// Initialize server
 	userServer := server.NewUserServer(repo)
@@ -48,10 +67,31 @@ func main() {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	s := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithMessageEvents(otelgrpc.ReceivedEvents, otelgrpc.SentEvents),
+		)),
+		grpc.ChainUnaryInterceptor(
+			requestid.UnaryServerInterceptor(),
+			tenant.UnaryServerInterceptor(),
+			deadline.UnaryServerInterceptor(),
+			validation.UnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			requestid.StreamServerInterceptor(),
+			tenant.StreamServerInterceptor(),
+			deadline.StreamServerInterceptor(),
+			validation.StreamServerInterceptor(),
+		),
+	)
 
 	userpb.RegisterUserServiceServer(s, userServer)
 
+	// The in-memory store has no dependencies, so the service is serving
+	// until it shuts down
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+
 	// Start server
 	go func() {
 		log.Println("User Service starting on :8082")
@@ -60,12 +100,30 @@ func main() {
 		}
 	}()
 
+	// Start admin API
+	adminOpts := admin.OptionsFromEnv("user-service", ":9092")
+	adminOpts.Level = logLevel
+	adminOpts.Sampler = sampler
+	adminOpts.Config = func() interface{} {
+		return map[string]interface{}{
+			"listen":             ":8082",
+			"store":              "memory",
+			"jaeger_endpoint":    os.Getenv("JAEGER_ENDPOINT"),
+			"log_level":          logLevel.Level().String(),
+			"trace_sample_ratio": sampler.Ratio(),
+		}
+	}
+	adminServer := admin.New(adminOpts)
+	adminServer.Start()
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
 
+	adminServer.Shutdown(ctx)
+	healthServer.Shutdown()
 	s.GracefulStop()
 	log.Println("Server exited")
 }
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+
+	"github.com/bonyuta0204/otel-lab/internal/search"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"github.com/bonyuta0204/otel-lab/user-service/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *UserServer) SearchUsers(ctx context.Context, req *userpb.SearchUsersRequest) (*userpb.SearchUsersResponse, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "UserServer.SearchUsers")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("search.query", req.Query),
+		attribute.Int("search.limit", int(req.Limit)),
+	)
+
+	if req.Limit <= 0 {
+		req.Limit = 10
+	}
+
+	// A query of punctuation only has nothing to match
+	terms := search.Terms(req.Query)
+	if len(terms) == 0 {
+		return &userpb.SearchUsersResponse{}, nil
+	}
+
+	hits, err := s.repo.SearchUsers(ctx, terms, int(req.Limit))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, "Failed to search users")
+		return nil, status.Error(codes.Internal, "failed to search users")
+	}
+
+	resp := &userpb.SearchUsersResponse{Hits: make([]*userpb.UserHit, len(hits))}
+	for i, hit := range hits {
+		resp.Hits[i] = &userpb.UserHit{User: hit.Item, Score: hit.Score}
+	}
+
+	span.SetAttributes(attribute.Int("result.count", len(resp.Hits)))
+
+	return resp, nil
+}
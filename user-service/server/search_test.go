@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"github.com/bonyuta0204/otel-lab/user-service/server"
+	"github.com/bonyuta0204/otel-lab/user-service/storage"
+)
+
+func TestSearchUsers(t *testing.T) {
+	s := server.NewUserServer(storage.NewUserRepository(storage.NewInMemoryUserStore()))
+	acme := tenant.NewContext(context.Background(), "acme")
+	globex := tenant.NewContext(context.Background(), "globex")
+
+	create := func(ctx context.Context, name, email string) string {
+		t.Helper()
+		user, err := s.CreateUser(ctx, &userpb.CreateUserRequest{Name: name, Email: email})
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		return user.Id
+	}
+	// Created one after the other, so each is newer than the one before
+	rivera := create(acme, "Sam Rivera", "rivera@example.com")
+	chen := create(acme, "Jo Chen", "sam.chen@example.com")
+	ortiz := create(acme, "Sam Ortiz", "ortiz@example.com")
+	kim := create(acme, "Pat Kim", "pat@example.com")
+	other := create(globex, "Sam Globex", "sam@globex.test")
+
+	type hit struct {
+		id    string
+		score float64
+	}
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		query string
+		limit int32
+		want  []hit
+	}{
+		// A name match weighs 1 and an email match 0.8; the two name
+		// matches tie and come newest first
+		{"name and email", acme, "sam", 0, []hit{{ortiz, 1}, {rivera, 1}, {chen, 0.8}}},
+		{"limit", acme, "sam", 2, []hit{{ortiz, 1}, {rivera, 1}}},
+		{"email only", acme, "example", 0, []hit{{kim, 0.8}, {ortiz, 0.8}, {chen, 0.8}, {rivera, 0.8}}},
+		{"every term has to match", acme, "sam pat", 0, nil},
+		{"punctuation only", acme, "@!? --", 0, nil},
+		{"other tenant", globex, "sam", 0, []hit{{other, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.SearchUsers(tt.ctx, &userpb.SearchUsersRequest{Query: tt.query, Limit: tt.limit})
+			if err != nil {
+				t.Fatalf("SearchUsers: %v", err)
+			}
+
+			if len(resp.Hits) != len(tt.want) {
+				t.Fatalf("%d hits, want %d: %v", len(resp.Hits), len(tt.want), resp.Hits)
+			}
+			for i, h := range resp.Hits {
+				if h.User.Id != tt.want[i].id || h.Score != tt.want[i].score {
+					t.Errorf("hit %d = %s (%s) %v, want %s %v", i, h.User.Id, h.User.Name, h.Score, tt.want[i].id, tt.want[i].score)
+				}
+			}
+		})
+	}
+}
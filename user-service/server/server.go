@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 
+	"github.com/bonyuta0204/otel-lab/internal/pagetoken"
 	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
 	"github.com/bonyuta0204/otel-lab/user-service/storage"
 	"github.com/bonyuta0204/otel-lab/user-service/tracing"
@@ -85,29 +86,42 @@ func (s *UserServer) ListUsers(ctx context.Context, req *userpb.ListUsersRequest
 
 	span.SetAttributes(
 		attribute.Int("page.size", int(req.PageSize)),
-		attribute.Int("page.number", int(req.PageNumber)),
+		attribute.Bool("page.token", req.PageToken != ""),
 	)
 
 	if req.PageSize <= 0 {
 		req.PageSize = 10
 	}
-	if req.PageNumber <= 0 {
-		req.PageNumber = 1
+
+	filter := pagetoken.Filter()
+	var after *pagetoken.Cursor
+	if req.PageToken != "" {
+		cursor, err := pagetoken.Decode(req.PageToken, filter)
+		if err != nil {
+			span.SetStatus(otelcodes.Error, "Invalid page token")
+			return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+		after = &cursor
 	}
 
-	users, totalCount, err := s.repo.ListUsers(ctx, req)
+	page, err := s.repo.ListUsers(ctx, req, after)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(otelcodes.Error, "Failed to list users")
 		return nil, status.Error(codes.Internal, "failed to list users")
 	}
 
-	span.SetAttributes(attribute.Int("result.count", len(users)))
+	span.SetAttributes(attribute.Int("result.count", len(page.Users)))
 
-	return &userpb.ListUsersResponse{
-		Users:      users,
-		TotalCount: totalCount,
-	}, nil
+	resp := &userpb.ListUsersResponse{
+		Users:      page.Users,
+		TotalCount: page.TotalCount,
+	}
+	if page.Next != nil {
+		resp.NextPageToken = pagetoken.Encode(*page.Next, filter)
+	}
+
+	return resp, nil
 }
 
 func (s *UserServer) GetUsersByIds(ctx context.Context, req *userpb.GetUsersByIdsRequest) (*userpb.GetUsersByIdsResponse, error) {
@@ -3,9 +3,13 @@ package storage
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/bonyuta0204/otel-lab/internal/pagetoken"
+	"github.com/bonyuta0204/otel-lab/internal/search"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
 	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
 	"github.com/bonyuta0204/otel-lab/user-service/tracing"
 	"go.opentelemetry.io/otel/attribute"
@@ -13,17 +17,35 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// InMemoryUserStore keeps a separate partition per tenant; the repository
+// only ever looks inside the partition of the tenant in the context.
 type InMemoryUserStore struct {
 	mu    sync.RWMutex
-	users map[string]*userpb.User
-	cache map[string]*userpb.User // Simple cache simulation
+	users map[string]map[string]*userpb.User // tenant -> id -> user
+	cache map[cacheKey]*userpb.User          // Simple cache simulation
+}
+
+type cacheKey struct {
+	tenant string
+	id     string
 }
 
 func NewInMemoryUserStore() *InMemoryUserStore {
 	return &InMemoryUserStore{
-		users: make(map[string]*userpb.User),
-		cache: make(map[string]*userpb.User),
+		users: make(map[string]map[string]*userpb.User),
+		cache: make(map[cacheKey]*userpb.User),
+	}
+}
+
+// partitionLocked returns the tenant's users, creating the partition when
+// create is set. The caller must hold mu, for writing if create is set.
+func (s *InMemoryUserStore) partitionLocked(tenantID string, create bool) map[string]*userpb.User {
+	users, ok := s.users[tenantID]
+	if !ok && create {
+		users = make(map[string]*userpb.User)
+		s.users[tenantID] = users
 	}
+	return users
 }
 
 type UserRepository struct {
@@ -34,10 +56,18 @@ func NewUserRepository(store *InMemoryUserStore) *UserRepository {
 	return &UserRepository{store: store}
 }
 
+// SeedInitialData adds a few users to the tenant in ctx.
 func (r *UserRepository) SeedInitialData(ctx context.Context) {
 	ctx, span := tracing.GetTracer().Start(ctx, "UserRepository.SeedInitialData")
 	defer span.End()
 
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return
+	}
+	span.SetAttributes(tenant.Key.String(tenantID))
+
 	users := []*userpb.User{
 		{
 			Id:        "user-001",
@@ -62,8 +92,9 @@ func (r *UserRepository) SeedInitialData(ctx context.Context) {
 	r.store.mu.Lock()
 	defer r.store.mu.Unlock()
 
+	partition := r.store.partitionLocked(tenantID, true)
 	for _, user := range users {
-		r.store.users[user.Id] = user
+		partition[user.Id] = user
 	}
 
 	span.SetAttributes(attribute.Int("seed.count", len(users)))
@@ -73,6 +104,12 @@ func (r *UserRepository) CreateUser(ctx context.Context, req *userpb.CreateUserR
 	ctx, span := tracing.GetTracer().Start(ctx, "UserRepository.CreateUser")
 	defer span.End()
 
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
 	span.SetAttributes(
 		attribute.String("user.name", req.Name),
 		attribute.String("user.email", req.Email),
@@ -88,7 +125,7 @@ func (r *UserRepository) CreateUser(ctx context.Context, req *userpb.CreateUserR
 	r.store.mu.Lock()
 	defer r.store.mu.Unlock()
 
-	r.store.users[user.Id] = user
+	r.store.partitionLocked(tenantID, true)[user.Id] = user
 
 	span.SetAttributes(attribute.String("user.id", user.Id))
 
@@ -99,12 +136,18 @@ func (r *UserRepository) GetUser(ctx context.Context, id string) (*userpb.User,
 	ctx, span := tracing.GetTracer().Start(ctx, "UserRepository.GetUser")
 	defer span.End()
 
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
 	span.SetAttributes(attribute.String("user.id", id))
 
 	// Check cache first
 	_, cacheSpan := tracing.GetTracer().Start(ctx, "cache.Get")
 	r.store.mu.RLock()
-	if cached, exists := r.store.cache[id]; exists {
+	if cached, exists := r.store.cache[cacheKey{tenantID, id}]; exists {
 		r.store.mu.RUnlock()
 		cacheSpan.SetAttributes(attribute.Bool("cache.hit", true))
 		cacheSpan.End()
@@ -116,7 +159,7 @@ func (r *UserRepository) GetUser(ctx context.Context, id string) (*userpb.User,
 
 	// Get from store
 	r.store.mu.RLock()
-	user, exists := r.store.users[id]
+	user, exists := r.store.partitionLocked(tenantID, false)[id]
 	r.store.mu.RUnlock()
 
 	if !exists {
@@ -126,53 +169,90 @@ func (r *UserRepository) GetUser(ctx context.Context, id string) (*userpb.User,
 
 	// Update cache
 	r.store.mu.Lock()
-	r.store.cache[id] = user
+	r.store.cache[cacheKey{tenantID, id}] = user
 	r.store.mu.Unlock()
 
 	return user, nil
 }
 
-func (r *UserRepository) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) ([]*userpb.User, int32, error) {
+// UserPage is one page of ListUsers. Next is nil on the last page and
+// TotalCount unless it was requested.
+type UserPage struct {
+	Users      []*userpb.User
+	Next       *pagetoken.Cursor
+	TotalCount *int32
+}
+
+// ListUsers returns the page of users after the cursor, newest first by
+// (created_at, id), the same order the task list uses.
+func (r *UserRepository) ListUsers(ctx context.Context, req *userpb.ListUsersRequest, after *pagetoken.Cursor) (*UserPage, error) {
 	ctx, span := tracing.GetTracer().Start(ctx, "UserRepository.ListUsers")
 	defer span.End()
 
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
 	span.SetAttributes(
 		attribute.Int("page.size", int(req.PageSize)),
-		attribute.Int("page.number", int(req.PageNumber)),
+		attribute.Bool("page.after_cursor", after != nil),
 	)
 
 	r.store.mu.RLock()
-	defer r.store.mu.RUnlock()
-
-	// Convert map to slice
-	allUsers := make([]*userpb.User, 0, len(r.store.users))
-	for _, user := range r.store.users {
+	partition := r.store.partitionLocked(tenantID, false)
+	allUsers := make([]*userpb.User, 0, len(partition))
+	for _, user := range partition {
 		allUsers = append(allUsers, user)
 	}
+	r.store.mu.RUnlock()
 
-	// Simple pagination
-	start := int((req.PageNumber - 1) * req.PageSize)
-	end := int(req.PageNumber * req.PageSize)
+	sort.Slice(allUsers, func(i, j int) bool {
+		a, b := allUsers[i], allUsers[j]
+		return (pagetoken.Cursor{CreatedAt: a.CreatedAt.AsTime(), ID: a.Id}).Before(b.CreatedAt.AsTime(), b.Id)
+	})
 
-	if start >= len(allUsers) {
-		span.SetAttributes(attribute.Int("result.count", 0))
-		return []*userpb.User{}, int32(len(allUsers)), nil
+	page := &UserPage{}
+	if req.IncludeTotalCount {
+		totalCount := int32(len(allUsers))
+		page.TotalCount = &totalCount
 	}
 
-	if end > len(allUsers) {
+	start := 0
+	if after != nil {
+		start = sort.Search(len(allUsers), func(i int) bool {
+			return after.Before(allUsers[i].CreatedAt.AsTime(), allUsers[i].Id)
+		})
+	}
+
+	end := start + int(req.PageSize)
+	if end < len(allUsers) {
+		last := allUsers[end-1]
+		page.Next = &pagetoken.Cursor{CreatedAt: last.CreatedAt.AsTime(), ID: last.Id}
+	} else {
 		end = len(allUsers)
 	}
 
-	users := allUsers[start:end]
-	span.SetAttributes(attribute.Int("result.count", len(users)))
+	page.Users = allUsers[start:end]
+	span.SetAttributes(
+		attribute.Int("result.count", len(page.Users)),
+		attribute.Bool("page.has_next", page.Next != nil),
+	)
 
-	return users, int32(len(allUsers)), nil
+	return page, nil
 }
 
 func (r *UserRepository) GetUsersByIds(ctx context.Context, ids []string) ([]*userpb.User, error) {
 	ctx, span := tracing.GetTracer().Start(ctx, "UserRepository.GetUsersByIds")
 	defer span.End()
 
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
 	span.SetAttributes(
 		attribute.StringSlice("user.ids", ids),
 		attribute.Int("ids.count", len(ids)),
@@ -181,9 +261,10 @@ func (r *UserRepository) GetUsersByIds(ctx context.Context, ids []string) ([]*us
 	r.store.mu.RLock()
 	defer r.store.mu.RUnlock()
 
+	partition := r.store.partitionLocked(tenantID, false)
 	var users []*userpb.User
 	for _, id := range ids {
-		if user, exists := r.store.users[id]; exists {
+		if user, exists := partition[id]; exists {
 			users = append(users, user)
 		}
 	}
@@ -193,6 +274,49 @@ func (r *UserRepository) GetUsersByIds(ctx context.Context, ids []string) ([]*us
 	return users, nil
 }
 
+// SearchUsers returns the users whose name or email contains every term,
+// best match first and newest first between equal scores.
+func (r *UserRepository) SearchUsers(ctx context.Context, terms []string, limit int) ([]search.Hit[*userpb.User], error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "UserRepository.SearchUsers")
+	defer span.End()
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "Missing tenant")
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("search.terms", len(terms)),
+		attribute.Int("search.limit", limit),
+	)
+
+	r.store.mu.RLock()
+	var hits []search.Hit[*userpb.User]
+	for _, user := range r.store.partitionLocked(tenantID, false) {
+		score := search.Score(terms,
+			search.Field{Text: user.Name, Weight: 1},
+			search.Field{Text: user.Email, Weight: 0.8},
+		)
+		if score > 0 {
+			hits = append(hits, search.Hit[*userpb.User]{Item: user, Score: score})
+		}
+	}
+	r.store.mu.RUnlock()
+
+	// Map order is random; fix the order of equal scores before ranking
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i].Item, hits[j].Item
+		return (pagetoken.Cursor{CreatedAt: a.CreatedAt.AsTime(), ID: a.Id}).Before(b.CreatedAt.AsTime(), b.Id)
+	})
+
+	span.SetAttributes(attribute.Int("search.candidates", len(hits)))
+	hits = search.Rank(hits, limit)
+	span.SetAttributes(attribute.Int("result.count", len(hits)))
+
+	return hits, nil
+}
+
 func generateUserID() string {
 	return fmt.Sprintf("user-%d", time.Now().UnixNano())
 }
\ No newline at end of file
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bonyuta0204/otel-lab/internal/pagetoken"
+	"github.com/bonyuta0204/otel-lab/internal/tenant"
+	userpb "github.com/bonyuta0204/otel-lab/proto/userpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// put adds users to the tenant's partition directly, so that tests can give
+// several of them the same created_at.
+func put(store *InMemoryUserStore, tenantID string, users ...*userpb.User) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	partition := store.partitionLocked(tenantID, true)
+	for _, user := range users {
+		partition[user.Id] = user
+	}
+}
+
+func newUser(id, name, email string, createdAt time.Time) *userpb.User {
+	return &userpb.User{Id: id, Name: name, Email: email, CreatedAt: timestamppb.New(createdAt)}
+}
+
+func TestListUsersPages(t *testing.T) {
+	store := NewInMemoryUserStore()
+	repo := NewUserRepository(store)
+	ctx := tenant.NewContext(context.Background(), "acme")
+
+	// Five users share a created_at, so the ID has to break the ties
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var want []string
+	put(store, "acme", newUser("user-z", "Newest", "z@example.com", base.Add(time.Hour)))
+	want = append(want, "user-z")
+	for i := 5; i >= 1; i-- {
+		id := fmt.Sprintf("user-%d", i)
+		put(store, "acme", newUser(id, id, id+"@example.com", base))
+		want = append(want, id)
+	}
+	put(store, "acme", newUser("user-a", "Oldest", "a@example.com", base.Add(-time.Hour)))
+	want = append(want, "user-a")
+
+	for _, pageSize := range []int32{1, 2, 3, 7, 10} {
+		t.Run(fmt.Sprintf("page size %d", pageSize), func(t *testing.T) {
+			var got []string
+			var after *pagetoken.Cursor
+			for pages := 1; ; pages++ {
+				page, err := repo.ListUsers(ctx, &userpb.ListUsersRequest{PageSize: pageSize, IncludeTotalCount: pages == 1}, after)
+				if err != nil {
+					t.Fatalf("ListUsers page %d: %v", pages, err)
+				}
+				if pages == 1 {
+					if page.TotalCount == nil || *page.TotalCount != int32(len(want)) {
+						t.Errorf("total count = %v, want %d", page.TotalCount, len(want))
+					}
+				} else if page.TotalCount != nil {
+					t.Errorf("page %d has a total count without asking for it", pages)
+				}
+				for _, user := range page.Users {
+					got = append(got, user.Id)
+				}
+
+				if page.Next == nil {
+					break
+				}
+				if len(page.Users) != int(pageSize) {
+					t.Fatalf("page %d has %d users and a next page", pages, len(page.Users))
+				}
+				if pages > len(want) {
+					t.Fatal("pagination does not end")
+				}
+				after = page.Next
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("users = %v, want each once in the order %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	repo := NewUserRepository(NewInMemoryUserStore())
+	acme := tenant.NewContext(context.Background(), "acme")
+	globex := tenant.NewContext(context.Background(), "globex")
+
+	created, err := repo.CreateUser(acme, &userpb.CreateUserRequest{Name: "Alice Private", Email: "alice@acme.test"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	// Acme's read fills the cache, which must not leak the user either
+	for _, when := range []string{"before", "after"} {
+		if _, err := repo.GetUser(globex, created.Id); err == nil {
+			t.Errorf("GetUser from another tenant %s the owner's read succeeded", when)
+		}
+		if got, err := repo.GetUser(acme, created.Id); err != nil || got.Id != created.Id {
+			t.Fatalf("GetUser from the owner = %v, %v", got, err)
+		}
+	}
+
+	users, err := repo.GetUsersByIds(globex, []string{created.Id})
+	if err != nil {
+		t.Fatalf("GetUsersByIds: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("GetUsersByIds from another tenant returned %d users", len(users))
+	}
+
+	page, err := repo.ListUsers(globex, &userpb.ListUsersRequest{PageSize: 10, IncludeTotalCount: true}, nil)
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(page.Users) != 0 || *page.TotalCount != 0 {
+		t.Errorf("another tenant lists %d users (total %d), want none", len(page.Users), *page.TotalCount)
+	}
+
+	hits, err := repo.SearchUsers(globex, []string{"alice"}, 10)
+	if err != nil {
+		t.Fatalf("SearchUsers: %v", err)
+	}
+	if len(hits) != 0 {
+		t.Errorf("SearchUsers from another tenant found %d users", len(hits))
+	}
+}
+
+func TestRequiresTenant(t *testing.T) {
+	repo := NewUserRepository(NewInMemoryUserStore())
+	acme := tenant.NewContext(context.Background(), "acme")
+	created, err := repo.CreateUser(acme, &userpb.CreateUserRequest{Name: "Alice", Email: "alice@acme.test"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := repo.CreateUser(ctx, &userpb.CreateUserRequest{Name: "a", Email: "a@example.com"}); !errors.Is(err, tenant.ErrMissing) {
+		t.Errorf("CreateUser without tenant = %v, want ErrMissing", err)
+	}
+	if _, err := repo.GetUser(ctx, created.Id); !errors.Is(err, tenant.ErrMissing) {
+		t.Errorf("GetUser without tenant = %v, want ErrMissing", err)
+	}
+	if _, err := repo.GetUsersByIds(ctx, []string{created.Id}); !errors.Is(err, tenant.ErrMissing) {
+		t.Errorf("GetUsersByIds without tenant = %v, want ErrMissing", err)
+	}
+	if _, err := repo.ListUsers(ctx, &userpb.ListUsersRequest{PageSize: 10}, nil); !errors.Is(err, tenant.ErrMissing) {
+		t.Errorf("ListUsers without tenant = %v, want ErrMissing", err)
+	}
+	if _, err := repo.SearchUsers(ctx, []string{"alice"}, 10); !errors.Is(err, tenant.ErrMissing) {
+		t.Errorf("SearchUsers without tenant = %v, want ErrMissing", err)
+	}
+}